@@ -52,6 +52,30 @@ type Config struct {
 	LogLevel string
 	LogFile  string
 
+	// SMTP (email notifications); empty host disables the email channel
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	SMTPFromName string
+	SMTPTLS      string // starttls, tls (implicit, port 465) or none (local stand-ins)
+
+	// SMS notifications; empty provider disables the sms channels
+	SMSProvider      string // log | http
+	SMSHTTPURL       string
+	SMSHTTPToken     string
+	SMSSender        string
+	SMSRatePerMinute int // process-wide send rate
+	SMSDailyLimit    int // per recipient per day (Asia/Bangkok)
+	SMSMaxSegments   int // longer texts are cut
+
+	// Learning hours: balance (in hours) under which the low-balance alert fires
+	LearningHoursLowThreshold float64
+
+	// LINE: leave form linked from the daily class reminder (absence_link)
+	AbsenceLink string
+
 	// Feature Toggles
 	UseRedisNotifications bool
 	SkipMigrate           bool
@@ -143,6 +167,24 @@ func LoadConfig() {
 		log.Fatal("Invalid MAX_FILE_SIZE format:", err)
 	}
 
+	getIntVal := func(key string, def int) int {
+		n, err := strconv.Atoi(getVal(key, strconv.Itoa(def)))
+		if err != nil {
+			log.Printf("Warning: invalid %s, using %d", key, def)
+			return def
+		}
+		return n
+	}
+
+	getFloatVal := func(key string, def float64) float64 {
+		n, err := strconv.ParseFloat(getVal(key, strconv.FormatFloat(def, 'f', -1, 64)), 64)
+		if err != nil {
+			log.Printf("Warning: invalid %s, using %v", key, def)
+			return def
+		}
+		return n
+	}
+
 	AppConfig = &Config{
 		DBHost:     getVal("DB_HOST", "localhost"),
 		DBPort:     getVal("DB_PORT", "3306"),
@@ -174,6 +216,26 @@ func LoadConfig() {
 		LogLevel: getVal("LOG_LEVEL", "info"),
 		LogFile:  getVal("LOG_FILE", "logs/app.log"),
 
+		SMTPHost:     getVal("SMTP_HOST", ""),
+		SMTPPort:     getVal("SMTP_PORT", "587"),
+		SMTPUsername: getVal("SMTP_USERNAME", ""),
+		SMTPPassword: getVal("SMTP_PASSWORD", ""),
+		SMTPFrom:     getVal("SMTP_FROM", ""),
+		SMTPFromName: getVal("SMTP_FROM_NAME", "English Korat"),
+		SMTPTLS:      strings.ToLower(getVal("SMTP_TLS", "starttls")),
+
+		SMSProvider:      strings.ToLower(getVal("SMS_PROVIDER", "")),
+		SMSHTTPURL:       getVal("SMS_HTTP_URL", ""),
+		SMSHTTPToken:     getVal("SMS_HTTP_TOKEN", ""),
+		SMSSender:        getVal("SMS_SENDER", "EnglishKorat"),
+		SMSRatePerMinute: getIntVal("SMS_RATE_PER_MINUTE", 30),
+		SMSDailyLimit:    getIntVal("SMS_DAILY_LIMIT", 10),
+		SMSMaxSegments:   getIntVal("SMS_MAX_SEGMENTS", 3),
+
+		LearningHoursLowThreshold: getFloatVal("LEARNING_HOURS_LOW_THRESHOLD", 4),
+
+		AbsenceLink: getVal("ABSENCE_LINK", ""),
+
 		UseRedisNotifications: strings.ToLower(getVal("USE_REDIS_NOTIFICATIONS", "false")) == "true",
 		SkipMigrate:           strings.ToLower(getVal("SKIP_MIGRATE", "false")) == "true",
 		PruneColumns:          strings.ToLower(getVal("PRUNE_COLUMNS", "true")) == "true",
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+
 	"englishkorat_go/database"
 	"englishkorat_go/middleware"
 	"englishkorat_go/models"
@@ -11,12 +13,57 @@ import (
 
 type AbsenceController struct{}
 
-func (ac *AbsenceController) CreateAbsence(c *fiber.Ctx) error {
-	var req struct {
-		GroupID   uint   `json:"group_id"`
-		SessionID uint   `json:"session_id"`
-		Reason    string `json:"reason"`
+// restrictStudentAbsence - นักเรียนแจ้งลาได้เฉพาะของตัวเอง (student_id ถูกบังคับเป็นโปรไฟล์ของผู้ใช้)
+func restrictStudentAbsence(c *fiber.Ctx, req *services.AbsenceRequest) error {
+	if c.Locals("role").(string) != "student" {
+		return nil
+	}
+	var student models.Student
+	if err := database.DB.Select("id").Where("user_id = ?", c.Locals("user_id").(uint)).First(&student).Error; err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Student profile not found"})
+	}
+	if req.StudentID != nil && *req.StudentID != student.ID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Students can only file their own absences"})
+	}
+	req.StudentID = &student.ID
+	return nil
+}
+
+// loadAccessibleAbsence loads :id and checks the caller may see it
+// (admin/owner, the session's teacher, the student concerned or whoever filed it)
+func loadAccessibleAbsence(c *fiber.Ctx) (*models.Absence, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid absence ID"})
 	}
+	var absence models.Absence
+	if err := database.DB.Preload("Session.Schedule").First(&absence, id).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Absence not found"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	allowed := false
+	switch c.Locals("role").(string) {
+	case "admin", "owner":
+		allowed = true
+	case "teacher":
+		allowed = canTakeAttendance(c, &absence.Session)
+	default:
+		if absence.CreatedBy == userID {
+			allowed = true
+		} else if absence.StudentID != nil {
+			var student models.Student
+			allowed = database.DB.Where("id = ? AND user_id = ?", *absence.StudentID, userID).First(&student).Error == nil
+		}
+	}
+	if !allowed {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+	}
+	return &absence, nil
+}
+
+func (ac *AbsenceController) CreateAbsence(c *fiber.Ctx) error {
+	var req services.AbsenceRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
@@ -25,12 +72,54 @@ func (ac *AbsenceController) CreateAbsence(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 	userID := claims.UserID
+	if err := restrictStudentAbsence(c, &req); err != nil {
+		return err
+	}
 
-	absence, err := services.CreateAbsence(req.GroupID, req.SessionID, userID, req.Reason)
+	absence, assessment, err := services.CreateAbsence(req, userID)
+	if errors.Is(err, services.ErrLateLeaveNotConfirmed) {
+		// ลาช้า: แสดงผลกระทบให้ผู้ขอยืนยันก่อน แล้วส่งใหม่พร้อม confirm_late: true
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":                 "Late leave must be confirmed",
+			"requires_confirmation": true,
+			"assessment":            assessment,
+		})
+	}
+	if errors.Is(err, services.ErrAlreadyOnLeave) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A leave for this session already exists"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"absence":    absence,
+		"assessment": assessment,
+	})
+}
+
+// PreviewAbsence - ตรวจสอบ cutoff ของสาขาก่อนแจ้งลา (ลาช้าหรือไม่ / หักกี่ชั่วโมง)
+func (ac *AbsenceController) PreviewAbsence(c *fiber.Ctx) error {
+	var req services.AbsenceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	claims, err := middleware.GetCurrentClaims(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if err := restrictStudentAbsence(c, &req); err != nil {
+		return err
+	}
+
+	assessment, _, err := services.AssessLeave(req.GroupID, req.SessionID, claims.UserID, req.StudentID)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(absence)
+	return c.JSON(fiber.Map{
+		"assessment":            assessment,
+		"requires_confirmation": assessment.IsLate,
+	})
 }
 
 func (ac *AbsenceController) ApproveAbsence(c *fiber.Ctx) error {
@@ -39,7 +128,8 @@ func (ac *AbsenceController) ApproveAbsence(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid absence ID"})
 	}
 	var req struct {
-		Approve bool `json:"approve"`
+		Approve bool   `json:"approve"`
+		Note    string `json:"note"`
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
@@ -50,10 +140,108 @@ func (ac *AbsenceController) ApproveAbsence(c *fiber.Ctx) error {
 	}
 	adminID := claims.UserID
 
-	if err := services.ApproveAbsence(uint(id), adminID, req.Approve); err != nil {
+	absence, err := services.ApproveAbsence(uint(id), adminID, req.Approve, req.Note)
+	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"message": "success"})
+
+	// อนุมัติการลาทั้งกลุ่มแล้ว -> เสนอช่วงเวลาเรียนชดเชย
+	var slots []services.MakeupSlot
+	if services.CheckMakeupOffered(*absence) == nil {
+		if slots, err = services.SuggestMakeupSlots(absence.SessionID, 5); err != nil {
+			slots = nil
+		}
+	}
+	go services.NotifyAbsenceDecision(*absence, slots)
+
+	middleware.LogActivity(c, "APPROVE", "absences", absence.ID, fiber.Map{
+		"status": absence.Status,
+	})
+
+	return c.JSON(fiber.Map{
+		"message":      "success",
+		"absence":      absence,
+		"makeup_slots": slots,
+	})
+}
+
+// GetMyAbsences - การลาของนักเรียนที่ล็อกอิน (รวมที่ตัวเองเป็นคนแจ้ง)
+func (ac *AbsenceController) GetMyAbsences(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	query := database.DB.Preload("Session").Preload("Group").Where("created_by = ?", userID)
+	var student models.Student
+	if err := database.DB.Select("id").Where("user_id = ?", userID).First(&student).Error; err == nil {
+		query = query.Or("student_id = ?", student.ID)
+	}
+
+	var absences []models.Absence
+	if err := query.Order("created_at DESC").Find(&absences).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "cannot fetch absences"})
+	}
+	return c.JSON(absences)
+}
+
+// GetMakeupSlots - ช่วงเวลาเรียนชดเชยที่ว่าง (ครู ห้อง และกลุ่มว่าง ไม่ตรงวันหยุด)
+func (ac *AbsenceController) GetMakeupSlots(c *fiber.Ctx) error {
+	absence, err := loadAccessibleAbsence(c)
+	if absence == nil {
+		return err
+	}
+	if err := services.CheckMakeupOffered(*absence); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	slots, err := services.SuggestMakeupSlots(absence.SessionID, c.QueryInt("limit", 5))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"absence_id":        absence.ID,
+		"makeup_session_id": absence.MakeupSessionID,
+		"slots":             slots,
+	})
+}
+
+// BookMakeup - จอง slot ที่เสนอให้เป็น makeup session จริง
+func (ac *AbsenceController) BookMakeup(c *fiber.Ctx) error {
+	absence, err := loadAccessibleAbsence(c)
+	if absence == nil {
+		return err
+	}
+
+	var req struct {
+		Date      string `json:"date"`       // YYYY-MM-DD
+		StartTime string `json:"start_time"` // HH:MM
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	date, err := services.ParseHolidayDate(req.Date)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid date, use YYYY-MM-DD"})
+	}
+
+	makeup, err := services.BookAbsenceMakeup(absence, date, req.StartTime)
+	switch {
+	case errors.Is(err, services.ErrMakeupSlotTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "The teacher, room or group is busy at that time"})
+	case errors.Is(err, services.ErrMakeupInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrMakeupOriginalNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Original session not found"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create makeup session"})
+	}
+
+	middleware.LogActivity(c, "CREATE", "makeup_sessions", makeup.ID, fiber.Map{
+		"absence_id": absence.ID,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":        "Makeup session created successfully",
+		"makeup_session": makeup,
+		"absence":        absence,
+	})
 }
 
 func (ac *AbsenceController) GetAbsences(c *fiber.Ctx) error {
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AttendanceController struct{}
+
+type recordAttendanceRequest struct {
+	Records []services.AttendanceInput `json:"records"`
+}
+
+func attendanceErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrAttendanceSessionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	case errors.Is(err, services.ErrAttendanceInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process attendance", "details": err.Error()})
+	}
+}
+
+// canTakeAttendance - ครูต้องเป็น default teacher ของ schedule หรือครูที่ถูก assign ใน session นั้น
+func canTakeAttendance(c *fiber.Ctx, session *models.Schedule_Sessions) bool {
+	if c.Locals("role").(string) != "teacher" {
+		return true
+	}
+	userID := c.Locals("user_id").(uint)
+	if session.Schedule != nil && session.Schedule.DefaultTeacherID != nil && *session.Schedule.DefaultTeacherID == userID {
+		return true
+	}
+	return session.AssignedTeacherID != nil && *session.AssignedTeacherID == userID
+}
+
+// teachesGroup - ครูที่สอนกลุ่มนี้ (default teacher หรือเคยถูก assign ใน session ของกลุ่ม)
+func teachesGroup(userID, groupID uint) bool {
+	var count int64
+	database.DB.Model(&models.Schedule_Sessions{}).
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id AND schedules.deleted_at IS NULL").
+		Where("schedules.group_id = ?", groupID).
+		Where("schedules.default_teacher_id = ? OR schedule_sessions.assigned_teacher_id = ?", userID, userID).
+		Limit(1).
+		Count(&count)
+	return count > 0
+}
+
+// GetSessionAttendance returns the roster of a session with recorded attendance
+func (ac *AttendanceController) GetSessionAttendance(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	session, roster, err := services.NewAttendanceService().Roster(uint(sessionID))
+	if err != nil {
+		return attendanceErrorResponse(c, err)
+	}
+	if !canTakeAttendance(c, session) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You are not assigned to this session"})
+	}
+
+	return c.JSON(fiber.Map{
+		"session_id": session.ID,
+		"group_id":   session.Schedule.GroupID,
+		"date":       session.Session_date,
+		"start_time": session.Start_time,
+		"status":     session.Status,
+		"students":   roster,
+	})
+}
+
+// RecordSessionAttendance saves attendance for a session (PUT replaces rows for the listed students)
+func (ac *AttendanceController) RecordSessionAttendance(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	var req recordAttendanceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var session models.Schedule_Sessions
+	if err := database.DB.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if !canTakeAttendance(c, &session) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You are not assigned to this session"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	records, err := services.NewAttendanceService().Record(session.ID, userID, req.Records)
+	if err != nil {
+		return attendanceErrorResponse(c, err)
+	}
+
+	middleware.LogActivity(c, "UPDATE", "attendance", session.ID, fiber.Map{
+		"records": len(records),
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Attendance recorded successfully",
+		"records": records,
+	})
+}
+
+// GetGroupAttendanceSummary returns attendance counts per student of a group
+func (ac *AttendanceController) GetGroupAttendanceSummary(c *fiber.Ctx) error {
+	groupID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid group ID"})
+	}
+
+	var group models.Group
+	if err := database.DB.First(&group, groupID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Group not found"})
+	}
+	if c.Locals("role").(string) == "teacher" && !teachesGroup(c.Locals("user_id").(uint), group.ID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You do not teach this group"})
+	}
+
+	summary, err := services.NewAttendanceService().GroupSummary(group.ID)
+	if err != nil {
+		return attendanceErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"group_name": group.GroupName,
+		"summary":    summary,
+	})
+}
+
+// GetStudentAttendanceSummary returns a student's attendance; students may only see their own
+func (ac *AttendanceController) GetStudentAttendanceSummary(c *fiber.Ctx) error {
+	studentID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid student ID"})
+	}
+
+	var student models.Student
+	if err := database.DB.First(&student, studentID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	switch c.Locals("role").(string) {
+	case "student":
+		if student.UserID == nil || *student.UserID != userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+		}
+	case "teacher":
+		var count int64
+		database.DB.Model(&models.GroupMember{}).Where("student_id = ?", student.ID).
+			Where("EXISTS (SELECT 1 FROM schedules WHERE schedules.group_id = group_members.group_id AND schedules.default_teacher_id = ? AND schedules.deleted_at IS NULL)", userID).
+			Count(&count)
+		if count == 0 {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+		}
+	}
+
+	report, err := services.NewAttendanceService().StudentSummary(student.ID, c.QueryInt("recent", 20))
+	if err != nil {
+		return attendanceErrorResponse(c, err)
+	}
+	return c.JSON(report)
+}
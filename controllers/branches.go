@@ -4,6 +4,7 @@ import (
 	"englishkorat_go/database"
 	"englishkorat_go/middleware"
 	"englishkorat_go/models"
+	"englishkorat_go/services"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
@@ -88,6 +89,14 @@ func (bc *BranchController) CreateBranch(c *fiber.Ctx) error {
 		})
 	}
 
+	if branch.LeaveCutoffTime == "" {
+		branch.LeaveCutoffTime = services.DefaultLeaveCutoff
+	} else if !services.ValidLeaveCutoff(branch.LeaveCutoffTime) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "leave_cutoff_time must be HH:MM",
+		})
+	}
+
 	// Set default values
 	if branch.Type == "" {
 		branch.Type = "offline"
@@ -142,6 +151,12 @@ func (bc *BranchController) UpdateBranch(c *fiber.Ctx) error {
 		}
 	}
 
+	if updateData.LeaveCutoffTime != "" && !services.ValidLeaveCutoff(updateData.LeaveCutoffTime) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "leave_cutoff_time must be HH:MM",
+		})
+	}
+
 	if err := database.DB.Model(&branch).Updates(updateData).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update branch",
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CalendarFeedController serves tokenized iCalendar subscription feeds
+type CalendarFeedController struct{}
+
+type createCalendarFeedRequest struct {
+	Scope   string `json:"scope"`    // user (default), room, branch
+	ScopeID *uint  `json:"scope_id"` // room/branch ID
+	Name    string `json:"name"`
+}
+
+func calendarFeedResponse(c *fiber.Ctx, feed models.CalendarFeedToken) fiber.Map {
+	return fiber.Map{
+		"id":               feed.ID,
+		"scope":            feed.Scope,
+		"scope_id":         feed.ScopeID,
+		"name":             feed.Name,
+		"url":              c.BaseURL() + "/api/calendar/" + feed.Token + ".ics",
+		"created_at":       feed.CreatedAt,
+		"last_accessed_at": feed.LastAccessedAt,
+	}
+}
+
+// GetFeed - GET /api/calendar/:token.ics (public, the token is the credential)
+func (cfc *CalendarFeedController) GetFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("token"), ".ics")
+	svc := services.NewCalendarFeedService()
+
+	feed, err := svc.Resolve(token)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("calendar feed not found")
+	}
+
+	sessions, err := svc.FeedSessions(feed)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("failed to build calendar feed")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="englishkorat.ics"`)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return c.SendString(services.RenderICS(svc.FeedName(feed), sessions))
+}
+
+// ListFeeds - GET /api/calendar/feeds (feeds created by the current user)
+func (cfc *CalendarFeedController) ListFeeds(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	feeds, err := services.NewCalendarFeedService().ListTokens(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch calendar feeds"})
+	}
+
+	out := make([]fiber.Map, 0, len(feeds))
+	for _, feed := range feeds {
+		out = append(out, calendarFeedResponse(c, feed))
+	}
+	return c.JSON(fiber.Map{"feeds": out})
+}
+
+// CreateFeed - POST /api/calendar/feeds
+// Everyone can create a personal feed; room and branch feeds are owner/admin only.
+func (cfc *CalendarFeedController) CreateFeed(c *fiber.Ctx) error {
+	var req createCalendarFeedRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if req.Scope == "" {
+		req.Scope = services.CalendarFeedScopeUser
+	}
+
+	role := c.Locals("role").(string)
+	if req.Scope != services.CalendarFeedScopeUser && role != "admin" && role != "owner" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admin and owner can create room or branch feeds"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	feed, err := services.NewCalendarFeedService().CreateToken(userID, req.Scope, req.ScopeID, req.Name)
+	if err != nil {
+		if errors.Is(err, services.ErrCalendarFeedScope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create calendar feed"})
+	}
+
+	middleware.LogActivity(c, "CREATE", "calendar_feed_tokens", feed.ID, fiber.Map{
+		"scope":    feed.Scope,
+		"scope_id": feed.ScopeID,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Calendar feed created",
+		"feed":    calendarFeedResponse(c, *feed),
+	})
+}
+
+// RevokeFeed - DELETE /api/calendar/feeds/:id (the URL stops working immediately)
+func (cfc *CalendarFeedController) RevokeFeed(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid feed ID"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	role := c.Locals("role").(string)
+	if err := services.NewCalendarFeedService().RevokeToken(uint(id), userID, role == "admin" || role == "owner"); err != nil {
+		if errors.Is(err, services.ErrCalendarFeedNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Calendar feed not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke calendar feed"})
+	}
+
+	middleware.LogActivity(c, "REVOKE", "calendar_feed_tokens", uint(id), nil)
+	return c.JSON(fiber.Map{"message": "Calendar feed revoked"})
+}
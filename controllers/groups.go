@@ -3,7 +3,9 @@ package controllers
 import (
 	"englishkorat_go/database"
 	"englishkorat_go/models"
+	"englishkorat_go/services"
 	"englishkorat_go/utils"
+	"errors"
 	"strconv"
 	"time"
 
@@ -24,6 +26,8 @@ type CreateGroupRequest struct {
 type AddMemberToGroupRequest struct {
 	StudentID     uint   `json:"student_id" validate:"required"`
 	PaymentStatus string `json:"payment_status" validate:"oneof=pending deposit_paid fully_paid"`
+	// Force adds the student even when their learning hours have run out
+	Force bool `json:"force"`
 }
 
 // CreateGroup creates a new learning group
@@ -227,6 +231,16 @@ func (gc *GroupController) AddMemberToGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	// Students that bought hours and used them all cannot join a new group (unless forced)
+	if !req.Force {
+		if balance, err := services.NewLearningHoursService().CheckCanEnroll(student.ID); errors.Is(err, services.ErrLearningHoursExhausted) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   "Student has no learning hours left",
+				"balance": balance,
+			})
+		}
+	}
+
 	// Check if student is already in the group
 	var existingMember models.GroupMember
 	if err := database.DB.Where("group_id = ? AND student_id = ?", groupID, req.StudentID).First(&existingMember).Error; err == nil {
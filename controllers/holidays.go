@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HolidayController manages public holidays and branch closures used by session generation
+type HolidayController struct{}
+
+type holidayRequest struct {
+	Date     string `json:"date"` // YYYY-MM-DD
+	Name     string `json:"name"`
+	Type     string `json:"type"`      // holiday (default), closure
+	BranchID *uint  `json:"branch_id"` // nil = ทุกสาขา
+	Notes    string `json:"notes"`
+}
+
+func (r holidayRequest) toModel() (models.Holiday, error) {
+	date, err := services.ParseHolidayDate(r.Date)
+	if err != nil {
+		return models.Holiday{}, err
+	}
+	return models.Holiday{
+		Date:     date,
+		Name:     r.Name,
+		Type:     strings.ToLower(strings.TrimSpace(r.Type)),
+		BranchID: r.BranchID,
+		Notes:    r.Notes,
+	}, nil
+}
+
+func parseOptionalBranchID(value string) (*uint, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		return nil, errors.New("invalid branch_id")
+	}
+	branchID := uint(id)
+	return &branchID, nil
+}
+
+func holidayErrorResponse(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, services.ErrHolidayNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Holiday not found"})
+	case errors.Is(err, services.ErrHolidayInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+	}
+}
+
+// GetHolidays - GET /api/holidays?year=&from=&to=&branch_id=&all_branches=&source=&type=
+// Without branch_id only all-branch holidays are returned (all_branches=true returns every row).
+func (hc *HolidayController) GetHolidays(c *fiber.Ctx) error {
+	filter := services.HolidayFilter{
+		AllBranches: c.Query("all_branches") == "true",
+		Source:      c.Query("source"),
+		Type:        c.Query("type"),
+	}
+
+	branchID, err := parseOptionalBranchID(c.Query("branch_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	filter.BranchID = branchID
+
+	if year := c.QueryInt("year", 0); year > 0 {
+		filter.From = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		filter.To = time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = services.ParseHolidayDate(from); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid from date"})
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = services.ParseHolidayDate(to); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid to date"})
+		}
+	}
+
+	svc := services.NewHolidayService()
+	var holidays []models.Holiday
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.Source == "" && filter.Type == "" && !filter.AllBranches {
+		// ช่วงวันที่ที่ชัดเจน: เติม cache จาก myhora ให้ปีที่ยังไม่เคยดึง
+		holidays, err = svc.HolidaysInRange(filter.From, filter.To, filter.BranchID)
+		if err != nil && holidays == nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch holidays"})
+		}
+	} else if holidays, err = svc.List(filter); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch holidays"})
+	}
+
+	return c.JSON(fiber.Map{
+		"holidays": holidays,
+		"total":    len(holidays),
+	})
+}
+
+// GetHoliday - GET /api/holidays/:id
+func (hc *HolidayController) GetHoliday(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid holiday ID"})
+	}
+
+	holiday, err := services.NewHolidayService().Get(uint(id))
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to fetch holiday")
+	}
+	return c.JSON(fiber.Map{"holiday": holiday})
+}
+
+// CreateHoliday - POST /api/holidays
+func (hc *HolidayController) CreateHoliday(c *fiber.Ctx) error {
+	var req holidayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	holiday, err := req.toModel()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := services.NewHolidayService().Create(&holiday); err != nil {
+		return holidayErrorResponse(c, err, "Failed to create holiday")
+	}
+
+	middleware.LogActivity(c, "CREATE", "holidays", holiday.ID, fiber.Map{
+		"date":      holiday.Date.Format("2006-01-02"),
+		"name":      holiday.Name,
+		"type":      holiday.Type,
+		"branch_id": holiday.BranchID,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Holiday created",
+		"holiday": holiday,
+	})
+}
+
+// UpdateHoliday - PUT /api/holidays/:id
+func (hc *HolidayController) UpdateHoliday(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid holiday ID"})
+	}
+
+	var req holidayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	input, err := req.toModel()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	holiday, err := services.NewHolidayService().Update(uint(id), input)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to update holiday")
+	}
+
+	middleware.LogActivity(c, "UPDATE", "holidays", holiday.ID, fiber.Map{
+		"date":      holiday.Date.Format("2006-01-02"),
+		"name":      holiday.Name,
+		"type":      holiday.Type,
+		"branch_id": holiday.BranchID,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Holiday updated",
+		"holiday": holiday,
+	})
+}
+
+// DeleteHoliday - DELETE /api/holidays/:id
+func (hc *HolidayController) DeleteHoliday(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid holiday ID"})
+	}
+
+	if err := services.NewHolidayService().Delete(uint(id)); err != nil {
+		return holidayErrorResponse(c, err, "Failed to delete holiday")
+	}
+
+	middleware.LogActivity(c, "DELETE", "holidays", uint(id), nil)
+	return c.JSON(fiber.Map{"message": "Holiday deleted"})
+}
+
+// ImportHolidays - POST /api/holidays/import (multipart: file=.ics|.csv, branch_id, type)
+func (hc *HolidayController) ImportHolidays(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file is required"})
+	}
+
+	filename := strings.ToLower(fileHeader.Filename)
+	var format string
+	switch {
+	case strings.HasSuffix(filename, ".ics"):
+		format = services.HolidayImportICS
+	case strings.HasSuffix(filename, ".csv"):
+		format = services.HolidayImportCSV
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unsupported file type (ics, csv)"})
+	}
+
+	branchID, err := parseOptionalBranchID(c.FormValue("branch_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot open file"})
+	}
+	defer file.Close()
+
+	result, err := services.NewHolidayService().Import(file, format, branchID, strings.ToLower(c.FormValue("type")))
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to import holidays")
+	}
+
+	middleware.LogActivity(c, "IMPORT", "holidays", 0, fiber.Map{
+		"file":      fileHeader.Filename,
+		"branch_id": branchID,
+		"created":   result.Created,
+		"updated":   result.Updated,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Holidays imported",
+		"result":  result,
+	})
+}
+
+// SyncHolidays - POST /api/holidays/sync?year=2026 (re-fetch Thai public holidays from myhora)
+func (hc *HolidayController) SyncHolidays(c *fiber.Ctx) error {
+	year := c.QueryInt("year", time.Now().Year())
+	if year < 2000 || year > 2100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid year"})
+	}
+
+	result, err := services.NewHolidayService().SyncMyHora(year)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	middleware.LogActivity(c, "SYNC", "holidays", 0, fiber.Map{
+		"year":    year,
+		"created": result.Created,
+		"updated": result.Updated,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Holidays synced",
+		"year":    year,
+		"result":  result,
+	})
+}
+
+type applyHolidayRequest struct {
+	HolidayID   *uint  `json:"holiday_id"` // apply an existing holiday row
+	Date        string `json:"date"`       // or a date (YYYY-MM-DD)
+	Name        string `json:"name"`       // with name: the holiday row is created on commit
+	Type        string `json:"type"`
+	BranchID    *uint  `json:"branch_id"` // closures: only schedules of this branch
+	ScheduleIDs []uint `json:"schedule_ids,omitempty"`
+}
+
+// resolveHolidayApplication builds the apply input from a request; the returned holiday is
+// non-nil when a new row should be created on commit
+func resolveHolidayApplication(req applyHolidayRequest) (services.HolidayApplyInput, *models.Holiday, error) {
+	input := services.HolidayApplyInput{ScheduleIDs: req.ScheduleIDs}
+
+	if req.HolidayID != nil {
+		holiday, err := services.NewHolidayService().Get(*req.HolidayID)
+		if err != nil {
+			return input, nil, err
+		}
+		input.Date = holiday.Date
+		input.Name = holiday.Name
+		input.BranchID = holiday.BranchID
+		return input, nil, nil
+	}
+
+	date, err := services.ParseHolidayDate(req.Date)
+	if err != nil {
+		return input, nil, fmt.Errorf("%w: holiday_id or a valid date is required", services.ErrHolidayInvalid)
+	}
+	if req.BranchID != nil && *req.BranchID == 0 {
+		req.BranchID = nil
+	}
+	input.Date = date
+	input.Name = strings.TrimSpace(req.Name)
+	input.BranchID = req.BranchID
+
+	var newHoliday *models.Holiday
+	if input.Name != "" {
+		newHoliday = &models.Holiday{
+			Date:     date,
+			Name:     input.Name,
+			Type:     strings.ToLower(strings.TrimSpace(req.Type)),
+			BranchID: req.BranchID,
+		}
+	}
+	return input, newHoliday, nil
+}
+
+// PreviewApplyHoliday - POST /api/holidays/apply/preview
+// Lists future sessions on the date (schedules with auto-reschedule on) and where they would move.
+func (hc *HolidayController) PreviewApplyHoliday(c *fiber.Ctx) error {
+	var req applyHolidayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	input, _, err := resolveHolidayApplication(req)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to load holiday")
+	}
+
+	plan, err := services.NewHolidayService().PlanHolidayApplication(input)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to preview holiday application")
+	}
+	return c.JSON(fiber.Map{
+		"dry_run": true,
+		"plan":    plan,
+	})
+}
+
+// ApplyHoliday - POST /api/holidays/apply
+// Recomputes the preview, moves the sessions, renumbers them and notifies teachers, students and LINE groups.
+func (hc *HolidayController) ApplyHoliday(c *fiber.Ctx) error {
+	var req applyHolidayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	input, newHoliday, err := resolveHolidayApplication(req)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to load holiday")
+	}
+
+	svc := services.NewHolidayService()
+	plan, err := svc.PlanHolidayApplication(input)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to plan holiday application")
+	}
+	created, err := svc.ApplyHolidayApplication(plan, newHoliday)
+	if err != nil {
+		return holidayErrorResponse(c, err, "Failed to apply holiday")
+	}
+	if created {
+		middleware.LogActivity(c, "CREATE", "holidays", newHoliday.ID, fiber.Map{
+			"date":      newHoliday.Date.Format("2006-01-02"),
+			"name":      newHoliday.Name,
+			"branch_id": newHoliday.BranchID,
+		})
+	}
+
+	scheduleIDs := make([]uint, 0, len(plan.Schedules))
+	for _, s := range plan.Schedules {
+		scheduleIDs = append(scheduleIDs, s.ScheduleID)
+	}
+	middleware.LogActivity(c, "APPLY_HOLIDAY", "schedules", 0, fiber.Map{
+		"date":         plan.Date,
+		"holiday_name": plan.HolidayName,
+		"branch_id":    plan.BranchID,
+		"schedule_ids": scheduleIDs,
+		"moved":        plan.TotalMoved,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Holiday applied",
+		"plan":    plan,
+	})
+}
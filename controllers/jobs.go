@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/middleware"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JobController exposes the durable delayed-job queue to admins
+type JobController struct{}
+
+// ListJobs lists scheduled jobs, optionally filtered by session_id, status and job_type
+func (jc *JobController) ListJobs(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := services.ListJobsFilter{
+		Status:  c.Query("status"),
+		JobType: c.Query("job_type"),
+		Limit:   limit,
+		Offset:  (page - 1) * limit,
+	}
+	if raw := c.Query("session_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session_id"})
+		}
+		sid := uint(id)
+		filter.SessionID = &sid
+	}
+
+	jobs, total, err := services.NewJobQueue().ListJobs(filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve jobs"})
+	}
+
+	return c.JSON(fiber.Map{
+		"jobs":        jobs,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// CancelJob cancels a single pending job
+func (jc *JobController) CancelJob(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID"})
+	}
+
+	if err := services.NewJobQueue().CancelJob(uint(id)); err != nil {
+		if errors.Is(err, services.ErrJobNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Job not found or not pending"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to cancel job"})
+	}
+
+	middleware.LogActivity(c, "CANCEL", "scheduled_jobs", uint(id), nil)
+	return c.JSON(fiber.Map{"message": "Job cancelled"})
+}
+
+// CancelSessionJobs cancels all pending jobs for a session
+func (jc *JobController) CancelSessionJobs(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	cancelled, err := services.NewJobQueue().CancelJobsForSession(uint(sessionID))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to cancel jobs"})
+	}
+
+	middleware.LogActivity(c, "CANCEL", "scheduled_jobs", uint(sessionID), fiber.Map{
+		"scope":     "session",
+		"cancelled": cancelled,
+	})
+	return c.JSON(fiber.Map{
+		"message":   "Pending jobs cancelled",
+		"cancelled": cancelled,
+	})
+}
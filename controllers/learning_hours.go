@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type LearningHoursController struct{}
+
+// loadLedgerStudent loads the student from :id; students may only access their own ledger
+func loadLedgerStudent(c *fiber.Ctx) (*models.Student, error) {
+	studentID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid student ID"})
+	}
+	var student models.Student
+	if err := database.DB.First(&student, studentID).Error; err != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
+	}
+	if c.Locals("role").(string) == "student" {
+		userID := c.Locals("user_id").(uint)
+		if student.UserID == nil || *student.UserID != userID {
+			return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+		}
+	}
+	return &student, nil
+}
+
+func learningHoursErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrLearningHoursInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process learning hours", "details": err.Error()})
+	}
+}
+
+// GetBalance returns purchased / consumed / refunded / forfeited hours and the balance
+func (lc *LearningHoursController) GetBalance(c *fiber.Ctx) error {
+	student, err := loadLedgerStudent(c)
+	if student == nil {
+		return err
+	}
+	balance, err := services.NewLearningHoursService().Balance(student.ID)
+	if err != nil {
+		return learningHoursErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{"balance": balance})
+}
+
+// GetHistory returns ledger entries (newest first)
+func (lc *LearningHoursController) GetHistory(c *fiber.Ctx) error {
+	student, err := loadLedgerStudent(c)
+	if student == nil {
+		return err
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	entries, total, err := services.NewLearningHoursService().History(student.ID, c.Query("type"), limit, offset)
+	if err != nil {
+		return learningHoursErrorResponse(c, err)
+	}
+
+	totalPages := (int(total) + limit - 1) / limit
+	return c.JSON(fiber.Map{
+		"entries":     entries,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": totalPages,
+	})
+}
+
+// PurchaseHours records bought hours (owner/admin)
+func (lc *LearningHoursController) PurchaseHours(c *fiber.Ctx) error {
+	return lc.postManualEntry(c, services.HoursPurchase)
+}
+
+// AdjustHours records a signed manual correction (owner/admin)
+func (lc *LearningHoursController) AdjustHours(c *fiber.Ctx) error {
+	return lc.postManualEntry(c, services.HoursAdjustment)
+}
+
+func (lc *LearningHoursController) postManualEntry(c *fiber.Ctx, entryType string) error {
+	student, err := loadLedgerStudent(c)
+	if student == nil {
+		return err
+	}
+
+	var req services.HoursPurchaseInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	svc := services.NewLearningHoursService()
+	userID := c.Locals("user_id").(uint)
+	var entry *models.LearningHourEntry
+	if entryType == services.HoursPurchase {
+		entry, err = svc.Purchase(student.ID, req, userID)
+	} else {
+		entry, err = svc.Adjust(student.ID, req, userID)
+	}
+	if err != nil {
+		return learningHoursErrorResponse(c, err)
+	}
+
+	middleware.LogActivity(c, "CREATE", "learning_hours", entry.ID, fiber.Map{
+		"student_id": student.ID,
+		"entry_type": entryType,
+		"hours":      entry.Hours,
+	})
+
+	balance, _ := svc.Balance(student.ID)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Learning hours recorded successfully",
+		"entry":   entry,
+		"balance": balance,
+	})
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type LeaveQuotaController struct{}
+
+func leaveQuotaErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrLeaveQuotaNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Leave quota not found"})
+	case errors.Is(err, services.ErrLeaveQuotaInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process leave quota", "details": err.Error()})
+	}
+}
+
+// GetPolicies lists leave quota policies
+func (lc *LeaveQuotaController) GetPolicies(c *fiber.Ctx) error {
+	policies, err := services.NewLeaveQuotaService().ListPolicies()
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{"policies": policies})
+}
+
+// CreatePolicy creates a policy, e.g. {"name": "Standard", "course_id": 3, "leaves": 2, "per_hours": 20}
+func (lc *LeaveQuotaController) CreatePolicy(c *fiber.Ctx) error {
+	var req services.LeaveQuotaPolicyInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	policy, err := services.NewLeaveQuotaService().CreatePolicy(req)
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "CREATE", "leave_quota_policies", policy.ID, policy)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Leave quota policy created successfully",
+		"policy":  policy,
+	})
+}
+
+// UpdatePolicy replaces a policy (active is unchanged when omitted); quotas already granted keep their base quota
+func (lc *LeaveQuotaController) UpdatePolicy(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid policy ID"})
+	}
+	var req services.LeaveQuotaPolicyInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	policy, err := services.NewLeaveQuotaService().UpdatePolicy(uint(id), req)
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "leave_quota_policies", policy.ID, policy)
+	return c.JSON(fiber.Map{
+		"message": "Leave quota policy updated successfully",
+		"policy":  policy,
+	})
+}
+
+func (lc *LeaveQuotaController) DeletePolicy(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid policy ID"})
+	}
+	if err := services.NewLeaveQuotaService().DeletePolicy(uint(id)); err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "DELETE", "leave_quota_policies", uint(id), nil)
+	return c.JSON(fiber.Map{"message": "Leave quota policy deleted successfully"})
+}
+
+// GetStudentQuotas returns the student's quota per enrollment (students: own only)
+func (lc *LeaveQuotaController) GetStudentQuotas(c *fiber.Ctx) error {
+	student, err := loadLedgerStudent(c)
+	if student == nil {
+		return err
+	}
+	quotas, err := services.NewLeaveQuotaService().ForStudent(student.ID)
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{"quotas": quotas})
+}
+
+// loadAccessibleQuota loads :id; students may only read their own quota
+func loadAccessibleQuota(c *fiber.Ctx) (*services.StudentLeaveQuotaView, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid quota ID"})
+	}
+	quota, err := services.NewLeaveQuotaService().Get(uint(id))
+	if err != nil {
+		return nil, leaveQuotaErrorResponse(c, err)
+	}
+	if c.Locals("role").(string) == "student" {
+		var student models.Student
+		if err := database.DB.Where("id = ? AND user_id = ?", quota.StudentID, c.Locals("user_id").(uint)).First(&student).Error; err != nil {
+			return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+		}
+	}
+	return quota, nil
+}
+
+// GetQuotaHistory returns grants, uses and adjustments of a quota
+func (lc *LeaveQuotaController) GetQuotaHistory(c *fiber.Ctx) error {
+	quota, err := loadAccessibleQuota(c)
+	if quota == nil {
+		return err
+	}
+	entries, err := services.NewLeaveQuotaService().History(quota.ID)
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"quota":   quota,
+		"history": entries,
+	})
+}
+
+// AdjustQuota adds or removes leaves manually (owner/admin)
+func (lc *LeaveQuotaController) AdjustQuota(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid quota ID"})
+	}
+	var req struct {
+		Amount int    `json:"amount"`
+		Notes  string `json:"notes"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	quota, err := services.NewLeaveQuotaService().Adjust(uint(id), req.Amount, req.Notes, c.Locals("user_id").(uint))
+	if err != nil {
+		return leaveQuotaErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "student_leave_quotas", quota.ID, fiber.Map{
+		"amount": req.Amount,
+		"notes":  req.Notes,
+	})
+	return c.JSON(fiber.Map{
+		"message": "Leave quota adjusted successfully",
+		"quota":   quota,
+	})
+}
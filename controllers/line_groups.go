@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LineGroupController lets admins review and fix LINE group ↔ Group matches
+type LineGroupController struct{}
+
+func lineGroupErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrLineGroupNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "LINE group not found"})
+	case errors.Is(err, services.ErrGroupNotFound):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Group not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update LINE group", "details": err.Error()})
+	}
+}
+
+func lineGroupIDParam(c *fiber.Ctx) (uint, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	return uint(id), err
+}
+
+// GetLineGroups lists LINE groups with their matched Group
+// Query: status=matched|unmatched|locked, active=true|false
+func (lc *LineGroupController) GetLineGroups(c *fiber.Ctx) error {
+	query := database.DB.Preload("MatchedGroup")
+	switch c.Query("status") {
+	case "":
+	case "matched":
+		query = query.Where("matched_group_id IS NOT NULL")
+	case "unmatched":
+		query = query.Where("matched_group_id IS NULL")
+	case "locked":
+		query = query.Where("match_locked = ?", true)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be matched, unmatched or locked"})
+	}
+	if active := c.Query("active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+
+	var lineGroups []models.LineGroup
+	if err := query.Order("last_joined_at DESC").Find(&lineGroups).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch LINE groups"})
+	}
+	return c.JSON(fiber.Map{"line_groups": lineGroups, "total": len(lineGroups)})
+}
+
+// GetUnmatchedLineGroups lists active LINE groups without a Group and fuzzy-ranked candidates
+// Query: limit = candidates per LINE group (default 5)
+func (lc *LineGroupController) GetUnmatchedLineGroups(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "5"))
+	if limit < 1 || limit > 20 {
+		limit = 5
+	}
+	unmatched, err := services.NewLineGroupMatcher().Unmatched(limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch unmatched LINE groups"})
+	}
+	return c.JSON(fiber.Map{"line_groups": unmatched, "total": len(unmatched)})
+}
+
+// LinkLineGroup binds a LINE group to a Group by hand (locked against the nightly matcher)
+func (lc *LineGroupController) LinkLineGroup(c *fiber.Ctx) error {
+	id, err := lineGroupIDParam(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid LINE group ID"})
+	}
+	var req struct {
+		GroupID uint `json:"group_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.GroupID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "group_id is required"})
+	}
+
+	lg, err := services.NewLineGroupMatcher().Link(id, req.GroupID, c.Locals("user_id").(uint))
+	if err != nil {
+		return lineGroupErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "line_groups", lg.ID, fiber.Map{"matched_group_id": req.GroupID})
+	return c.JSON(fiber.Map{"message": "LINE group linked successfully", "line_group": lg})
+}
+
+// UnlinkLineGroup removes the match. Query lock=false lets the matcher re-link it later (default: locked).
+func (lc *LineGroupController) UnlinkLineGroup(c *fiber.Ctx) error {
+	id, err := lineGroupIDParam(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid LINE group ID"})
+	}
+	lock := c.Query("lock", "true") != "false"
+
+	lg, err := services.NewLineGroupMatcher().Unlink(id, c.Locals("user_id").(uint), lock)
+	if err != nil {
+		return lineGroupErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "line_groups", lg.ID, fiber.Map{"matched_group_id": nil, "match_locked": lock})
+	return c.JSON(fiber.Map{"message": "LINE group unlinked successfully", "line_group": lg})
+}
+
+// SetLineGroupLock locks / unlocks a LINE group's match against the automatic matcher
+func (lc *LineGroupController) SetLineGroupLock(c *fiber.Ctx) error {
+	id, err := lineGroupIDParam(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid LINE group ID"})
+	}
+	var req struct {
+		Locked *bool `json:"locked"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Locked == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "locked is required"})
+	}
+
+	lg, err := services.NewLineGroupMatcher().SetLocked(id, *req.Locked)
+	if err != nil {
+		return lineGroupErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "line_groups", lg.ID, fiber.Map{"match_locked": *req.Locked})
+	return c.JSON(fiber.Map{"message": "LINE group lock updated", "line_group": lg})
+}
+
+// RunLineGroupMatcher runs the automatic exact-name matcher now
+func (lc *LineGroupController) RunLineGroupMatcher(c *fiber.Ctx) error {
+	services.NewLineGroupMatcher().MatchLineGroupsToGroups()
+	middleware.LogActivity(c, "UPDATE", "line_groups", 0, fiber.Map{"action": "auto_match"})
+	return c.JSON(fiber.Map{"message": "LINE group matching completed"})
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type LineLinkController struct{}
+
+// CreateLinkCode issues a one-time code; the user sends it to the LINE OA to link their account
+func (lc *LineLinkController) CreateLinkCode(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	link, err := services.NewLineLinkService().IssueCode(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create link code"})
+	}
+	middleware.LogActivity(c, "CREATE", "line_link_codes", link.ID, nil)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"code":            link.Code,
+		"expires_at":      link.ExpiresAt,
+		"line_oa_url":     os.Getenv("LINE_OA_URL"),
+		"instructions":    "Add the LINE Official Account and send this code in the chat.",
+		"instructions_th": "เพิ่มเพื่อน LINE OA แล้วส่งรหัสนี้ในแชต",
+	})
+}
+
+// GetMyLink returns the LINE link status of the current user
+func (lc *LineLinkController) GetMyLink(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	return c.JSON(fiber.Map{"link": services.LineLinkStatusOf(user)})
+}
+
+func unlinkLineUser(c *fiber.Ctx, userID uint) error {
+	if err := services.NewLineLinkService().Unlink(userID); err != nil {
+		if errors.Is(err, services.ErrLineLinkNotLinked) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "LINE account is not linked"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to unlink LINE account"})
+	}
+	middleware.LogActivity(c, "UPDATE", "users", userID, fiber.Map{"line": "unlinked"})
+	return c.JSON(fiber.Map{"message": "LINE account unlinked successfully"})
+}
+
+// UnlinkMe removes the current user's LINE link
+func (lc *LineLinkController) UnlinkMe(c *fiber.Ctx) error {
+	return unlinkLineUser(c, c.Locals("user_id").(uint))
+}
+
+// UnlinkUser removes a user's LINE link (owner/admin)
+func (lc *LineLinkController) UnlinkUser(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+	return unlinkLineUser(c, uint(id))
+}
+
+// GetLinks lists users with their LINE link status (owner/admin)
+// Query: status=linked|unreachable|unlinked, role, branch_id, page, limit
+func (lc *LineLinkController) GetLinks(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := database.DB.Model(&models.User{}).Where("status = ?", "active")
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		query = query.Where("branch_id = ?", branchID)
+	}
+	switch c.Query("status") {
+	case "":
+	case services.LineLinkLinked:
+		query = query.Where("line_user_id IS NOT NULL AND line_unfollowed_at IS NULL")
+	case services.LineLinkUnreachable:
+		query = query.Where("line_user_id IS NOT NULL AND line_unfollowed_at IS NOT NULL")
+	case services.LineLinkUnlinked:
+		query = query.Where("line_user_id IS NULL")
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be linked, unreachable or unlinked"})
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var users []models.User
+	if err := query.Order("id ASC").Offset((page - 1) * limit).Limit(limit).Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch LINE links"})
+	}
+
+	links := make([]fiber.Map, 0, len(users))
+	for _, u := range users {
+		links = append(links, fiber.Map{
+			"user_id":   u.ID,
+			"username":  u.Username,
+			"role":      u.Role,
+			"branch_id": u.BranchID,
+			"link":      services.LineLinkStatusOf(u),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"links":      links,
+		"pagination": fiber.Map{"page": page, "limit": limit, "total": total},
+	})
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"errors"
+
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type LineTemplateController struct{}
+
+type lineTemplateRequest struct {
+	Name         string `json:"name"`
+	AltText      string `json:"alt_text"`
+	FlexJSON     string `json:"flex_json"`
+	TextFallback string `json:"text_fallback"`
+	Active       *bool  `json:"active"`
+}
+
+func (r lineTemplateRequest) toModel(key string) models.LineMessageTemplate {
+	tpl := models.LineMessageTemplate{Key: key, Name: r.Name, AltText: r.AltText, FlexJSON: r.FlexJSON, TextFallback: r.TextFallback, Active: true}
+	if r.Active != nil {
+		tpl.Active = *r.Active
+	}
+	return tpl
+}
+
+func lineTemplateErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrLineTemplateNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "LINE template not found"})
+	case errors.Is(err, services.ErrLineTemplateInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process LINE template", "details": err.Error()})
+	}
+}
+
+// GetTemplates lists the LINE templates with their variables
+func (lc *LineTemplateController) GetTemplates(c *fiber.Ctx) error {
+	templates, err := services.NewLineTemplateService().List()
+	if err != nil {
+		return lineTemplateErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"templates":   templates,
+		"definitions": services.LineTemplateDefinitions(),
+	})
+}
+
+// GetTemplate returns the current template of a key
+func (lc *LineTemplateController) GetTemplate(c *fiber.Ctx) error {
+	tpl, err := services.NewLineTemplateService().Get(c.Params("key"))
+	if err != nil {
+		return lineTemplateErrorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{"template": tpl})
+}
+
+// UpdateTemplate validates (variables + Flex JSON) and stores the template of a key
+func (lc *LineTemplateController) UpdateTemplate(c *fiber.Ctx) error {
+	var req lineTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	tpl, err := services.NewLineTemplateService().Save(req.toModel(c.Params("key")), c.Locals("user_id").(uint))
+	if err != nil {
+		return lineTemplateErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "UPDATE", "line_message_templates", tpl.ID, fiber.Map{"key": tpl.Key})
+	return c.JSON(fiber.Map{
+		"message":  "LINE template updated successfully",
+		"template": tpl,
+	})
+}
+
+// ResetTemplate drops the stored template so the built-in default is used again
+func (lc *LineTemplateController) ResetTemplate(c *fiber.Ctx) error {
+	key := c.Params("key")
+	if err := services.NewLineTemplateService().Reset(key); err != nil {
+		return lineTemplateErrorResponse(c, err)
+	}
+	middleware.LogActivity(c, "DELETE", "line_message_templates", 0, fiber.Map{"key": key})
+	return c.JSON(fiber.Map{"message": "LINE template reset to default"})
+}
+
+// PreviewTemplate renders a template with sample (or given) variables.
+// Body is optional: template fields preview unsaved changes, "variables" override the samples.
+func (lc *LineTemplateController) PreviewTemplate(c *fiber.Ctx) error {
+	key := c.Params("key")
+	var req struct {
+		lineTemplateRequest
+		Variables map[string]string `json:"variables"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	ts := services.NewLineTemplateService()
+	tpl, err := ts.Get(key)
+	if err != nil {
+		return lineTemplateErrorResponse(c, err)
+	}
+	if req.TextFallback != "" || req.FlexJSON != "" || req.AltText != "" {
+		draft := req.lineTemplateRequest.toModel(key)
+		if _, err := ts.Validate(draft); err != nil {
+			return lineTemplateErrorResponse(c, err)
+		}
+		tpl = &draft
+	}
+
+	vars := map[string]string{}
+	for _, def := range services.LineTemplateDefinitions() {
+		if def.Key == key {
+			for k, v := range def.Sample {
+				vars[k] = v
+			}
+		}
+	}
+	for k, v := range req.Variables {
+		vars[k] = v
+	}
+	return c.JSON(fiber.Map{
+		"key":       key,
+		"variables": vars,
+		"preview":   services.RenderLineTemplate(*tpl, vars),
+	})
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/middleware"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LineWebhookEventController lets admins inspect and replay persisted LINE webhook events
+type LineWebhookEventController struct{}
+
+// ListEvents lists stored events, filtered by status, event_type and source_id
+func (lc *LineWebhookEventController) ListEvents(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	events, total, err := services.NewLineWebhookEventService().List(services.ListLineEventsFilter{
+		Status:    c.Query("status"),
+		EventType: c.Query("event_type"),
+		SourceID:  c.Query("source_id"),
+		Limit:     limit,
+		Offset:    (page - 1) * limit,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve LINE webhook events"})
+	}
+
+	return c.JSON(fiber.Map{
+		"events":      events,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// GetEvent returns one stored event with its payload
+func (lc *LineWebhookEventController) GetEvent(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid event ID"})
+	}
+	ev, err := services.NewLineWebhookEventService().Get(uint(id))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "LINE webhook event not found"})
+	}
+	return c.JSON(fiber.Map{"event": ev})
+}
+
+// ReplayEvent re-processes a failed event right away
+func (lc *LineWebhookEventController) ReplayEvent(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid event ID"})
+	}
+	ev, err := services.NewLineWebhookEventService().Replay(uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrLineEventNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "LINE webhook event not found or not failed"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to replay LINE webhook event"})
+	}
+	middleware.LogActivity(c, "REPLAY", "line_webhook_events", ev.ID, fiber.Map{"status": ev.Status})
+	return c.JSON(fiber.Map{"message": "LINE webhook event replayed", "event": ev})
+}
+
+// ReplayFailedEvents re-queues every failed event for the worker
+func (lc *LineWebhookEventController) ReplayFailedEvents(c *fiber.Ctx) error {
+	count, err := services.NewLineWebhookEventService().ReplayFailed()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to replay LINE webhook events"})
+	}
+	middleware.LogActivity(c, "REPLAY", "line_webhook_events", 0, fiber.Map{"count": count})
+	return c.JSON(fiber.Map{"message": "Failed LINE webhook events re-queued", "count": count})
+}
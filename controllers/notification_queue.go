@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/middleware"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// deadLetterSelection picks dead-lettered notifications by id, or all of them
+type deadLetterSelection struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+// GetQueueStats returns Redis notification queue depth, oldest pending age and counters
+func (nc *NotificationController) GetQueueStats(c *fiber.Ctx) error {
+	stats, err := notifsvc.NewService().QueueStats(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to read notification queue"})
+	}
+	return c.JSON(stats)
+}
+
+// GetDeadLetters lists notifications that failed every queue attempt
+func (nc *NotificationController) GetDeadLetters(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	items, total, err := notifsvc.NewService().ListDeadLetters(c.Context(), (page-1)*limit, limit)
+	if err != nil {
+		return queueError(c, err, "Failed to read dead-lettered notifications")
+	}
+	return c.JSON(fiber.Map{
+		"dead_letters": items,
+		"pagination":   fiber.Map{"page": page, "limit": limit, "total": total},
+	})
+}
+
+// RequeueDeadLetters puts dead-lettered notifications back on the queue. Body: {"ids": [...]} or {"all": true}
+func (nc *NotificationController) RequeueDeadLetters(c *fiber.Ctx) error {
+	sel, err := parseDeadLetterSelection(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	count, err := notifsvc.NewService().RequeueDeadLetters(c.Context(), sel.IDs)
+	if err != nil {
+		return queueError(c, err, "Failed to requeue notifications")
+	}
+	middleware.LogActivity(c, "REQUEUE", "notification_queue", 0, fiber.Map{"ids": sel.IDs, "all": sel.All, "count": count})
+	return c.JSON(fiber.Map{"message": "Notifications requeued", "count": count})
+}
+
+// PurgeDeadLetters deletes dead-lettered notifications. Body: {"ids": [...]} or {"all": true}
+func (nc *NotificationController) PurgeDeadLetters(c *fiber.Ctx) error {
+	sel, err := parseDeadLetterSelection(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	count, err := notifsvc.NewService().PurgeDeadLetters(c.Context(), sel.IDs)
+	if err != nil {
+		return queueError(c, err, "Failed to purge notifications")
+	}
+	middleware.LogActivity(c, "PURGE", "notification_queue", 0, fiber.Map{"ids": sel.IDs, "all": sel.All, "count": count})
+	return c.JSON(fiber.Map{"message": "Notifications purged", "count": count})
+}
+
+// parseDeadLetterSelection requires either ids or all=true so an empty body never touches every entry
+func parseDeadLetterSelection(c *fiber.Ctx) (deadLetterSelection, error) {
+	var sel deadLetterSelection
+	if err := c.BodyParser(&sel); err != nil {
+		return sel, errors.New("Invalid request body")
+	}
+	if sel.All {
+		sel.IDs = nil
+		return sel, nil
+	}
+	if len(sel.IDs) == 0 {
+		return sel, errors.New("Provide ids or set all to true")
+	}
+	return sel, nil
+}
+
+func queueError(c *fiber.Ctx, err error, msg string) error {
+	if errors.Is(err, notifsvc.ErrQueueDisabled) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Redis notification queue is disabled"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": msg})
+}
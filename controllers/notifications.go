@@ -147,7 +147,8 @@ func (nc *NotificationController) GetNotification(c *fiber.Ctx) error {
 	})
 }
 
-// CreateNotification creates a new notification (admin only)
+// CreateNotification creates a new notification (admin only).
+// External channels (line, email, sms) are delivered by the notification service with per-recipient records.
 func (nc *NotificationController) CreateNotification(c *fiber.Ctx) error { //nolint:gocognit,gocyclo
 	var req struct {
 		UserID    uint     `json:"user_id"`
@@ -159,7 +160,7 @@ func (nc *NotificationController) CreateNotification(c *fiber.Ctx) error { //nol
 		Message   string   `json:"message" validate:"required"`
 		MessageTh string   `json:"message_th"`
 		Type      string   `json:"type" validate:"required"`
-		Channels  []string `json:"channels"` // e.g., ["normal","popup","line"]
+		Channels  []string `json:"channels"` // e.g., ["normal","popup","line","email"]
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -231,21 +232,6 @@ func (nc *NotificationController) CreateNotification(c *fiber.Ctx) error { //nol
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create notifications"})
 	}
 
-	// Optional LINE delivery when requested
-	if containsChannel(req.Channels, "line") {
-		lineSvc := services.NewLineMessagingService()
-		// fetch users to get LineID
-		var users []models.User
-		database.DB.Where("id IN ?", userIDs).Find(&users)
-		for _, u := range users {
-			if u.LineID != "" {
-				if err := lineSvc.SendLineMessageToUser(u.LineID, buildLineMessage(req.Title, req.TitleTh, req.Message, req.MessageTh)); err != nil {
-					log.Printf("LINE push failed for user %d: %v", u.ID, err)
-				}
-			}
-		}
-	}
-
 	// Log activity
 	middleware.LogActivity(c, "CREATE", "notifications", 0, fiber.Map{
 		"target_users": len(userIDs),
@@ -260,27 +246,6 @@ func (nc *NotificationController) CreateNotification(c *fiber.Ctx) error { //nol
 	})
 }
 
-// containsChannel checks if list contains the given channel
-func containsChannel(channels []string, target string) bool {
-	for _, c := range channels {
-		if c == target {
-			return true
-		}
-	}
-	return false
-}
-
-// buildLineMessage builds a simple message combining Thai/English if present
-func buildLineMessage(titleEn, titleTh, msgEn, msgTh string) string {
-	if titleTh != "" || msgTh != "" {
-		if titleEn != "" || msgEn != "" {
-			return titleTh + "\n" + msgTh + "\n\n" + titleEn + "\n" + msgEn
-		}
-		return titleTh + "\n" + msgTh
-	}
-	return titleEn + "\n" + msgEn
-}
-
 // MarkAsRead marks a notification as read
 func (nc *NotificationController) MarkAsRead(c *fiber.Ctx) error {
 	user, err := middleware.GetCurrentUser(c)
@@ -432,6 +397,38 @@ func (nc *NotificationController) GetNotificationStats(c *fiber.Ctx) error {
 	})
 }
 
+// GetDeliveries lists per-recipient delivery records of external channels (admin only)
+// Query: notification_id, user_id, student_id, channel, status, page, limit
+func (nc *NotificationController) GetDeliveries(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := database.DB.Model(&models.NotificationDelivery{})
+	for _, key := range []string{"notification_id", "user_id", "student_id", "channel", "status"} {
+		if v := c.Query(key); v != "" {
+			query = query.Where(key+" = ?", v)
+		}
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var deliveries []models.NotificationDelivery
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&deliveries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch deliveries"})
+	}
+	return c.JSON(fiber.Map{
+		"deliveries": deliveries,
+		"pagination": fiber.Map{"page": page, "limit": limit, "total": total},
+	})
+}
+
 // TestWebSocketPopup sends test popup notifications with various scenarios
 // GET /api/notifications/test/popup?user_id=X&case=scenario
 func (nc *NotificationController) TestWebSocketPopup(c *fiber.Ctx) error {
@@ -0,0 +1,444 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// BulkEditScheduleRequest changes a running schedule from session N onward.
+// Only the fields that are sent are changed.
+type BulkEditScheduleRequest struct {
+	FromSession      int               `json:"from_session"`
+	DefaultTeacherID *uint             `json:"default_teacher_id,omitempty"`
+	DefaultRoomID    *uint             `json:"default_room_id,omitempty"`
+	SessionTimes     []SessionTimeSlot `json:"session_times,omitempty"`     // new weekday/time slots (dates are regenerated)
+	HoursPerSession  int               `json:"hours_per_session,omitempty"` // new length (dates are kept unless session_times is sent)
+	StartDate        *time.Time        `json:"start_date,omitempty"`        // first date for regenerated slots (default: date of session N)
+	DryRun           bool              `json:"dry_run"`
+	Force            bool              `json:"force"` // apply even when the conflict report is not empty
+}
+
+type SessionSnapshot struct {
+	Date      string `json:"date"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	TeacherID *uint  `json:"teacher_id,omitempty"`
+	RoomID    *uint  `json:"room_id,omitempty"`
+	Status    string `json:"status"`
+}
+
+type SessionDiff struct {
+	SessionID     uint            `json:"session_id"`
+	SessionNumber int             `json:"session_number"`
+	Old           SessionSnapshot `json:"old"`
+	New           SessionSnapshot `json:"new"`
+	Changes       []string        `json:"changes"` // date, time, teacher, room, status
+}
+
+// Sessions in these statuses are history and are never edited in bulk
+var bulkEditLockedStatuses = map[string]bool{
+	"completed":   true,
+	"cancelled":   true,
+	"rescheduled": true,
+	"no-show":     true,
+}
+
+// splitBulkEditSessions splits a schedule's sessions into the ones rewritten by a bulk edit from
+// session N (affected) and the ones left as they are (kept): sessions before N, and history
+// (completed/cancelled/...) or sessions without a time from N on, counted in locked
+func splitBulkEditSessions(sessions []models.Schedule_Sessions, fromSession int) (affected, kept []models.Schedule_Sessions, locked int) {
+	for _, s := range sessions {
+		if s.Session_number < fromSession {
+			kept = append(kept, s)
+			continue
+		}
+		if bulkEditLockedStatuses[s.Status] || s.Session_date == nil || s.Start_time == nil || s.End_time == nil {
+			kept = append(kept, s)
+			locked++
+			continue
+		}
+		affected = append(affected, s)
+	}
+	return affected, kept, locked
+}
+
+func snapshotSession(s models.Schedule_Sessions) SessionSnapshot {
+	return SessionSnapshot{
+		Date:      formatSessionDate(s.Session_date),
+		StartTime: formatSessionTime(s.Start_time),
+		EndTime:   formatSessionTime(s.End_time),
+		TeacherID: s.AssignedTeacherID,
+		RoomID:    s.RoomID,
+		Status:    s.Status,
+	}
+}
+
+func uintPtrEqual(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func diffSessionSnapshots(oldSnap, newSnap SessionSnapshot) []string {
+	var changes []string
+	if oldSnap.Date != newSnap.Date {
+		changes = append(changes, "date")
+	}
+	if oldSnap.StartTime != newSnap.StartTime || oldSnap.EndTime != newSnap.EndTime {
+		changes = append(changes, "time")
+	}
+	if !uintPtrEqual(oldSnap.TeacherID, newSnap.TeacherID) {
+		changes = append(changes, "teacher")
+	}
+	if !uintPtrEqual(oldSnap.RoomID, newSnap.RoomID) {
+		changes = append(changes, "room")
+	}
+	if oldSnap.Status != newSnap.Status {
+		changes = append(changes, "status")
+	}
+	return changes
+}
+
+// BulkEditSchedule - POST /api/schedules/:id/bulk-edit (owner/admin)
+// Changes teacher, room, slots or hours per session from session N onward. With dry_run the
+// response is only a diff + conflict report; otherwise everything is applied in one transaction.
+func (sc *ScheduleController) BulkEditSchedule(c *fiber.Ctx) error {
+	scheduleID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid schedule ID"})
+	}
+
+	var req BulkEditScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.FromSession <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from_session must be greater than zero"})
+	}
+	if req.DefaultTeacherID == nil && req.DefaultRoomID == nil && len(req.SessionTimes) == 0 && req.HoursPerSession == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "nothing to change: send default_teacher_id, default_room_id, session_times or hours_per_session"})
+	}
+	if req.HoursPerSession < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "hours_per_session must be greater than zero"})
+	}
+
+	var schedule models.Schedules
+	if err := database.DB.First(&schedule, scheduleID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+	if schedule.Status == "cancelled" || schedule.Status == "completed" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot edit a cancelled or completed schedule"})
+	}
+
+	if req.DefaultTeacherID != nil {
+		var teacher models.User
+		if err := database.DB.Where("id = ? AND role IN ?", *req.DefaultTeacherID, []string{"teacher", "admin", "owner"}).First(&teacher).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Default teacher not found or not authorized to teach"})
+		}
+	}
+	if req.DefaultRoomID != nil {
+		if err := database.DB.First(&models.Room{}, *req.DefaultRoomID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Room not found"})
+		}
+	}
+
+	var group *models.Group
+	var branch *models.Branch
+	if schedule.GroupID != nil {
+		var g models.Group
+		if err := database.DB.Preload("Members").Preload("Course.Branch").First(&g, *schedule.GroupID).Error; err == nil {
+			group = &g
+			if g.Course.Branch.ID != 0 {
+				branch = &g.Course.Branch
+			}
+		}
+	}
+
+	var sessions []models.Schedule_Sessions
+	if err := database.DB.Where("schedule_id = ?", schedule.ID).Order("session_date ASC, start_time ASC").Find(&sessions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sessions"})
+	}
+
+	affected, kept, lockedCount := splitBulkEditSessions(sessions, req.FromSession)
+	if len(affected) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("no editable sessions from session %d onward", req.FromSession)})
+	}
+
+	hoursPerSession := schedule.Hours_per_session
+	if req.HoursPerSession > 0 {
+		hoursPerSession = req.HoursPerSession
+	}
+	openMinutes, closeMinutes, err := resolveBranchHours(branch)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	branchHours := services.BranchHours{OpenMinutes: openMinutes, CloseMinutes: closeMinutes}
+
+	// Build the new version of every affected session (same IDs, same order)
+	updated := copySessions(affected)
+	if len(req.SessionTimes) > 0 {
+		slots := make([]services.SessionSlot, 0, len(req.SessionTimes))
+		seenWeekdays := make(map[int]struct{}, len(req.SessionTimes))
+		for _, slot := range req.SessionTimes {
+			if slot.Weekday < 0 || slot.Weekday > 6 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_times.weekday must be between 0 (Sunday) and 6 (Saturday)"})
+			}
+			if _, exists := seenWeekdays[slot.Weekday]; exists {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_times contains duplicate weekdays"})
+			}
+			seenWeekdays[slot.Weekday] = struct{}{}
+			hour, minute, err := parseHourMinute(slot.StartTime)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("invalid start_time in session_times: %v", err)})
+			}
+			slots = append(slots, services.SessionSlot{Weekday: time.Weekday(slot.Weekday), StartHour: hour, StartMinute: minute})
+		}
+
+		anchor := *affected[0].Session_date
+		if req.StartDate != nil && !req.StartDate.IsZero() {
+			anchor = *req.StartDate
+		}
+		// "today" is the school's day, not the server's
+		bangkokLoc, _ := time.LoadLocation("Asia/Bangkok")
+		now := time.Now().In(bangkokLoc)
+		if today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, bangkokLoc); anchor.Before(today) {
+			anchor = today // never generate into the past
+		}
+
+		// The new slots replace the RRULE/preset weekdays; bi-weekly/monthly/yearly spacing is kept
+		generator := schedule
+		generator.Start_date = anchor
+		generator.Recurring_pattern = slotPattern(schedule.Recurring_pattern)
+		generator.RecurrenceRule = ""
+		generated, err := services.GenerateScheduleSessionsWithSlots(generator, slots, len(affected), hoursPerSession, branchHours)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if schedule.Auto_Reschedule_holiday {
+			if rescheduled, err := services.RescheduleSessionsForBranch(generated, services.ResolveBranchID(database.DB, services.BranchLookup{
+				RoomID: req.DefaultRoomID, DefaultRoomID: schedule.DefaultRoomID, GroupID: schedule.GroupID, Group: group,
+			})); err == nil {
+				generated = rescheduled
+			}
+		}
+		for i := range updated {
+			updated[i].Session_date = generated[i].Session_date
+			updated[i].Start_time = generated[i].Start_time
+			updated[i].End_time = generated[i].End_time
+		}
+	} else if req.HoursPerSession > 0 {
+		for i := range updated {
+			start := *updated[i].Start_time
+			startMinutes := start.Hour()*60 + start.Minute()
+			if startMinutes+hoursPerSession*60 > branchHours.CloseMinutes {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("session %d would end after branch closing time", updated[i].Session_number)})
+			}
+			end := start.Add(time.Duration(hoursPerSession) * time.Hour)
+			updated[i].End_time = &end
+		}
+	}
+
+	timingChanged := len(req.SessionTimes) > 0 || req.HoursPerSession > 0
+	for i := range updated {
+		if req.DefaultTeacherID != nil {
+			updated[i].AssignedTeacherID = req.DefaultTeacherID
+		}
+		if req.DefaultRoomID != nil {
+			updated[i].RoomID = req.DefaultRoomID
+		}
+		// A confirmation was for the old teacher/time: ask again
+		teacherChanged := !uintPtrEqual(affected[i].AssignedTeacherID, updated[i].AssignedTeacherID)
+		if (timingChanged || teacherChanged) && schedule.ScheduleType == "class" && updated[i].Status == "confirmed" {
+			updated[i].Status = "assigned"
+			updated[i].ConfirmedAt = nil
+			updated[i].ConfirmedByUserID = nil
+		}
+	}
+	// Week numbers follow the new dates; session numbers of the run stay as they are.
+	// ReindexSessions sorts in place, so map the results back by ID to keep affected[i] <-> updated[i].
+	reindexed := copySessions(updated)
+	services.ReindexSessions(reindexed, schedule.Start_date)
+	weekByID := make(map[uint]int, len(reindexed))
+	for _, s := range reindexed {
+		weekByID[s.ID] = s.Week_number
+	}
+	for i := range updated {
+		updated[i].Week_number = weekByID[updated[i].ID]
+	}
+
+	diff := make([]SessionDiff, 0, len(updated))
+	for i := range updated {
+		oldSnap := snapshotSession(affected[i])
+		newSnap := snapshotSession(updated[i])
+		changes := diffSessionSnapshots(oldSnap, newSnap)
+		if len(changes) == 0 {
+			continue
+		}
+		diff = append(diff, SessionDiff{
+			SessionID:     affected[i].ID,
+			SessionNumber: affected[i].Session_number,
+			Old:           oldSnap,
+			New:           newSnap,
+			Changes:       changes,
+		})
+	}
+
+	// Conflicts of the new tail against every other schedule (this schedule is excluded,
+	// and group_id is left out so the group's own active schedule is not reported)
+	effectiveTeacher := schedule.DefaultTeacherID
+	if req.DefaultTeacherID != nil {
+		effectiveTeacher = req.DefaultTeacherID
+	}
+	effectiveRoom := schedule.DefaultRoomID
+	if req.DefaultRoomID != nil {
+		effectiveRoom = req.DefaultRoomID
+	}
+	var participantIDs []uint
+	database.DB.Model(&models.ScheduleParticipant{}).Where("schedule_id = ?", schedule.ID).Pluck("user_id", &participantIDs)
+	conflictReq := CreateScheduleRequest{
+		ScheduleType:       schedule.ScheduleType,
+		DefaultTeacherID:   effectiveTeacher,
+		DefaultRoomID:      effectiveRoom,
+		ParticipantUserIDs: participantIDs,
+	}
+	excludeID := schedule.ID
+	minDate, maxDate := getSessionDateRange(updated)
+	report, err := generateScheduleConflictReport(conflictReq, group, updated, minDate, maxDate, &excludeID, conflictReportOptions{IncludeStudentConflicts: schedule.ScheduleType == "class"})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("failed to evaluate conflicts: %v", err)})
+	}
+	hasConflicts := len(report.RoomConflicts) > 0 || len(report.TeacherConflicts) > 0 ||
+		len(report.ParticipantConflicts) > 0 || len(report.StudentConflicts) > 0
+
+	newEndDate := schedule.Estimated_end_date
+	for _, s := range append(append([]models.Schedule_Sessions{}, sessions...), updated...) {
+		if s.Session_date != nil && s.Session_date.After(newEndDate) {
+			newEndDate = *s.Session_date
+		}
+	}
+
+	response := fiber.Map{
+		"dry_run":         req.DryRun,
+		"schedule_id":     schedule.ID,
+		"from_session":    req.FromSession,
+		"affected":        len(affected),
+		"locked_sessions": lockedCount,
+		"changed":         len(diff),
+		"diff":            diff,
+		"conflicts":       report,
+		"has_conflicts":   hasConflicts,
+		"estimated_end":   newEndDate.Format("2006-01-02"),
+	}
+	if req.DryRun {
+		return c.JSON(response)
+	}
+	if hasConflicts && !req.Force {
+		response["error"] = "The new sessions conflict with other schedules; resolve them or send force=true"
+		return c.Status(fiber.StatusConflict).JSON(response)
+	}
+	if len(diff) == 0 {
+		response["message"] = "Nothing changed"
+		return c.JSON(response)
+	}
+
+	keptIDs := make([]uint, 0, len(kept))
+	for _, s := range kept {
+		keptIDs = append(keptIDs, s.ID)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Sessions that are not rewritten (before N, or history from N on) keep the old default
+		// teacher/room even after the schedule default changes
+		if len(keptIDs) > 0 && req.DefaultTeacherID != nil && schedule.DefaultTeacherID != nil && !uintPtrEqual(req.DefaultTeacherID, schedule.DefaultTeacherID) {
+			if err := tx.Model(&models.Schedule_Sessions{}).
+				Where("id IN ? AND assigned_teacher_id IS NULL", keptIDs).
+				Update("assigned_teacher_id", *schedule.DefaultTeacherID).Error; err != nil {
+				return err
+			}
+		}
+		if len(keptIDs) > 0 && req.DefaultRoomID != nil && schedule.DefaultRoomID != nil && !uintPtrEqual(req.DefaultRoomID, schedule.DefaultRoomID) {
+			if err := tx.Model(&models.Schedule_Sessions{}).
+				Where("id IN ? AND room_id IS NULL", keptIDs).
+				Update("room_id", *schedule.DefaultRoomID).Error; err != nil {
+				return err
+			}
+		}
+
+		for i := range updated {
+			if len(diffSessionSnapshots(snapshotSession(affected[i]), snapshotSession(updated[i]))) == 0 {
+				continue
+			}
+			if err := tx.Model(&models.Schedule_Sessions{}).Where("id = ?", updated[i].ID).Updates(map[string]interface{}{
+				"session_date":         updated[i].Session_date,
+				"start_time":           updated[i].Start_time,
+				"end_time":             updated[i].End_time,
+				"week_number":          updated[i].Week_number,
+				"assigned_teacher_id":  updated[i].AssignedTeacherID,
+				"room_id":              updated[i].RoomID,
+				"status":               updated[i].Status,
+				"confirmed_at":         updated[i].ConfirmedAt,
+				"confirmed_by_user_id": updated[i].ConfirmedByUserID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		scheduleUpdates := map[string]interface{}{"estimated_end_date": newEndDate}
+		if req.DefaultTeacherID != nil {
+			scheduleUpdates["default_teacher_id"] = *req.DefaultTeacherID
+		}
+		if req.DefaultRoomID != nil {
+			scheduleUpdates["default_room_id"] = *req.DefaultRoomID
+		}
+		if req.HoursPerSession > 0 && req.HoursPerSession != schedule.Hours_per_session {
+			scheduleUpdates["hours_per_session"] = req.HoursPerSession
+			scheduleUpdates["total_hours"] = schedule.Total_hours + len(affected)*(req.HoursPerSession-schedule.Hours_per_session)
+		}
+		if len(req.SessionTimes) > 0 {
+			scheduleUpdates["session_per_week"] = len(req.SessionTimes)
+			scheduleUpdates["recurring_pattern"] = slotPattern(schedule.Recurring_pattern)
+			scheduleUpdates["recurrence_rule"] = ""
+		}
+		return tx.Model(&models.Schedules{}).Where("id = ?", schedule.ID).Updates(scheduleUpdates).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to apply schedule changes"})
+	}
+
+	// Reminders follow the new time/teacher
+	if req.DefaultTeacherID != nil {
+		schedule.DefaultTeacherID = req.DefaultTeacherID
+	}
+	queue := services.NewJobQueue()
+	for _, d := range diff {
+		if _, err := queue.CancelJobsForSession(d.SessionID); err != nil {
+			fmt.Printf("warning: failed to cancel pending jobs for session %d: %v\n", d.SessionID, err)
+		}
+	}
+	for i := range updated {
+		services.ScheduleTeacherConfirmReminders(updated[i], schedule)
+	}
+
+	middleware.LogActivity(c, "BULK_EDIT", "schedules", schedule.ID, fiber.Map{
+		"from_session":       req.FromSession,
+		"changed_sessions":   len(diff),
+		"default_teacher_id": req.DefaultTeacherID,
+		"default_room_id":    req.DefaultRoomID,
+		"session_times":      req.SessionTimes,
+		"hours_per_session":  req.HoursPerSession,
+		"forced":             hasConflicts,
+	})
+
+	response["message"] = "Schedule updated"
+	return c.JSON(response)
+}
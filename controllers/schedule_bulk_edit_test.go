@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestSplitBulkEditSessionsKeepsHistoryAfterN(t *testing.T) {
+	start := time.Date(2025, 10, 20, 17, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	teacherID := uint(4)
+	session := func(id uint, number int, status string, teacher *uint) models.Schedule_Sessions {
+		s := models.Schedule_Sessions{Session_number: number, Status: status, Session_date: &start, Start_time: &start, End_time: &end, AssignedTeacherID: teacher}
+		s.ID = id
+		return s
+	}
+	sessions := []models.Schedule_Sessions{
+		session(1, 1, "completed", nil),
+		session(2, 2, "scheduled", nil),
+		session(3, 3, "completed", nil), // after N, no teacher of its own
+		session(4, 4, "scheduled", &teacherID),
+		session(5, 5, "cancelled", nil),
+		session(6, 6, "scheduled", nil),
+	}
+
+	affected, kept, locked := splitBulkEditSessions(sessions, 3)
+	ids := func(list []models.Schedule_Sessions) []uint {
+		out := make([]uint, 0, len(list))
+		for _, s := range list {
+			out = append(out, s.ID)
+		}
+		return out
+	}
+	if got := ids(affected); len(got) != 2 || got[0] != 4 || got[1] != 6 {
+		t.Fatalf("affected = %v, want [4 6]", got)
+	}
+	// the completed session 3 is not rewritten, so it must get the old default teacher pinned
+	// before the schedule default changes
+	if got := ids(kept); len(got) != 4 || got[0] != 1 || got[1] != 2 || got[2] != 3 || got[3] != 5 {
+		t.Fatalf("kept = %v, want [1 2 3 5]", got)
+	}
+	if locked != 2 {
+		t.Fatalf("locked = %d, want 2", locked)
+	}
+}
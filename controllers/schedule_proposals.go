@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProposeTimetableRequest - input of the timetable proposal solver
+type ProposeTimetableRequest struct {
+	GroupID         uint      `json:"group_id"`
+	BranchID        *uint     `json:"branch_id,omitempty"` // default: branch of the group's course
+	HoursPerSession int       `json:"hours_per_session"`
+	SessionPerWeek  int       `json:"session_per_week"`
+	TeacherID       *uint     `json:"teacher_id,omitempty"`
+	RoomID          *uint     `json:"room_id,omitempty"`
+	StartDate       time.Time `json:"start_date"`             // default: tomorrow
+	Weeks           int       `json:"weeks,omitempty"`        // conflict horizon, default 8
+	Weekdays        []int     `json:"weekdays,omitempty"`     // 0=Sunday ... 6=Saturday
+	StepMinutes     int       `json:"step_minutes,omitempty"` // 15, 30 or 60
+	TopK            int       `json:"top_k,omitempty"`
+}
+
+// ProposeTimetable - POST /api/schedules/proposals
+// เสนอชุดเวลาเรียนรายสัปดาห์ (top-k) สำหรับกลุ่มใหม่ พร้อมคะแนนและคำอธิบายแต่ละเงื่อนไข
+func (sc *ScheduleController) ProposeTimetable(c *fiber.Ctx) error {
+	var req ProposeTimetableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.GroupID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "group_id is required"})
+	}
+	if req.HoursPerSession <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "hours_per_session must be greater than zero"})
+	}
+	if req.SessionPerWeek <= 0 || req.SessionPerWeek > 7 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_per_week must be between 1 and 7"})
+	}
+	switch req.StepMinutes {
+	case 0:
+		req.StepMinutes = 30
+	case 15, 30, 60:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "step_minutes must be 15, 30 or 60"})
+	}
+	if req.Weeks <= 0 {
+		req.Weeks = 8
+	} else if req.Weeks > 26 {
+		req.Weeks = 26
+	}
+	if req.TopK <= 0 {
+		req.TopK = 5
+	} else if req.TopK > 20 {
+		req.TopK = 20
+	}
+
+	bangkokLoc, _ := time.LoadLocation("Asia/Bangkok")
+	if req.StartDate.IsZero() {
+		req.StartDate = time.Now().In(bangkokLoc).AddDate(0, 0, 1)
+	}
+
+	weekdays := make([]time.Weekday, 0, len(req.Weekdays))
+	for _, d := range req.Weekdays {
+		if d < 0 || d > 6 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "weekdays must be between 0 (Sunday) and 6 (Saturday)"})
+		}
+		weekdays = append(weekdays, time.Weekday(d))
+	}
+
+	var group models.Group
+	if err := database.DB.Preload("Members", "status = ?", "active").Preload("Members.Student").Preload("Course.Branch").
+		First(&group, req.GroupID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Group not found"})
+	}
+
+	var branch *models.Branch
+	if req.BranchID != nil && *req.BranchID != 0 {
+		var b models.Branch
+		if err := database.DB.First(&b, *req.BranchID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Branch not found"})
+		}
+		branch = &b
+	} else if group.Course.Branch.ID != 0 {
+		branch = &group.Course.Branch
+	}
+	open, close, err := resolveBranchHours(branch)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	hours := services.BranchHours{OpenMinutes: open, CloseMinutes: close}
+
+	members := make([]services.MemberAvailability, 0, len(group.Members))
+	for _, m := range group.Members {
+		members = append(members, services.ParseStudentAvailability(m.Student))
+	}
+
+	checks := make([]services.TimetableConflictCheck, 0, 3)
+	if req.TeacherID != nil && *req.TeacherID != 0 {
+		teacherID := *req.TeacherID
+		checks = append(checks, services.TimetableConflictCheck{
+			Name:  "teacher_conflicts",
+			Label: "teacher",
+			Check: func(sessions []models.Schedule_Sessions) (int, error) {
+				minDate, maxDate := getSessionDateRange(sessions)
+				detail, err := collectTeacherConflictDetail(&teacherID, sessions, minDate, maxDate, nil)
+				if err != nil || detail == nil {
+					return 0, err
+				}
+				return len(detail.Conflicts), nil
+			},
+		})
+	}
+	if req.RoomID != nil && *req.RoomID != 0 {
+		roomID := *req.RoomID
+		checks = append(checks, services.TimetableConflictCheck{
+			Name:  "room_conflicts",
+			Label: "room",
+			Check: func(sessions []models.Schedule_Sessions) (int, error) {
+				rows, err := findRoomConflicts(roomID, sessions, nil)
+				return len(rows), err
+			},
+		})
+	}
+	if len(group.Members) > 0 {
+		checks = append(checks, services.TimetableConflictCheck{
+			Name:  "member_schedules",
+			Label: "member class",
+			Check: func(sessions []models.Schedule_Sessions) (int, error) {
+				minDate, maxDate := getSessionDateRange(sessions)
+				details, err := collectStudentConflictDetails(&group, sessions, minDate, maxDate, nil)
+				return len(details), err
+			},
+		})
+	}
+
+	result, err := services.SolveTimetable(services.TimetableSolverInput{
+		Hours:           hours,
+		HoursPerSession: req.HoursPerSession,
+		SessionsPerWeek: req.SessionPerWeek,
+		StartDate:       req.StartDate,
+		HorizonWeeks:    req.Weeks,
+		StepMinutes:     req.StepMinutes,
+		Weekdays:        weekdays,
+		Members:         members,
+		Checks:          checks,
+		TopK:            req.TopK,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	response := fiber.Map{
+		"group_id":          group.ID,
+		"group_name":        group.GroupName,
+		"members":           len(members),
+		"branch_hours":      fiber.Map{"open": fmt.Sprintf("%02d:%02d", open/60, open%60), "close": fmt.Sprintf("%02d:%02d", close/60, close%60)},
+		"start_date":        req.StartDate.In(bangkokLoc).Format("2006-01-02"),
+		"weeks_checked":     req.Weeks,
+		"candidate_slots":   result.CandidateSlots,
+		"rejected_by_check": result.RejectedByCheck,
+		"proposals":         result.Proposals,
+	}
+	if len(result.Proposals) == 0 {
+		reasons := make([]string, 0, len(result.RejectedByCheck))
+		for name, n := range result.RejectedByCheck {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", name, n))
+		}
+		response["message"] = "No slot set satisfies the hard constraints"
+		if len(reasons) > 0 {
+			response["message"] = "No slot set satisfies the hard constraints (rejected " + strings.Join(reasons, ", ") + ")"
+		}
+	}
+
+	return c.JSON(response)
+}
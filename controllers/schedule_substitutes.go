@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type assignSubstituteRequest struct {
+	TeacherID uint   `json:"teacher_id"`
+	Reason    string `json:"reason"`
+	Force     bool   `json:"force"` // assign even if the teacher has an overlapping session
+}
+
+// GetSubstituteTeachers - GET /api/schedules/sessions/:id/substitutes
+// Ranks active teachers as replacements for a session: available teachers first, then by score.
+func (sc *ScheduleController) GetSubstituteTeachers(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+	onlyAvailable := c.Query("available_only", "false") == "true"
+
+	ranking, err := services.NewSubstituteService().RankSubstitutes(uint(sessionID), limit, onlyAvailable)
+	switch {
+	case errors.Is(err, services.ErrSubstituteSessionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	case errors.Is(err, services.ErrSubstituteNoTimeRange):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Session has no time range"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to rank substitute teachers"})
+	}
+	return c.JSON(ranking)
+}
+
+// AssignSubstituteTeacher - POST /api/schedules/sessions/:id/substitute
+// Sets AssignedTeacherID, puts the session back to 'assigned' for confirmation and notifies everyone involved.
+func (sc *ScheduleController) AssignSubstituteTeacher(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	var req assignSubstituteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.TeacherID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "teacher_id is required"})
+	}
+
+	substitutes := services.NewSubstituteService()
+	session, schedule, err := substitutes.LoadSession(uint(sessionID))
+	if errors.Is(err, services.ErrSubstituteSessionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load session"})
+	}
+	switch session.Status {
+	case "completed", "cancelled", "no-show":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot reassign a " + session.Status + " session"})
+	}
+
+	var teacher models.Teacher
+	if err := database.DB.Preload("User").Where("user_id = ? AND active = ?", req.TeacherID, true).First(&teacher).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Teacher not found or inactive"})
+	}
+
+	previous := services.ResponsibleTeacherID(*session, schedule)
+	if previous != nil && *previous == req.TeacherID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Teacher is already assigned to this session"})
+	}
+
+	conflicts, err := substitutes.TeacherConflicts([]uint{req.TeacherID}, *session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check teacher conflicts"})
+	}
+	conflict := conflicts[req.TeacherID]
+	if len(conflict) > 0 && !req.Force {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":     "Teacher has an overlapping session",
+			"conflicts": conflict,
+		})
+	}
+
+	var previousID *uint
+	if previous != nil {
+		id := *previous
+		previousID = &id
+	}
+
+	notes := session.Notes
+	if r := strings.TrimSpace(req.Reason); r != "" {
+		line := fmt.Sprintf("Substitute teacher assigned: %s", r)
+		if notes != "" {
+			notes += "\n"
+		}
+		notes += line
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.Model(session).Updates(map[string]interface{}{
+			"assigned_teacher_id":  req.TeacherID,
+			"status":               "assigned",
+			"confirmed_at":         nil,
+			"confirmed_by_user_id": nil,
+			"notes":                notes,
+		}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to assign substitute"})
+	}
+
+	// Old teacher's confirmation reminders are obsolete; schedule new ones for the substitute
+	if _, err := services.NewJobQueue().CancelJobsForSession(session.ID, services.JobTypeTeacherConfirmReminder); err != nil {
+		fmt.Printf("warning: failed to cancel reminder jobs for session %d: %v\n", session.ID, err)
+	}
+	newTeacherID := req.TeacherID
+	session.AssignedTeacherID = &newTeacherID
+	session.Status = "assigned"
+	services.ScheduleTeacherConfirmReminders(*session, *schedule)
+	services.NotifySubstituteAssigned(*session, *schedule, previousID, req.TeacherID)
+
+	middleware.LogActivity(c, "ASSIGN_SUBSTITUTE", "schedule_sessions", session.ID, fiber.Map{
+		"previous_teacher_id": previousID,
+		"new_teacher_id":      req.TeacherID,
+		"reason":              req.Reason,
+		"forced":              req.Force && len(conflict) > 0,
+	})
+
+	return c.JSON(fiber.Map{
+		"message":             "Substitute teacher assigned",
+		"session_id":          session.ID,
+		"previous_teacher_id": previousID,
+		"assigned_teacher_id": req.TeacherID,
+		"conflicts":           conflict,
+	})
+}
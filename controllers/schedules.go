@@ -36,6 +36,7 @@ type CreateScheduleRequest struct {
 
 	// Schedule timing
 	RecurringPattern string    `json:"recurring_pattern" validate:"required,oneof=daily weekly bi-weekly monthly yearly custom"`
+	RecurrenceRule   string    `json:"recurrence_rule,omitempty"` // RFC 5545 RRULE (BYDAY, BYMONTHDAY, INTERVAL, COUNT, UNTIL + EXDATE lines)
 	TotalHours       int       `json:"total_hours" validate:"required,min=1"`
 	HoursPerSession  int       `json:"hours_per_session" validate:"required,min=1"`
 	SessionPerWeek   int       `json:"session_per_week" validate:"required,min=1"`
@@ -73,6 +74,7 @@ type CheckRoomConflictRequest struct {
 	RoomIDs             []uint            `json:"room_ids,omitempty"`
 	BranchID            *uint             `json:"branch_id,omitempty"`
 	RecurringPattern    string            `json:"recurring_pattern"`
+	RecurrenceRule      string            `json:"recurrence_rule,omitempty"`
 	TotalHours          int               `json:"total_hours"`
 	HoursPerSession     int               `json:"hours_per_session"`
 	SessionPerWeek      int               `json:"session_per_week"`
@@ -262,52 +264,39 @@ func parseHourMinute(value string) (int, int, error) {
 }
 
 func resolveBranchHours(branch *models.Branch) (int, int, error) {
-	const (
-		defaultOpenMinutes  = 8 * 60
-		defaultCloseMinutes = 21 * 60
-	)
-
-	if branch == nil {
-		return defaultOpenMinutes, defaultCloseMinutes, nil
-	}
-
-	// Convert branch time.Time fields to strings if present, otherwise treat as empty
-	openStr := ""
-	if !branch.OpenTime.IsZero() {
-		openStr = branch.OpenTime.Format("15:04")
+	hours, err := services.BranchOpenHours(branch)
+	if err != nil {
+		return 0, 0, err
 	}
+	return hours.OpenMinutes, hours.CloseMinutes, nil
+}
 
-	closeStr := ""
-	if !branch.CloseTime.IsZero() {
-		closeStr = branch.CloseTime.Format("15:04")
+// resolveRecurrence splits the request into the preset stored in Recurring_pattern and a normalized RRULE.
+// An RRULE sent in recurring_pattern is accepted as well; schedules with an RRULE are stored as "custom".
+func resolveRecurrence(pattern, rule string) (string, string, error) {
+	pattern = strings.TrimSpace(pattern)
+	rule = strings.TrimSpace(rule)
+	if rule == "" && services.IsRecurrenceRule(pattern) {
+		rule, pattern = pattern, ""
 	}
-
-	openStr = strings.TrimSpace(openStr)
-	closeStr = strings.TrimSpace(closeStr)
-
-	openMinutes := defaultOpenMinutes
-	if openStr != "" {
-		hour, minute, err := parseHourMinute(openStr)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid branch open_time: %w", err)
-		}
-		openMinutes = hour*60 + minute
+	if rule == "" {
+		return pattern, "", nil
 	}
-
-	closeMinutes := defaultCloseMinutes
-	if closeStr != "" {
-		hour, minute, err := parseHourMinute(closeStr)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid branch close_time: %w", err)
-		}
-		closeMinutes = hour*60 + minute
+	parsed, err := services.ParseRecurrenceRule(rule)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid recurrence_rule: %w", err)
 	}
+	return "custom", parsed.String(), nil
+}
 
-	if closeMinutes <= openMinutes {
-		return 0, 0, fmt.Errorf("branch closing time must be after opening time")
+// slotPattern keeps presets that change how session_times repeat (every other week, monthly, yearly);
+// anything else becomes "custom" (every week on the slot weekdays)
+func slotPattern(pattern string) string {
+	switch pattern {
+	case "bi-weekly", "monthly", "yearly":
+		return pattern
 	}
-
-	return openMinutes, closeMinutes, nil
+	return "custom"
 }
 
 func formatSessionTime(t *time.Time) string {
@@ -543,34 +532,21 @@ func collectTeacherConflictDetail(teacherID *uint, candidateSessions []models.Sc
 		return nil, nil
 	}
 
-	var rows []scheduleConflictRow
-	query := database.DB.Table("schedule_sessions").
-		Select("schedule_sessions.id AS session_id, schedule_sessions.schedule_id, schedules.schedule_name, schedule_sessions.session_date, schedule_sessions.start_time, schedule_sessions.end_time").
-		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id").
-		Where("(schedule_sessions.assigned_teacher_id = ? OR schedules.default_teacher_id = ?)", *teacherID, *teacherID).
-		Where("schedule_sessions.status NOT IN ?", []string{"cancelled", "no-show"}).
-		Where("schedules.status IN ?", []string{"assigned", "scheduled"}).
-		Where("schedule_sessions.session_date BETWEEN ? AND ?", minDate, maxDate)
-	if excludeScheduleID != nil {
-		query = query.Where("schedule_sessions.schedule_id <> ?", *excludeScheduleID)
-	}
-	if err := query.Find(&rows).Error; err != nil {
+	// กฎเดียวกับการหาครูแทน: ครูของ session คือ assigned teacher ถ้าไม่มีจึงใช้ default teacher ของ schedule
+	conflicts, err := services.FindTeacherConflicts(database.DB, services.TeacherConflictQuery{
+		TeacherIDs:        []uint{*teacherID},
+		Sessions:          candidateSessions,
+		From:              minDate,
+		To:                maxDate,
+		ExcludeScheduleID: excludeScheduleID,
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	slots := make([]ScheduleConflictSlot, 0)
-	for _, row := range rows {
-		existing := models.Schedule_Sessions{
-			Session_date: row.SessionDate,
-			Start_time:   row.StartTime,
-			End_time:     row.EndTime,
-		}
-		for _, candidate := range candidateSessions {
-			if sessionsOverlapOnSameDay(existing, candidate) {
-				slots = append(slots, makeConflictSlot(row))
-				break
-			}
-		}
+	slots := make([]ScheduleConflictSlot, 0, len(conflicts[*teacherID]))
+	for _, c := range conflicts[*teacherID] {
+		slots = append(slots, ScheduleConflictSlot(c))
 	}
 
 	if len(slots) == 0 {
@@ -950,8 +926,11 @@ func (sc *ScheduleController) CreateSchedule(c *fiber.Ctx) error {
 		}
 	}
 
-	pattern := req.RecurringPattern
-	if len(sessionSlots) > 0 && strings.TrimSpace(pattern) == "" {
+	pattern, recurrenceRule, recErr := resolveRecurrence(req.RecurringPattern, req.RecurrenceRule)
+	if recErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": recErr.Error()})
+	}
+	if len(sessionSlots) > 0 && pattern == "" {
 		pattern = "custom"
 	}
 
@@ -966,6 +945,7 @@ func (sc *ScheduleController) CreateSchedule(c *fiber.Ctx) error {
 		GroupID:                 req.GroupID,
 		CreatedByUserID:         &userID,
 		Recurring_pattern:       pattern,
+		RecurrenceRule:          recurrenceRule,
 		Total_hours:             req.TotalHours,
 		Hours_per_session:       req.HoursPerSession,
 		Session_per_week:        req.SessionPerWeek,
@@ -999,36 +979,16 @@ func (sc *ScheduleController) CreateSchedule(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no sessions generated for the provided configuration"})
 	}
 
-	minDate, maxDate := getSessionDateRange(sessions)
-	startYear := schedule.Start_date.Year()
-	if !minDate.IsZero() && minDate.Year() < startYear {
-		startYear = minDate.Year()
-	}
-	endYear := schedule.Estimated_end_date.Year()
-	if endYear < startYear {
-		endYear = startYear
-	}
-	if !maxDate.IsZero() && maxDate.Year() > endYear {
-		endYear = maxDate.Year()
-	}
-
 	// Apply holiday rescheduling if enabled
 	if autoReschedule {
-		var holidayDates []time.Time
-		if _, err := services.GetThaiHolidaysWithNames(startYear, endYear); err == nil {
-			// Fetch dates for rescheduling
-			if holidays, err := services.GetThaiHolidays(startYear, endYear); err == nil {
-				holidayDates = holidays
-			}
-		}
-
-		if len(holidayDates) > 0 {
-			sessions = services.RescheduleSessions(sessions, holidayDates)
+		// วันหยุดทุกสาขา + วันปิดของสาขานี้ จากตาราง holidays
+		if rescheduled, err := services.RescheduleSessionsForBranch(sessions, services.ResolveBranchID(database.DB, services.BranchLookup{DefaultRoomID: req.DefaultRoomID, GroupID: req.GroupID})); err == nil {
+			sessions = rescheduled
 		}
 	}
 
 	services.ReindexSessions(sessions, schedule.Start_date)
-	minDate, maxDate = getSessionDateRange(sessions)
+	_, maxDate := getSessionDateRange(sessions)
 	if !maxDate.IsZero() {
 		schedule.Estimated_end_date = maxDate
 	}
@@ -1210,7 +1170,10 @@ func (sc *ScheduleController) CheckRoomConflicts(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session_per_week must be greater than zero"})
 	}
 
-	pattern := strings.TrimSpace(req.RecurringPattern)
+	pattern, recurrenceRule, recErr := resolveRecurrence(req.RecurringPattern, req.RecurrenceRule)
+	if recErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": recErr.Error()})
+	}
 	if pattern == "" {
 		pattern = "weekly"
 	}
@@ -1268,7 +1231,7 @@ func (sc *ScheduleController) CheckRoomConflicts(c *fiber.Ctx) error {
 				StartMinute: minute,
 			})
 		}
-		pattern = "custom"
+		pattern = slotPattern(pattern)
 	}
 
 	if len(sessionSlots) == 0 && strings.TrimSpace(req.SessionStartTime) == "" {
@@ -1290,6 +1253,7 @@ func (sc *ScheduleController) CheckRoomConflicts(c *fiber.Ctx) error {
 	totalSessions := req.TotalHours / req.HoursPerSession
 	schedule := models.Schedules{
 		Recurring_pattern:  pattern,
+		RecurrenceRule:     recurrenceRule,
 		Total_hours:        req.TotalHours,
 		Hours_per_session:  req.HoursPerSession,
 		Session_per_week:   req.SessionPerWeek,
@@ -1475,7 +1439,10 @@ func (sc *ScheduleController) PreviewSchedule(c *fiber.Ctx) error {
 		addIssue("error", "no_sessions", "unable to derive session count from provided hours", nil, true)
 	}
 
-	pattern := strings.TrimSpace(req.RecurringPattern)
+	pattern, recurrenceRule, recErr := resolveRecurrence(req.RecurringPattern, req.RecurrenceRule)
+	if recErr != nil {
+		addIssue("error", "invalid_recurrence_rule", recErr.Error(), nil, true)
+	}
 
 	sessionSlots := make([]services.SessionSlot, 0, len(req.SessionTimes))
 	if len(req.SessionTimes) > 0 {
@@ -1514,7 +1481,7 @@ func (sc *ScheduleController) PreviewSchedule(c *fiber.Ctx) error {
 				StartMinute: minute,
 			})
 		}
-		pattern = "custom"
+		pattern = slotPattern(pattern)
 	}
 
 	if len(sessionSlots) == 0 {
@@ -1543,6 +1510,7 @@ func (sc *ScheduleController) PreviewSchedule(c *fiber.Ctx) error {
 		ScheduleType:            req.ScheduleType,
 		GroupID:                 req.GroupID,
 		Recurring_pattern:       pattern,
+		RecurrenceRule:          recurrenceRule,
 		Total_hours:             req.TotalHours,
 		Hours_per_session:       req.HoursPerSession,
 		Session_per_week:        req.SessionPerWeek,
@@ -1596,7 +1564,7 @@ func (sc *ScheduleController) PreviewSchedule(c *fiber.Ctx) error {
 			}
 
 			holidayNames := make(map[string]string)
-			if names, err := services.GetThaiHolidaysWithNames(startYear, endYear); err == nil {
+			if names, err := services.GetBranchHolidaysWithNames(startYear, endYear+1, services.ResolveBranchID(database.DB, services.BranchLookup{DefaultRoomID: req.DefaultRoomID, GroupID: req.GroupID})); err == nil {
 				holidayNames = names
 				for dateStr := range names {
 					if date, err := time.Parse("2006-01-02", dateStr); err == nil {
@@ -2539,18 +2507,17 @@ func (sc *ScheduleController) GetCalendarView(c *fiber.Ctx) error {
 		startDateParsed, _ := time.Parse("2006-01-02", startDate)
 		endDateParsed, _ := time.Parse("2006-01-02", endDate)
 
-		// Get Thai holidays for the date range
-		holidayDates, err := services.GetThaiHolidays(startDateParsed.Year(), endDateParsed.Year())
-		if err == nil {
-			for _, holiday := range holidayDates {
-				if holiday.After(startDateParsed.AddDate(0, 0, -1)) && holiday.Before(endDateParsed.AddDate(0, 0, 1)) {
-					holidays = append(holidays, map[string]interface{}{
-						"date":  holiday.Format("2006-01-02"),
-						"title": "Thai Holiday",
-						"type":  "holiday",
-					})
-				}
-			}
+		// Holidays for the date range: all-branch ones plus the selected branch's closures
+		holidayBranchID, _ := parseOptionalBranchID(branchID)
+		holidayRows, _ := services.NewHolidayService().HolidaysInRange(startDateParsed, endDateParsed, holidayBranchID)
+		for _, holiday := range holidayRows {
+			holidays = append(holidays, map[string]interface{}{
+				"id":        holiday.ID,
+				"date":      holiday.Date.Format("2006-01-02"),
+				"title":     holiday.Name,
+				"type":      holiday.Type,
+				"branch_id": holiday.BranchID,
+			})
 		}
 	}
 
@@ -2679,6 +2646,7 @@ func (sc *ScheduleController) GetScheduleDetail(c *fiber.Ctx) error {
 		ScheduleType:     s.ScheduleType,
 		Status:           s.Status,
 		RecurringPattern: s.Recurring_pattern,
+		RecurrenceRule:   s.RecurrenceRule,
 		TotalHours:       s.Total_hours,
 		HoursPerSession:  s.Hours_per_session,
 		SessionPerWeek:   s.Session_per_week,
@@ -2762,6 +2730,20 @@ func (sc *ScheduleController) UpdateSessionStatus(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update session status"})
 	}
 
+	// Pending reminders are pointless once the session will not take place
+	if req.Status == "cancelled" {
+		if _, err := services.NewJobQueue().CancelJobsForSession(session.ID); err != nil {
+			fmt.Printf("warning: failed to cancel pending jobs for session %d: %v\n", session.ID, err)
+		}
+	}
+
+	// Completed class sessions consume the students' learning hours
+	if req.Status == "completed" {
+		if _, err := services.NewLearningHoursService().PostSessionConsumption(session.ID); err != nil {
+			fmt.Printf("warning: failed to post learning hours for session %d: %v\n", session.ID, err)
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"message": "Session status updated successfully",
 	})
@@ -2808,6 +2790,14 @@ func (sc *ScheduleController) ConfirmSession(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You are not authorized to confirm this session"})
 	}
 
+	// The responsible teacher confirming goes through the accept workflow so it is recorded in SessionConfirmation
+	if teacherID := services.ResponsibleTeacherID(session, session.Schedule); teacherID != nil && *teacherID == userID {
+		if _, err := services.NewTeacherConfirmationService().Respond(session.ID, userID, true, ""); err != nil {
+			return handleTeacherConfirmationError(c, err)
+		}
+		return c.JSON(fiber.Map{"message": "Session confirmed successfully"})
+	}
+
 	// Update confirmation fields
 	now := time.Now()
 	updates := map[string]interface{}{
@@ -2967,69 +2957,22 @@ func (sc *ScheduleController) CreateMakeupSession(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Find original session
-	var originalSession models.Schedule_Sessions
-	if err := database.DB.First(&originalSession, req.OriginalSessionID).Error; err != nil {
+	makeupSession, _, err := services.CreateMakeupSession(services.MakeupInput{
+		OriginalSessionID: req.OriginalSessionID,
+		SessionDate:       req.NewSessionDate,
+		StartTime:         req.NewStartTime,
+		OriginalStatus:    req.NewSessionStatus,
+		CancellingReason:  req.CancellingReason,
+	})
+	switch {
+	case errors.Is(err, services.ErrMakeupOriginalNotFound):
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Original session not found"})
-	}
-
-	// Parse new start time
-	newStartTime, err := time.Parse("15:04", req.NewStartTime)
-	if err != nil {
+	case errors.Is(err, services.ErrMakeupInvalid):
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid time format, use HH:MM"})
-	}
-
-	// Create new session date with the time
-	newStartDateTime := time.Date(
-		req.NewSessionDate.Year(), req.NewSessionDate.Month(), req.NewSessionDate.Day(),
-		newStartTime.Hour(), newStartTime.Minute(), 0, 0, req.NewSessionDate.Location(),
-	)
-	// Calculate duration safely: ensure original start/end times are non-nil
-	var newEndDateTime time.Time
-	if originalSession.Start_time != nil && originalSession.End_time != nil {
-		duration := originalSession.End_time.Sub(*originalSession.Start_time)
-		newEndDateTime = newStartDateTime.Add(duration)
-	} else {
-		// Fallback: assume 1 hour session if original times missing
-		newEndDateTime = newStartDateTime.Add(time.Hour)
-	}
-
-	tx := database.DB.Begin()
-
-	// Update original session status
-	originalSession.Status = req.NewSessionStatus
-	originalSession.Cancelling_Reason = req.CancellingReason
-	if err := tx.Save(&originalSession).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update original session"})
-	}
-
-	// Create makeup session
-	// Use pointer assignments for time fields
-	nd := req.NewSessionDate
-	st := newStartDateTime
-	et := newEndDateTime
-	makeupSession := models.Schedule_Sessions{
-		ScheduleID:            originalSession.ScheduleID,
-		Session_date:          &nd,
-		Start_time:            &st,
-		End_time:              &et,
-		Session_number:        originalSession.Session_number,
-		Week_number:           originalSession.Week_number,
-		Status:                "scheduled",
-		Is_makeup:             true,
-		Makeup_for_session_id: &originalSession.ID,
-		AssignedTeacherID:     originalSession.AssignedTeacherID,
-		RoomID:                originalSession.RoomID,
-	}
-
-	if err := tx.Create(&makeupSession).Error; err != nil {
-		tx.Rollback()
+	case err != nil:
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create makeup session"})
 	}
 
-	tx.Commit()
-
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message":        "Makeup session created successfully",
 		"makeup_session": makeupSession,
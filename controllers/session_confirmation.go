@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"englishkorat_go/database"
+	"englishkorat_go/middleware"
+	"englishkorat_go/models"
+	"englishkorat_go/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SessionConfirmationController handles teacher accept/decline of assigned sessions
+type SessionConfirmationController struct{}
+
+type sessionResponseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AcceptSession - teacher accepts an assigned session
+func (scc *SessionConfirmationController) AcceptSession(c *fiber.Ctx) error {
+	return scc.respond(c, true)
+}
+
+// DeclineSession - teacher declines an assigned session (reason required); admins are notified
+func (scc *SessionConfirmationController) DeclineSession(c *fiber.Ctx) error {
+	return scc.respond(c, false)
+}
+
+func (scc *SessionConfirmationController) respond(c *fiber.Ctx, accept bool) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	var req sessionResponseRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	userID := c.Locals("user_id").(uint)
+	result, err := services.NewTeacherConfirmationService().Respond(uint(sessionID), userID, accept, req.Reason)
+	if err != nil {
+		return handleTeacherConfirmationError(c, err)
+	}
+
+	action := "ACCEPT"
+	message := "Session accepted"
+	if !accept {
+		action = "DECLINE"
+		message = "Session declined"
+	}
+	if result.Changed {
+		middleware.LogActivity(c, action, "schedule_sessions", uint(sessionID), fiber.Map{
+			"reason": req.Reason,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":      message,
+		"changed":      result.Changed,
+		"confirmation": result.Confirmation,
+		"session":      result.Session,
+	})
+}
+
+// GetSessionConfirmations - list accept/decline history of a session
+func (scc *SessionConfirmationController) GetSessionConfirmations(c *fiber.Ctx) error {
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	var confirmations []models.SessionConfirmation
+	if err := database.DB.Preload("User").
+		Where("session_id = ?", sessionID).
+		Order("updated_at DESC").
+		Find(&confirmations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch confirmations"})
+	}
+
+	return c.JSON(fiber.Map{"confirmations": confirmations})
+}
+
+// GetConfirmationDashboard - admin view of unconfirmed/declined class sessions in the next N days, per branch
+func (scc *SessionConfirmationController) GetConfirmationDashboard(c *fiber.Ctx) error {
+	days, err := strconv.Atoi(c.Query("days", "7"))
+	if err != nil || days < 1 || days > 90 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 90"})
+	}
+
+	var branchID *uint
+	if raw := c.Query("branch_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid branch_id"})
+		}
+		bid := uint(id)
+		branchID = &bid
+	}
+
+	branches, err := services.NewTeacherConfirmationService().ConfirmationDashboard(days, branchID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to build confirmation dashboard"})
+	}
+
+	totalUnconfirmed, totalDeclined := 0, 0
+	for _, b := range branches {
+		totalUnconfirmed += b.Unconfirmed
+		totalDeclined += b.Declined
+	}
+
+	return c.JSON(fiber.Map{
+		"days":              days,
+		"branches":          branches,
+		"total_unconfirmed": totalUnconfirmed,
+		"total_declined":    totalDeclined,
+	})
+}
+
+func handleTeacherConfirmationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrConfirmationSessionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	case errors.Is(err, services.ErrNotSessionTeacher):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrSessionNotConfirmable), errors.Is(err, services.ErrDeclineReasonRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record session response"})
+	}
+}
@@ -20,7 +20,8 @@ const (
 )
 
 type SettingsController struct {
-	service *services.SettingsService
+	service     *services.SettingsService
+	preferences *services.NotificationPreferenceService
 }
 
 type updateSettingsRequest struct {
@@ -34,7 +35,10 @@ type updateSettingsRequest struct {
 }
 
 func NewSettingsController() *SettingsController {
-	return &SettingsController{service: services.NewSettingsService()}
+	return &SettingsController{
+		service:     services.NewSettingsService(),
+		preferences: services.NewNotificationPreferenceService(),
+	}
 }
 
 func (sc *SettingsController) GetMySettings(c *fiber.Ctx) error {
@@ -236,6 +240,69 @@ func (sc *SettingsController) UploadUserCustomSound(c *fiber.Ctx) error {
 	return c.JSON(payload)
 }
 
+// GetMyNotificationPreferences returns the caller's schedule reminder preferences (defaults if never saved)
+func (sc *SettingsController) GetMyNotificationPreferences(c *fiber.Ctx) error {
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": errUserNotFoundMessage})
+	}
+
+	pref, exists, err := sc.preferences.Get(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load notification preferences"})
+	}
+	return c.JSON(fiber.Map{"preferences": sc.preferences.BuildDTO(pref, exists)})
+}
+
+// UpdateMyNotificationPreferences creates or updates the caller's schedule reminder preferences
+func (sc *SettingsController) UpdateMyNotificationPreferences(c *fiber.Ctx) error {
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": errUserNotFoundMessage})
+	}
+
+	var req services.UpdateNotificationPreferenceInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	pref, svcErr := sc.preferences.Upsert(user.ID, req)
+	if svcErr != nil {
+		if errors.Is(svcErr, services.ErrSettingsValidation) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": svcErr.Error()})
+		}
+		log.Printf("notification preferences update error: %v", svcErr)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update notification preferences"})
+	}
+
+	middleware.LogActivity(c, "UPDATE", "notification_preferences", pref.ID, fiber.Map{
+		"target_user_id": user.ID,
+	})
+
+	return c.JSON(fiber.Map{
+		"message":     "Notification preferences updated",
+		"preferences": sc.preferences.BuildDTO(pref, true),
+	})
+}
+
+// ResetMyNotificationPreferences removes the caller's stored preferences so defaults apply
+func (sc *SettingsController) ResetMyNotificationPreferences(c *fiber.Ctx) error {
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": errUserNotFoundMessage})
+	}
+
+	if err := sc.preferences.Reset(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reset notification preferences"})
+	}
+
+	def := services.DefaultNotificationPreference(user.ID)
+	return c.JSON(fiber.Map{
+		"message":     "Notification preferences reset to defaults",
+		"preferences": sc.preferences.BuildDTO(&def, false),
+	})
+}
+
 func findUserByParamID(c *fiber.Ctx) (*models.User, error) {
 	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
 	if err != nil {
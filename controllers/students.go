@@ -125,6 +125,7 @@ type StudentRegistrationRequest struct {
 	Address              string                           `json:"address"`
 	ParentName           string                           `json:"parent_name"`
 	ParentPhone          string                           `json:"parent_phone"`
+	ParentSMSOptIn       bool                             `json:"parent_sms_opt_in"`
 	EmergencyContact     string                           `json:"emergency_contact"`
 	EmergencyPhone       string                           `json:"emergency_phone"`
 	ContactSource        string                           `json:"contact_source"`
@@ -147,6 +148,15 @@ type StudentRegistrationRequest struct {
 }
 
 // Helpers
+// parentSMSOptInAt is the consent time stored with parent_sms_opt_in (nil when not opted in)
+func parentSMSOptInAt(optIn bool) *time.Time {
+	if !optIn {
+		return nil
+	}
+	now := time.Now()
+	return &now
+}
+
 func calculateAge(dob time.Time) int {
 	now := time.Now()
 	age := now.Year() - dob.Year()
@@ -371,6 +381,8 @@ func (sc *StudentController) CreateStudent(c *fiber.Ctx) error {
 		LearningGoals:        getStringOrEmpty(req.LearningGoals),
 		ParentName:           strings.TrimSpace(req.ParentName),
 		ParentPhone:          strings.TrimSpace(req.ParentPhone),
+		ParentSMSOptIn:       req.ParentSMSOptIn,
+		ParentSMSOptInAt:     parentSMSOptInAt(req.ParentSMSOptIn),
 		EmergencyContact:     strings.TrimSpace(req.EmergencyContact),
 		EmergencyPhone:       strings.TrimSpace(req.EmergencyPhone),
 		PreferredTimeSlots:   preferredSlotsJSON,
@@ -485,6 +497,24 @@ func (sc *StudentController) UpdateStudent(c *fiber.Ctx) error {
 			payload["admin_contact"] = (lv == "true" || lv == "1" || lv == "yes")
 		}
 	}
+	// Parent SMS consent: coerce to bool and record when it changed (the time is not client-settable)
+	delete(payload, "parent_sms_opt_in_at")
+	if v, ok := payload["parent_sms_opt_in"]; ok {
+		optIn := false
+		switch t := v.(type) {
+		case bool:
+			optIn = t
+		case float64:
+			optIn = t != 0
+		case string:
+			lv := strings.ToLower(strings.TrimSpace(t))
+			optIn = (lv == "true" || lv == "1" || lv == "yes")
+		}
+		payload["parent_sms_opt_in"] = optIn
+		if optIn != student.ParentSMSOptIn {
+			payload["parent_sms_opt_in_at"] = parentSMSOptInAt(optIn)
+		}
+	}
 
 	if err := database.DB.Model(&student).Updates(payload).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -622,6 +652,8 @@ func (sc *StudentController) PublicRegisterStudent(c *fiber.Ctx) error {
 		LearningGoals:        getStringOrEmpty(req.LearningGoals),
 		ParentName:           strings.TrimSpace(req.ParentName),
 		ParentPhone:          strings.TrimSpace(req.ParentPhone),
+		ParentSMSOptIn:       req.ParentSMSOptIn,
+		ParentSMSOptInAt:     parentSMSOptInAt(req.ParentSMSOptIn),
 		EmergencyContact:     strings.TrimSpace(req.EmergencyContact),
 		EmergencyPhone:       strings.TrimSpace(req.EmergencyPhone),
 		PreferredTimeSlots:   preferredSlotsJSON,
@@ -127,6 +127,8 @@ func AutoMigrate() {
 		&models.CourseCategory{},
 		&models.ActivityLog{},
 		&models.Notification{},
+		&models.NotificationDelivery{},
+		&models.SMSDailyCount{},
 		&models.LogArchive{},
 		&models.Student_Group{}, // Legacy model for backward compatibility
 		&models.Group{},         // New Group model
@@ -139,13 +141,24 @@ func AutoMigrate() {
 		&models.ScheduleParticipant{},
 		&models.SessionConfirmation{},
 		&models.NotificationPreference{},
+		&models.ScheduledJob{},
+		&models.CalendarFeedToken{},
+		&models.LineLinkCode{},
+		&models.LineMessageTemplate{},
+		&models.Holiday{},
 		&models.UserSettings{},
 		&models.LineGroup{},
+		&models.LineWebhookEvent{},
 		&models.Book{},
 		&models.ClassProgress{},
 		&models.Bill{},
 		&models.GroupLeaveQuota{},
+		&models.LeaveQuotaPolicy{},
+		&models.StudentLeaveQuota{},
+		&models.LeaveQuotaEntry{},
 		&models.Absence{},
+		&models.Attendance{},
+		&models.LearningHourEntry{},
 	}
 
 	// Pre-sanitize: fix invalid JSON in notifications.channels before altering column type
@@ -166,6 +179,11 @@ func AutoMigrate() {
 		log.Printf("Warning: could not ensure users.email is nullable: %v", err)
 	}
 
+	// Post-migration: copy legacy per-group leave quotas to per-student quotas (once per member)
+	if err := migrateGroupLeaveQuotas(DB); err != nil {
+		log.Printf("Warning: could not migrate group leave quotas: %v", err)
+	}
+
 	// Optional: prune extra columns not defined in models (dangerous - gated by env)
 	if config.AppConfig != nil && config.AppConfig.PruneColumns {
 		log.Println("PRUNE_COLUMNS=true; starting schema prune for extra columns")
@@ -222,6 +240,74 @@ func ensureUsersEmailNullable(db *gorm.DB) error {
 	return db.Exec("ALTER TABLE `users` MODIFY COLUMN `email` varchar(255) NULL DEFAULT NULL").Error
 }
 
+// migrateGroupLeaveQuotas gives every active member of a group with a legacy GroupLeaveQuota
+// their own StudentLeaveQuota with the same total. Used leaves are counted from the member's
+// approved on-time absences (group-wide absences count for everyone). Members that already
+// have a quota for the group are skipped. A group quota is soft-deleted once its members are
+// migrated, so later starts only pick up quotas that have not been migrated yet.
+func migrateGroupLeaveQuotas(db *gorm.DB) error {
+	var quotas []models.GroupLeaveQuota
+	if err := db.Find(&quotas).Error; err != nil {
+		return err
+	}
+	migrated := 0
+	for _, q := range quotas {
+		var group models.Group
+		if err := db.Select("id", "course_id").First(&group, q.GroupID).Error; err != nil {
+			continue
+		}
+		var members []models.GroupMember
+		if err := db.Where("group_id = ? AND status = ?", q.GroupID, "active").Find(&members).Error; err != nil {
+			return err
+		}
+		for _, m := range members {
+			var existing int64
+			db.Model(&models.StudentLeaveQuota{}).Where("student_id = ? AND group_id = ?", m.StudentID, q.GroupID).Count(&existing)
+			if existing > 0 {
+				continue
+			}
+			var used int64
+			db.Model(&models.Absence{}).
+				Where("group_id = ? AND status = ? AND is_late = ?", q.GroupID, "approved", false).
+				Where("student_id = ? OR student_id IS NULL", m.StudentID).
+				Count(&used)
+
+			courseID := group.CourseID
+			err := db.Transaction(func(tx *gorm.DB) error {
+				sq := models.StudentLeaveQuota{
+					StudentID:  m.StudentID,
+					GroupID:    q.GroupID,
+					CourseID:   &courseID,
+					BaseQuota:  q.TotalQuota,
+					UsedQuota:  int(used),
+					LastUsedAt: q.LastUsedAt,
+				}
+				if err := tx.Create(&sq).Error; err != nil {
+					return err
+				}
+				return tx.Create(&models.LeaveQuotaEntry{
+					QuotaID:   sq.ID,
+					StudentID: m.StudentID,
+					EntryType: "migration",
+					Amount:    q.TotalQuota - int(used),
+					Notes:     fmt.Sprintf("migrated from group quota %d (group used %d/%d)", q.ID, q.UsedQuota, q.TotalQuota),
+				}).Error
+			})
+			if err != nil {
+				return err
+			}
+			migrated++
+		}
+		if err := db.Delete(&q).Error; err != nil {
+			return err
+		}
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d student leave quotas from group quotas", migrated)
+	}
+	return nil
+}
+
 // pruneExtraColumns drops columns that exist in the DB table but not in the model definition.
 // It uses gorm schema parser to get model fields, then compares with DB column list.
 // WARNING: This is destructive. Gate with PRUNE_COLUMNS=true in env and use with backups.
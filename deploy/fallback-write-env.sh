@@ -73,6 +73,19 @@ S3_BUCKET_NAME=${S3_BUCKET_NAME:-$(stage_pick S3_BUCKET_NAME || true)}
 # LINE Notify/Channel (optional)
 LINE_CHANNEL_SECRET=${LINE_CHANNEL_SECRET:-$(stage_pick LINE_CHANNEL_SECRET || true)}
 LINE_CHANNEL_ACCESS_TOKEN=${LINE_CHANNEL_ACCESS_TOKEN:-$(stage_pick LINE_CHANNEL_ACCESS_TOKEN || true)}
+ABSENCE_LINK=${ABSENCE_LINK:-$(stage_pick ABSENCE_LINK || true)}
+
+# SMTP for email notifications (optional)
+SMTP_HOST=${SMTP_HOST:-$(stage_pick SMTP_HOST || true)}
+SMTP_PORT=${SMTP_PORT:-$(stage_pick SMTP_PORT || true)}
+SMTP_USERNAME=${SMTP_USERNAME:-$(stage_pick SMTP_USERNAME || true)}
+SMTP_PASSWORD=${SMTP_PASSWORD:-$(stage_pick SMTP_PASSWORD || true)}
+SMTP_FROM=${SMTP_FROM:-$(stage_pick SMTP_FROM || true)}
+
+# SMS gateway (optional)
+SMS_PROVIDER=${SMS_PROVIDER:-$(stage_pick SMS_PROVIDER || true)}
+SMS_HTTP_URL=${SMS_HTTP_URL:-$(stage_pick SMS_HTTP_URL || true)}
+SMS_HTTP_TOKEN=${SMS_HTTP_TOKEN:-$(stage_pick SMS_HTTP_TOKEN || true)}
 
 # phpMyAdmin convenience (optional). If not provided, derive from DB settings
 PMA_HOST=${PMA_HOST:-${DB_HOST:-}}
@@ -132,6 +145,19 @@ TMP_FILE=.env.new
 		# LINE (optional)
 		[ -n "$LINE_CHANNEL_SECRET" ] && echo "LINE_CHANNEL_SECRET=$LINE_CHANNEL_SECRET"
 		[ -n "$LINE_CHANNEL_ACCESS_TOKEN" ] && echo "LINE_CHANNEL_ACCESS_TOKEN=$LINE_CHANNEL_ACCESS_TOKEN"
+		[ -n "$ABSENCE_LINK" ] && echo "ABSENCE_LINK=$ABSENCE_LINK"
+
+	# SMTP (optional)
+	[ -n "$SMTP_HOST" ] && echo "SMTP_HOST=$SMTP_HOST"
+	[ -n "$SMTP_PORT" ] && echo "SMTP_PORT=$SMTP_PORT"
+	[ -n "$SMTP_USERNAME" ] && echo "SMTP_USERNAME=$SMTP_USERNAME"
+	[ -n "$SMTP_PASSWORD" ] && echo "SMTP_PASSWORD=$SMTP_PASSWORD"
+	[ -n "$SMTP_FROM" ] && echo "SMTP_FROM=$SMTP_FROM"
+
+	# SMS (optional)
+	[ -n "$SMS_PROVIDER" ] && echo "SMS_PROVIDER=$SMS_PROVIDER"
+	[ -n "$SMS_HTTP_URL" ] && echo "SMS_HTTP_URL=$SMS_HTTP_URL"
+	[ -n "$SMS_HTTP_TOKEN" ] && echo "SMS_HTTP_TOKEN=$SMS_HTTP_TOKEN"
 
 	# Logging defaults (safe)
 	echo "LOG_LEVEL=${LOG_LEVEL:-info}"
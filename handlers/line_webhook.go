@@ -4,11 +4,13 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"time"                   
-    "englishkorat_go/models"
+	"time"
+
+	"englishkorat_go/models"
 	"englishkorat_go/services"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,8 +19,9 @@ import (
 )
 
 type LineWebhookHandler struct {
-	DB  *gorm.DB
-	Bot *linebot.Client
+	DB     *gorm.DB
+	Bot    *linebot.Client
+	Events *services.LineWebhookEventService
 }
 
 func NewLineWebhookHandler(db *gorm.DB) *LineWebhookHandler {
@@ -34,14 +37,14 @@ func NewLineWebhookHandler(db *gorm.DB) *LineWebhookHandler {
 	if err != nil {
 		log.Fatalf("cannot create LINE bot client: %v", err)
 	}
-	return &LineWebhookHandler{DB: db, Bot: bot}
+	h := &LineWebhookHandler{DB: db, Bot: bot, Events: services.NewLineWebhookEventService()}
+	services.RegisterLineEventProcessor(h.processEvent)
+	return h
 }
 
-// Handle รับ webhook event
+// Handle รับ webhook event: บันทึกทุก event ลงตารางก่อนตอบ 200 แล้วให้ worker ประมวลผล
+// DB ล่ม -> ตอบ 500 เพื่อให้ LINE ส่งซ้ำ (redelivery) ส่วน event ที่เคยบันทึกแล้วจะถูกข้ามตาม webhookEventId
 func (h *LineWebhookHandler) Handle(c *fiber.Ctx) error {
-	log.Println("📥 Received webhook request")
-	log.Printf("📥 Headers: %+v", c.GetReqHeaders())
-
 	if h.Bot == nil {
 		log.Println("⚠️ LINE Bot not initialized")
 		return c.SendStatus(fiber.StatusOK)
@@ -54,105 +57,148 @@ func (h *LineWebhookHandler) Handle(c *fiber.Ctx) error {
 	}
 
 	if !validateSignature(os.Getenv("LINE_CHANNEL_SECRET"), c.Body(), signature) {
-		expected := computeSignature(os.Getenv("LINE_CHANNEL_SECRET"), c.Body())
-		log.Printf("❌ Signature mismatch\nHeader: %s\nExpected: %s\nBody: %s",
-			signature, expected, string(c.Body()))
+		log.Println("❌ Signature mismatch")
 		return c.SendStatus(fiber.StatusUnauthorized)
 	}
 
-	// ✅ ตอบกลับ 200 ก่อน เพื่อให้ LINE Verify ผ่าน
-	go func(body []byte) {
-    var webhook struct {
-        Events []*linebot.Event `json:"events"`
-    }
-    if err := json.Unmarshal(body, &webhook); err != nil {
-        log.Printf("❌ Failed to parse event JSON: %v", err)
-        return
-    }
-
-    for _, event := range webhook.Events {
-        switch event.Type {
-			case linebot.EventTypeJoin:
-				groupID := event.Source.GroupID
-				if groupID == "" {
-					log.Println("⚠️ Join event ไม่พบ groupID")
-					continue
-				}
-				
-        groupSummary, err := h.Bot.GetGroupSummary(groupID).Do()
-        if err != nil {
-            log.Printf("❌ Failed to get group summary: %v", err)
-            continue
-        }
-
-        log.Printf("✅ Bot joined group: %s (%s)", groupSummary.GroupName, groupID)
-
-        var existing models.LineGroup
-        result := h.DB.Where("group_id = ?", groupID).First(&existing)
-
-        if result.Error == nil {
-            existing.GroupName = groupSummary.GroupName
-            existing.LastJoinedAt = time.Now()
-			existing.IsActive = true
-			existing.LastLeftAt = nil
-            if err := h.DB.Save(&existing).Error; err != nil {
-                log.Printf("❌ Failed to update LineGroup in DB: %v", err)
-            } else {
-                log.Printf("♻️ Updated LineGroup in DB: %s (%s) at %s",
-                    groupSummary.GroupName, groupID, existing.LastJoinedAt.Format(time.RFC3339))
-            }
-        } else {
-            lineGroup := models.LineGroup{
-                GroupName:    groupSummary.GroupName,
-                GroupID:      groupID,
-                LastJoinedAt: time.Now(),
-				IsActive:     true,
-            }
-            if err := h.DB.Create(&lineGroup).Error; err != nil {
-                log.Printf("❌ Failed to save LineGroup to DB: %v", err)
-            } else {
-                log.Printf("💾 Saved LineGroup to DB: %s (%s) at %s",
-                    groupSummary.GroupName, groupID, lineGroup.LastJoinedAt.Format(time.RFC3339))
-
-					matcher := services.NewLineGroupMatcher()
-    				go matcher.MatchLineGroupsToGroups()
-            }
-        }
-    case linebot.EventTypeLeave:
-				groupID := event.Source.GroupID
-				if groupID == "" {
-					log.Println("⚠️ Leave event ไม่มี groupID")
-					continue
-				}
-
-				var existing models.LineGroup
-				if err := h.DB.Where("group_id = ?", groupID).First(&existing).Error; err == nil {
-					now := time.Now()
-					existing.LastLeftAt = &now
-					existing.IsActive = false
-					if err := h.DB.Save(&existing).Error; err != nil {
-						log.Printf("❌ Failed to update LineGroup leave info: %v", err)
-					} else {
-						log.Printf("🚪 OA left group: %s (%s) at %s",
-							existing.GroupName, groupID, now.Format(time.RFC3339))
-					}
-				} else {
-					log.Printf("⚠️ Leave event received but groupID '%s' not found in DB", groupID)
-				}
+	ids, err := h.Events.Store(c.Body())
+	if err != nil {
+		if errors.Is(err, services.ErrLineWebhookPayload) {
+			log.Printf("❌ Failed to parse event JSON: %v", err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		log.Printf("❌ Failed to persist LINE webhook events: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// ✅ ตอบกลับ 200 ทันที event ใหม่ประมวลผลต่อเบื้องหลัง (ที่ล้มเหลว worker จะ retry)
+	if len(ids) > 0 {
+		go h.Events.ProcessIDs(ids)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// processEvent ประมวลผล event ที่บันทึกไว้ คืน error เมื่อควร retry (DB / LINE API ล้มเหลว)
+// การตอบแชตที่ล้มเหลวไม่ retry เพราะ reply token ใช้ได้ครั้งเดียวและหมดอายุเร็ว
+func (h *LineWebhookHandler) processEvent(event *linebot.Event) error {
+	switch event.Type {
+	case linebot.EventTypeJoin:
+		return h.handleJoin(event)
+	case linebot.EventTypeLeave:
+		return h.handleLeave(event)
+	case linebot.EventTypeMessage, linebot.EventTypePostback, linebot.EventTypeFollow:
+		h.handleChatbot(event)
+	case linebot.EventTypeUnfollow:
+		// บล็อก OA: ผู้ใช้ที่ผูก LINE นี้จะไม่ได้รับข้อความจนกว่าจะ follow ใหม่
+		if event.Source != nil && event.Source.UserID != "" {
+			if err := services.NewLineLinkService().MarkUnfollowed(event.Source.UserID); err != nil {
+				return fmt.Errorf("mark LINE user unreachable: %w", err)
 			}
 		}
-	}(c.Body())
+	}
+	return nil
+}
 
+func (h *LineWebhookHandler) handleJoin(event *linebot.Event) error {
+	if event.Source == nil || event.Source.GroupID == "" {
+		log.Println("⚠️ Join event ไม่พบ groupID")
+		return nil
+	}
+	groupID := event.Source.GroupID
 
+	groupSummary, err := h.Bot.GetGroupSummary(groupID).Do()
+	if err != nil {
+		return fmt.Errorf("get group summary: %w", err)
+	}
+	log.Printf("✅ Bot joined group: %s (%s)", groupSummary.GroupName, groupID)
+
+	var existing models.LineGroup
+	result := h.DB.Where("group_id = ?", groupID).First(&existing)
+	if result.Error == nil {
+		existing.GroupName = groupSummary.GroupName
+		existing.LastJoinedAt = time.Now()
+		existing.IsActive = true
+		existing.LastLeftAt = nil
+		if err := h.DB.Save(&existing).Error; err != nil {
+			return fmt.Errorf("update LineGroup: %w", err)
+		}
+		log.Printf("♻️ Updated LineGroup in DB: %s (%s) at %s",
+			groupSummary.GroupName, groupID, existing.LastJoinedAt.Format(time.RFC3339))
+		return nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("find LineGroup: %w", result.Error)
+	}
 
-	return c.SendStatus(fiber.StatusOK)
+	lineGroup := models.LineGroup{
+		GroupName:    groupSummary.GroupName,
+		GroupID:      groupID,
+		LastJoinedAt: time.Now(),
+		IsActive:     true,
+	}
+	if err := h.DB.Create(&lineGroup).Error; err != nil {
+		return fmt.Errorf("save LineGroup: %w", err)
+	}
+	log.Printf("💾 Saved LineGroup to DB: %s (%s) at %s",
+		groupSummary.GroupName, groupID, lineGroup.LastJoinedAt.Format(time.RFC3339))
+
+	go services.NewLineGroupMatcher().MatchLineGroupsToGroups()
+	return nil
 }
 
-// computeSignature ใช้คำนวณ expected signature เพื่อ debug
-func computeSignature(secret string, body []byte) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(body)
-	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+func (h *LineWebhookHandler) handleLeave(event *linebot.Event) error {
+	if event.Source == nil || event.Source.GroupID == "" {
+		log.Println("⚠️ Leave event ไม่มี groupID")
+		return nil
+	}
+	groupID := event.Source.GroupID
+
+	var existing models.LineGroup
+	if err := h.DB.Where("group_id = ?", groupID).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️ Leave event received but groupID '%s' not found in DB", groupID)
+			return nil
+		}
+		return fmt.Errorf("find LineGroup: %w", err)
+	}
+	now := time.Now()
+	existing.LastLeftAt = &now
+	existing.IsActive = false
+	if err := h.DB.Save(&existing).Error; err != nil {
+		return fmt.Errorf("update LineGroup leave info: %w", err)
+	}
+	log.Printf("🚪 OA left group: %s (%s) at %s", existing.GroupName, groupID, now.Format(time.RFC3339))
+	return nil
+}
+
+// handleChatbot ตอบแชต 1:1 (เชื่อมบัญชี / ตารางเรียน / ชั่วโมงเรียน / สิทธิ์ลา / แจ้งลา)
+func (h *LineWebhookHandler) handleChatbot(event *linebot.Event) {
+	if event.Source == nil || event.Source.Type != linebot.EventSourceTypeUser || event.ReplyToken == "" {
+		return
+	}
+	bot := services.NewLineChatbotService()
+	var replies []linebot.SendingMessage
+	switch event.Type {
+	case linebot.EventTypeMessage:
+		message, ok := event.Message.(*linebot.TextMessage)
+		if !ok {
+			return
+		}
+		replies = bot.HandleText(event.Source.UserID, message.Text)
+	case linebot.EventTypePostback:
+		if event.Postback == nil {
+			return
+		}
+		replies = bot.HandlePostback(event.Source.UserID, event.Postback.Data)
+	case linebot.EventTypeFollow:
+		replies = bot.HandleFollow(event.Source.UserID)
+	}
+	if len(replies) == 0 {
+		return
+	}
+	if _, err := h.Bot.ReplyMessage(event.ReplyToken, replies...).Do(); err != nil {
+		log.Printf("❌ Failed to reply chatbot message: %v", err)
+	}
 }
 
 // validateSignature ตรวจสอบว่า signature ถูกต้อง
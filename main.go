@@ -98,6 +98,14 @@ func main() {
 		notifService.StartWorker(stopNotif)
 	}
 
+	// Retries external notification deliveries (line, email, sms)
+	stopDeliveries := make(chan struct{})
+	notifService.StartDeliveryWorker(stopDeliveries)
+
+	// Durable delayed-job worker (session reminders survive restarts and run once across replicas)
+	stopJobs := make(chan struct{})
+	services.NewJobQueue().StartWorker(stopJobs)
+
 	// Start schedule management services after WebSocket hub is ready
 	scheduleManager := services.NewScheduleManager()
 	scheduleManager.SetWebSocketHub(wsHub)
@@ -112,6 +120,8 @@ func main() {
 	lineChannelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
 	if lineChannelSecret != "" && lineChannelToken != "" {
 		lineHandler := handlers.NewLineWebhookHandler(database.DB)
+		// Persisted webhook events: retry failed / interrupted events
+		lineHandler.Events.StartWorker(stopJobs)
 
 		// POST route สำหรับ LINE webhook จริง
 		app.Post("/line/webhook", func(c *fiber.Ctx) error {
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
 )
 
 // Base model with common fields
@@ -69,6 +71,8 @@ type Branch struct {
 	Active    bool      `json:"active" gorm:"default:true"`
 	OpenTime  time.Time `json:"open_time" gorm:"type:time;not null;default:'08:00:00'"`
 	CloseTime time.Time `json:"close_time" gorm:"type:time;not null;default:'21:00:00'"`
+	// LeaveCutoffTime (HH:MM) - ต้องแจ้งลาก่อนเวลานี้ของวันก่อนเรียน ไม่งั้นนับเป็นลาช้า (หักชั่วโมง)
+	LeaveCutoffTime string `json:"leave_cutoff_time" gorm:"size:5;not null;default:'18:00'"`
 
 	// Relationships
 	Users    []User    `json:"users,omitempty" gorm:"foreignKey:BranchID"`
@@ -80,11 +84,15 @@ type Branch struct {
 // User model
 type User struct {
 	BaseModel
-	Username             string     `json:"username" gorm:"size:100;not null;uniqueIndex"`
-	Password             string     `json:"-" gorm:"size:255;not null"`
-	Email                *string    `json:"email" gorm:"size:255;uniqueIndex;default:null"`
-	Phone                string     `json:"phone" gorm:"size:20"`
-	LineID               string     `json:"line_id" gorm:"size:100"`
+	Username string  `json:"username" gorm:"size:100;not null;uniqueIndex"`
+	Password string  `json:"-" gorm:"size:255;not null"`
+	Email    *string `json:"email" gorm:"size:255;uniqueIndex;default:null"`
+	Phone    string  `json:"phone" gorm:"size:20"`
+	LineID   string  `json:"line_id" gorm:"size:100"`
+	// LINE userId ที่ยืนยันแล้วผ่าน link code (ใช้ส่งข้อความแทน LineID ที่พิมพ์เอง); LINE เดียวผูกได้หลายบัญชี เช่น ผู้ปกครอง
+	LineUserID           *string    `json:"line_user_id" gorm:"size:64;index;default:null"`
+	LineLinkedAt         *time.Time `json:"line_linked_at"`
+	LineUnfollowedAt     *time.Time `json:"line_unfollowed_at"`                                                                            // บล็อก/เลิกติดตาม OA = ส่งข้อความไม่ถึง
 	Role                 string     `json:"role" gorm:"size:50;not null;default:'student';type:enum('owner','admin','teacher','student')"` // owner, admin, teacher, student
 	BranchID             uint       `json:"branch_id" gorm:"not null"`
 	Status               string     `json:"status" gorm:"size:50;not null;default:'active';type:enum('active','inactive','suspended')"` // active, inactive, suspended
@@ -140,6 +148,9 @@ type Student struct {
 	ParentPhone      string `json:"parent_phone" gorm:"size:20"`
 	EmergencyContact string `json:"emergency_contact" gorm:"size:200"`
 	EmergencyPhone   string `json:"emergency_phone" gorm:"size:20"`
+	// ผู้ปกครองยินยอมรับ SMS แจ้งเตือน (ช่องทาง sms_parent จะไม่ส่งถ้ายังไม่ยินยอม)
+	ParentSMSOptIn   bool       `json:"parent_sms_opt_in" gorm:"column:parent_sms_opt_in;default:false"`
+	ParentSMSOptInAt *time.Time `json:"parent_sms_opt_in_at" gorm:"column:parent_sms_opt_in_at"`
 
 	// JSON Fields for complex data
 	PreferredTimeSlots   JSON `json:"preferred_time_slots" gorm:"type:json"`
@@ -226,6 +237,35 @@ type LineGroup struct {
 	LastLeftAt     *time.Time `json:"last_left_at"` // null ได้ถ้า OA ยังอยู่ในกลุ่ม
 	IsActive       bool       `json:"is_active" gorm:"default:true"`
 	MatchedGroupID *uint      `json:"matched_group_id" gorm:"index"`
+	// MatchLocked - แอดมินผูก/ยกเลิกการผูกเองแล้ว: matcher อัตโนมัติจะไม่เปลี่ยน
+	MatchLocked bool       `json:"match_locked" gorm:"default:false"`
+	MatchedBy   *uint      `json:"matched_by"` // null = matcher อัตโนมัติ
+	MatchedAt   *time.Time `json:"matched_at"`
+
+	// Relationships
+	MatchedGroup *Group `json:"matched_group,omitempty" gorm:"foreignKey:MatchedGroupID"`
+}
+
+// LineWebhookEvent model - LINE webhook event ที่รับมา (บันทึกก่อนตอบ 200) แล้วให้ worker ประมวลผลพร้อม retry
+type LineWebhookEvent struct {
+	BaseModel
+	WebhookEventID string     `json:"webhook_event_id" gorm:"size:64;not null;uniqueIndex"` // dedupe redelivery
+	EventType      string     `json:"event_type" gorm:"size:50;index"`
+	SourceType     string     `json:"source_type" gorm:"size:20"`
+	SourceID       string     `json:"source_id" gorm:"size:64;index"` // userId / groupId / roomId
+	IsRedelivery   bool       `json:"is_redelivery"`
+	EventTime      *time.Time `json:"event_time"`
+	Payload        JSON       `json:"payload" gorm:"type:json"`
+	Status         string     `json:"status" gorm:"size:20;default:'pending';index;type:enum('pending','processing','done','failed')"`
+
+	// Retry bookkeeping (same semantics as ScheduledJob)
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts   int        `json:"max_attempts" gorm:"default:5"`
+	LastError     string     `json:"last_error,omitempty" gorm:"type:text"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null;index"`
+	LockedBy      string     `json:"locked_by,omitempty" gorm:"size:100"`
+	LeaseUntil    *time.Time `json:"lease_until,omitempty"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
 }
 
 type User_inCourse struct {
@@ -328,18 +368,46 @@ type Notification struct {
 	MessageTh string `json:"message_th" gorm:"type:text"`
 	Type      string `json:"type" gorm:"size:50;not null;type:enum('info','warning','error','success')"` // info, warning, error, success
 	// Channels defines how to deliver/display this notification on the client side
-	// Allowed values: "normal", "popup", "line". Can contain multiple.
+	// Allowed values: "normal", "popup", "line", "email", "sms", "sms_parent". Can contain multiple.
 	// Note: MySQL JSON columns cannot have a DB-level DEFAULT. We set the default at insert time in code.
 	Channels JSON `json:"channels" gorm:"type:json"`
 	// Data contains optional structured payload for deep-links or actions (e.g., links to sessions)
 	Data   JSON       `json:"data,omitempty" gorm:"type:json"`
 	Read   bool       `json:"read" gorm:"default:false"`
 	ReadAt *time.Time `json:"read_at"`
+	// DedupeKey makes creation idempotent per user, e.g. "session:123:reminder:30m:user:9" (NULL = no dedupe)
+	DedupeKey *string `json:"dedupe_key,omitempty" gorm:"size:191;uniqueIndex"`
 
 	// Relationships
 	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
 }
 
+// NotificationDelivery model - สถานะการส่งแจ้งเตือนผ่านช่องทางภายนอก (email, sms ฯลฯ) ต่อผู้รับหนึ่งราย
+type NotificationDelivery struct {
+	BaseModel
+	NotificationID    uint       `json:"notification_id" gorm:"not null;index"` // 0 = sms_parent of a student without a user account
+	UserID            uint       `json:"user_id" gorm:"not null;index"`         // 0 = sms_parent of a student without a user account
+	StudentID         *uint      `json:"student_id,omitempty" gorm:"index"`     // set for sms_parent sent without a notification
+	Message           string     `json:"message,omitempty" gorm:"type:text"`    // text sent when there is no notification row
+	Channel           string     `json:"channel" gorm:"size:20;not null;index"`
+	Recipient         string     `json:"recipient" gorm:"size:255"` // email address / phone / LINE userId
+	Status            string     `json:"status" gorm:"size:20;not null;default:'pending';index;type:enum('pending','sent','failed','skipped')"`
+	Attempts          int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts       int        `json:"max_attempts" gorm:"default:3"`
+	NextAttemptAt     *time.Time `json:"next_attempt_at,omitempty" gorm:"index"` // pending: next (re)try, doubles as the claim lease
+	LastError         string     `json:"last_error,omitempty" gorm:"type:text"`  // error, or why it was skipped
+	ProviderMessageID string     `json:"provider_message_id,omitempty" gorm:"size:255"`
+	SentAt            *time.Time `json:"sent_at"`
+}
+
+// SMSDailyCount reserves the per-recipient daily SMS budget (one row per phone per Bangkok day)
+type SMSDailyCount struct {
+	BaseModel
+	Recipient string `json:"recipient" gorm:"size:32;not null;uniqueIndex:idx_sms_daily_recipient_day"`
+	Day       string `json:"day" gorm:"size:10;not null;uniqueIndex:idx_sms_daily_recipient_day"` // YYYY-MM-DD (Asia/Bangkok)
+	Count     int    `json:"count" gorm:"not null;default:0"`
+}
+
 // LogArchive model for tracking archived logs
 type LogArchive struct {
 	BaseModel
@@ -361,7 +429,7 @@ type Schedule_Sessions struct {
 	End_time              *time.Time `json:"end_time,omitempty" gorm:"not null"`
 	Session_number        int        `json:"session_number" gorm:"not null"`
 	Week_number           int        `json:"week_number" gorm:"not null"`
-	Status                string     `json:"status" gorm:"size:50;default:'scheduled';type:enum('scheduled','confirmed','pending','completed','cancelled','rescheduled','no-show')"` // scheduled, confirmed, pending, completed, cancelled, rescheduled, no-show
+	Status                string     `json:"status" gorm:"size:50;default:'scheduled';type:enum('scheduled','assigned','confirmed','pending','completed','cancelled','rescheduled','no-show')"` // scheduled, assigned (awaiting teacher confirmation), confirmed, pending, completed, cancelled, rescheduled, no-show
 	Cancelling_Reason     string     `json:"cancelling_reason" gorm:"type:text"`
 	Is_makeup             bool       `json:"is_makeup" gorm:"default:false"`            //เป็นชดเชยไหม
 	Makeup_for_session_id *uint      `json:"makeup_for_session_id" gorm:"default:null"` // ชดเชยให้กับ Session ID ไหน
@@ -372,6 +440,7 @@ type Schedule_Sessions struct {
 	RoomID            *uint      `json:"room_id" gorm:"default:null"`             // Room can be different per session
 	ConfirmedAt       *time.Time `json:"confirmed_at"`
 	ConfirmedByUserID *uint      `json:"confirmed_by_user_id"`
+	Revision          int        `json:"revision" gorm:"not null;default:0"` // เพิ่มทุกครั้งที่แก้ไข session (ใช้เป็น SEQUENCE ใน iCal)
 
 	// Relationships
 	Schedule        *Schedules `json:"schedule,omitempty" gorm:"foreignKey:ScheduleID"`
@@ -380,6 +449,32 @@ type Schedule_Sessions struct {
 	ConfirmedBy     *User      `json:"confirmed_by,omitempty" gorm:"foreignKey:ConfirmedByUserID"`
 }
 
+// BeforeUpdate bumps Revision on every update made through GORM (Save, Updates, Update).
+// The bump is always revision + 1 in SQL, never a value from the struct: a batch
+// Model(&Schedule_Sessions{}).Where(...).Updates(struct) or a stale struct must not move it back.
+func (s *Schedule_Sessions) BeforeUpdate(tx *gorm.DB) error {
+	bump := gorm.Expr("revision + 1")
+	if _, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		tx.Statement.SetColumn("revision", bump)
+		return nil
+	}
+	// struct updates: SetColumn can only store a plain value in the struct, so build the SET
+	// clause here (the update callback uses an existing SET clause as is)
+	set := callbacks.ConvertToAssignments(tx.Statement)
+	if len(set) == 0 {
+		return nil
+	}
+	bumped := make(clause.Set, 0, len(set)+1)
+	for _, a := range set {
+		if a.Column.Name != "revision" {
+			bumped = append(bumped, a)
+		}
+	}
+	bumped = append(bumped, clause.Assignment{Column: clause.Column{Name: "revision"}, Value: bump})
+	tx.Statement.AddClause(bumped)
+	return nil
+}
+
 type Schedules struct {
 	BaseModel
 	// Core schedule information
@@ -394,6 +489,7 @@ type Schedules struct {
 
 	// Schedule timing and recurrence
 	Recurring_pattern  string     `json:"recurring_pattern" gorm:"size:100;type:enum('daily','weekly','bi-weekly','monthly','yearly','custom')"` // daily, weekly, bi-weekly, monthly, yearly, custom
+	RecurrenceRule     string     `json:"recurrence_rule,omitempty" gorm:"type:text"`                                                            // RFC 5545 RRULE (+ EXDATE); overrides the Recurring_pattern preset when set
 	Total_hours        int        `json:"total_hours"`
 	Hours_per_session  int        `json:"hours_per_session"`
 	Session_per_week   int        `json:"session_per_week"`
@@ -462,8 +558,8 @@ type ScheduleParticipant struct {
 // SessionConfirmation model - tracks session confirmations
 type SessionConfirmation struct {
 	BaseModel
-	SessionID   uint       `json:"session_id" gorm:"not null"`
-	UserID      uint       `json:"user_id" gorm:"not null"`
+	SessionID   uint       `json:"session_id" gorm:"not null;uniqueIndex:idx_session_confirmation_user"`
+	UserID      uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_session_confirmation_user"`
 	Status      string     `json:"status" gorm:"size:50;default:'pending';type:enum('pending','confirmed','declined','no_show')"`
 	ConfirmedAt *time.Time `json:"confirmed_at"`
 	DeclinedAt  *time.Time `json:"declined_at"`
@@ -474,6 +570,85 @@ type SessionConfirmation struct {
 	User    User              `json:"user" gorm:"foreignKey:UserID"`
 }
 
+// ScheduledJob model - durable delayed job (e.g. session reminders) claimed by workers with a lease
+type ScheduledJob struct {
+	BaseModel
+	JobType    string  `json:"job_type" gorm:"size:100;not null;index"`
+	SessionID  *uint   `json:"session_id" gorm:"index"`
+	ScheduleID *uint   `json:"schedule_id" gorm:"index"`
+	Payload    JSON    `json:"payload" gorm:"type:json"`
+	DedupeKey  *string `json:"dedupe_key,omitempty" gorm:"size:191;uniqueIndex"` // prevents enqueueing the same reminder twice
+
+	RunAt  time.Time `json:"run_at" gorm:"not null;index"`
+	Status string    `json:"status" gorm:"size:20;default:'pending';index;type:enum('pending','running','done','failed','cancelled')"`
+
+	// Retry bookkeeping
+	Attempts    int    `json:"attempts" gorm:"default:0"`
+	MaxAttempts int    `json:"max_attempts" gorm:"default:5"`
+	LastError   string `json:"last_error,omitempty" gorm:"type:text"`
+
+	// Lease: a worker owns a running job until LeaseUntil; expired leases are re-claimable
+	LockedBy    string     `json:"locked_by,omitempty" gorm:"size:100"`
+	LeaseUntil  *time.Time `json:"lease_until,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// CalendarFeedToken model - secret, revocable token behind an iCalendar (.ics) subscription URL
+type CalendarFeedToken struct {
+	BaseModel
+	UserID  uint   `json:"user_id" gorm:"not null;index"`                                                  // owner (whose sessions for scope 'user')
+	Token   string `json:"-" gorm:"size:64;not null;uniqueIndex"`                                          // exposed only through the feed URL
+	Scope   string `json:"scope" gorm:"size:20;not null;default:'user';type:enum('user','room','branch')"` // user, room, branch
+	ScopeID *uint  `json:"scope_id"`                                                                       // room/branch ID
+	Name    string `json:"name" gorm:"size:100"`
+
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// LineLinkCode model - รหัสครั้งเดียวสำหรับเชื่อมบัญชี LINE: ผู้ใช้ส่งรหัสไปที่ LINE OA แล้ว webhook ผูก userId จริง
+type LineLinkCode struct {
+	BaseModel
+	UserID     uint       `json:"user_id" gorm:"not null;index"`
+	Code       string     `json:"-" gorm:"size:16;not null;uniqueIndex"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt     *time.Time `json:"used_at"`
+	LineUserID string     `json:"line_user_id" gorm:"size:64"` // ผู้ที่ใช้รหัส
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// LineMessageTemplate model - ข้อความ LINE ที่แอดมินแก้ไขได้: Flex message (JSON) + ข้อความ text สำรอง
+// ตัวแปรเขียนเป็น {{group_name}} ตามรายการของแต่ละ key
+type LineMessageTemplate struct {
+	BaseModel
+	Key          string `json:"key" gorm:"size:100;not null;uniqueIndex"` // daily_class_reminder, announcement
+	Name         string `json:"name" gorm:"size:150"`
+	AltText      string `json:"alt_text" gorm:"size:400"`       // ข้อความแจ้งเตือน/preview ของ Flex (LINE จำกัด 400 ตัวอักษร)
+	FlexJSON     string `json:"flex_json" gorm:"type:longtext"` // bubble หรือ carousel; ว่าง = ส่ง text อย่างเดียว
+	TextFallback string `json:"text_fallback" gorm:"type:text"` // ใช้เมื่อไม่มี Flex หรือ Flex render ไม่ผ่าน
+	Active       bool   `json:"active" gorm:"default:true"`     // false = ใช้ค่าเริ่มต้นของระบบ
+	UpdatedBy    *uint  `json:"updated_by"`
+}
+
+// Holiday model - public holidays and branch closures skipped when generating/rescheduling sessions
+type Holiday struct {
+	BaseModel
+	Date     time.Time `json:"date" gorm:"type:date;not null;index"`
+	Name     string    `json:"name" gorm:"size:255;not null"`
+	Type     string    `json:"type" gorm:"size:20;not null;default:'holiday';type:enum('holiday','closure')"`         // holiday, closure
+	BranchID *uint     `json:"branch_id" gorm:"index"`                                                                // nil = ทุกสาขา
+	Source   string    `json:"source" gorm:"size:20;not null;default:'manual';type:enum('manual','myhora','import')"` // manual, myhora, import
+	Notes    string    `json:"notes" gorm:"type:text"`
+
+	// Relationships
+	Branch *Branch `json:"branch,omitempty" gorm:"foreignKey:BranchID"`
+}
+
 // NotificationPreference model - configurable notification settings
 type NotificationPreference struct {
 	BaseModel
@@ -636,15 +811,21 @@ type Bill struct {
 // ประวัติการลา
 type Absence struct {
 	BaseModel
-	GroupID    uint       `json:"group_id" gorm:"not null"`
-	SessionID  uint       `json:"session_id" gorm:"not null"`
-	Reason     string     `json:"reason" gorm:"type:text"`
-	Status     string     `json:"status" gorm:"size:50;default:'approved';type:enum('approved','rejected','pending')"`
-	Note       string     `json:"note" gorm:"type:text"`
-	ApprovedBy *uint      `json:"approved_by" gorm:"default:null"` // user_id ของแอดมินที่กดอนุมัติ
-	ApprovedAt *time.Time `json:"approved_at"`
+	GroupID   uint   `json:"group_id" gorm:"not null"`
+	SessionID uint   `json:"session_id" gorm:"not null"`
+	StudentID *uint  `json:"student_id" gorm:"index;default:null"` // null = ลาทั้งกลุ่ม
+	Reason    string `json:"reason" gorm:"type:text"`
+	Status    string `json:"status" gorm:"size:50;default:'approved';type:enum('approved','rejected','pending','forfeited')"`
+	// IsLate - แจ้งลาหลัง cutoff ของสาขา: ไม่ใช้ quota แต่หักชั่วโมงเรียน (HoursDeducted), status = forfeited
+	IsLate        bool       `json:"is_late" gorm:"default:false"`
+	HoursDeducted float64    `json:"hours_deducted" gorm:"type:decimal(8,2);default:0"`
+	Note          string     `json:"note" gorm:"type:text"`
+	ApprovedBy    *uint      `json:"approved_by" gorm:"default:null"` // user_id ของแอดมินที่กดอนุมัติ
+	ApprovedAt    *time.Time `json:"approved_at"`
 	// CreatedBy stores the user ID who created the absence request
 	CreatedBy uint `json:"created_by" gorm:"not null"`
+	// MakeupSessionID - makeup session ที่จองจากการลานี้ (ถ้ามี)
+	MakeupSessionID *uint `json:"makeup_session_id" gorm:"default:null"`
 
 	// Relationship
 	ApprovedByUser *User             `json:"approved_by_user,omitempty" gorm:"foreignKey:ApprovedBy"`
@@ -653,7 +834,44 @@ type Absence struct {
 	Session        Schedule_Sessions `json:"session" gorm:"foreignKey:SessionID"`
 }
 
-// จำนวนสิทธิ์การลาคงเหลือ
+// Attendance model - per-student attendance of a session (present, late, absent, excused)
+type Attendance struct {
+	BaseModel
+	SessionID  uint       `json:"session_id" gorm:"not null;uniqueIndex:idx_attendance_session_student"`
+	StudentID  uint       `json:"student_id" gorm:"not null;uniqueIndex:idx_attendance_session_student;index"`
+	GroupID    *uint      `json:"group_id" gorm:"index"`
+	Status     string     `json:"status" gorm:"size:20;not null;default:'present';type:enum('present','late','absent','excused')"`
+	CheckInAt  *time.Time `json:"check_in_at"`
+	Notes      string     `json:"notes" gorm:"type:text"`
+	AbsenceID  *uint      `json:"absence_id"`  // excused ผ่านการลาที่อนุมัติแล้ว
+	RecordedBy *uint      `json:"recorded_by"` // user_id ของครู/แอดมินที่เช็คชื่อ
+
+	// Relationships
+	Session *Schedule_Sessions `json:"session,omitempty" gorm:"foreignKey:SessionID"`
+	Student *Student           `json:"student,omitempty" gorm:"foreignKey:StudentID"`
+}
+
+// LearningHourEntry - ledger ชั่วโมงเรียนของนักเรียน (hours: + เพิ่มชั่วโมง, - ใช้/ตัดชั่วโมง)
+type LearningHourEntry struct {
+	BaseModel
+	StudentID uint    `json:"student_id" gorm:"not null;index;uniqueIndex:idx_learning_hours_session_entry"`
+	EntryType string  `json:"entry_type" gorm:"size:30;not null;type:enum('purchase','consumption','leave_refund','late_leave_forfeit','adjustment');uniqueIndex:idx_learning_hours_session_entry"`
+	Hours     float64 `json:"hours" gorm:"type:decimal(8,2);not null"`
+	// SessionID is set for consumption / refund / forfeit entries (one entry per student, session and type)
+	SessionID *uint  `json:"session_id" gorm:"uniqueIndex:idx_learning_hours_session_entry"`
+	GroupID   *uint  `json:"group_id" gorm:"index"`
+	CourseID  *uint  `json:"course_id"`
+	AbsenceID *uint  `json:"absence_id"`
+	BillID    *uint  `json:"bill_id"`
+	Reference string `json:"reference" gorm:"size:100"` // เช่น เลขที่ใบแจ้งหนี้
+	Notes     string `json:"notes" gorm:"type:text"`
+	CreatedBy *uint  `json:"created_by"` // null = ระบบบันทึกอัตโนมัติ
+
+	Student *Student           `json:"student,omitempty" gorm:"foreignKey:StudentID"`
+	Session *Schedule_Sessions `json:"session,omitempty" gorm:"foreignKey:SessionID"`
+}
+
+// จำนวนสิทธิ์การลาคงเหลือ (legacy: ใช้สิทธิ์รวมทั้งกลุ่ม ถูกย้ายไป StudentLeaveQuota แล้ว, แถวที่ย้ายแล้วถูก soft delete)
 type GroupLeaveQuota struct {
 	BaseModel
 	GroupID    uint       `gorm:"uniqueIndex;not null"` // ผูกกับกลุ่มเดียว
@@ -661,3 +879,45 @@ type GroupLeaveQuota struct {
 	UsedQuota  int        `gorm:"default:0"`            // สิทธิ์ที่ใช้ไปแล้ว
 	LastUsedAt *time.Time `json:"last_used_at"`
 }
+
+// LeaveQuotaPolicy - สิทธิ์ลาตามคอร์ส/แพ็กเกจ เช่น ลาได้ 2 ครั้งต่อ 20 ชั่วโมง
+type LeaveQuotaPolicy struct {
+	BaseModel
+	Name     string `json:"name" gorm:"size:100;not null"`
+	CourseID *uint  `json:"course_id" gorm:"index;default:null"` // null = นโยบายเริ่มต้นของทุกคอร์ส
+	Leaves   int    `json:"leaves" gorm:"not null"`
+	PerHours int    `json:"per_hours" gorm:"default:0"` // 0 = ได้ Leaves ครั้งต่อการลงทะเบียน
+	Active   bool   `json:"active" gorm:"default:true"`
+
+	Course *Course `json:"course,omitempty" gorm:"foreignKey:CourseID"`
+}
+
+// StudentLeaveQuota - สิทธิ์ลาของนักเรียนต่อการลงทะเบียน (นักเรียน + กลุ่ม)
+type StudentLeaveQuota struct {
+	BaseModel
+	StudentID     uint       `json:"student_id" gorm:"not null;uniqueIndex:idx_student_leave_quota"`
+	GroupID       uint       `json:"group_id" gorm:"not null;uniqueIndex:idx_student_leave_quota"`
+	CourseID      *uint      `json:"course_id" gorm:"index"`
+	PolicyID      *uint      `json:"policy_id"`
+	EnrolledHours float64    `json:"enrolled_hours" gorm:"type:decimal(8,2);default:0"`
+	BaseQuota     int        `json:"base_quota" gorm:"default:0"` // คำนวณจาก policy
+	Adjustment    int        `json:"adjustment" gorm:"default:0"` // แอดมินปรับเพิ่ม/ลด
+	UsedQuota     int        `json:"used_quota" gorm:"default:0"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+
+	Student *Student          `json:"student,omitempty" gorm:"foreignKey:StudentID"`
+	Group   *Group            `json:"group,omitempty" gorm:"foreignKey:GroupID"`
+	Policy  *LeaveQuotaPolicy `json:"policy,omitempty" gorm:"foreignKey:PolicyID"`
+}
+
+// LeaveQuotaEntry - ประวัติการใช้/ปรับสิทธิ์ลา (amount = การเปลี่ยนแปลงของสิทธิ์คงเหลือ)
+type LeaveQuotaEntry struct {
+	BaseModel
+	QuotaID   uint   `json:"quota_id" gorm:"not null;index"`
+	StudentID uint   `json:"student_id" gorm:"not null;index"`
+	EntryType string `json:"entry_type" gorm:"size:20;not null;type:enum('grant','use','adjustment','migration')"`
+	Amount    int    `json:"amount"`
+	AbsenceID *uint  `json:"absence_id"`
+	Notes     string `json:"notes" gorm:"type:text"`
+	CreatedBy *uint  `json:"created_by"`
+}
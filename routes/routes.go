@@ -32,6 +32,17 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	billsImportController := &controllers.BillsImportController{}
 	billsController := &controllers.BillsController{}
 	absenceController := &controllers.AbsenceController{}
+	attendanceController := &controllers.AttendanceController{}
+	learningHoursController := &controllers.LearningHoursController{}
+	leaveQuotaController := &controllers.LeaveQuotaController{}
+	jobController := &controllers.JobController{}
+	sessionConfirmationController := &controllers.SessionConfirmationController{}
+	calendarFeedController := &controllers.CalendarFeedController{}
+	holidayController := &controllers.HolidayController{}
+	lineLinkController := &controllers.LineLinkController{}
+	lineTemplateController := &controllers.LineTemplateController{}
+	lineGroupController := &controllers.LineGroupController{}
+	lineWebhookEventController := &controllers.LineWebhookEventController{}
 	settingsController := controllers.NewSettingsController()
 	healthController := controllers.NewHealthController(healthService)
 	wsController := controllers.NewWebSocketController(wsHub)
@@ -96,6 +107,9 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	api.Get("/courses/:id", courseController.GetCourse)
 	api.Get("/courses/branch/:branch_id", courseController.GetCoursesByBranch)
 
+	// iCalendar subscription feed - the secret token in the URL is the credential (no JWT)
+	api.Get("/calendar/:token.ics", calendarFeedController.GetFeed)
+
 	// Authentication routes (no middleware)
 	auth := api.Group("/auth")
 	auth.Post("/login", authController.Login)
@@ -161,6 +175,13 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	students.Get("/by-status/:status", middleware.RequireTeacherOrAbove(), studentController.GetStudentsByStatus) // Filter by registration status
 	students.Post("/:id/exam-scores", middleware.RequireTeacherOrAbove(), studentController.SetExamScores)        // Record exam scores
 
+	// Learning hours ledger (students can read their own balance/history)
+	students.Get("/:id/hours", learningHoursController.GetBalance)
+	students.Get("/:id/hours/history", learningHoursController.GetHistory)
+	students.Post("/:id/hours/purchase", middleware.RequireOwnerOrAdmin(), learningHoursController.PurchaseHours)
+	students.Post("/:id/hours/adjust", middleware.RequireOwnerOrAdmin(), learningHoursController.AdjustHours)
+	students.Get("/:id/leave-quotas", leaveQuotaController.GetStudentQuotas)
+
 	// Backward/alternate path for Update as per docs
 	api.Put("/v1/students/:id", middleware.JWTMiddleware(), middleware.RequireTeacherOrAbove(), studentController.UpdateStudent)
 
@@ -191,6 +212,11 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	notifications.Get("/", notificationController.GetNotifications)
 	notifications.Get("/unread-count", notificationController.GetUnreadCount)
 	notifications.Get("/stats", middleware.RequireOwnerOrAdmin(), notificationController.GetNotificationStats) //nolint:goconst
+	notifications.Get("/deliveries", middleware.RequireOwnerOrAdmin(), notificationController.GetDeliveries)
+	notifications.Get("/queue/stats", middleware.RequireOwnerOrAdmin(), notificationController.GetQueueStats)
+	notifications.Get("/queue/dead-letters", middleware.RequireOwnerOrAdmin(), notificationController.GetDeadLetters)
+	notifications.Post("/queue/dead-letters/requeue", middleware.RequireOwnerOrAdmin(), notificationController.RequeueDeadLetters)
+	notifications.Post("/queue/dead-letters/purge", middleware.RequireOwnerOrAdmin(), notificationController.PurgeDeadLetters)
 	notifications.Get("/:id", notificationController.GetNotification)
 	notifications.Post("/", middleware.RequireOwnerOrAdmin(), notificationController.CreateNotification)
 	notifications.Patch("/:id/read", notificationController.MarkAsRead)
@@ -208,6 +234,50 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	logs.Get("/export", logController.ExportLogs)
 	logs.Post("/flush-cache", logController.FlushCachedLogs)
 
+	// LINE account linking (one-time code sent to the OA) + admin view of link status
+	line := protected.Group("/line")
+	line.Post("/link-code", lineLinkController.CreateLinkCode)
+	line.Get("/link", lineLinkController.GetMyLink)
+	line.Delete("/link", lineLinkController.UnlinkMe)
+	line.Get("/links", middleware.RequireOwnerOrAdmin(), lineLinkController.GetLinks)
+	line.Delete("/links/:id", middleware.RequireOwnerOrAdmin(), lineLinkController.UnlinkUser)
+	// LINE message templates (Flex + text fallback) - owner/admin
+	line.Get("/templates", middleware.RequireOwnerOrAdmin(), lineTemplateController.GetTemplates)
+	line.Get("/templates/:key", middleware.RequireOwnerOrAdmin(), lineTemplateController.GetTemplate)
+	line.Put("/templates/:key", middleware.RequireOwnerOrAdmin(), lineTemplateController.UpdateTemplate)
+	line.Delete("/templates/:key", middleware.RequireOwnerOrAdmin(), lineTemplateController.ResetTemplate)
+	line.Post("/templates/:key/preview", middleware.RequireOwnerOrAdmin(), lineTemplateController.PreviewTemplate)
+
+	line.Get("/groups", middleware.RequireOwnerOrAdmin(), lineGroupController.GetLineGroups)
+	line.Get("/groups/unmatched", middleware.RequireOwnerOrAdmin(), lineGroupController.GetUnmatchedLineGroups)
+	line.Post("/groups/match", middleware.RequireOwnerOrAdmin(), lineGroupController.RunLineGroupMatcher)
+	line.Put("/groups/:id/link", middleware.RequireOwnerOrAdmin(), lineGroupController.LinkLineGroup)
+	line.Delete("/groups/:id/link", middleware.RequireOwnerOrAdmin(), lineGroupController.UnlinkLineGroup)
+	line.Patch("/groups/:id/lock", middleware.RequireOwnerOrAdmin(), lineGroupController.SetLineGroupLock)
+
+	line.Get("/webhook-events", middleware.RequireOwnerOrAdmin(), lineWebhookEventController.ListEvents)
+	line.Post("/webhook-events/replay", middleware.RequireOwnerOrAdmin(), lineWebhookEventController.ReplayFailedEvents)
+	line.Get("/webhook-events/:id", middleware.RequireOwnerOrAdmin(), lineWebhookEventController.GetEvent)
+	line.Post("/webhook-events/:id/replay", middleware.RequireOwnerOrAdmin(), lineWebhookEventController.ReplayEvent)
+
+	// Calendar feed management (personal feeds for everyone, room/branch feeds for owner/admin)
+	calendarFeeds := protected.Group("/calendar/feeds")
+	calendarFeeds.Get("/", calendarFeedController.ListFeeds)
+	calendarFeeds.Post("/", calendarFeedController.CreateFeed)
+	calendarFeeds.Delete("/:id", calendarFeedController.RevokeFeed)
+
+	// Holiday and branch closure calendar (read for everyone, manage for owner/admin)
+	holidays := protected.Group("/holidays")
+	holidays.Get("/", holidayController.GetHolidays)
+	holidays.Post("/", middleware.RequireOwnerOrAdmin(), holidayController.CreateHoliday)
+	holidays.Post("/import", middleware.RequireOwnerOrAdmin(), holidayController.ImportHolidays)
+	holidays.Post("/sync", middleware.RequireOwnerOrAdmin(), holidayController.SyncHolidays)
+	holidays.Post("/apply/preview", middleware.RequireOwnerOrAdmin(), holidayController.PreviewApplyHoliday)
+	holidays.Post("/apply", middleware.RequireOwnerOrAdmin(), holidayController.ApplyHoliday)
+	holidays.Get("/:id", holidayController.GetHoliday)
+	holidays.Put("/:id", middleware.RequireOwnerOrAdmin(), holidayController.UpdateHoliday)
+	holidays.Delete("/:id", middleware.RequireOwnerOrAdmin(), holidayController.DeleteHoliday)
+
 	// Schedule management routes
 	schedules := protected.Group("/schedules")
 
@@ -215,6 +285,7 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	schedules.Post("/", middleware.RequireOwnerOrAdmin(), scheduleController.CreateSchedule)
 	schedules.Post("/preview", middleware.RequireOwnerOrAdmin(), scheduleController.PreviewSchedule)
 	schedules.Post("/rooms/check-conflicts", middleware.RequireOwnerOrAdmin(), scheduleController.CheckRoomConflicts)
+	schedules.Post("/proposals", middleware.RequireOwnerOrAdmin(), scheduleController.ProposeTimetable) // เสนอเวลาเรียนอัตโนมัติ
 	schedules.Get("/", middleware.RequireOwnerOrAdmin(), scheduleController.GetSchedules)
 	schedules.Get("/teachers", middleware.RequireTeacherOrAbove(), scheduleController.GetTeachersSchedules)
 	// Alias singular path as requested
@@ -222,16 +293,31 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 	schedules.Get("/my", scheduleController.GetMySchedules)             // ดู schedule ของตัวเอง
 	schedules.Patch("/:id/confirm", scheduleController.ConfirmSchedule) // ยืนยัน schedule
 	schedules.Patch("/sessions/:id/confirm", scheduleController.ConfirmSession)
+	// Teacher accept/decline workflow (recorded in session_confirmations)
+	schedules.Patch("/sessions/:id/accept", middleware.RequireTeacherOrAbove(), sessionConfirmationController.AcceptSession)
+	schedules.Patch("/sessions/:id/decline", middleware.RequireTeacherOrAbove(), sessionConfirmationController.DeclineSession)
+	schedules.Get("/sessions/:id/confirmations", middleware.RequireTeacherOrAbove(), sessionConfirmationController.GetSessionConfirmations)
+	schedules.Get("/confirmations/dashboard", middleware.RequireOwnerOrAdmin(), sessionConfirmationController.GetConfirmationDashboard)
+	// Substitute teacher finder (ranked by availability, branch, specialization, teacher type)
+	schedules.Get("/sessions/:id/substitutes", middleware.RequireOwnerOrAdmin(), scheduleController.GetSubstituteTeachers)
+	schedules.Post("/sessions/:id/substitute", middleware.RequireOwnerOrAdmin(), scheduleController.AssignSubstituteTeacher)
 
 	// Session management
 	schedules.Get("/:id/sessions", scheduleController.GetScheduleSessions)          // ดู sessions ของ schedule
 	schedules.Get("/sessions/:id", scheduleController.GetSession)                   // ดูรายละเอียดของ session
 	schedules.Patch("/sessions/:id/status", scheduleController.UpdateSessionStatus) // อัพเดทสถานะ session
 	schedules.Post("/sessions/makeup", scheduleController.CreateMakeupSession)      // สร้าง makeup session
+
+	// Attendance - เช็คชื่อรายนักเรียนของ session
+	schedules.Get("/sessions/:id/attendance", middleware.RequireTeacherOrAbove(), attendanceController.GetSessionAttendance)
+	schedules.Put("/sessions/:id/attendance", middleware.RequireTeacherOrAbove(), attendanceController.RecordSessionAttendance)
+
 	// New: participant updates participation status for non-class schedules
 	schedules.Patch("/:id/participants/me", scheduleController.UpdateMyParticipationStatus)
 	// New: add a session into an existing schedule
 	schedules.Post("/:id/sessions", scheduleController.AddSessionToSchedule)
+	// Bulk edit teacher/room/slots/hours from session N onward (dry_run returns the diff only)
+	schedules.Post("/:id/bulk-edit", middleware.RequireOwnerOrAdmin(), scheduleController.BulkEditSchedule)
 
 	// Comment management
 	schedules.Post("/comments", scheduleController.AddComment) // เพิ่ม comment
@@ -271,16 +357,43 @@ func SetupRoutes(app *fiber.App, wsHub *websocket.Hub, healthService *services.H
 
 	// Absence routes
 	absences := protected.Group("/absences")
-	absences.Post("/", middleware.RequireOwnerOrAdmin(), absenceController.CreateAbsence)        // นักเรียนส่งคำขอลา
-	absences.Get("/", middleware.RequireTeacherOrAbove(), absenceController.GetAbsences)         // นักเรียน / ครู / แอดมิน ดูประวัติลา
-	absences.Get("/:id", middleware.RequireOwnerOrAdmin(), absenceController.GetAbsencesByGroup) // ดูรายละเอียดการลา
-	// absences.Patch("/:id/approve", middleware.RequireOwnerOrAdmin(), absenceController.ApproveAbsence) // อนุมัติ / ปฏิเสธการลา
+	absences.Post("/", absenceController.CreateAbsence)                                                // นักเรียนแจ้งลาของตัวเอง / แอดมินแจ้งแทน
+	absences.Post("/preview", absenceController.PreviewAbsence)                                        // ตรวจ cutoff ก่อนแจ้งลา (ลาช้า = หักชั่วโมง)
+	absences.Get("/", middleware.RequireTeacherOrAbove(), absenceController.GetAbsences)               // ครู / แอดมิน ดูประวัติลา
+	absences.Get("/me", absenceController.GetMyAbsences)                                               // นักเรียนดูการลาของตัวเอง
+	absences.Get("/:id", middleware.RequireOwnerOrAdmin(), absenceController.GetAbsencesByGroup)       // ดูรายละเอียดการลา
+	absences.Patch("/:id/approve", middleware.RequireOwnerOrAdmin(), absenceController.ApproveAbsence) // อนุมัติ / ปฏิเสธการลา
+	absences.Get("/:id/makeup-slots", absenceController.GetMakeupSlots)                                // ช่วงเวลาเรียนชดเชยที่เสนอ
+	absences.Post("/:id/makeup", absenceController.BookMakeup)                                         // จองเรียนชดเชย
+
+	// Leave quotas - สิทธิ์ลาต่อนักเรียนต่อการลงทะเบียน ตามนโยบายของคอร์ส
+	leaveQuotas := protected.Group("/leave-quotas")
+	leaveQuotas.Get("/policies", middleware.RequireTeacherOrAbove(), leaveQuotaController.GetPolicies)
+	leaveQuotas.Post("/policies", middleware.RequireOwnerOrAdmin(), leaveQuotaController.CreatePolicy)
+	leaveQuotas.Put("/policies/:id", middleware.RequireOwnerOrAdmin(), leaveQuotaController.UpdatePolicy)
+	leaveQuotas.Delete("/policies/:id", middleware.RequireOwnerOrAdmin(), leaveQuotaController.DeletePolicy)
+	leaveQuotas.Get("/:id/history", leaveQuotaController.GetQuotaHistory)
+	leaveQuotas.Post("/:id/adjust", middleware.RequireOwnerOrAdmin(), leaveQuotaController.AdjustQuota)
+
+	// Attendance summaries
+	attendance := protected.Group("/attendance")
+	attendance.Get("/groups/:id/summary", middleware.RequireTeacherOrAbove(), attendanceController.GetGroupAttendanceSummary)
+	attendance.Get("/students/:id/summary", attendanceController.GetStudentAttendanceSummary) // นักเรียนดูได้เฉพาะของตัวเอง
+
+	// Scheduled job routes (Admin/Owner only) - durable delayed reminders
+	jobs := protected.Group("/jobs", middleware.RequireOwnerOrAdmin())
+	jobs.Get("/", jobController.ListJobs)
+	jobs.Post("/:id/cancel", jobController.CancelJob)
+	jobs.Post("/sessions/:id/cancel", jobController.CancelSessionJobs)
 
 	// Settings routes
 	settings := protected.Group("/settings")
 	settings.Get("/me", settingsController.GetMySettings)
 	settings.Put("/me", settingsController.UpdateMySettings)
 	settings.Post("/me/custom-sound", settingsController.UploadMyCustomSound)
+	settings.Get("/me/notification-preferences", settingsController.GetMyNotificationPreferences)
+	settings.Put("/me/notification-preferences", settingsController.UpdateMyNotificationPreferences)
+	settings.Delete("/me/notification-preferences", settingsController.ResetMyNotificationPreferences)
 
 	// WebSocket routes
 	ws := protected.Group("/ws")
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+)
+
+// absenceAudience collects who hears about an absence: the students concerned (plus whoever filed it),
+// the session's teacher and a display label for the student / group
+type absenceAudience struct {
+	session    models.Schedule_Sessions
+	studentIDs []uint
+	teacherID  *uint
+	who        string
+	when       string
+}
+
+func loadAbsenceAudience(absence models.Absence) (*absenceAudience, error) {
+	db := database.DB
+	var session models.Schedule_Sessions
+	if err := db.Preload("Schedule").First(&session, absence.SessionID).Error; err != nil {
+		return nil, err
+	}
+	a := &absenceAudience{session: session}
+	if session.Start_time != nil {
+		a.when = session.Start_time.Format("2006-01-02 15:04")
+	}
+
+	a.teacherID = session.AssignedTeacherID
+	if a.teacherID == nil && session.Schedule != nil {
+		a.teacherID = session.Schedule.DefaultTeacherID
+	}
+
+	seen := map[uint]bool{}
+	add := func(id uint) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			a.studentIDs = append(a.studentIDs, id)
+		}
+	}
+	if absence.StudentID != nil {
+		var student models.Student
+		if err := db.First(&student, *absence.StudentID).Error; err == nil {
+			a.who = studentDisplayName(student)
+			if student.UserID != nil {
+				add(*student.UserID)
+			}
+		}
+	} else {
+		var group models.Group
+		if err := db.Select("id", "group_name").First(&group, absence.GroupID).Error; err == nil {
+			a.who = "กลุ่ม " + group.GroupName
+		}
+		for _, id := range GroupStudentUserIDs(db, absence.GroupID) {
+			add(id)
+		}
+	}
+	// ผู้แจ้งลา (อาจเป็นผู้ปกครอง/แอดมิน) ไม่ต้องแจ้งซ้ำถ้าเป็นครูของคาบ
+	if a.teacherID == nil || *a.teacherID != absence.CreatedBy {
+		add(absence.CreatedBy)
+	}
+	return a, nil
+}
+
+func (a *absenceAudience) scheduleName() string {
+	if a.session.Schedule != nil {
+		return a.session.Schedule.ScheduleName
+	}
+	return fmt.Sprintf("session #%d", a.session.ID)
+}
+
+func absenceLink(absence models.Absence) map[string]any {
+	return map[string]any{"href": fmt.Sprintf("/api/absences/%d/makeup-slots", absence.ID), "method": "GET"}
+}
+
+// NotifyAbsenceFiled tells branch admins and the teacher that a leave was filed
+func NotifyAbsenceFiled(absence models.Absence) {
+	a, err := loadAbsenceAudience(absence)
+	if err != nil {
+		return
+	}
+	db := database.DB
+	recipients := BranchAdminUserIDs(db, SessionBranchID(db, a.session))
+	if a.teacherID != nil {
+		recipients = append(recipients, *a.teacherID)
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	title, titleTh := "New leave request", "มีคำขอลาใหม่"
+	msg := fmt.Sprintf("%s requested leave for '%s' at %s.", a.who, a.scheduleName(), a.when)
+	msgTh := fmt.Sprintf("%s แจ้งลาคลาส '%s' เวลา %s", a.who, a.scheduleName(), a.when)
+	if absence.IsLate {
+		title, titleTh = "Late leave received", "มีการแจ้งลาช้า"
+		msg += fmt.Sprintf(" Filed after the cutoff: %.2f hour(s) deducted.", absence.HoursDeducted)
+		msgTh += fmt.Sprintf(" (ลาช้า หักชั่วโมง %.2f ชม.)", absence.HoursDeducted)
+	}
+	data := map[string]any{
+		"action":     "review-absence",
+		"absence_id": absence.ID,
+		"session_id": absence.SessionID,
+		"link":       map[string]any{"href": fmt.Sprintf("/api/absences/%d/approve", absence.ID), "method": "PATCH"},
+	}
+	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "info", data, "popup", "normal")
+	if err := notifsvc.NewService().EnqueueOrCreate(recipients, q); err != nil {
+		log.Printf("Error notifying leave request %d: %v", absence.ID, err)
+	}
+}
+
+// NotifyAbsenceDecision informs the student(s), the teacher and the LINE group of an approval / rejection
+func NotifyAbsenceDecision(absence models.Absence, slots []MakeupSlot) {
+	a, err := loadAbsenceAudience(absence)
+	if err != nil {
+		return
+	}
+	db := database.DB
+	ns := notifsvc.NewService()
+
+	approved := absence.Status == "approved"
+	title, titleTh, typ := "Leave approved", "อนุมัติการลาแล้ว", "success"
+	msg := fmt.Sprintf("Leave for '%s' at %s has been approved.", a.scheduleName(), a.when)
+	msgTh := fmt.Sprintf("อนุมัติการลาคลาส '%s' เวลา %s แล้ว", a.scheduleName(), a.when)
+	if approved && len(slots) > 0 {
+		msg += fmt.Sprintf(" %d makeup slot(s) are available to book.", len(slots))
+		msgTh += fmt.Sprintf(" เลือกเวลาเรียนชดเชยได้ %d ช่วงเวลา", len(slots))
+	}
+	if !approved {
+		title, titleTh, typ = "Leave rejected", "ไม่อนุมัติการลา", "warning"
+		msg = fmt.Sprintf("Leave for '%s' at %s was not approved.", a.scheduleName(), a.when)
+		msgTh = fmt.Sprintf("คำขอลาคลาส '%s' เวลา %s ไม่ได้รับการอนุมัติ", a.scheduleName(), a.when)
+		if absence.Note != "" {
+			msg += " " + absence.Note
+			msgTh += " " + absence.Note
+		}
+	}
+	data := map[string]any{
+		"action":       "open-absence",
+		"absence_id":   absence.ID,
+		"session_id":   absence.SessionID,
+		"makeup_slots": slots,
+		"link":         absenceLink(absence),
+	}
+	if len(a.studentIDs) > 0 {
+		q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, typ, data, "popup", "normal", notifsvc.ChannelLine)
+		if err := ns.EnqueueOrCreate(a.studentIDs, q); err != nil {
+			log.Printf("Error notifying students of absence %d: %v", absence.ID, err)
+		}
+	}
+
+	if a.teacherID != nil && approved {
+		q := notifsvc.QueuedWithData(
+			"Student on leave", "นักเรียนลาเรียน",
+			fmt.Sprintf("%s will miss '%s' at %s.", a.who, a.scheduleName(), a.when),
+			fmt.Sprintf("%s ลาเรียนคลาส '%s' เวลา %s", a.who, a.scheduleName(), a.when),
+			"info", map[string]any{"action": "open-session", "session_id": absence.SessionID, "absence_id": absence.ID}, "normal",
+		)
+		if err := ns.EnqueueOrCreate([]uint{*a.teacherID}, q); err != nil {
+			log.Printf("Error notifying teacher of absence %d: %v", absence.ID, err)
+		}
+	}
+
+	if approved {
+		PushLineToGroup(db, absence.GroupID, fmt.Sprintf("📢 แจ้งลาเรียน\n%s ลาคลาส '%s' เวลา %s", a.who, a.scheduleName(), a.when))
+	}
+}
+
+// NotifyMakeupBooked informs the student(s), the teacher and the LINE group about a booked makeup
+func NotifyMakeupBooked(absence models.Absence, makeup models.Schedule_Sessions) {
+	a, err := loadAbsenceAudience(absence)
+	if err != nil {
+		return
+	}
+	db := database.DB
+	ns := notifsvc.NewService()
+
+	when := ""
+	if makeup.Start_time != nil {
+		when = makeup.Start_time.Format("2006-01-02 15:04")
+	}
+	msg := fmt.Sprintf("Makeup for '%s' is booked at %s.", a.scheduleName(), when)
+	msgTh := fmt.Sprintf("จองเรียนชดเชยคลาส '%s' เวลา %s แล้ว", a.scheduleName(), when)
+	data := map[string]any{
+		"action":     "open-session",
+		"absence_id": absence.ID,
+		"session_id": makeup.ID,
+		"link":       map[string]any{"href": fmt.Sprintf("/api/schedules/sessions/%d", makeup.ID), "method": "GET"},
+	}
+
+	// นักเรียนได้ LINE ด้วย ครูได้เฉพาะในระบบ
+	if len(a.studentIDs) > 0 {
+		q := notifsvc.QueuedWithData("Makeup session booked", "จองเรียนชดเชยแล้ว", msg, msgTh, "info", data, "popup", "normal", notifsvc.ChannelLine)
+		if err := ns.EnqueueOrCreate(a.studentIDs, q); err != nil {
+			log.Printf("Error notifying makeup for absence %d: %v", absence.ID, err)
+		}
+	}
+	if a.teacherID != nil {
+		q := notifsvc.QueuedWithData("Makeup session booked", "จองเรียนชดเชยแล้ว", msg, msgTh, "info", data, "popup", "normal")
+		if err := ns.EnqueueOrCreate([]uint{*a.teacherID}, q); err != nil {
+			log.Printf("Error notifying makeup for absence %d: %v", absence.ID, err)
+		}
+	}
+	PushLineToGroup(db, absence.GroupID, fmt.Sprintf("📢 เรียนชดเชย\n%s: คลาส '%s' เวลา %s", a.who, a.scheduleName(), when))
+}
@@ -3,65 +3,461 @@ package services
 import (
 	"englishkorat_go/database"
 	"englishkorat_go/models"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// สร้างการลา
-func CreateAbsence(groupID, sessionID uint, userId uint, reason string) (*models.Absence, error) {
-	// ตรวจสอบ quota
-	var quota models.GroupLeaveQuota
-	if err := database.DB.Where("group_id = ?", groupID).First(&quota).Error; err != nil {
-		return nil, fmt.Errorf("ไม่พบสิทธิ์การลา")
+// DefaultLeaveCutoff ใช้เมื่อสาขาไม่ได้ตั้ง leave_cutoff_time (แจ้งลาก่อน 18:00 ของวันก่อนเรียน)
+const DefaultLeaveCutoff = "18:00"
+
+// ErrLateLeaveNotConfirmed - ลาช้าต้องให้ผู้ขอยืนยันว่ารับทราบการหักชั่วโมงก่อน
+var ErrLateLeaveNotConfirmed = errors.New("late leave must be confirmed")
+
+// ErrAlreadyOnLeave - นักเรียน (หรือทั้งกลุ่ม) มีการลาคาบนี้ที่ยังไม่ถูกปฏิเสธอยู่แล้ว
+var ErrAlreadyOnLeave = errors.New("already on leave for this session")
+
+// AbsenceForfeited - สถานะของการลาช้า: รับเรื่องโดยระบบ (ไม่มีผู้อนุมัติ) และหักชั่วโมงเรียนแทนการใช้สิทธิ์ลา
+const AbsenceForfeited = "forfeited"
+
+// AbsenceRequest is the input of CreateAbsence
+type AbsenceRequest struct {
+	GroupID     uint   `json:"group_id"`
+	SessionID   uint   `json:"session_id"`
+	StudentID   *uint  `json:"student_id"` // ไม่ส่ง = นักเรียนของผู้ใช้ที่ล็อกอิน (หรือทั้งกลุ่มถ้าแอดมินแจ้ง)
+	Reason      string `json:"reason"`
+	ConfirmLate bool   `json:"confirm_late"`
+}
+
+// LeaveAssessment tells the requester whether the leave is on time and what happens if it is late
+type LeaveAssessment struct {
+	SessionID     uint      `json:"session_id"`
+	SessionStart  time.Time `json:"session_start"`
+	Cutoff        time.Time `json:"cutoff"`
+	CutoffTime    string    `json:"cutoff_time"`
+	IsLate        bool      `json:"is_late"`
+	UsesQuota     bool      `json:"uses_quota"`
+	HoursDeducted float64   `json:"hours_deducted"`
+	StudentIDs    []uint    `json:"student_ids"`
+	Message       string    `json:"message"`
+	MessageTh     string    `json:"message_th"`
+}
+
+// ValidLeaveCutoff checks a HH:MM cutoff
+func ValidLeaveCutoff(value string) bool {
+	_, err := time.Parse("15:04", strings.TrimSpace(value))
+	return err == nil
+}
+
+// LeaveCutoffFor returns the HH:MM leave cutoff of the schedule's branch
+func LeaveCutoffFor(schedule models.Schedules) string {
+	if branchID := ScheduleBranchID(database.DB, schedule); branchID != nil {
+		var branch models.Branch
+		if err := database.DB.Select("id", "leave_cutoff_time").First(&branch, *branchID).Error; err == nil && ValidLeaveCutoff(branch.LeaveCutoffTime) {
+			return strings.TrimSpace(branch.LeaveCutoffTime)
+		}
+	}
+	return DefaultLeaveCutoff
+}
+
+// leaveCutoffAt - วันก่อนเรียน เวลา cutoff (เวลาไทย)
+func leaveCutoffAt(sessionDate time.Time, cutoff string) time.Time {
+	t, err := time.Parse("15:04", cutoff)
+	if err != nil {
+		t, _ = time.Parse("15:04", DefaultLeaveCutoff)
+	}
+	day := holidayDate(sessionDate).AddDate(0, 0, -1)
+	return day.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+}
+
+// resolveAbsenceStudents returns who the leave is for: the given student, the requester's student
+// profile, or (for staff filing on behalf of the group) every active member
+func resolveAbsenceStudents(groupID, userID uint, studentID *uint) (*uint, []uint, error) {
+	if studentID == nil {
+		var student models.Student
+		if err := database.DB.Select("id").Where("user_id = ?", userID).First(&student).Error; err == nil {
+			studentID = &student.ID
+		}
+	}
+	if studentID != nil {
+		var member models.GroupMember
+		if err := database.DB.Where("group_id = ? AND student_id = ? AND status = ?", groupID, *studentID, "active").First(&member).Error; err != nil {
+			return nil, nil, fmt.Errorf("นักเรียนไม่ได้อยู่ในกลุ่มนี้")
+		}
+		return studentID, []uint{*studentID}, nil
+	}
+	var ids []uint
+	database.DB.Model(&models.GroupMember{}).
+		Where("group_id = ? AND status = ?", groupID, "active").
+		Pluck("student_id", &ids)
+	return nil, ids, nil
+}
+
+// AssessLeave checks the branch cutoff for a leave request without creating it
+func AssessLeave(groupID, sessionID, userID uint, studentID *uint) (*LeaveAssessment, *uint, error) {
+	var session models.Schedule_Sessions
+	if err := database.DB.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		return nil, nil, fmt.Errorf("ไม่พบคาบเรียน")
+	}
+	if session.Schedule == nil || session.Schedule.GroupID == nil || *session.Schedule.GroupID != groupID {
+		return nil, nil, fmt.Errorf("คาบเรียนนี้ไม่ใช่ของกลุ่มที่แจ้งลา")
+	}
+	if session.Session_date == nil || session.Start_time == nil {
+		return nil, nil, fmt.Errorf("คาบเรียนไม่มีวันเวลาเรียน")
+	}
+	now := time.Now()
+	if !session.Start_time.After(now) {
+		return nil, nil, fmt.Errorf("ไม่สามารถแจ้งลาคาบเรียนที่เริ่มไปแล้ว")
+	}
+
+	resolved, studentIDs, err := resolveAbsenceStudents(groupID, userID, studentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cutoffTime := LeaveCutoffFor(*session.Schedule)
+	cutoff := leaveCutoffAt(*session.Session_date, cutoffTime)
+	// ลาทั้งกลุ่ม (เจ้าหน้าที่แจ้งแทน) ไม่นับเป็นลาช้า: เข้าขั้นตอนอนุมัติตามปกติ ไม่หักชั่วโมงของทุกคนในกลุ่ม
+	a := &LeaveAssessment{
+		SessionID:    session.ID,
+		SessionStart: *session.Start_time,
+		Cutoff:       cutoff,
+		CutoffTime:   cutoffTime,
+		IsLate:       resolved != nil && now.After(cutoff),
+		StudentIDs:   studentIDs,
 	}
-	if quota.UsedQuota >= quota.TotalQuota {
-		return nil, fmt.Errorf("ใช้สิทธิ์ลาครบแล้ว")
+	a.UsesQuota = !a.IsLate
+	cutoffLabel := cutoff.Format("02/01/2006 15:04")
+	if resolved == nil && now.After(cutoff) {
+		a.Message = fmt.Sprintf("Whole-group leave after the cutoff (%s) goes to approval as usual. No hours are deducted.", cutoffLabel)
+		a.MessageTh = fmt.Sprintf("แจ้งลาทั้งกลุ่มหลังเวลาที่กำหนด (%s น.) รออนุมัติตามปกติ ไม่หักชั่วโมงเรียน", cutoffLabel)
+	} else if a.IsLate {
+		a.HoursDeducted = SessionHours(session)
+		a.Message = fmt.Sprintf("This leave is after the cutoff (%s). It will not use leave quota, but %.2f hour(s) will be deducted from the student's learning hours.", cutoffLabel, a.HoursDeducted)
+		a.MessageTh = fmt.Sprintf("แจ้งลาหลังเวลาที่กำหนด (%s น.) จะไม่ใช้สิทธิ์ลา แต่ระบบจะหักชั่วโมงเรียน %.2f ชม.", cutoffLabel, a.HoursDeducted)
+	} else {
+		a.Message = fmt.Sprintf("Leave is on time (before %s) and uses one leave from the quota.", cutoffLabel)
+		a.MessageTh = fmt.Sprintf("แจ้งลาทันเวลา (ก่อน %s น.) ใช้สิทธิ์ลา 1 ครั้ง", cutoffLabel)
+	}
+	return a, resolved, nil
+}
+
+// สร้างการลา - ลาทันเวลาใช้ quota และรออนุมัติ, ลาช้ารับเรื่องทันทีแต่หักชั่วโมงเรียน (ต้อง confirm_late)
+func CreateAbsence(req AbsenceRequest, userId uint) (*models.Absence, *LeaveAssessment, error) {
+	assessment, studentID, err := AssessLeave(req.GroupID, req.SessionID, userId, req.StudentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if onLeave, err := alreadyOnLeave(database.DB, req.SessionID, studentID); err != nil {
+		return nil, assessment, err
+	} else if onLeave {
+		return nil, assessment, ErrAlreadyOnLeave
+	}
+
+	if assessment.IsLate {
+		if !req.ConfirmLate {
+			return nil, assessment, ErrLateLeaveNotConfirmed
+		}
+		return createLateAbsence(req, userId, studentID, assessment)
+	}
+
+	// ตรวจสอบ quota ของนักเรียนแต่ละคน (ต่อการลงทะเบียน)
+	if err := NewLeaveQuotaService().CheckAvailable(req.GroupID, assessment.StudentIDs); err != nil {
+		return nil, assessment, leaveQuotaError(err)
 	}
 
 	absence := models.Absence{
-		GroupID:   groupID,
-		SessionID: sessionID,
-		Reason:    reason,
+		GroupID:   req.GroupID,
+		SessionID: req.SessionID,
+		StudentID: studentID,
+		Reason:    req.Reason,
 		Status:    "pending",
 		CreatedBy: userId,
 	}
-	if err := database.DB.Create(&absence).Error; err != nil {
-		return nil, err
+	if err := insertAbsence(&absence, nil); err != nil {
+		return nil, assessment, err
 	}
-	return &absence, nil
+	go NotifyAbsenceFiled(absence)
+	return &absence, assessment, nil
+}
+
+// leaveQuotaError turns a quota check error into the message shown to the requester
+func leaveQuotaError(err error) error {
+	switch {
+	case errors.Is(err, ErrLeaveQuotaExhausted):
+		return fmt.Errorf("ใช้สิทธิ์ลาครบแล้ว: %s", strings.TrimPrefix(err.Error(), ErrLeaveQuotaExhausted.Error()+": "))
+	case errors.Is(err, ErrLeaveQuotaNotFound):
+		return fmt.Errorf("ไม่พบสิทธิ์การลา")
+	default:
+		return fmt.Errorf("ตรวจสอบสิทธิ์การลาไม่สำเร็จ: %w", err)
+	}
+}
+
+// createLateAbsence - รับเรื่องลาช้าทันที (forfeited + is_late) ไม่แตะ quota และหักชั่วโมงเรียนของนักเรียน
+// ใน transaction เดียวกับการบันทึกการลา: หักไม่สำเร็จ = ไม่บันทึกการลา และคืน error ให้ผู้แจ้งลองใหม่
+func createLateAbsence(req AbsenceRequest, userID uint, studentID *uint, assessment *LeaveAssessment) (*models.Absence, *LeaveAssessment, error) {
+	var session models.Schedule_Sessions
+	if err := database.DB.Preload("Schedule").First(&session, req.SessionID).Error; err != nil {
+		return nil, assessment, err
+	}
+
+	absence := models.Absence{
+		GroupID:       req.GroupID,
+		SessionID:     req.SessionID,
+		StudentID:     studentID,
+		Reason:        req.Reason,
+		Status:        AbsenceForfeited,
+		IsLate:        true,
+		HoursDeducted: assessment.HoursDeducted,
+		Note:          assessment.MessageTh,
+		CreatedBy:     userID,
+	}
+	hours := NewLearningHoursService()
+	deducted := make(map[uint]float64)
+	err := insertAbsence(&absence, func(tx *gorm.DB) error {
+		for _, id := range assessment.StudentIDs {
+			h, err := hours.PostLateLeaveForfeit(tx, id, session, req.GroupID, absence.ID)
+			if err != nil {
+				return fmt.Errorf("post late leave forfeit for student %d: %w", id, err)
+			}
+			if h > 0 {
+				deducted[id] = h
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, assessment, err
+	}
+
+	for id, h := range deducted {
+		hours.AlertLowBalance(id, h)
+	}
+	go NotifyAbsenceFiled(absence)
+	return &absence, assessment, nil
+}
+
+// alreadyOnLeave reports whether the session already has a non-rejected leave covering the student.
+// A whole-group leave (studentID nil) only clashes with another whole-group leave.
+func alreadyOnLeave(db *gorm.DB, sessionID uint, studentID *uint) (bool, error) {
+	q := db.Model(&models.Absence{}).Where("session_id = ? AND status <> ?", sessionID, "rejected")
+	if studentID != nil {
+		q = q.Where("student_id = ? OR student_id IS NULL", *studentID)
+	} else {
+		q = q.Where("student_id IS NULL")
+	}
+	var count int64
+	if err := q.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// insertAbsence checks for a duplicate and creates the absence while holding a lock on the session row,
+// so two requests for the same student and session cannot both pass the check.
+// then (optional) runs in the same transaction after the absence is created.
+func insertAbsence(absence *models.Absence, then func(tx *gorm.DB) error) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Schedule_Sessions
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&session, absence.SessionID).Error; err != nil {
+			return err
+		}
+		onLeave, err := alreadyOnLeave(tx, absence.SessionID, absence.StudentID)
+		if err != nil {
+			return err
+		}
+		if onLeave {
+			return ErrAlreadyOnLeave
+		}
+		if err := tx.Create(absence).Error; err != nil {
+			return err
+		}
+		if then != nil {
+			return then(tx)
+		}
+		return nil
+	})
 }
 
 // อนุมัติ / ปฏิเสธการลา
-func ApproveAbsence(absenceID, adminID uint, approve bool) error {
+func ApproveAbsence(absenceID, adminID uint, approve bool, note string) (*models.Absence, error) {
 	var absence models.Absence
 	if err := database.DB.First(&absence, absenceID).Error; err != nil {
-		return err
+		return nil, err
 	}
 
 	if absence.Status != "pending" {
-		return fmt.Errorf("สถานะการลาได้รับการดำเนินการแล้ว")
+		return nil, fmt.Errorf("สถานะการลาได้รับการดำเนินการแล้ว")
 	}
 
+	quotas := NewLeaveQuotaService()
+	var studentIDs []uint
 	if approve {
-		absence.Status = "approved"
-		// อัพเดต quota
-		var quota models.GroupLeaveQuota
-		if err := database.DB.Where("group_id = ?", absence.GroupID).First(&quota).Error; err == nil {
-			quota.UsedQuota++
-			quota.LastUsedAt = ptrTime(time.Now())
-			database.DB.Save(&quota)
-		}
-		// อัพเดตสถานะ session
-		database.DB.Model(&models.Schedule_Sessions{}).
-			Where("id = ?", absence.SessionID).
-			Update("status", "rescheduled")
-	} else {
-		absence.Status = "rejected"
+		if absence.StudentID != nil {
+			studentIDs = []uint{*absence.StudentID}
+		} else {
+			database.DB.Model(&models.GroupMember{}).
+				Where("group_id = ? AND status = ?", absence.GroupID, "active").
+				Pluck("student_id", &studentIDs)
+		}
+		// สร้าง quota ที่ยังไม่มีก่อนเข้า transaction (เช็คซ้ำแบบล็อกแถวใน UseForAbsence)
+		if err := quotas.CheckAvailable(absence.GroupID, studentIDs); err != nil {
+			return nil, leaveQuotaError(err)
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var locked models.Absence
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, absenceID).Error; err != nil {
+			return err
+		}
+		if locked.Status != "pending" {
+			return fmt.Errorf("สถานะการลาได้รับการดำเนินการแล้ว")
+		}
+		absence = locked
+
+		if approve {
+			absence.Status = "approved"
+			// ลาทั้งกลุ่ม -> เลื่อน session, ลารายคนคาบเรียนยังเรียนตามปกติ
+			if absence.StudentID == nil {
+				if err := tx.Model(&models.Schedule_Sessions{}).
+					Where("id = ?", absence.SessionID).
+					Update("status", "rescheduled").Error; err != nil {
+					return err
+				}
+			}
+		} else {
+			absence.Status = "rejected"
+		}
+
+		if strings.TrimSpace(note) != "" {
+			absence.Note = strings.TrimSpace(note)
+		}
+		absence.ApprovedBy = &adminID
+		absence.ApprovedAt = ptrTime(time.Now())
+		if err := tx.Save(&absence).Error; err != nil {
+			return err
+		}
+		// การลาที่อนุมัติแล้ว -> ใช้สิทธิ์ลาของนักเรียน (ถ้าสิทธิ์หมดระหว่างนี้ ยกเลิกการอนุมัติทั้งหมด)
+		if absence.Status == "approved" {
+			return quotas.UseForAbsence(tx, absence, studentIDs)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrLeaveQuotaExhausted) || errors.Is(err, ErrLeaveQuotaNotFound) {
+			return nil, leaveQuotaError(err)
+		}
+		return nil, err
+	}
+
+	// เช็คชื่อเป็น excused อัตโนมัติ และคืนชั่วโมงที่ถูกตัดไปแล้ว
+	if absence.Status == "approved" {
+		if err := NewAttendanceService().ApplyApprovedAbsence(absence); err != nil {
+			log.Printf("apply approved absence %d to attendance: %v", absence.ID, err)
+		}
+		if _, err := NewLearningHoursService().PostLeaveRefund(absence); err != nil {
+			log.Printf("refund learning hours for absence %d: %v", absence.ID, err)
+		}
+	}
+	return &absence, nil
+}
+
+// CheckMakeupOffered - เสนอเรียนชดเชยเฉพาะการลาทั้งกลุ่มที่อนุมัติแล้ว (ลาทันเวลา)
+// การลารายคนได้คืนชั่วโมงเรียนแทน เพราะ makeup session เป็นของทั้งกลุ่ม (ตัดชั่วโมงและแจ้งทุกคน)
+func CheckMakeupOffered(absence models.Absence) error {
+	if absence.Status != "approved" || absence.IsLate {
+		return fmt.Errorf("%w: only approved on-time leaves can book a makeup", ErrMakeupInvalid)
+	}
+	if absence.StudentID != nil {
+		return fmt.Errorf("%w: makeups are booked for whole-group leaves only; the student's hours are refunded instead", ErrMakeupInvalid)
+	}
+	return nil
+}
+
+// CheckMakeupBookable - CheckMakeupOffered และยังไม่ได้จอง makeup ของการลานี้
+func CheckMakeupBookable(absence models.Absence) error {
+	if err := CheckMakeupOffered(absence); err != nil {
+		return err
+	}
+	if absence.MakeupSessionID != nil {
+		return fmt.Errorf("%w: a makeup is already booked for this leave", ErrMakeupInvalid)
+	}
+	return nil
+}
+
+// BookAbsenceMakeup turns one of the offered slots into a makeup session for an approved whole-group absence
+func BookAbsenceMakeup(absence *models.Absence, date time.Time, startTime string) (*models.Schedule_Sessions, error) {
+	if err := CheckMakeupBookable(*absence); err != nil {
+		return nil, err
+	}
+
+	var session models.Schedule_Sessions
+	if err := database.DB.Preload("Schedule").First(&session, absence.SessionID).Error; err != nil {
+		return nil, ErrMakeupOriginalNotFound
+	}
+	if session.Schedule == nil || session.Start_time == nil || session.End_time == nil {
+		return nil, fmt.Errorf("%w: session has no time", ErrMakeupInvalid)
+	}
+	if _, err := time.Parse("15:04", startTime); err != nil {
+		return nil, fmt.Errorf("%w: invalid time format, use HH:MM", ErrMakeupInvalid)
+	}
+
+	// ต้องเป็นช่วงเวลาที่ระบบเสนอ (เวลาเดิมของคาบ อยู่ในเวลาเปิดสาขา ไม่ตรงวันหยุด)
+	slots, err := SuggestMakeupSlots(session.ID, makeupSearchDays+1)
+	if err != nil {
+		return nil, err
+	}
+	slot := offeredSlot(slots, date, startTime)
+	if slot == nil {
+		return nil, fmt.Errorf("%w: %s %s is not one of the offered makeup slots", ErrMakeupInvalid, holidayDate(date).Format("2006-01-02"), startTime)
+	}
+
+	makeup, err := buildMakeupSession(session, holidayDate(slot.Start), startTime)
+	if err != nil {
+		return nil, err
+	}
+	teacherID := session.AssignedTeacherID
+	if teacherID == nil {
+		teacherID = session.Schedule.DefaultTeacherID
+	}
+	roomID := session.RoomID
+	if roomID == nil {
+		roomID = session.Schedule.DefaultRoomID
+	}
+
+	// ตรวจว่าง + สร้าง session ใน transaction เดียว (ล็อกการลา ครู ห้อง และกลุ่มไว้จนกว่าจะ commit)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var locked models.Absence
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, absence.ID).Error; err != nil {
+			return err
+		}
+		if err := CheckMakeupBookable(locked); err != nil {
+			return err
+		}
+		if err := lockSlotOwners(tx, teacherID, roomID, session.Schedule.GroupID); err != nil {
+			return err
+		}
+		if sessionSlotBusy(tx, *makeup.Start_time, *makeup.End_time, teacherID, roomID, session.Schedule.GroupID) {
+			return ErrMakeupSlotTaken
+		}
+		if err := tx.Create(&makeup).Error; err != nil {
+			return fmt.Errorf("failed to create makeup session: %w", err)
+		}
+		return tx.Model(&locked).Update("makeup_session_id", makeup.ID).Error
+	})
+	if err != nil {
+		return nil, err
 	}
+	absence.MakeupSessionID = &makeup.ID
 
-	absence.ApprovedBy = &adminID
-	absence.ApprovedAt = ptrTime(time.Now())
-	return database.DB.Save(&absence).Error
+	go NotifyMakeupBooked(*absence, makeup)
+	return &makeup, nil
 }
 
 func ptrTime(t time.Time) *time.Time { return &t }
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaveCutoffAt(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	// session date stored as midnight UTC of a Bangkok day must still map to that Bangkok day
+	session := time.Date(2025, 3, 10, 17, 0, 0, 0, time.UTC) // 2025-03-11 00:00 Bangkok
+
+	got := leaveCutoffAt(session, "18:00")
+	want := time.Date(2025, 3, 10, 18, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Fatalf("cutoff = %v, want %v", got, want)
+	}
+
+	got = leaveCutoffAt(time.Date(2025, 3, 1, 9, 0, 0, 0, loc), "20:30")
+	want = time.Date(2025, 2, 28, 20, 30, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Fatalf("cutoff across month = %v, want %v", got, want)
+	}
+
+	// invalid cutoff falls back to the default
+	got = leaveCutoffAt(time.Date(2025, 3, 1, 9, 0, 0, 0, loc), "late")
+	want = time.Date(2025, 2, 28, 18, 0, 0, 0, loc)
+	if !got.Equal(want) {
+		t.Fatalf("fallback cutoff = %v, want %v", got, want)
+	}
+}
+
+func TestValidLeaveCutoff(t *testing.T) {
+	for value, ok := range map[string]bool{"18:00": true, "07:30": true, " 9:05 ": true, "24:00": false, "18": false, "": false} {
+		if ValidLeaveCutoff(value) != ok {
+			t.Errorf("ValidLeaveCutoff(%q) = %v, want %v", value, !ok, ok)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+)
+
+// NotifyStudentsAbsent alerts students newly marked absent in-app, by SMS when they opted in,
+// and their parents by SMS (Student.ParentPhone, only when the parent opted in). Students without
+// a user account only get the parent SMS.
+func NotifyStudentsAbsent(session models.Schedule_Sessions, studentIDs []uint) {
+	if len(studentIDs) == 0 {
+		return
+	}
+	var students []models.Student
+	if err := database.DB.Where("id IN ?", studentIDs).Find(&students).Error; err != nil {
+		log.Printf("Error loading absent students of session %d: %v", session.ID, err)
+		return
+	}
+
+	scheduleName := fmt.Sprintf("session #%d", session.ID)
+	if session.Schedule != nil {
+		scheduleName = session.Schedule.ScheduleName
+	}
+	when := ""
+	if session.Start_time != nil {
+		when = session.Start_time.Format("2006-01-02 15:04")
+	}
+
+	service := notifsvc.NewService()
+	for _, student := range students {
+		name := student.NicknameTh
+		if name == "" {
+			name = studentDisplayName(student)
+		}
+		nameEn := student.NicknameEn
+		if nameEn == "" {
+			nameEn = name
+		}
+		data := map[string]any{
+			"action":     "attendance-absent",
+			"session_id": session.ID,
+			"student_id": student.ID,
+		}
+		q := notifsvc.QueuedWithData(
+			"Absence recorded", "แจ้งขาดเรียน",
+			fmt.Sprintf("%s was marked absent from '%s' at %s.", nameEn, scheduleName, when),
+			fmt.Sprintf("%s ขาดเรียนคลาส '%s' เวลา %s", name, scheduleName, when),
+			"warning", data, "normal", notifsvc.ChannelSMS, notifsvc.ChannelSMSParent,
+		)
+		if student.UserID == nil {
+			// ไม่มีบัญชีผู้ใช้: ส่งได้เฉพาะ SMS ถึงผู้ปกครอง
+			if err := service.SendParentSMS(student, q); err != nil {
+				log.Printf("Error sending parent SMS for absence of student %d: %v", student.ID, err)
+			}
+			continue
+		}
+		if err := service.EnqueueOrCreate([]uint{*student.UserID}, q); err != nil {
+			log.Printf("Error notifying absence of student %d: %v", student.ID, err)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Attendance statuses
+const (
+	AttendancePresent = "present"
+	AttendanceLate    = "late"
+	AttendanceAbsent  = "absent"
+	AttendanceExcused = "excused"
+)
+
+// Check-ins later than this after the session start count as late when no status is sent
+const attendanceLateGrace = 10 * time.Minute
+
+var (
+	ErrAttendanceInvalid         = errors.New("invalid attendance")
+	ErrAttendanceSessionNotFound = errors.New("session not found")
+)
+
+// AttendanceInput is one row sent by the teacher
+type AttendanceInput struct {
+	StudentID uint       `json:"student_id"`
+	Status    string     `json:"status"` // present, late, absent, excused (empty: derived from check_in_at)
+	CheckInAt *time.Time `json:"check_in_at"`
+	Notes     string     `json:"notes"`
+}
+
+// AttendanceRosterEntry is a group member with the recorded attendance (if any)
+type AttendanceRosterEntry struct {
+	StudentID          uint               `json:"student_id"`
+	StudentName        string             `json:"student_name"`
+	Nickname           string             `json:"nickname"`
+	Attendance         *models.Attendance `json:"attendance,omitempty"`
+	ExcusedByAbsenceID *uint              `json:"excused_by_absence_id,omitempty"`
+}
+
+// AttendanceCounts aggregates attendance rows. Rate = (present + late) / (present + late + absent).
+type AttendanceCounts struct {
+	Present  int     `json:"present"`
+	Late     int     `json:"late"`
+	Absent   int     `json:"absent"`
+	Excused  int     `json:"excused"`
+	Recorded int     `json:"recorded"`
+	Rate     float64 `json:"attendance_rate"`
+}
+
+func (a *AttendanceCounts) add(status string, n int) {
+	switch status {
+	case AttendancePresent:
+		a.Present += n
+	case AttendanceLate:
+		a.Late += n
+	case AttendanceAbsent:
+		a.Absent += n
+	case AttendanceExcused:
+		a.Excused += n
+	}
+	a.Recorded += n
+	if counted := a.Present + a.Late + a.Absent; counted > 0 {
+		a.Rate = float64(a.Present+a.Late) / float64(counted)
+	}
+}
+
+type StudentAttendanceSummary struct {
+	StudentID   uint   `json:"student_id"`
+	StudentName string `json:"student_name"`
+	AttendanceCounts
+}
+
+type GroupAttendanceSummary struct {
+	GroupID          uint                       `json:"group_id"`
+	SessionsRecorded int                        `json:"sessions_recorded"`
+	Totals           AttendanceCounts           `json:"totals"`
+	Students         []StudentAttendanceSummary `json:"students"`
+}
+
+type StudentGroupAttendance struct {
+	GroupID   uint   `json:"group_id"`
+	GroupName string `json:"group_name"`
+	AttendanceCounts
+}
+
+type StudentAttendanceReport struct {
+	StudentID   uint                     `json:"student_id"`
+	StudentName string                   `json:"student_name"`
+	Totals      AttendanceCounts         `json:"totals"`
+	Groups      []StudentGroupAttendance `json:"groups"`
+	Recent      []models.Attendance      `json:"recent"`
+}
+
+// AttendanceService records and summarises per-student attendance
+type AttendanceService struct {
+	db *gorm.DB
+}
+
+func NewAttendanceService() *AttendanceService {
+	return &AttendanceService{db: database.DB}
+}
+
+func studentDisplayName(s models.Student) string {
+	name := strings.TrimSpace(s.FirstName + " " + s.LastName)
+	if name == "" {
+		name = strings.TrimSpace(s.FirstNameEn + " " + s.LastNameEn)
+	}
+	return name
+}
+
+func (s *AttendanceService) loadSession(sessionID uint) (*models.Schedule_Sessions, error) {
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttendanceSessionNotFound
+		}
+		return nil, err
+	}
+	if session.Schedule == nil || session.Schedule.GroupID == nil {
+		return nil, fmt.Errorf("%w: attendance is only taken for class sessions with a group", ErrAttendanceInvalid)
+	}
+	return &session, nil
+}
+
+// groupStudents returns the active members of a group
+func (s *AttendanceService) groupStudents(groupID uint) ([]models.Student, error) {
+	var students []models.Student
+	err := s.db.Model(&models.Student{}).
+		Joins("JOIN group_members ON group_members.student_id = students.id AND group_members.deleted_at IS NULL").
+		Where("group_members.group_id = ? AND group_members.status = ?", groupID, "active").
+		Order("students.first_name ASC").
+		Find(&students).Error
+	return students, err
+}
+
+// ExcusedStudentsForSession maps student ID -> approved absence ID for a session.
+// A group-level absence (no student_id) excuses every member; late leaves do not excuse anyone.
+func (s *AttendanceService) ExcusedStudentsForSession(sessionID, groupID uint, students []models.Student) map[uint]uint {
+	excused := make(map[uint]uint)
+	var absences []models.Absence
+	if err := s.db.Where("session_id = ? AND group_id = ? AND status = ? AND is_late = ?", sessionID, groupID, "approved", false).
+		Order("approved_at ASC").Find(&absences).Error; err != nil {
+		return excused
+	}
+	for _, absence := range absences {
+		if absence.StudentID != nil {
+			excused[*absence.StudentID] = absence.ID
+			continue
+		}
+		for _, student := range students {
+			excused[student.ID] = absence.ID
+		}
+	}
+	return excused
+}
+
+// Roster lists the session's group members with their attendance
+func (s *AttendanceService) Roster(sessionID uint) (*models.Schedule_Sessions, []AttendanceRosterEntry, error) {
+	session, err := s.loadSession(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupID := *session.Schedule.GroupID
+
+	students, err := s.groupStudents(groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records []models.Attendance
+	if err := s.db.Where("session_id = ?", sessionID).Find(&records).Error; err != nil {
+		return nil, nil, err
+	}
+	byStudent := make(map[uint]models.Attendance, len(records))
+	for _, r := range records {
+		byStudent[r.StudentID] = r
+	}
+	excused := s.ExcusedStudentsForSession(sessionID, groupID, students)
+
+	roster := make([]AttendanceRosterEntry, 0, len(students))
+	for _, student := range students {
+		entry := AttendanceRosterEntry{
+			StudentID:   student.ID,
+			StudentName: studentDisplayName(student),
+			Nickname:    student.NicknameTh,
+		}
+		if record, ok := byStudent[student.ID]; ok {
+			rec := record
+			entry.Attendance = &rec
+		}
+		if absenceID, ok := excused[student.ID]; ok {
+			id := absenceID
+			entry.ExcusedByAbsenceID = &id
+		}
+		roster = append(roster, entry)
+	}
+	return session, roster, nil
+}
+
+// Record saves attendance for a session (one row per student, re-sending overwrites).
+// Members with an approved absence that are not in the input are marked excused.
+func (s *AttendanceService) Record(sessionID, recordedBy uint, inputs []AttendanceInput) ([]models.Attendance, error) {
+	session, err := s.loadSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	groupID := *session.Schedule.GroupID
+
+	students, err := s.groupStudents(groupID)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[uint]bool, len(students))
+	for _, student := range students {
+		members[student.ID] = true
+	}
+	excused := s.ExcusedStudentsForSession(sessionID, groupID, students)
+
+	now := time.Now()
+	rows := make([]models.Attendance, 0, len(students))
+	seen := make(map[uint]bool, len(inputs))
+	for _, in := range inputs {
+		if !members[in.StudentID] {
+			return nil, fmt.Errorf("%w: student %d is not an active member of this group", ErrAttendanceInvalid, in.StudentID)
+		}
+		if seen[in.StudentID] {
+			return nil, fmt.Errorf("%w: student %d is listed twice", ErrAttendanceInvalid, in.StudentID)
+		}
+		seen[in.StudentID] = true
+
+		status, checkIn, err := deriveAttendanceStatus(in.Status, in.CheckInAt, session.Start_time, now)
+		if err != nil {
+			return nil, err
+		}
+
+		row := models.Attendance{
+			SessionID:  sessionID,
+			StudentID:  in.StudentID,
+			GroupID:    &groupID,
+			Status:     status,
+			CheckInAt:  checkIn,
+			Notes:      strings.TrimSpace(in.Notes),
+			RecordedBy: &recordedBy,
+		}
+		if absenceID, ok := excused[in.StudentID]; ok && status == AttendanceExcused {
+			row.AbsenceID = &absenceID
+		}
+		rows = append(rows, row)
+	}
+	for studentID, absenceID := range excused {
+		if seen[studentID] {
+			continue
+		}
+		id := absenceID
+		rows = append(rows, models.Attendance{
+			SessionID:  sessionID,
+			StudentID:  studentID,
+			GroupID:    &groupID,
+			Status:     AttendanceExcused,
+			AbsenceID:  &id,
+			RecordedBy: &recordedBy,
+		})
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no attendance records", ErrAttendanceInvalid)
+	}
+
+	// แจ้งเตือนเฉพาะคนที่เพิ่งถูกบันทึกว่าขาด (ส่งซ้ำ/แก้ไขไม่แจ้งซ้ำ)
+	var previous []models.Attendance
+	if err := s.db.Select("student_id", "status").Where("session_id = ?", sessionID).Find(&previous).Error; err != nil {
+		return nil, err
+	}
+	wasAbsent := make(map[uint]bool, len(previous))
+	for _, p := range previous {
+		wasAbsent[p.StudentID] = p.Status == AttendanceAbsent
+	}
+	var newlyAbsent []uint
+	for _, row := range rows {
+		if row.Status == AttendanceAbsent && !wasAbsent[row.StudentID] {
+			newlyAbsent = append(newlyAbsent, row.StudentID)
+		}
+	}
+
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}, {Name: "student_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "check_in_at", "notes", "absence_id", "recorded_by", "group_id", "updated_at"}),
+	}).Create(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(newlyAbsent) > 0 {
+		go NotifyStudentsAbsent(*session, newlyAbsent)
+	}
+
+	var saved []models.Attendance
+	err = s.db.Where("session_id = ?", sessionID).Preload("Student").Order("student_id ASC").Find(&saved).Error
+	return saved, err
+}
+
+// deriveAttendanceStatus normalises a sent status. Without one, the check-in time (default now)
+// decides between present and late (after start + attendanceLateGrace). Absent/excused rows have no check-in.
+func deriveAttendanceStatus(sent string, checkIn, sessionStart *time.Time, now time.Time) (string, *time.Time, error) {
+	status := strings.ToLower(strings.TrimSpace(sent))
+	switch status {
+	case "":
+		if checkIn == nil {
+			checkIn = &now
+		}
+		status = AttendancePresent
+		if sessionStart != nil && checkIn.After(sessionStart.Add(attendanceLateGrace)) {
+			status = AttendanceLate
+		}
+	case AttendancePresent, AttendanceLate:
+		if checkIn == nil {
+			checkIn = &now
+		}
+	case AttendanceAbsent, AttendanceExcused:
+		checkIn = nil
+	default:
+		return "", nil, fmt.Errorf("%w: status must be present, late, absent or excused", ErrAttendanceInvalid)
+	}
+	return status, checkIn, nil
+}
+
+// ApplyApprovedAbsence marks the students covered by an approved absence as excused.
+// Rows already recorded as present/late are left alone.
+func (s *AttendanceService) ApplyApprovedAbsence(absence models.Absence) error {
+	if absence.Status != "approved" || absence.IsLate {
+		return nil
+	}
+	students, err := s.groupStudents(absence.GroupID)
+	if err != nil {
+		return err
+	}
+	if absence.StudentID != nil {
+		students = filterStudents(students, *absence.StudentID)
+	}
+
+	groupID := absence.GroupID
+	absenceID := absence.ID
+	for _, student := range students {
+		var existing models.Attendance
+		err := s.db.Where("session_id = ? AND student_id = ?", absence.SessionID, student.ID).First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			row := models.Attendance{
+				SessionID:  absence.SessionID,
+				StudentID:  student.ID,
+				GroupID:    &groupID,
+				Status:     AttendanceExcused,
+				AbsenceID:  &absenceID,
+				RecordedBy: absence.ApprovedBy,
+			}
+			if err := s.db.Create(&row).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case existing.Status == AttendanceAbsent:
+			if err := s.db.Model(&existing).Updates(map[string]interface{}{
+				"status":     AttendanceExcused,
+				"absence_id": absenceID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func filterStudents(students []models.Student, studentID uint) []models.Student {
+	for _, student := range students {
+		if student.ID == studentID {
+			return []models.Student{student}
+		}
+	}
+	return nil
+}
+
+type attendanceCountRow struct {
+	StudentID uint
+	GroupID   *uint
+	Status    string
+	Total     int
+}
+
+// GroupSummary returns per-student attendance counts for a group
+func (s *AttendanceService) GroupSummary(groupID uint) (*GroupAttendanceSummary, error) {
+	var rows []attendanceCountRow
+	if err := s.db.Model(&models.Attendance{}).
+		Select("student_id, status, COUNT(*) AS total").
+		Where("group_id = ?", groupID).
+		Group("student_id, status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	var sessionsRecorded int64
+	s.db.Model(&models.Attendance{}).Where("group_id = ?", groupID).Distinct("session_id").Count(&sessionsRecorded)
+
+	students, err := s.groupStudents(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &GroupAttendanceSummary{GroupID: groupID, SessionsRecorded: int(sessionsRecorded)}
+	index := make(map[uint]int, len(students))
+	for _, student := range students {
+		index[student.ID] = len(summary.Students)
+		summary.Students = append(summary.Students, StudentAttendanceSummary{StudentID: student.ID, StudentName: studentDisplayName(student)})
+	}
+	for _, row := range rows {
+		summary.Totals.add(row.Status, row.Total)
+		idx, ok := index[row.StudentID]
+		if !ok {
+			// อดีตสมาชิกกลุ่มที่ยังมีประวัติเช็คชื่อ
+			var student models.Student
+			s.db.Unscoped().First(&student, row.StudentID)
+			idx = len(summary.Students)
+			index[row.StudentID] = idx
+			summary.Students = append(summary.Students, StudentAttendanceSummary{StudentID: row.StudentID, StudentName: studentDisplayName(student)})
+		}
+		summary.Students[idx].add(row.Status, row.Total)
+	}
+	return summary, nil
+}
+
+// StudentSummary returns a student's attendance per group and the most recent records
+func (s *AttendanceService) StudentSummary(studentID uint, recentLimit int) (*StudentAttendanceReport, error) {
+	var student models.Student
+	if err := s.db.First(&student, studentID).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []attendanceCountRow
+	if err := s.db.Model(&models.Attendance{}).
+		Select("student_id, group_id, status, COUNT(*) AS total").
+		Where("student_id = ?", studentID).
+		Group("student_id, group_id, status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	report := &StudentAttendanceReport{StudentID: studentID, StudentName: studentDisplayName(student)}
+	index := make(map[uint]int)
+	for _, row := range rows {
+		report.Totals.add(row.Status, row.Total)
+		if row.GroupID == nil {
+			continue
+		}
+		idx, ok := index[*row.GroupID]
+		if !ok {
+			var group models.Group
+			s.db.Select("id", "group_name").First(&group, *row.GroupID)
+			idx = len(report.Groups)
+			index[*row.GroupID] = idx
+			report.Groups = append(report.Groups, StudentGroupAttendance{GroupID: *row.GroupID, GroupName: group.GroupName})
+		}
+		report.Groups[idx].add(row.Status, row.Total)
+	}
+
+	if recentLimit <= 0 {
+		recentLimit = 20
+	}
+	// most recent classes first, not most recently recorded (late entry of an old session)
+	if err := s.db.Joins("JOIN schedule_sessions ON schedule_sessions.id = attendances.session_id").
+		Where("attendances.student_id = ?", studentID).
+		Preload("Session").
+		Order("schedule_sessions.session_date DESC, schedule_sessions.start_time DESC, attendances.id DESC").
+		Limit(recentLimit).
+		Find(&report.Recent).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDeriveAttendanceStatus(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := start.Add(d)
+		return &v
+	}
+
+	tests := []struct {
+		name        string
+		status      string
+		checkIn     *time.Time
+		start       *time.Time
+		now         time.Time
+		want        string
+		wantCheckIn *time.Time
+	}{
+		{name: "on time check-in", checkIn: at(2 * time.Minute), start: &start, want: AttendancePresent, wantCheckIn: at(2 * time.Minute)},
+		{name: "check-in at the end of the grace period", checkIn: at(attendanceLateGrace), start: &start, want: AttendancePresent, wantCheckIn: at(attendanceLateGrace)},
+		{name: "check-in after the grace period", checkIn: at(attendanceLateGrace + time.Second), start: &start, want: AttendanceLate, wantCheckIn: at(attendanceLateGrace + time.Second)},
+		{name: "no check-in defaults to now", start: &start, now: start.Add(30 * time.Minute), want: AttendanceLate, wantCheckIn: at(30 * time.Minute)},
+		{name: "session without start time is never late", checkIn: at(time.Hour), want: AttendancePresent, wantCheckIn: at(time.Hour)},
+		{name: "explicit present keeps a late check-in", status: "Present", checkIn: at(time.Hour), start: &start, want: AttendancePresent, wantCheckIn: at(time.Hour)},
+		{name: "explicit late without check-in uses now", status: "late", start: &start, now: start.Add(5 * time.Minute), want: AttendanceLate, wantCheckIn: at(5 * time.Minute)},
+		{name: "absent drops the check-in", status: " absent ", checkIn: at(0), start: &start, want: AttendanceAbsent},
+		{name: "excused drops the check-in", status: "excused", checkIn: at(0), start: &start, want: AttendanceExcused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, checkIn, err := deriveAttendanceStatus(tt.status, tt.checkIn, tt.start, tt.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("status = %q, want %q", got, tt.want)
+			}
+			switch {
+			case tt.wantCheckIn == nil && checkIn != nil:
+				t.Fatalf("check-in = %v, want none", checkIn)
+			case tt.wantCheckIn != nil && (checkIn == nil || !checkIn.Equal(*tt.wantCheckIn)):
+				t.Fatalf("check-in = %v, want %v", checkIn, tt.wantCheckIn)
+			}
+		})
+	}
+
+	if _, _, err := deriveAttendanceStatus("sick", nil, &start, start); !errors.Is(err, ErrAttendanceInvalid) {
+		t.Fatalf("expected ErrAttendanceInvalid for unknown status, got %v", err)
+	}
+}
+
+func TestAttendanceCountsRate(t *testing.T) {
+	tests := []struct {
+		name string
+		rows map[string]int
+		want AttendanceCounts
+	}{
+		{name: "empty", rows: nil, want: AttendanceCounts{}},
+		{
+			name: "late counts as attended",
+			rows: map[string]int{AttendancePresent: 6, AttendanceLate: 2, AttendanceAbsent: 2},
+			want: AttendanceCounts{Present: 6, Late: 2, Absent: 2, Recorded: 10, Rate: 0.8},
+		},
+		{
+			name: "excused is recorded but not in the rate",
+			rows: map[string]int{AttendancePresent: 3, AttendanceAbsent: 1, AttendanceExcused: 4},
+			want: AttendanceCounts{Present: 3, Absent: 1, Excused: 4, Recorded: 8, Rate: 0.75},
+		},
+		{
+			name: "only excused",
+			rows: map[string]int{AttendanceExcused: 2},
+			want: AttendanceCounts{Excused: 2, Recorded: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got AttendanceCounts
+			for status, n := range tt.rows {
+				got.add(status, n)
+			}
+			if math.Abs(got.Rate-tt.want.Rate) > 1e-9 {
+				t.Fatalf("rate = %v, want %v", got.Rate, tt.want.Rate)
+			}
+			got.Rate = tt.want.Rate
+			if got != tt.want {
+				t.Fatalf("counts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+// BranchLookup is what is known about where a session or schedule takes place.
+// Loaded relations are used as-is; only the missing ones are queried.
+type BranchLookup struct {
+	RoomID        *uint
+	Room          *models.Room
+	DefaultRoomID *uint
+	DefaultRoom   *models.Room
+	GroupID       *uint
+	Group         *models.Group // Course loaded when available
+}
+
+// ResolveBranchID is the single branch rule for sessions and schedules:
+// the session's room, then the schedule's default room, then the group's course branch (nil when unknown)
+func ResolveBranchID(db *gorm.DB, in BranchLookup) *uint {
+	if id := roomBranchID(db, in.Room, in.RoomID); id != nil {
+		return id
+	}
+	if id := roomBranchID(db, in.DefaultRoom, in.DefaultRoomID); id != nil {
+		return id
+	}
+	if in.Group != nil && in.Group.Course.ID != 0 {
+		if in.Group.Course.BranchID != 0 {
+			id := in.Group.Course.BranchID
+			return &id
+		}
+		return nil
+	}
+	if in.GroupID != nil {
+		var branchID uint
+		if err := db.Table("`groups`").
+			Select("courses.branch_id").
+			Joins("JOIN courses ON courses.id = `groups`.course_id").
+			Where("`groups`.id = ?", *in.GroupID).
+			Scan(&branchID).Error; err == nil && branchID != 0 {
+			return &branchID
+		}
+	}
+	return nil
+}
+
+func roomBranchID(db *gorm.DB, room *models.Room, roomID *uint) *uint {
+	if room != nil && room.BranchID != 0 {
+		id := room.BranchID
+		return &id
+	}
+	if room == nil && roomID != nil {
+		var r models.Room
+		if err := db.Select("id", "branch_id").First(&r, *roomID).Error; err == nil && r.BranchID != 0 {
+			return &r.BranchID
+		}
+	}
+	return nil
+}
+
+// ScheduleBranchID resolves the branch of a schedule from its default room or group course (see ResolveBranchID)
+func ScheduleBranchID(db *gorm.DB, schedule models.Schedules) *uint {
+	return ResolveBranchID(db, BranchLookup{
+		DefaultRoomID: schedule.DefaultRoomID, DefaultRoom: schedule.DefaultRoom,
+		GroupID: schedule.GroupID, Group: schedule.Group,
+	})
+}
+
+// SessionBranchID resolves the branch of a session (see ResolveBranchID); the schedule is loaded when not preloaded
+func SessionBranchID(db *gorm.DB, session models.Schedule_Sessions) *uint {
+	schedule := session.Schedule
+	if schedule == nil {
+		var sch models.Schedules
+		if err := db.Select("id", "group_id", "default_room_id").First(&sch, session.ScheduleID).Error; err == nil {
+			schedule = &sch
+		}
+	}
+	in := BranchLookup{RoomID: session.RoomID, Room: session.Room}
+	if schedule != nil {
+		in.DefaultRoomID, in.DefaultRoom = schedule.DefaultRoomID, schedule.DefaultRoom
+		in.GroupID, in.Group = schedule.GroupID, schedule.Group
+	}
+	return ResolveBranchID(db, in)
+}
+
+// Branch hours used when the branch is unknown
+const (
+	defaultBranchOpenMinutes  = 8 * 60
+	defaultBranchCloseMinutes = 21 * 60
+)
+
+// BranchOpenHours returns the branch's open/close time in minutes from midnight (08:00-21:00 when branch is nil)
+func BranchOpenHours(branch *models.Branch) (BranchHours, error) {
+	hours := BranchHours{OpenMinutes: defaultBranchOpenMinutes, CloseMinutes: defaultBranchCloseMinutes}
+	if branch == nil {
+		return hours, nil
+	}
+	if !branch.OpenTime.IsZero() {
+		hours.OpenMinutes = branch.OpenTime.Hour()*60 + branch.OpenTime.Minute()
+	}
+	if !branch.CloseTime.IsZero() {
+		hours.CloseMinutes = branch.CloseTime.Hour()*60 + branch.CloseTime.Minute()
+	}
+	if hours.CloseMinutes <= hours.OpenMinutes {
+		return BranchHours{}, fmt.Errorf("branch closing time must be after opening time")
+	}
+	return hours, nil
+}
+
+// SessionBranchHours returns the open hours of the session's branch (defaults when unknown or invalid)
+func SessionBranchHours(db *gorm.DB, session models.Schedule_Sessions) BranchHours {
+	var branch *models.Branch
+	if branchID := SessionBranchID(db, session); branchID != nil {
+		var b models.Branch
+		if err := db.Select("id", "open_time", "close_time").First(&b, *branchID).Error; err == nil {
+			branch = &b
+		}
+	}
+	hours, err := BranchOpenHours(branch)
+	if err != nil {
+		hours, _ = BranchOpenHours(nil)
+	}
+	return hours
+}
+
+// Contains reports whether [start, end) on one day lies within the open hours (start/end in the branch's local time)
+func (h BranchHours) Contains(start, end time.Time) bool {
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := startMinutes + int(end.Sub(start).Minutes())
+	return startMinutes >= h.OpenMinutes && endMinutes <= h.CloseMinutes
+}
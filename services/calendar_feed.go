@@ -0,0 +1,334 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	"englishkorat_go/utils"
+
+	"gorm.io/gorm"
+)
+
+// Calendar feed scopes
+const (
+	CalendarFeedScopeUser   = "user"
+	CalendarFeedScopeRoom   = "room"
+	CalendarFeedScopeBranch = "branch"
+)
+
+// Feed window relative to now: past sessions stay visible for a while, future ones far enough ahead
+const (
+	calendarFeedPastDays   = 60
+	calendarFeedFutureDays = 365
+)
+
+var (
+	ErrCalendarFeedNotFound = errors.New("calendar feed not found")
+	ErrCalendarFeedScope    = errors.New("invalid calendar feed scope")
+)
+
+// CalendarFeedService manages ICS subscription tokens and renders feeds
+type CalendarFeedService struct {
+	db *gorm.DB
+}
+
+func NewCalendarFeedService() *CalendarFeedService {
+	return &CalendarFeedService{db: database.DB}
+}
+
+// CreateToken issues a new feed token. scopeID is required for room and branch feeds.
+func (s *CalendarFeedService) CreateToken(userID uint, scope string, scopeID *uint, name string) (*models.CalendarFeedToken, error) {
+	switch scope {
+	case CalendarFeedScopeUser:
+		scopeID = nil
+	case CalendarFeedScopeRoom:
+		if scopeID == nil || s.db.First(&models.Room{}, *scopeID).Error != nil {
+			return nil, fmt.Errorf("%w: room not found", ErrCalendarFeedScope)
+		}
+	case CalendarFeedScopeBranch:
+		if scopeID == nil || s.db.First(&models.Branch{}, *scopeID).Error != nil {
+			return nil, fmt.Errorf("%w: branch not found", ErrCalendarFeedScope)
+		}
+	default:
+		return nil, ErrCalendarFeedScope
+	}
+
+	token, err := utils.GenerateRandomString(48)
+	if err != nil {
+		return nil, err
+	}
+	feed := &models.CalendarFeedToken{
+		UserID:  userID,
+		Token:   token,
+		Scope:   scope,
+		ScopeID: scopeID,
+		Name:    strings.TrimSpace(name),
+	}
+	if err := s.db.Create(feed).Error; err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// ListTokens returns active (non revoked) feeds created by a user
+func (s *CalendarFeedService) ListTokens(userID uint) ([]models.CalendarFeedToken, error) {
+	var feeds []models.CalendarFeedToken
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&feeds).Error
+	return feeds, err
+}
+
+// RevokeToken disables a feed URL. Non-admins may only revoke their own feeds.
+func (s *CalendarFeedService) RevokeToken(id, userID uint, isAdmin bool) error {
+	var feed models.CalendarFeedToken
+	q := s.db.Where("id = ? AND revoked_at IS NULL", id)
+	if !isAdmin {
+		q = q.Where("user_id = ?", userID)
+	}
+	if err := q.First(&feed).Error; err != nil {
+		return ErrCalendarFeedNotFound
+	}
+	now := time.Now()
+	return s.db.Model(&feed).Update("revoked_at", &now).Error
+}
+
+// Resolve looks up an active token; feeds of inactive users stop working
+func (s *CalendarFeedService) Resolve(token string) (*models.CalendarFeedToken, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, ErrCalendarFeedNotFound
+	}
+	var feed models.CalendarFeedToken
+	if err := s.db.Preload("User").Where("token = ? AND revoked_at IS NULL", token).First(&feed).Error; err != nil {
+		return nil, ErrCalendarFeedNotFound
+	}
+	if feed.User.Status != "" && feed.User.Status != "active" {
+		return nil, ErrCalendarFeedNotFound
+	}
+	now := time.Now()
+	s.db.Model(&feed).UpdateColumn("last_accessed_at", &now)
+	return &feed, nil
+}
+
+// FeedSessions loads sessions in the feed window for the feed's scope, including cancelled ones
+// so subscribed calendars can mark them as cancelled.
+func (s *CalendarFeedService) FeedSessions(feed *models.CalendarFeedToken) ([]models.Schedule_Sessions, error) {
+	now := time.Now()
+	from := now.AddDate(0, 0, -calendarFeedPastDays).Format("2006-01-02")
+	to := now.AddDate(0, 0, calendarFeedFutureDays).Format("2006-01-02")
+
+	query := s.db.Model(&models.Schedule_Sessions{}).
+		Joins("JOIN schedules ON schedule_sessions.schedule_id = schedules.id AND schedules.deleted_at IS NULL").
+		Where("schedule_sessions.session_date BETWEEN ? AND ?", from, to)
+
+	switch feed.Scope {
+	case CalendarFeedScopeUser:
+		userID := feed.UserID
+		switch feed.User.Role {
+		case "student":
+			// Same as GetMySchedules / GetCalendarView: sessions of the student's groups
+			query = query.Where(`schedules.group_id IN (
+				SELECT group_members.group_id FROM group_members
+				JOIN students ON students.id = group_members.student_id
+				WHERE students.user_id = ? AND group_members.deleted_at IS NULL)
+				OR schedules.id IN (SELECT schedule_id FROM schedule_participants WHERE user_id = ? AND deleted_at IS NULL)`, userID, userID).
+				Where("schedules.status <> ?", "assigned") // students only see confirmed schedules
+		default:
+			// Teachers/admins: sessions they teach plus meetings/events they participate in
+			query = query.Where(`schedule_sessions.assigned_teacher_id = ?
+				OR (schedule_sessions.assigned_teacher_id IS NULL AND schedules.default_teacher_id = ?)
+				OR schedules.id IN (SELECT schedule_id FROM schedule_participants WHERE user_id = ? AND deleted_at IS NULL)`, userID, userID, userID)
+		}
+	case CalendarFeedScopeRoom:
+		if feed.ScopeID == nil {
+			return nil, ErrCalendarFeedScope
+		}
+		query = query.Where("schedule_sessions.room_id = ? OR (schedule_sessions.room_id IS NULL AND schedules.default_room_id = ?)", *feed.ScopeID, *feed.ScopeID)
+	case CalendarFeedScopeBranch:
+		if feed.ScopeID == nil {
+			return nil, ErrCalendarFeedScope
+		}
+		query = query.
+			Joins("LEFT JOIN rooms session_rooms ON session_rooms.id = schedule_sessions.room_id").
+			Joins("LEFT JOIN rooms default_rooms ON default_rooms.id = schedules.default_room_id").
+			Joins("LEFT JOIN `groups` ON schedules.group_id = `groups`.id").
+			Joins("LEFT JOIN courses ON `groups`.course_id = courses.id").
+			Where("COALESCE(session_rooms.branch_id, default_rooms.branch_id, courses.branch_id) = ?", *feed.ScopeID)
+	default:
+		return nil, ErrCalendarFeedScope
+	}
+
+	var sessions []models.Schedule_Sessions
+	err := query.
+		Preload("Schedule.Group.Course").
+		Preload("Schedule.DefaultTeacher.Teacher").
+		Preload("Schedule.DefaultRoom.Branch").
+		Preload("AssignedTeacher.Teacher").
+		Preload("Room.Branch").
+		Order("schedule_sessions.start_time ASC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// FeedName is the calendar display name (X-WR-CALNAME)
+func (s *CalendarFeedService) FeedName(feed *models.CalendarFeedToken) string {
+	if feed.Name != "" {
+		return "EnglishKorat - " + feed.Name
+	}
+	switch feed.Scope {
+	case CalendarFeedScopeRoom:
+		var room models.Room
+		if feed.ScopeID != nil && s.db.First(&room, *feed.ScopeID).Error == nil {
+			return "EnglishKorat - Room " + room.RoomName
+		}
+	case CalendarFeedScopeBranch:
+		var branch models.Branch
+		if feed.ScopeID != nil && s.db.First(&branch, *feed.ScopeID).Error == nil {
+			return "EnglishKorat - " + branch.NameEn
+		}
+	}
+	return "EnglishKorat - " + feed.User.Username
+}
+
+// RenderICS builds an RFC 5545 calendar. UIDs are derived from Schedule_Sessions.ID so updates
+// replace the same event in subscribed calendars.
+func RenderICS(calendarName string, sessions []models.Schedule_Sessions) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//EnglishKorat//Schedules//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:"+escapeICSText(calendarName))
+	writeICSLine(&b, "X-WR-TIMEZONE:Asia/Bangkok")
+	writeICSLine(&b, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	writeICSLine(&b, "X-PUBLISHED-TTL:PT1H")
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, session := range sessions {
+		if session.Start_time == nil || session.End_time == nil || session.Schedule == nil {
+			continue
+		}
+		schedule := session.Schedule
+
+		summary := schedule.ScheduleName
+		if session.Is_makeup {
+			summary += " (Makeup)"
+		}
+
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, fmt.Sprintf("UID:session-%d@englishkorat.com", session.ID))
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		if session.UpdatedAt != nil {
+			writeICSLine(&b, "LAST-MODIFIED:"+session.UpdatedAt.UTC().Format("20060102T150405Z"))
+		}
+		// Revision is bumped on every session update, so SEQUENCE grows with each change
+		writeICSLine(&b, fmt.Sprintf("SEQUENCE:%d", session.Revision))
+		writeICSLine(&b, "DTSTART:"+session.Start_time.UTC().Format("20060102T150405Z"))
+		writeICSLine(&b, "DTEND:"+session.End_time.UTC().Format("20060102T150405Z"))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(summary))
+		if location := sessionLocation(session); location != "" {
+			writeICSLine(&b, "LOCATION:"+escapeICSText(location))
+		}
+		writeICSLine(&b, "DESCRIPTION:"+escapeICSText(sessionDescription(session)))
+		writeICSLine(&b, "STATUS:"+icsStatus(session))
+		if schedule.ScheduleType != "" {
+			writeICSLine(&b, "CATEGORIES:"+escapeICSText(strings.ToUpper(schedule.ScheduleType)))
+		}
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func icsStatus(session models.Schedule_Sessions) string {
+	if session.Status == "cancelled" || (session.Schedule != nil && session.Schedule.Status == "cancelled") {
+		return "CANCELLED"
+	}
+	switch session.Status {
+	case "assigned", "pending":
+		return "TENTATIVE"
+	}
+	return "CONFIRMED"
+}
+
+func sessionLocation(session models.Schedule_Sessions) string {
+	room := session.Room
+	if room == nil && session.Schedule != nil {
+		room = session.Schedule.DefaultRoom
+	}
+	if room == nil {
+		return ""
+	}
+	if room.Branch.NameEn != "" {
+		return fmt.Sprintf("%s, %s", room.RoomName, room.Branch.NameEn)
+	}
+	return room.RoomName
+}
+
+func sessionTeacherName(session models.Schedule_Sessions) string {
+	teacher := session.AssignedTeacher
+	if teacher == nil && session.Schedule != nil {
+		teacher = session.Schedule.DefaultTeacher
+	}
+	if teacher == nil {
+		return ""
+	}
+	if teacher.Teacher != nil && teacher.Teacher.NicknameEn != "" {
+		return "T." + teacher.Teacher.NicknameEn
+	}
+	return teacher.Username
+}
+
+func sessionDescription(session models.Schedule_Sessions) string {
+	lines := make([]string, 0, 6)
+	if name := sessionTeacherName(session); name != "" {
+		lines = append(lines, "Teacher: "+name)
+	}
+	if session.Schedule != nil && session.Schedule.Group != nil && session.Schedule.Group.Course.Name != "" {
+		course := session.Schedule.Group.Course
+		if course.Level != "" {
+			lines = append(lines, fmt.Sprintf("Course: %s (%s)", course.Name, course.Level))
+		} else {
+			lines = append(lines, "Course: "+course.Name)
+		}
+	}
+	if session.Session_number > 0 {
+		lines = append(lines, fmt.Sprintf("Session %d", session.Session_number))
+	}
+	lines = append(lines, "Status: "+session.Status)
+	if session.Status == "cancelled" && session.Cancelling_Reason != "" {
+		lines = append(lines, "Reason: "+session.Cancelling_Reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeICSText escapes TEXT values (RFC 5545 3.3.11)
+func escapeICSText(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(v)
+}
+
+// writeICSLine folds content lines longer than 75 octets without splitting UTF-8 characters
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8StartByte(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func utf8StartByte(c byte) bool {
+	return c&0xC0 != 0x80
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"englishkorat_go/config"
 	"englishkorat_go/database"
+	notifsvc "englishkorat_go/services/notifications"
 	"fmt"
 	"runtime"
 	"strings"
@@ -280,6 +281,13 @@ func (s *HealthService) checkRedis(ctx context.Context) (*DependencyStatus, stri
 			"address": addr,
 			"mode":    "notifications",
 		}
+		if stats, err := notifsvc.NewService().QueueStats(ctx); err == nil && stats.Enabled {
+			dep.Details["queue_pending"] = stats.Pending
+			dep.Details["queue_processing"] = stats.Processing
+			dep.Details["queue_retrying"] = stats.Retrying
+			dep.Details["queue_dead_lettered"] = stats.DeadLettered
+			dep.Details["queue_oldest_pending_age_seconds"] = stats.OldestPendingAgeSeconds
+		}
 	} else {
 		dep.Details = map[string]interface{}{
 			"address": addr,
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"gorm.io/gorm"
+)
+
+// Session statuses that may still be moved when a holiday is applied retroactively.
+// completed/cancelled/rescheduled/no-show sessions are history and are never touched.
+var holidayMovableStatuses = []string{"scheduled", "assigned", "confirmed", "pending"}
+
+// HolidayApplyInput describes a retroactive holiday application.
+// BranchID limits the application to schedules of that branch (branch closures).
+type HolidayApplyInput struct {
+	Date        time.Time
+	Name        string
+	BranchID    *uint
+	ScheduleIDs []uint
+}
+
+// HolidaySessionShift is one session whose date and/or number changes
+type HolidaySessionShift struct {
+	SessionID     uint   `json:"session_id"`
+	OldNumber     int    `json:"old_session_number"`
+	NewNumber     int    `json:"new_session_number"`
+	FromDate      string `json:"from_date"`
+	ToDate        string `json:"to_date"`
+	StartTime     string `json:"start_time"`
+	EndTime       string `json:"end_time"`
+	Moved         bool   `json:"moved"`
+	HolidayName   string `json:"holiday_name,omitempty"`
+	TeacherUserID *uint  `json:"teacher_user_id,omitempty"`
+}
+
+// ScheduleHolidayPlan lists the changes for one schedule
+type ScheduleHolidayPlan struct {
+	ScheduleID   uint                  `json:"schedule_id"`
+	ScheduleName string                `json:"schedule_name"`
+	GroupID      *uint                 `json:"group_id,omitempty"`
+	BranchID     *uint                 `json:"branch_id,omitempty"`
+	OldEndDate   string                `json:"old_end_date"`
+	NewEndDate   string                `json:"new_end_date"`
+	Moved        int                   `json:"moved"`
+	Renumbered   int                   `json:"renumbered"`
+	Changes      []HolidaySessionShift `json:"changes"`
+
+	schedule models.Schedules
+	updated  []models.Schedule_Sessions // sessions whose date/number changed, with the new values
+}
+
+// HolidayApplication is the preview (and, once applied, the record) of a retroactive holiday
+type HolidayApplication struct {
+	Date           string                `json:"date"`
+	HolidayName    string                `json:"holiday_name,omitempty"`
+	BranchID       *uint                 `json:"branch_id,omitempty"`
+	Schedules      []ScheduleHolidayPlan `json:"schedules"`
+	TotalMoved     int                   `json:"total_moved"`
+	TotalSchedules int                   `json:"total_schedules"`
+}
+
+// PlanHolidayApplication finds future sessions on the date in schedules with auto-reschedule on
+// and previews how RescheduleSessions would shift them (then renumbers with ReindexSessions).
+func (s *HolidayService) PlanHolidayApplication(in HolidayApplyInput) (*HolidayApplication, error) {
+	if in.Date.IsZero() {
+		return nil, fmt.Errorf("%w: date is required", ErrHolidayInvalid)
+	}
+	target := holidayDate(in.Date)
+	today := holidayDate(time.Now())
+	if target.Before(today) {
+		return nil, fmt.Errorf("%w: date is in the past", ErrHolidayInvalid)
+	}
+	targetKey := target.Format("2006-01-02")
+
+	query := s.db.Model(&models.Schedule_Sessions{}).
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id AND schedules.deleted_at IS NULL").
+		Where("DATE(schedule_sessions.session_date) = ?", targetKey).
+		Where("schedule_sessions.status IN ?", holidayMovableStatuses).
+		Where("schedules.auto_reschedule_holiday = ? AND schedules.status NOT IN ?", true, []string{"cancelled", "completed"})
+	if len(in.ScheduleIDs) > 0 {
+		query = query.Where("schedules.id IN ?", in.ScheduleIDs)
+	}
+	var scheduleIDs []uint
+	if err := query.Distinct("schedule_sessions.schedule_id").Pluck("schedule_sessions.schedule_id", &scheduleIDs).Error; err != nil {
+		return nil, err
+	}
+	sort.Slice(scheduleIDs, func(i, j int) bool { return scheduleIDs[i] < scheduleIDs[j] })
+
+	app := &HolidayApplication{Date: targetKey, HolidayName: strings.TrimSpace(in.Name), BranchID: in.BranchID}
+	for _, scheduleID := range scheduleIDs {
+		var schedule models.Schedules
+		if err := s.db.First(&schedule, scheduleID).Error; err != nil {
+			return nil, err
+		}
+		branchID := ScheduleBranchID(s.db, schedule)
+		if in.BranchID != nil && (branchID == nil || *branchID != *in.BranchID) {
+			continue
+		}
+
+		plan, err := s.planScheduleShift(schedule, branchID, target, app.HolidayName, today)
+		if err != nil {
+			return nil, err
+		}
+		if plan == nil {
+			continue
+		}
+		app.Schedules = append(app.Schedules, *plan)
+		app.TotalMoved += plan.Moved
+	}
+	app.TotalSchedules = len(app.Schedules)
+	return app, nil
+}
+
+func (s *HolidayService) planScheduleShift(schedule models.Schedules, branchID *uint, target time.Time, targetName string, today time.Time) (*ScheduleHolidayPlan, error) {
+	var all []models.Schedule_Sessions
+	if err := s.db.Where("schedule_id = ?", schedule.ID).Order("session_date ASC, start_time ASC").Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	movable := make(map[string]bool, len(holidayMovableStatuses))
+	for _, status := range holidayMovableStatuses {
+		movable[status] = true
+	}
+
+	original := make(map[uint]models.Schedule_Sessions, len(all))
+	var future []models.Schedule_Sessions
+	var fixed []models.Schedule_Sessions
+	maxYear := target.Year()
+	for _, session := range all {
+		original[session.ID] = session
+		if session.Session_date == nil || session.Start_time == nil || session.End_time == nil {
+			fixed = append(fixed, session)
+			continue
+		}
+		if movable[session.Status] && !holidayDate(*session.Session_date).Before(today) {
+			future = append(future, session)
+			if y := session.Session_date.Year(); y > maxYear {
+				maxYear = y
+			}
+		} else {
+			fixed = append(fixed, session)
+		}
+	}
+	if len(future) == 0 {
+		return nil, nil
+	}
+
+	// วันหยุดทั้งหมดของสาขา + วันที่ที่ประกาศใหม่ (อาจยังไม่อยู่ในตาราง)
+	holidayNames, err := GetBranchHolidaysWithNames(today.Year(), maxYear+1, branchID)
+	if err != nil {
+		log.Printf("warning: holiday lookup for schedule %d failed, applying %s only: %v", schedule.ID, target.Format("2006-01-02"), err)
+		holidayNames = map[string]string{}
+	}
+	targetKey := target.Format("2006-01-02")
+	if _, exists := holidayNames[targetKey]; !exists || targetName != "" {
+		holidayNames[targetKey] = targetName
+	}
+	holidays := make(map[string]bool, len(holidayNames))
+	for dateStr := range holidayNames {
+		if date, err := ParseHolidayDate(dateStr); err == nil {
+			holidays[date.Format("2006-01-02")] = true
+		}
+	}
+
+	// only sessions on the applied date move; the other holidays are just avoided as new dates
+	shifted := rescheduleSessionsOn(future, map[string]bool{targetKey: true}, holidays)
+
+	// Renumber the regular run (makeups keep the number of the session they replace)
+	merged := append(append([]models.Schedule_Sessions{}, fixed...), shifted...)
+	var regular []models.Schedule_Sessions
+	var others []models.Schedule_Sessions
+	for _, session := range merged {
+		if session.Is_makeup || session.Status == "cancelled" || session.Status == "rescheduled" || session.Status == "no-show" || session.Session_date == nil {
+			others = append(others, session)
+		} else {
+			regular = append(regular, session)
+		}
+	}
+	ReindexSessions(regular, schedule.Start_date)
+
+	plan := &ScheduleHolidayPlan{
+		ScheduleID:   schedule.ID,
+		ScheduleName: schedule.ScheduleName,
+		GroupID:      schedule.GroupID,
+		BranchID:     branchID,
+		OldEndDate:   schedule.Estimated_end_date.Format("2006-01-02"),
+		schedule:     schedule,
+	}
+
+	newEnd := schedule.Estimated_end_date
+	for _, session := range append(regular, others...) {
+		before := original[session.ID]
+		if session.Session_date != nil && session.Session_date.After(newEnd) {
+			newEnd = *session.Session_date
+		}
+		moved := before.Session_date != nil && session.Session_date != nil &&
+			before.Session_date.Format("2006-01-02") != session.Session_date.Format("2006-01-02")
+		if !moved && before.Session_number == session.Session_number && before.Week_number == session.Week_number {
+			continue
+		}
+
+		teacherID := session.AssignedTeacherID
+		if teacherID == nil {
+			teacherID = schedule.DefaultTeacherID
+		}
+		change := HolidaySessionShift{
+			SessionID:     session.ID,
+			OldNumber:     before.Session_number,
+			NewNumber:     session.Session_number,
+			FromDate:      formatDateOrEmpty(before.Session_date),
+			ToDate:        formatDateOrEmpty(session.Session_date),
+			StartTime:     session.Start_time.Format("15:04"),
+			EndTime:       session.End_time.Format("15:04"),
+			Moved:         moved,
+			TeacherUserID: teacherID,
+		}
+		if moved {
+			change.HolidayName = holidayNames[change.FromDate]
+			session.Notes = before.Notes // RescheduleSessions overwrites notes; keep the original for the shift note
+			plan.Moved++
+		} else {
+			plan.Renumbered++
+		}
+		plan.Changes = append(plan.Changes, change)
+		plan.updated = append(plan.updated, session)
+	}
+	if plan.Moved == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool { return plan.Changes[i].NewNumber < plan.Changes[j].NewNumber })
+	plan.NewEndDate = newEnd.Format("2006-01-02")
+	return plan, nil
+}
+
+func formatDateOrEmpty(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// ApplyHolidayApplication persists a plan atomically, re-queues teacher reminders and notifies
+// teachers, students/participants and matched LINE groups of every affected schedule.
+// A non-nil holiday is created in the same transaction (unless one already exists on the date);
+// created reports whether it was.
+func (s *HolidayService) ApplyHolidayApplication(app *HolidayApplication, holiday *models.Holiday) (created bool, err error) {
+	if holiday != nil {
+		holiday.Source = HolidaySourceManual
+		if err := s.validate(holiday); err != nil {
+			return false, err
+		}
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if holiday != nil {
+			exists, err := holidayExistsOn(tx, holiday.Date, holiday.BranchID)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				// บันทึกวันหยุดใหม่ไว้ด้วย เพื่อให้ตารางที่สร้างภายหลังข้ามวันนี้เช่นกัน
+				if err := tx.Create(holiday).Error; err != nil {
+					return err
+				}
+				created = true
+			}
+		}
+		for _, plan := range app.Schedules {
+			for _, session := range plan.updated {
+				change := findHolidayChange(plan.Changes, session.ID)
+				updates := map[string]interface{}{
+					"session_number": session.Session_number,
+					"week_number":    session.Week_number,
+				}
+				if change.Moved {
+					updates["session_date"] = session.Session_date
+					updates["start_time"] = session.Start_time
+					updates["end_time"] = session.End_time
+					updates["notes"] = holidayShiftNote(session.Notes, change)
+					// ครูยืนยันวันเดิมไว้ ต้องยืนยันวันใหม่อีกครั้ง
+					if session.Status == "confirmed" {
+						updates["status"] = "assigned"
+						updates["confirmed_at"] = nil
+						updates["confirmed_by_user_id"] = nil
+					}
+				}
+				if err := tx.Model(&models.Schedule_Sessions{}).Where("id = ?", session.ID).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+
+			if plan.NewEndDate != plan.OldEndDate {
+				newEnd, err := ParseHolidayDate(plan.NewEndDate)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&models.Schedules{}).Where("id = ?", plan.ScheduleID).Update("estimated_end_date", newEnd).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	queue := NewJobQueue()
+	for _, plan := range app.Schedules {
+		for _, change := range plan.Changes {
+			if !change.Moved {
+				continue
+			}
+			if _, err := queue.CancelJobsForSession(change.SessionID); err != nil {
+				log.Printf("warning: failed to cancel pending jobs for session %d: %v", change.SessionID, err)
+			}
+			var session models.Schedule_Sessions
+			if err := s.db.First(&session, change.SessionID).Error; err == nil {
+				ScheduleTeacherConfirmReminders(session, plan.schedule)
+			}
+		}
+		go NotifyHolidayShift(plan, app.HolidayName)
+	}
+	return created, nil
+}
+
+func findHolidayChange(changes []HolidaySessionShift, sessionID uint) HolidaySessionShift {
+	for _, change := range changes {
+		if change.SessionID == sessionID {
+			return change
+		}
+	}
+	return HolidaySessionShift{}
+}
+
+func holidayShiftNote(existing string, change HolidaySessionShift) string {
+	note := fmt.Sprintf("Rescheduled due to holiday (%s → %s)", change.FromDate, change.ToDate)
+	if change.HolidayName != "" {
+		note = fmt.Sprintf("Rescheduled due to holiday %s (%s → %s)", change.HolidayName, change.FromDate, change.ToDate)
+	}
+	if strings.TrimSpace(existing) == "" {
+		return note
+	}
+	return existing + "\n" + note
+}
+
+// NotifyHolidayShift informs everyone of a schedule that sessions were moved off a holiday
+func NotifyHolidayShift(plan ScheduleHolidayPlan, holidayName string) {
+	db := database.DB
+	ns := notifsvc.NewService()
+
+	var lines []string
+	teacherSet := make(map[uint]struct{})
+	for _, change := range plan.Changes {
+		if !change.Moved {
+			continue
+		}
+		if len(lines) < 5 {
+			lines = append(lines, fmt.Sprintf("%s → %s (%s-%s)", change.FromDate, change.ToDate, change.StartTime, change.EndTime))
+		}
+		if change.TeacherUserID != nil {
+			teacherSet[*change.TeacherUserID] = struct{}{}
+		}
+	}
+	if plan.Moved > len(lines) {
+		lines = append(lines, fmt.Sprintf("... +%d", plan.Moved-len(lines)))
+	}
+	summary := strings.Join(lines, "\n")
+
+	reason := "a holiday"
+	reasonTh := "วันหยุด"
+	if holidayName != "" {
+		reason = holidayName
+		reasonTh = holidayName
+	}
+	title, titleTh := "Class rescheduled for holiday", "เลื่อนคาบเรียนเนื่องจากวันหยุด"
+	msg := fmt.Sprintf("%d session(s) of '%s' moved because of %s:\n%s", plan.Moved, plan.ScheduleName, reason, summary)
+	msgTh := fmt.Sprintf("คลาส '%s' เลื่อน %d คาบเนื่องจาก%s:\n%s", plan.ScheduleName, plan.Moved, reasonTh, summary)
+	data := map[string]any{
+		"link":        map[string]any{"href": fmt.Sprintf("/api/schedules/%d/sessions", plan.ScheduleID), "method": "GET"},
+		"action":      "open-schedule",
+		"schedule_id": plan.ScheduleID,
+		"new_end":     plan.NewEndDate,
+	}
+
+	teacherIDs := make([]uint, 0, len(teacherSet))
+	for id := range teacherSet {
+		teacherIDs = append(teacherIDs, id)
+	}
+	if len(teacherIDs) > 0 {
+		q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "warning", data, "popup", "normal")
+		if err := ns.EnqueueOrCreate(teacherIDs, q); err != nil {
+			log.Printf("Error notifying teachers of schedule %d about holiday shift: %v", plan.ScheduleID, err)
+		}
+	}
+
+	var recipients []uint
+	if plan.GroupID != nil {
+		recipients = GroupStudentUserIDs(db, *plan.GroupID)
+	}
+	var participantIDs []uint
+	db.Model(&models.ScheduleParticipant{}).Where("schedule_id = ?", plan.ScheduleID).Pluck("user_id", &participantIDs)
+	for _, id := range participantIDs {
+		if _, isTeacher := teacherSet[id]; !isTeacher {
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) > 0 {
+		q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "info", data, "normal", "popup")
+		if err := ns.EnqueueOrCreate(recipients, q); err != nil {
+			log.Printf("Error notifying students of schedule %d about holiday shift: %v", plan.ScheduleID, err)
+		}
+	}
+
+	if plan.GroupID != nil {
+		PushLineToGroup(db, *plan.GroupID, "📢 แจ้งเลื่อนคาบเรียน\n"+msgTh)
+	}
+}
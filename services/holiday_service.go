@@ -0,0 +1,553 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+// Holiday types and sources
+const (
+	HolidayTypeHoliday = "holiday"
+	HolidayTypeClosure = "closure"
+
+	HolidaySourceManual = "manual"
+	HolidaySourceMyHora = "myhora"
+	HolidaySourceImport = "import"
+)
+
+// Holiday import formats
+const (
+	HolidayImportICS = "ics"
+	HolidayImportCSV = "csv"
+)
+
+var (
+	ErrHolidayNotFound = errors.New("holiday not found")
+	ErrHolidayInvalid  = errors.New("invalid holiday")
+)
+
+// myHoraRetryTTL is how long a year that myhora returned nothing (or failed) for is not fetched again
+const myHoraRetryTTL = time.Hour
+
+// myHoraAttempt remembers the last fetch of a year that left the cache empty
+type myHoraAttempt struct {
+	at  time.Time
+	err error
+}
+
+// myHoraAttempts caches empty/failed myhora fetches per year so every holiday lookup
+// does not hit myhora again while it is down (or has no data for the year yet)
+type myHoraAttempts struct {
+	mu    sync.Mutex
+	years map[int]myHoraAttempt
+}
+
+var myHoraMisses = &myHoraAttempts{years: map[int]myHoraAttempt{}}
+
+// recent returns the cached outcome of a year fetched less than ttl ago
+func (a *myHoraAttempts) recent(year int, now time.Time, ttl time.Duration) (myHoraAttempt, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	attempt, ok := a.years[year]
+	if !ok || now.Sub(attempt.at) >= ttl {
+		return myHoraAttempt{}, false
+	}
+	return attempt, true
+}
+
+func (a *myHoraAttempts) record(year int, now time.Time, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.years[year] = myHoraAttempt{at: now, err: err}
+}
+
+func (a *myHoraAttempts) forget(year int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.years, year)
+}
+
+// HolidayFilter narrows holiday listings. BranchID returns that branch's closures plus
+// all-branch holidays; nil returns all-branch holidays only unless AllBranches is set.
+type HolidayFilter struct {
+	From        time.Time
+	To          time.Time
+	BranchID    *uint
+	AllBranches bool
+	Source      string
+	Type        string
+}
+
+// HolidayImportResult summarises an uploaded ICS/CSV import
+type HolidayImportResult struct {
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// HolidayService manages the holiday/closure calendar and the myhora cache
+type HolidayService struct {
+	db *gorm.DB
+}
+
+func NewHolidayService() *HolidayService {
+	return &HolidayService{db: database.DB}
+}
+
+// holidayDate normalises a date to midnight Asia/Bangkok (the holidays.date column is DATE)
+func holidayDate(t time.Time) time.Time {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// ParseHolidayDate accepts YYYY-MM-DD, YYYYMMDD and DD/MM/YYYY (พ.ศ. is converted to ค.ศ.)
+func ParseHolidayDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	for _, layout := range []string{"2006-01-02", "20060102", "02/01/2006", "2/1/2006"} {
+		if date, err := time.ParseInLocation(layout, value, loc); err == nil {
+			if date.Year() > 2400 {
+				date = date.AddDate(-543, 0, 0)
+			}
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func (s *HolidayService) validate(h *models.Holiday) error {
+	h.Name = strings.TrimSpace(h.Name)
+	if h.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrHolidayInvalid)
+	}
+	if h.Date.IsZero() {
+		return fmt.Errorf("%w: date is required", ErrHolidayInvalid)
+	}
+	if h.Type == "" {
+		h.Type = HolidayTypeHoliday
+	}
+	if h.Type != HolidayTypeHoliday && h.Type != HolidayTypeClosure {
+		return fmt.Errorf("%w: type must be holiday or closure", ErrHolidayInvalid)
+	}
+	if h.BranchID != nil {
+		if *h.BranchID == 0 {
+			h.BranchID = nil
+		} else if s.db.First(&models.Branch{}, *h.BranchID).Error != nil {
+			return fmt.Errorf("%w: branch not found", ErrHolidayInvalid)
+		}
+	}
+	h.Date = holidayDate(h.Date)
+	return nil
+}
+
+// List returns holidays matching the filter ordered by date
+func (s *HolidayService) List(filter HolidayFilter) ([]models.Holiday, error) {
+	query := s.db.Model(&models.Holiday{}).Preload("Branch")
+	if !filter.From.IsZero() {
+		query = query.Where("date >= ?", holidayDate(filter.From).Format("2006-01-02"))
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("date <= ?", holidayDate(filter.To).Format("2006-01-02"))
+	}
+	if filter.BranchID != nil {
+		query = query.Where("branch_id IS NULL OR branch_id = ?", *filter.BranchID)
+	} else if !filter.AllBranches {
+		query = query.Where("branch_id IS NULL")
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	var holidays []models.Holiday
+	err := query.Order("date ASC, branch_id ASC").Find(&holidays).Error
+	return holidays, err
+}
+
+// Get returns a single holiday
+func (s *HolidayService) Get(id uint) (*models.Holiday, error) {
+	var holiday models.Holiday
+	if err := s.db.Preload("Branch").First(&holiday, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrHolidayNotFound
+		}
+		return nil, err
+	}
+	return &holiday, nil
+}
+
+// holidayExistsOn reports whether a holiday is already recorded for the date in exactly this branch scope
+func holidayExistsOn(db *gorm.DB, date time.Time, branchID *uint) (bool, error) {
+	query := db.Model(&models.Holiday{}).Where("date = ?", holidayDate(date).Format("2006-01-02"))
+	if branchID != nil {
+		query = query.Where("branch_id = ?", *branchID)
+	} else {
+		query = query.Where("branch_id IS NULL")
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// Create adds a manual holiday or closure
+func (s *HolidayService) Create(holiday *models.Holiday) error {
+	holiday.Source = HolidaySourceManual
+	if err := s.validate(holiday); err != nil {
+		return err
+	}
+	return s.db.Create(holiday).Error
+}
+
+// Update replaces the editable fields of a holiday. Edited myhora rows become manual so a
+// later sync does not overwrite the correction.
+func (s *HolidayService) Update(id uint, input models.Holiday) (*models.Holiday, error) {
+	holiday, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validate(&input); err != nil {
+		return nil, err
+	}
+
+	holiday.Date = input.Date
+	holiday.Name = input.Name
+	holiday.Type = input.Type
+	holiday.BranchID = input.BranchID
+	holiday.Notes = input.Notes
+	if holiday.Source == HolidaySourceMyHora {
+		holiday.Source = HolidaySourceManual
+	}
+	holiday.Branch = nil
+
+	if err := s.db.Save(holiday).Error; err != nil {
+		return nil, err
+	}
+	return holiday, nil
+}
+
+// Delete soft-deletes a holiday. A deleted myhora row stays deleted: the cache check is unscoped.
+func (s *HolidayService) Delete(id uint) error {
+	result := s.db.Delete(&models.Holiday{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrHolidayNotFound
+	}
+	return nil
+}
+
+// upsert creates or updates a holiday keyed by date, branch, source and name, so different
+// holidays falling on the same date are kept as separate rows.
+// Soft-deleted rows are honoured (skipped) so admins can remove wrong entries permanently.
+func (s *HolidayService) upsert(tx *gorm.DB, holiday models.Holiday) (created bool, updated bool, err error) {
+	var existing models.Holiday
+	query := tx.Unscoped().Where("date = ? AND source = ? AND name = ?", holiday.Date.Format("2006-01-02"), holiday.Source, holiday.Name)
+	if holiday.BranchID != nil {
+		query = query.Where("branch_id = ?", *holiday.BranchID)
+	} else {
+		query = query.Where("branch_id IS NULL")
+	}
+
+	err = query.First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, false, tx.Create(&holiday).Error
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if existing.DeletedAt.Valid {
+		return false, false, nil
+	}
+	if existing.Type == holiday.Type && existing.Notes == holiday.Notes {
+		return false, false, nil
+	}
+	err = tx.Model(&existing).Updates(map[string]interface{}{
+		"type":  holiday.Type,
+		"notes": holiday.Notes,
+	}).Error
+	return false, err == nil, err
+}
+
+// SyncMyHora fetches Thai public holidays for a year from myhora and caches them
+func (s *HolidayService) SyncMyHora(year int) (*HolidayImportResult, error) {
+	fetched, err := fetchMyHoraHolidays(year)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch holidays for %d: %w", year, err)
+	}
+
+	result := &HolidayImportResult{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		current := make(map[string]string, len(fetched))
+		for dateStr, name := range fetched {
+			date, err := ParseHolidayDate(dateStr)
+			if err != nil {
+				result.Skipped++
+				continue
+			}
+			current[date.Format("2006-01-02")] = name
+			created, updated, err := s.upsert(tx, models.Holiday{
+				Date:   date,
+				Name:   name,
+				Type:   HolidayTypeHoliday,
+				Source: HolidaySourceMyHora,
+			})
+			if err != nil {
+				return err
+			}
+			switch {
+			case created:
+				result.Created++
+			case updated:
+				result.Updated++
+			default:
+				result.Skipped++
+			}
+		}
+		if len(current) == 0 {
+			return nil
+		}
+		// myhora renamed or dropped a holiday: remove the old cache row instead of keeping both
+		var stale []models.Holiday
+		if err := tx.Where("source = ? AND date BETWEEN ? AND ?", HolidaySourceMyHora, fmt.Sprintf("%d-01-01", year), fmt.Sprintf("%d-12-31", year)).
+			Find(&stale).Error; err != nil {
+			return err
+		}
+		for _, h := range stale {
+			if current[h.Date.Format("2006-01-02")] == h.Name {
+				continue
+			}
+			if err := tx.Unscoped().Delete(&h).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ensureMyHoraYear fills the cache for a year that has never been fetched.
+// Years that came back empty or failed are retried only after myHoraRetryTTL.
+func (s *HolidayService) ensureMyHoraYear(year int) error {
+	var count int64
+	if err := s.db.Unscoped().Model(&models.Holiday{}).
+		Where("source = ? AND date BETWEEN ? AND ?", HolidaySourceMyHora, fmt.Sprintf("%d-01-01", year), fmt.Sprintf("%d-12-31", year)).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		myHoraMisses.forget(year)
+		return nil
+	}
+	now := time.Now()
+	if attempt, ok := myHoraMisses.recent(year, now, myHoraRetryTTL); ok {
+		return attempt.err
+	}
+	_, err := s.SyncMyHora(year)
+	myHoraMisses.record(year, now, err)
+	return err
+}
+
+// Import reads holidays from an uploaded ICS or CSV file.
+// CSV columns: date,name[,type][,notes] (a header row is detected and skipped).
+func (s *HolidayService) Import(r io.Reader, format string, branchID *uint, holidayType string) (*HolidayImportResult, error) {
+	if branchID != nil && *branchID == 0 {
+		branchID = nil
+	}
+	if branchID != nil && s.db.First(&models.Branch{}, *branchID).Error != nil {
+		return nil, fmt.Errorf("%w: branch not found", ErrHolidayInvalid)
+	}
+	if holidayType == "" {
+		holidayType = HolidayTypeHoliday
+	}
+	if holidayType != HolidayTypeHoliday && holidayType != HolidayTypeClosure {
+		return nil, fmt.Errorf("%w: type must be holiday or closure", ErrHolidayInvalid)
+	}
+
+	result := &HolidayImportResult{}
+	var entries []models.Holiday
+
+	switch format {
+	case HolidayImportICS:
+		parsed, err := parseICSHolidays(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHolidayInvalid, err)
+		}
+		for dateStr, name := range parsed {
+			date, _ := ParseHolidayDate(dateStr)
+			entries = append(entries, models.Holiday{Date: date, Name: name, Type: holidayType})
+		}
+	case HolidayImportCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHolidayInvalid, err)
+		}
+		for idx, row := range rows {
+			if len(row) < 2 {
+				result.Skipped++
+				continue
+			}
+			date, err := ParseHolidayDate(strings.TrimPrefix(row[0], "\ufeff"))
+			if err != nil {
+				if idx == 0 {
+					continue // header
+				}
+				result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", idx+1, err))
+				continue
+			}
+			entry := models.Holiday{Date: date, Name: row[1], Type: holidayType}
+			if len(row) > 2 && strings.TrimSpace(row[2]) != "" {
+				entry.Type = strings.ToLower(strings.TrimSpace(row[2]))
+			}
+			if len(row) > 3 {
+				entry.Notes = strings.TrimSpace(row[3])
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported import format %q", ErrHolidayInvalid, format)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range entries {
+			entry.BranchID = branchID
+			entry.Source = HolidaySourceImport
+			if err := s.validate(&entry); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.Date.Format("2006-01-02"), err))
+				continue
+			}
+			created, updated, err := s.upsert(tx, entry)
+			if err != nil {
+				return err
+			}
+			switch {
+			case created:
+				result.Created++
+			case updated:
+				result.Updated++
+			default:
+				result.Skipped++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// HolidaysInRange returns holidays applying to a branch (plus all-branch ones) between two dates.
+// Years without cached myhora data are fetched first; fetch failures are returned alongside
+// whatever the table already holds.
+func (s *HolidayService) HolidaysInRange(from, to time.Time, branchID *uint) ([]models.Holiday, error) {
+	var fetchErrors []string
+	for year := from.Year(); year <= to.Year(); year++ {
+		if err := s.ensureMyHoraYear(year); err != nil {
+			fetchErrors = append(fetchErrors, fmt.Sprintf("%d: %v", year, err))
+		}
+	}
+
+	holidays, err := s.List(HolidayFilter{From: from, To: to, BranchID: branchID})
+	if err != nil {
+		return nil, err
+	}
+	if len(fetchErrors) > 0 {
+		return holidays, fmt.Errorf("failed to fetch holidays: %s", strings.Join(fetchErrors, "; "))
+	}
+	return holidays, nil
+}
+
+func holidayYearRange(startYear, endYear int) (time.Time, time.Time) {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	return time.Date(startYear, 1, 1, 0, 0, 0, 0, loc), time.Date(endYear, 12, 31, 0, 0, 0, 0, loc)
+}
+
+// GetBranchHolidaysWithNames returns date (YYYY-MM-DD) -> name for all-branch holidays and the branch's closures
+func GetBranchHolidaysWithNames(startYear, endYear int, branchID *uint) (map[string]string, error) {
+	from, to := holidayYearRange(startYear, endYear)
+	holidays, err := NewHolidayService().HolidaysInRange(from, to, branchID)
+	if err != nil {
+		if len(holidays) == 0 {
+			return nil, err
+		}
+		log.Printf("warning: partial holiday fetch failures: %v", err)
+	}
+
+	holidayNames := make(map[string]string, len(holidays))
+	for _, holiday := range holidays {
+		key := holiday.Date.Format("2006-01-02")
+		if _, exists := holidayNames[key]; !exists {
+			holidayNames[key] = holiday.Name
+		}
+	}
+	return holidayNames, nil
+}
+
+// GetBranchHolidays returns sorted holiday dates for all branches plus the branch's closures
+func GetBranchHolidays(startYear, endYear int, branchID *uint) ([]time.Time, error) {
+	names, err := GetBranchHolidaysWithNames(startYear, endYear, branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make([]time.Time, 0, len(names))
+	for dateStr := range names {
+		if date, err := ParseHolidayDate(dateStr); err == nil {
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
+
+// RescheduleSessionsForBranch เลื่อน sessions ที่ตรงวันหยุด/วันปิดสาขา (อ่านจากตาราง holidays)
+// ดึงปีถัดไปมาด้วยเพราะ session ที่ถูกเลื่อนไปต่อท้ายอาจข้ามปี
+func RescheduleSessionsForBranch(sessions []models.Schedule_Sessions, branchID *uint) ([]models.Schedule_Sessions, error) {
+	startYear, endYear := 0, 0
+	for _, session := range sessions {
+		if session.Session_date == nil {
+			continue
+		}
+		year := session.Session_date.Year()
+		if startYear == 0 || year < startYear {
+			startYear = year
+		}
+		if year > endYear {
+			endYear = year
+		}
+	}
+	if startYear == 0 {
+		return sessions, nil
+	}
+
+	holidays, err := GetBranchHolidays(startYear, endYear+1, branchID)
+	if err != nil {
+		return sessions, err
+	}
+	return RescheduleSessions(sessions, holidays), nil
+}
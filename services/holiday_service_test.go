@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICSHolidays(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20250413",
+		"DTEND;VALUE=DATE:20250416",
+		"SUMMARY;LANGUAGE=th:วันสงกรานต์",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20251205",
+		"SUMMARY:วันพ่อแห่งชาติ",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20251206",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	got, err := parseICSHolidays(strings.NewReader(ics))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := map[string]string{
+		"2025-04-13": "วันสงกรานต์",
+		"2025-04-14": "วันสงกรานต์",
+		"2025-04-15": "วันสงกรานต์",
+		"2025-12-05": "วันพ่อแห่งชาติ",
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for date, name := range exp {
+		if got[date] != name {
+			t.Fatalf("expected %s on %s, got %q", name, date, got[date])
+		}
+	}
+}
+
+func TestParseHolidayDate(t *testing.T) {
+	for _, value := range []string{"2025-12-05", "20251205", "05/12/2025", "5/12/2568"} {
+		date, err := ParseHolidayDate(value)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", value, err)
+		}
+		if got := date.Format("2006-01-02"); got != "2025-12-05" {
+			t.Fatalf("expected 2025-12-05 for %q, got %s", value, got)
+		}
+	}
+	if _, err := ParseHolidayDate("next friday"); err == nil {
+		t.Fatalf("expected error for invalid date")
+	}
+}
+
+func TestMyHoraAttemptsTTL(t *testing.T) {
+	attempts := &myHoraAttempts{years: map[int]myHoraAttempt{}}
+	now := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	failure := errors.New("myhora down")
+
+	if _, ok := attempts.recent(2025, now, time.Hour); ok {
+		t.Fatalf("expected no cached attempt")
+	}
+	attempts.record(2025, now, failure)
+	if got, ok := attempts.recent(2025, now.Add(59*time.Minute), time.Hour); !ok || got.err != failure {
+		t.Fatalf("expected cached failure within TTL, got %+v %v", got, ok)
+	}
+	if _, ok := attempts.recent(2025, now.Add(time.Hour), time.Hour); ok {
+		t.Fatalf("expected attempt to expire after TTL")
+	}
+	if _, ok := attempts.recent(2026, now, time.Hour); ok {
+		t.Fatalf("expected other years to be unaffected")
+	}
+
+	attempts.record(2026, now, nil)
+	attempts.forget(2026)
+	if _, ok := attempts.recent(2026, now, time.Hour); ok {
+		t.Fatalf("expected forgotten year to be fetched again")
+	}
+}
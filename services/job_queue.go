@@ -0,0 +1,316 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	"englishkorat_go/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Job types handled by the durable job worker
+const (
+	JobTypeTeacherConfirmReminder = "teacher_confirm_reminder"
+	JobTypeUpcomingClassReminder  = "upcoming_class_reminder"
+)
+
+// Job statuses
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusDone      = "done"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+const (
+	jobDefaultMaxAttempts = 5
+	jobLeaseDuration      = 2 * time.Minute
+	jobPollInterval       = 5 * time.Second
+	jobClaimBatchSize     = 20
+	jobBaseBackoff        = 30 * time.Second
+	jobMaxBackoff         = time.Hour
+)
+
+// ErrJobNotFound is returned when a job does not exist or is no longer pending
+var ErrJobNotFound = errors.New("job not found or not pending")
+
+// JobHandler executes a claimed job. Returning an error schedules a retry with backoff.
+type JobHandler func(job *models.ScheduledJob) error
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = map[string]JobHandler{}
+)
+
+// RegisterJobHandler binds a handler to a job type. Later registrations replace earlier ones.
+func RegisterJobHandler(jobType string, h JobHandler) {
+	jobHandlersMu.Lock()
+	defer jobHandlersMu.Unlock()
+	jobHandlers[jobType] = h
+}
+
+func lookupJobHandler(jobType string) (JobHandler, bool) {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	h, ok := jobHandlers[jobType]
+	return h, ok
+}
+
+// EnqueueJobInput describes a job to be persisted
+type EnqueueJobInput struct {
+	JobType     string
+	RunAt       time.Time
+	SessionID   *uint
+	ScheduleID  *uint
+	Payload     any
+	DedupeKey   string // optional; identical keys are enqueued only once
+	MaxAttempts int
+}
+
+// JobQueue is a DB-backed delayed job queue with claim/lease semantics.
+// Multiple replicas can poll the same table safely: a job is owned by the worker
+// whose conditional UPDATE succeeds, and an expired lease makes it claimable again.
+type JobQueue struct {
+	db       *gorm.DB
+	workerID string
+}
+
+// NewJobQueue creates a job queue bound to the global DB
+func NewJobQueue() *JobQueue {
+	host, _ := os.Hostname()
+	suffix, _ := utils.GenerateRandomString(8)
+	return &JobQueue{
+		db:       database.DB,
+		workerID: fmt.Sprintf("%s-%d-%s", host, os.Getpid(), suffix),
+	}
+}
+
+// Enqueue persists a job. A duplicate DedupeKey is silently ignored.
+func (q *JobQueue) Enqueue(in EnqueueJobInput) error {
+	if q.db == nil {
+		return errors.New("database not initialized")
+	}
+	if in.JobType == "" {
+		return errors.New("job type is required")
+	}
+	job := models.ScheduledJob{
+		JobType:     in.JobType,
+		SessionID:   in.SessionID,
+		ScheduleID:  in.ScheduleID,
+		RunAt:       in.RunAt,
+		Status:      JobStatusPending,
+		MaxAttempts: in.MaxAttempts,
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = jobDefaultMaxAttempts
+	}
+	if in.DedupeKey != "" {
+		key := in.DedupeKey
+		job.DedupeKey = &key
+	}
+	if in.Payload != nil {
+		b, err := json.Marshal(in.Payload)
+		if err != nil {
+			return err
+		}
+		job.Payload = models.JSON(b)
+	}
+	return q.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&job).Error
+}
+
+// ListJobsFilter narrows ListJobs results
+type ListJobsFilter struct {
+	SessionID *uint
+	Status    string
+	JobType   string
+	Limit     int
+	Offset    int
+}
+
+// ListJobs returns jobs ordered by run time along with the total count
+func (q *JobQueue) ListJobs(f ListJobsFilter) ([]models.ScheduledJob, int64, error) {
+	query := q.db.Model(&models.ScheduledJob{})
+	if f.SessionID != nil {
+		query = query.Where("session_id = ?", *f.SessionID)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.JobType != "" {
+		query = query.Where("job_type = ?", f.JobType)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	var jobs []models.ScheduledJob
+	err := query.Order("run_at ASC").Limit(f.Limit).Offset(f.Offset).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// CancelJob cancels a single pending job
+func (q *JobQueue) CancelJob(jobID uint) error {
+	res := q.db.Model(&models.ScheduledJob{}).
+		Where("id = ? AND status = ?", jobID, JobStatusPending).
+		Update("status", JobStatusCancelled)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// CancelJobsForSession cancels pending jobs tied to a session (optionally only the given job types)
+// and returns how many were cancelled
+func (q *JobQueue) CancelJobsForSession(sessionID uint, jobTypes ...string) (int64, error) {
+	query := q.db.Model(&models.ScheduledJob{}).
+		Where("session_id = ? AND status = ?", sessionID, JobStatusPending)
+	if len(jobTypes) > 0 {
+		query = query.Where("job_type IN ?", jobTypes)
+	}
+	res := query.Update("status", JobStatusCancelled)
+	return res.RowsAffected, res.Error
+}
+
+// StartWorker polls for due jobs until stop is closed
+func (q *JobQueue) StartWorker(stop <-chan struct{}) {
+	go func() {
+		log.Printf("[jobs] worker %s started", q.workerID)
+		ticker := time.NewTicker(jobPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				log.Printf("[jobs] worker %s stopping", q.workerID)
+				return
+			case <-ticker.C:
+				q.processDue()
+			}
+		}
+	}()
+}
+
+// processDue claims a batch of due jobs and runs them one by one
+func (q *JobQueue) processDue() {
+	if q.db == nil {
+		return
+	}
+	now := time.Now()
+	var candidates []uint
+	err := q.db.Model(&models.ScheduledJob{}).
+		Where("(status = ? AND run_at <= ?) OR (status = ? AND lease_until < ?)", JobStatusPending, now, JobStatusRunning, now).
+		Order("run_at ASC").
+		Limit(jobClaimBatchSize).
+		Pluck("id", &candidates).Error
+	if err != nil {
+		log.Printf("[jobs] poll failed: %v", err)
+		return
+	}
+	for _, id := range candidates {
+		job, ok := q.claim(id)
+		if !ok {
+			continue
+		}
+		q.run(job)
+	}
+}
+
+// claim takes ownership of a job via a conditional update; only one worker can win
+func (q *JobQueue) claim(id uint) (*models.ScheduledJob, bool) {
+	now := time.Now()
+	leaseUntil := now.Add(jobLeaseDuration)
+	res := q.db.Model(&models.ScheduledJob{}).
+		Where("id = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND lease_until < ?))", id, JobStatusPending, now, JobStatusRunning, now).
+		Updates(map[string]interface{}{
+			"status":      JobStatusRunning,
+			"locked_by":   q.workerID,
+			"lease_until": leaseUntil,
+			"attempts":    gorm.Expr("attempts + 1"),
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, false
+	}
+	var job models.ScheduledJob
+	if err := q.db.First(&job, id).Error; err != nil {
+		return nil, false
+	}
+	return &job, true
+}
+
+func (q *JobQueue) run(job *models.ScheduledJob) {
+	h, ok := lookupJobHandler(job.JobType)
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %q", job.JobType)
+	} else {
+		err = safeRunJob(h, job)
+	}
+
+	owned := q.db.Model(&models.ScheduledJob{}).Where("id = ? AND locked_by = ? AND status = ?", job.ID, q.workerID, JobStatusRunning)
+	if err == nil {
+		now := time.Now()
+		owned.Updates(map[string]interface{}{
+			"status":       JobStatusDone,
+			"completed_at": now,
+			"lease_until":  nil,
+			"last_error":   "",
+		})
+		return
+	}
+
+	log.Printf("[jobs] job %d (%s) attempt %d failed: %v", job.ID, job.JobType, job.Attempts, err)
+	if job.Attempts >= job.MaxAttempts || !ok {
+		owned.Updates(map[string]interface{}{
+			"status":      JobStatusFailed,
+			"lease_until": nil,
+			"last_error":  err.Error(),
+		})
+		return
+	}
+	owned.Updates(map[string]interface{}{
+		"status":      JobStatusPending,
+		"run_at":      time.Now().Add(jobBackoff(job.Attempts)),
+		"lease_until": nil,
+		"last_error":  err.Error(),
+	})
+}
+
+// safeRunJob converts a handler panic into an error so one bad job cannot kill the worker
+func safeRunJob(h JobHandler, job *models.ScheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(job)
+}
+
+// jobBackoff returns an exponential delay (30s, 1m, 2m, ...) capped at one hour
+func jobBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := jobBaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeSQL is a minimal database/sql driver that records statements, so the queue's SQL can be
+// checked without a MySQL server. Exec returns rowsAffected; SELECTs return the job in row.
+type fakeSQL struct {
+	mu           sync.Mutex
+	execs        []fakeStmt
+	queries      []fakeStmt
+	selects      int
+	rowsAffected int64
+	row          map[string]driver.Value
+}
+
+type fakeStmt struct {
+	query string
+	args  []driver.Value
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeSQL) Driver() driver.Driver                        { return nil }
+
+// lastExec returns the last statement starting with prefix
+func (f *fakeSQL) lastExec(prefix string) (fakeStmt, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.execs) - 1; i >= 0; i-- {
+		if strings.HasPrefix(f.execs[i].query, prefix) {
+			return f.execs[i], true
+		}
+	}
+	return fakeStmt{}, false
+}
+
+type fakeConn struct{ f *fakeSQL }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	stmt := fakeStmt{query: query}
+	for _, a := range args {
+		stmt.args = append(stmt.args, a.Value)
+	}
+	c.f.execs = append(c.f.execs, stmt)
+	return fakeResult(c.f.rowsAffected), nil
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return 1, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.selects++
+	stmt := fakeStmt{query: query}
+	for _, a := range args {
+		stmt.args = append(stmt.args, a.Value)
+	}
+	c.f.queries = append(c.f.queries, stmt)
+	rows := &fakeRows{}
+	for k, v := range c.f.row {
+		rows.cols = append(rows.cols, k)
+		rows.vals = append(rows.vals, v)
+	}
+	return rows, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	cols []string
+	vals []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done || len(r.cols) == 0 {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.vals)
+	return nil
+}
+
+func newFakeDB(t *testing.T) (*gorm.DB, *fakeSQL) {
+	t.Helper()
+	f := &fakeSQL{rowsAffected: 1}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sql.OpenDB(f), SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f
+}
+
+func newFakeJobQueue(t *testing.T) (*JobQueue, *fakeSQL) {
+	t.Helper()
+	db, f := newFakeDB(t)
+	return &JobQueue{db: db, workerID: "worker-a"}, f
+}
+
+func hasArg(args []driver.Value, want driver.Value) bool {
+	for _, a := range args {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJobBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		0: 30 * time.Second, 1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute,
+		7: 32 * time.Minute, 8: time.Hour, 20: time.Hour,
+	}
+	for attempt, want := range cases {
+		if got := jobBackoff(attempt); got != want {
+			t.Errorf("jobBackoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestEnqueueIgnoresDuplicateDedupeKey(t *testing.T) {
+	q, f := newFakeJobQueue(t)
+	sessionID := uint(7)
+	runAt := time.Now().Add(time.Hour)
+	err := q.Enqueue(EnqueueJobInput{
+		JobType: JobTypeTeacherConfirmReminder, RunAt: runAt, SessionID: &sessionID,
+		Payload:   teacherConfirmJobPayload{SessionID: 7, NotifyAt: runAt.Unix()},
+		DedupeKey: "teacher-confirm:7:3:1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, ok := f.lastExec("INSERT INTO `scheduled_jobs`")
+	if !ok {
+		t.Fatalf("no insert recorded: %+v", f.execs)
+	}
+	// a second replica enqueueing the same key hits the unique index and the insert is a no-op
+	if !strings.Contains(stmt.query, "ON DUPLICATE KEY UPDATE") {
+		t.Fatalf("insert does not ignore duplicate keys: %s", stmt.query)
+	}
+	if !hasArg(stmt.args, "teacher-confirm:7:3:1") || !hasArg(stmt.args, JobStatusPending) || !hasArg(stmt.args, int64(jobDefaultMaxAttempts)) {
+		t.Fatalf("unexpected insert args %v", stmt.args)
+	}
+
+	if err := q.Enqueue(EnqueueJobInput{RunAt: runAt}); err == nil {
+		t.Fatal("expected an error without a job type")
+	}
+}
+
+func TestClaimIsConditional(t *testing.T) {
+	q, f := newFakeJobQueue(t)
+
+	// another worker won the update: nothing is loaded or run
+	f.rowsAffected = 0
+	if job, ok := q.claim(5); ok || job != nil {
+		t.Fatalf("claim succeeded without updating a row: %+v", job)
+	}
+	if f.selects != 0 {
+		t.Fatalf("lost claim still loaded the job")
+	}
+	stmt, _ := f.lastExec("UPDATE `scheduled_jobs`")
+	// due pending jobs and running jobs with an expired lease (crashed worker) are claimable
+	for _, part := range []string{"status = ? AND run_at <= ?", "status = ? AND lease_until < ?", "`attempts`=attempts + 1"} {
+		if !strings.Contains(stmt.query, part) {
+			t.Fatalf("claim update %q is missing %q", stmt.query, part)
+		}
+	}
+	if !hasArg(stmt.args, JobStatusRunning) || !hasArg(stmt.args, "worker-a") {
+		t.Fatalf("claim args %v", stmt.args)
+	}
+
+	f.rowsAffected = 1
+	f.row = map[string]driver.Value{"id": int64(5), "job_type": JobTypeUpcomingClassReminder, "status": JobStatusRunning, "attempts": int64(1), "max_attempts": int64(5), "locked_by": "worker-a"}
+	job, ok := q.claim(5)
+	if !ok || job.ID != 5 || job.LockedBy != "worker-a" {
+		t.Fatalf("claim = %+v, %v", job, ok)
+	}
+}
+
+func TestRunRetriesThenFails(t *testing.T) {
+	q, f := newFakeJobQueue(t)
+	RegisterJobHandler("test_failing_job", func(*models.ScheduledJob) error { return errors.New("db down") })
+
+	job := &models.ScheduledJob{JobType: "test_failing_job", Attempts: 2, MaxAttempts: 3}
+	job.ID = 9
+	q.run(job)
+	stmt, _ := f.lastExec("UPDATE `scheduled_jobs`")
+	if !hasArg(stmt.args, JobStatusPending) || !strings.Contains(stmt.query, "`run_at`=?") || !strings.Contains(stmt.query, "locked_by = ?") {
+		t.Fatalf("failed attempt was not rescheduled by its owner: %s %v", stmt.query, stmt.args)
+	}
+
+	job.Attempts = 3
+	q.run(job)
+	stmt, _ = f.lastExec("UPDATE `scheduled_jobs`")
+	if !hasArg(stmt.args, JobStatusFailed) || !hasArg(stmt.args, "db down") || strings.Contains(stmt.query, "`run_at`=?") {
+		t.Fatalf("last attempt did not fail the job: %s %v", stmt.query, stmt.args)
+	}
+
+	job.JobType, job.Attempts = "test_unknown_job", 1
+	q.run(job)
+	stmt, _ = f.lastExec("UPDATE `scheduled_jobs`")
+	if !hasArg(stmt.args, JobStatusFailed) {
+		t.Fatalf("job without a handler was retried: %v", stmt.args)
+	}
+}
+
+func TestCancelJobsForSession(t *testing.T) {
+	q, f := newFakeJobQueue(t)
+	f.rowsAffected = 2
+	n, err := q.CancelJobsForSession(4, JobTypeUpcomingClassReminder)
+	if err != nil || n != 2 {
+		t.Fatalf("CancelJobsForSession = %d, %v", n, err)
+	}
+	stmt, _ := f.lastExec("UPDATE `scheduled_jobs`")
+	if !strings.Contains(stmt.query, "session_id = ? AND status = ?") || !strings.Contains(stmt.query, "job_type IN (?)") ||
+		!hasArg(stmt.args, JobStatusCancelled) || !hasArg(stmt.args, JobStatusPending) {
+		t.Fatalf("cancel update %s %v", stmt.query, stmt.args)
+	}
+}
+
+func TestTeacherConfirmDedupeKey(t *testing.T) {
+	job := &models.ScheduledJob{RunAt: time.Unix(2000, 0)}
+	job.ID = 12
+	p := teacherConfirmJobPayload{SessionID: 3, NotifyAt: 1000}
+	if got := teacherConfirmDedupeKey(job, p); got != "session:3:confirm-reminder:1000" {
+		t.Fatalf("key = %q", got)
+	}
+	// a retry moves run_at; the key must stay the same
+	job.RunAt = job.RunAt.Add(time.Minute)
+	if got := teacherConfirmDedupeKey(job, p); got != "session:3:confirm-reminder:1000" {
+		t.Fatalf("key changed on retry: %q", got)
+	}
+	raw, _ := json.Marshal(teacherConfirmJobPayload{SessionID: 3})
+	var legacy teacherConfirmJobPayload
+	_ = json.Unmarshal(raw, &legacy)
+	if got := teacherConfirmDedupeKey(job, legacy); got != "session:3:confirm-reminder:job-12" {
+		t.Fatalf("legacy key = %q", got)
+	}
+}
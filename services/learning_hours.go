@@ -0,0 +1,458 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"englishkorat_go/config"
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ledger entry types
+const (
+	HoursPurchase         = "purchase"
+	HoursConsumption      = "consumption"
+	HoursLeaveRefund      = "leave_refund"
+	HoursLateLeaveForfeit = "late_leave_forfeit"
+	HoursAdjustment       = "adjustment"
+)
+
+// A low-balance alert fires when the remaining hours drop below this many hours
+// (LEARNING_HOURS_LOW_THRESHOLD overrides it)
+const defaultLearningHoursLowThreshold = 4.0
+
+var (
+	ErrLearningHoursInvalid   = errors.New("invalid learning hours entry")
+	ErrLearningHoursExhausted = errors.New("student has no learning hours left")
+)
+
+// LearningHoursBalance summarises a student's ledger
+type LearningHoursBalance struct {
+	StudentID uint    `json:"student_id"`
+	Purchased float64 `json:"purchased"`
+	Consumed  float64 `json:"consumed"`
+	Refunded  float64 `json:"refunded"`
+	Forfeited float64 `json:"forfeited"`
+	Adjusted  float64 `json:"adjusted"`
+	Balance   float64 `json:"balance"`
+	// Tracked is false for students that never bought hours; they are never blocked
+	Tracked   bool `json:"tracked"`
+	Exhausted bool `json:"exhausted"`
+}
+
+// HoursPurchaseInput is the body of a purchase / manual adjustment
+type HoursPurchaseInput struct {
+	Hours     float64 `json:"hours"`
+	CourseID  *uint   `json:"course_id"`
+	GroupID   *uint   `json:"group_id"`
+	BillID    *uint   `json:"bill_id"`
+	Reference string  `json:"reference"`
+	Notes     string  `json:"notes"`
+}
+
+// LearningHoursService posts and reads the per-student hours ledger
+type LearningHoursService struct {
+	db *gorm.DB
+}
+
+func NewLearningHoursService() *LearningHoursService {
+	return &LearningHoursService{db: database.DB}
+}
+
+func learningHoursLowThreshold() float64 {
+	if config.AppConfig != nil && config.AppConfig.LearningHoursLowThreshold > 0 {
+		return config.AppConfig.LearningHoursLowThreshold
+	}
+	return defaultLearningHoursLowThreshold
+}
+
+func roundHours(h float64) float64 {
+	return math.Round(h*100) / 100
+}
+
+// SessionHours is the length of a session in hours (falls back to the schedule's hours per session)
+func SessionHours(session models.Schedule_Sessions) float64 {
+	if session.Start_time != nil && session.End_time != nil && session.End_time.After(*session.Start_time) {
+		return roundHours(session.End_time.Sub(*session.Start_time).Hours())
+	}
+	if session.Schedule != nil && session.Schedule.Hours_per_session > 0 {
+		return float64(session.Schedule.Hours_per_session)
+	}
+	return 0
+}
+
+// Balance sums the ledger of a student
+func (s *LearningHoursService) Balance(studentID uint) (*LearningHoursBalance, error) {
+	var rows []struct {
+		EntryType string
+		Total     float64
+	}
+	if err := s.db.Model(&models.LearningHourEntry{}).
+		Select("entry_type, COALESCE(SUM(hours), 0) AS total").
+		Where("student_id = ?", studentID).
+		Group("entry_type").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		totals[row.EntryType] = row.Total
+	}
+	return summarizeLedger(studentID, totals), nil
+}
+
+// summarizeLedger turns per-entry-type sums into a balance
+func summarizeLedger(studentID uint, totals map[string]float64) *LearningHoursBalance {
+	b := &LearningHoursBalance{StudentID: studentID}
+	for entryType, total := range totals {
+		switch entryType {
+		case HoursPurchase:
+			b.Purchased = total
+			b.Tracked = true
+		case HoursConsumption:
+			b.Consumed = -total
+		case HoursLeaveRefund:
+			b.Refunded = total
+		case HoursLateLeaveForfeit:
+			b.Forfeited = -total
+		case HoursAdjustment:
+			b.Adjusted = total
+		}
+		b.Balance += total
+	}
+	b.Balance = roundHours(b.Balance)
+	b.Exhausted = b.Tracked && b.Balance <= 0
+	return b
+}
+
+// History returns ledger entries, newest first
+func (s *LearningHoursService) History(studentID uint, entryType string, limit, offset int) ([]models.LearningHourEntry, int64, error) {
+	query := s.db.Model(&models.LearningHourEntry{}).Where("student_id = ?", studentID)
+	if entryType != "" {
+		query = query.Where("entry_type = ?", entryType)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []models.LearningHourEntry
+	err := query.Preload("Session").
+		Order("created_at DESC, id DESC").
+		Limit(limit).Offset(offset).
+		Find(&entries).Error
+	return entries, total, err
+}
+
+// Purchase adds bought hours to a student
+func (s *LearningHoursService) Purchase(studentID uint, in HoursPurchaseInput, createdBy uint) (*models.LearningHourEntry, error) {
+	if in.Hours <= 0 {
+		return nil, fmt.Errorf("%w: hours must be greater than 0", ErrLearningHoursInvalid)
+	}
+	return s.postManual(studentID, HoursPurchase, in, createdBy)
+}
+
+// Adjust posts a signed manual correction
+func (s *LearningHoursService) Adjust(studentID uint, in HoursPurchaseInput, createdBy uint) (*models.LearningHourEntry, error) {
+	if in.Hours == 0 {
+		return nil, fmt.Errorf("%w: hours must not be 0", ErrLearningHoursInvalid)
+	}
+	if in.Notes == "" {
+		return nil, fmt.Errorf("%w: notes are required for adjustments", ErrLearningHoursInvalid)
+	}
+	return s.postManual(studentID, HoursAdjustment, in, createdBy)
+}
+
+func (s *LearningHoursService) postManual(studentID uint, entryType string, in HoursPurchaseInput, createdBy uint) (*models.LearningHourEntry, error) {
+	var student models.Student
+	if err := s.db.Select("id").First(&student, studentID).Error; err != nil {
+		return nil, err
+	}
+	entry := models.LearningHourEntry{
+		StudentID: studentID,
+		EntryType: entryType,
+		Hours:     roundHours(in.Hours),
+		CourseID:  in.CourseID,
+		GroupID:   in.GroupID,
+		BillID:    in.BillID,
+		Reference: in.Reference,
+		Notes:     in.Notes,
+		CreatedBy: &createdBy,
+	}
+	if err := s.db.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// postSessionEntry inserts one session-bound entry; it returns false when it already exists
+func (s *LearningHoursService) postSessionEntry(entry *models.LearningHourEntry) (bool, error) {
+	res := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(entry)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// excusedForSession returns the students that are not charged for a session
+// (approved leave, excused attendance, or a late leave that already forfeited the hours)
+func (s *LearningHoursService) excusedForSession(session models.Schedule_Sessions, groupID uint, students []models.Student) map[uint]bool {
+	excused := make(map[uint]bool)
+	for studentID := range NewAttendanceService().ExcusedStudentsForSession(session.ID, groupID, students) {
+		excused[studentID] = true
+	}
+	var ids []uint
+	s.db.Model(&models.Attendance{}).
+		Where("session_id = ? AND status = ?", session.ID, AttendanceExcused).
+		Pluck("student_id", &ids)
+	for _, id := range ids {
+		excused[id] = true
+	}
+	// ลาช้าถูกหักชั่วโมงไปแล้ว (late_leave_forfeit) ไม่ต้องตัดซ้ำ
+	ids = nil
+	s.db.Model(&models.LearningHourEntry{}).
+		Where("session_id = ? AND entry_type = ?", session.ID, HoursLateLeaveForfeit).
+		Pluck("student_id", &ids)
+	for _, id := range ids {
+		excused[id] = true
+	}
+	return excused
+}
+
+// PostSessionConsumption deducts the session length from every active member of the session's group.
+// Excused students are skipped. Safe to call more than once per session.
+func (s *LearningHoursService) PostSessionConsumption(sessionID uint) (int, error) {
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		return 0, err
+	}
+	if session.Status != "completed" || session.Schedule == nil || session.Schedule.GroupID == nil {
+		return 0, nil
+	}
+	hours := SessionHours(session)
+	if hours <= 0 {
+		return 0, nil
+	}
+	groupID := *session.Schedule.GroupID
+
+	students, err := NewAttendanceService().groupStudents(groupID)
+	if err != nil {
+		return 0, err
+	}
+	excused := s.excusedForSession(session, groupID, students)
+
+	var group models.Group
+	s.db.Select("id", "course_id").First(&group, groupID)
+
+	students = chargeableStudents(students, excused)
+	entries := consumptionEntries(session, groupID, group.CourseID, students, hours)
+	posted := 0
+	for i := range entries {
+		created, err := s.postSessionEntry(&entries[i])
+		if err != nil {
+			return posted, err
+		}
+		if created {
+			posted++
+			s.checkBalanceAlert(students[i], hours)
+		}
+	}
+	return posted, nil
+}
+
+func chargeableStudents(students []models.Student, excused map[uint]bool) []models.Student {
+	out := make([]models.Student, 0, len(students))
+	for _, student := range students {
+		if !excused[student.ID] {
+			out = append(out, student)
+		}
+	}
+	return out
+}
+
+// consumptionEntries builds one -hours entry per charged student (same order as students)
+func consumptionEntries(session models.Schedule_Sessions, groupID, courseID uint, students []models.Student, hours float64) []models.LearningHourEntry {
+	note := fmt.Sprintf("#%d", session.Session_number)
+	if session.Schedule != nil {
+		note = fmt.Sprintf("%s #%d", session.Schedule.ScheduleName, session.Session_number)
+	}
+	entries := make([]models.LearningHourEntry, 0, len(students))
+	for _, student := range students {
+		entry := models.LearningHourEntry{
+			StudentID: student.ID,
+			EntryType: HoursConsumption,
+			Hours:     -hours,
+			SessionID: &session.ID,
+			GroupID:   &groupID,
+			Notes:     note,
+		}
+		if courseID != 0 {
+			id := courseID
+			entry.CourseID = &id
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// PostLeaveRefund gives back hours already consumed for a session covered by an approved absence
+func (s *LearningHoursService) PostLeaveRefund(absence models.Absence) (int, error) {
+	if absence.Status != "approved" || absence.IsLate {
+		return 0, nil
+	}
+	query := s.db.Where("session_id = ? AND group_id = ? AND entry_type = ?", absence.SessionID, absence.GroupID, HoursConsumption)
+	if absence.StudentID != nil {
+		query = query.Where("student_id = ?", *absence.StudentID)
+	}
+	var consumed []models.LearningHourEntry
+	if err := query.Find(&consumed).Error; err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	for _, entry := range refundEntries(absence, consumed) {
+		created, err := s.postSessionEntry(&entry)
+		if err != nil {
+			return posted, err
+		}
+		if created {
+			posted++
+		}
+	}
+	return posted, nil
+}
+
+// refundEntries mirrors consumption entries of the absent students back as leave refunds
+func refundEntries(absence models.Absence, consumed []models.LearningHourEntry) []models.LearningHourEntry {
+	absenceID := absence.ID
+	entries := make([]models.LearningHourEntry, 0, len(consumed))
+	for _, c := range consumed {
+		if c.EntryType != HoursConsumption || (absence.StudentID != nil && c.StudentID != *absence.StudentID) {
+			continue
+		}
+		entries = append(entries, models.LearningHourEntry{
+			StudentID: c.StudentID,
+			EntryType: HoursLeaveRefund,
+			Hours:     -c.Hours,
+			SessionID: c.SessionID,
+			GroupID:   c.GroupID,
+			CourseID:  c.CourseID,
+			AbsenceID: &absenceID,
+			Notes:     "คืนชั่วโมงจากการลาที่อนุมัติ",
+			CreatedBy: absence.ApprovedBy,
+		})
+	}
+	return entries
+}
+
+// PostLateLeaveForfeit deducts the session hours of a leave filed after the cutoff inside tx (the caller's
+// transaction). It returns the hours deducted (0 when there is nothing to deduct or it was already posted);
+// call AlertLowBalance with them after the transaction commits.
+func (s *LearningHoursService) PostLateLeaveForfeit(tx *gorm.DB, studentID uint, session models.Schedule_Sessions, groupID, absenceID uint) (float64, error) {
+	hours := SessionHours(session)
+	if hours <= 0 {
+		return 0, nil
+	}
+	entry := models.LearningHourEntry{
+		StudentID: studentID,
+		EntryType: HoursLateLeaveForfeit,
+		Hours:     -hours,
+		SessionID: &session.ID,
+		GroupID:   &groupID,
+		AbsenceID: &absenceID,
+		Notes:     "ลาหลังเวลาที่กำหนด - ตัดชั่วโมงเรียน",
+	}
+	created, err := (&LearningHoursService{db: tx}).postSessionEntry(&entry)
+	if err != nil || !created {
+		return 0, err
+	}
+	return hours, nil
+}
+
+// AlertLowBalance sends the low / used-up balance alert when a deduction of `hours` crossed the line
+func (s *LearningHoursService) AlertLowBalance(studentID uint, hours float64) {
+	var student models.Student
+	if s.db.First(&student, studentID).Error == nil {
+		s.checkBalanceAlert(student, hours)
+	}
+}
+
+// CheckCanEnroll blocks tracked students whose balance ran out
+func (s *LearningHoursService) CheckCanEnroll(studentID uint) (*LearningHoursBalance, error) {
+	balance, err := s.Balance(studentID)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Exhausted {
+		return balance, ErrLearningHoursExhausted
+	}
+	return balance, nil
+}
+
+// checkBalanceAlert notifies admins (and the student) when a deduction of `hours` crossed the low / empty line
+func (s *LearningHoursService) checkBalanceAlert(student models.Student, hours float64) {
+	balance, err := s.Balance(student.ID)
+	if err != nil || !balance.Tracked {
+		return
+	}
+	after := balance.Balance
+	before := roundHours(after + hours)
+
+	var title, titleTh, msg, msgTh string
+	action := balanceAlertAction(before, after, learningHoursLowThreshold())
+	switch action {
+	case "learning-hours-exhausted":
+		title, titleTh = "Learning hours used up", "ชั่วโมงเรียนหมดแล้ว"
+		msg = fmt.Sprintf("%s has used all purchased hours (balance %.2f h).", studentDisplayName(student), after)
+		msgTh = fmt.Sprintf("%s ใช้ชั่วโมงเรียนครบแล้ว (คงเหลือ %.2f ชม.)", studentDisplayName(student), after)
+	case "learning-hours-low":
+		title, titleTh = "Learning hours running low", "ชั่วโมงเรียนใกล้หมด"
+		msg = fmt.Sprintf("%s has %.2f hours left.", studentDisplayName(student), after)
+		msgTh = fmt.Sprintf("%s เหลือชั่วโมงเรียน %.2f ชม.", studentDisplayName(student), after)
+	default:
+		return
+	}
+
+	var userIDs []uint
+	s.db.Model(&models.User{}).Where("role IN ?", []string{"admin", "owner"}).Pluck("id", &userIDs)
+	if student.UserID != nil {
+		userIDs = append(userIDs, *student.UserID)
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+	data := map[string]interface{}{
+		"action":     action,
+		"student_id": student.ID,
+		"balance":    after,
+		"link": map[string]interface{}{
+			"href":   fmt.Sprintf("/api/students/%d/hours", student.ID),
+			"method": "GET",
+		},
+		"checked_at": time.Now().Format(time.RFC3339),
+	}
+	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "warning", data, "normal", "popup")
+	if err := notifsvc.NewService().EnqueueOrCreate(userIDs, q); err != nil {
+		log.Printf("learning hours alert for student %d: %v", student.ID, err)
+	}
+}
+
+// balanceAlertAction returns the alert for a deduction that moved the balance from before to after:
+// exhausted when it reached zero, low when it first dropped under the threshold, "" otherwise
+func balanceAlertAction(before, after, low float64) string {
+	switch {
+	case after <= 0 && before > 0:
+		return "learning-hours-exhausted"
+	case after > 0 && after < low && before >= low:
+		return "learning-hours-low"
+	}
+	return ""
+}
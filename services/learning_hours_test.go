@@ -0,0 +1,121 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestSummarizeLedger(t *testing.T) {
+	tests := []struct {
+		name   string
+		totals map[string]float64
+		want   LearningHoursBalance
+	}{
+		{
+			name:   "never bought hours is not tracked",
+			totals: map[string]float64{HoursConsumption: -3},
+			want:   LearningHoursBalance{StudentID: 1, Consumed: 3, Balance: -3},
+		},
+		{
+			name: "all entry types",
+			totals: map[string]float64{
+				HoursPurchase:         20,
+				HoursConsumption:      -9,
+				HoursLeaveRefund:      1.5,
+				HoursLateLeaveForfeit: -1.5,
+				HoursAdjustment:       -0.333,
+			},
+			want: LearningHoursBalance{StudentID: 1, Purchased: 20, Consumed: 9, Refunded: 1.5, Forfeited: 1.5, Adjusted: -0.333, Balance: 10.67, Tracked: true},
+		},
+		{
+			name:   "used up",
+			totals: map[string]float64{HoursPurchase: 6, HoursConsumption: -6},
+			want:   LearningHoursBalance{StudentID: 1, Purchased: 6, Consumed: 6, Tracked: true, Exhausted: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeLedger(1, tt.totals); *got != tt.want {
+				t.Fatalf("balance = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumptionEntries(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Minute)
+	session := models.Schedule_Sessions{Start_time: &start, End_time: &end, Session_number: 4, Schedule: &models.Schedules{ScheduleName: "Kids A"}}
+	session.ID = 7
+	students := []models.Student{{BaseModel: models.BaseModel{ID: 1}}, {BaseModel: models.BaseModel{ID: 2}}, {BaseModel: models.BaseModel{ID: 3}}}
+
+	charged := chargeableStudents(students, map[uint]bool{2: true})
+	entries := consumptionEntries(session, 5, 9, charged, SessionHours(session))
+	if len(entries) != 2 || entries[0].StudentID != 1 || entries[1].StudentID != 3 {
+		t.Fatalf("entries = %+v, want students 1 and 3", entries)
+	}
+	for _, e := range entries {
+		if e.EntryType != HoursConsumption || e.Hours != -1.5 || *e.SessionID != 7 || *e.GroupID != 5 || e.CourseID == nil || *e.CourseID != 9 {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+		if e.Notes != "Kids A #4" {
+			t.Fatalf("notes = %q", e.Notes)
+		}
+	}
+
+	if entries := consumptionEntries(session, 5, 0, charged, 1); entries[0].CourseID != nil {
+		t.Fatalf("expected no course on entries of a group without course")
+	}
+}
+
+func TestRefundEntries(t *testing.T) {
+	sessionID, groupID, approver := uint(7), uint(5), uint(99)
+	consumed := []models.LearningHourEntry{
+		{StudentID: 1, EntryType: HoursConsumption, Hours: -1.5, SessionID: &sessionID, GroupID: &groupID},
+		{StudentID: 2, EntryType: HoursConsumption, Hours: -1.5, SessionID: &sessionID, GroupID: &groupID},
+		{StudentID: 2, EntryType: HoursLateLeaveForfeit, Hours: -1.5, SessionID: &sessionID, GroupID: &groupID},
+	}
+
+	groupAbsence := models.Absence{GroupID: groupID, ApprovedBy: &approver}
+	groupAbsence.ID = 11
+	refunds := refundEntries(groupAbsence, consumed)
+	if len(refunds) != 2 {
+		t.Fatalf("group absence: %d refunds, want 2", len(refunds))
+	}
+	for _, r := range refunds {
+		if r.EntryType != HoursLeaveRefund || r.Hours != 1.5 || *r.AbsenceID != 11 || *r.CreatedBy != approver {
+			t.Fatalf("unexpected refund %+v", r)
+		}
+	}
+
+	studentID := uint(2)
+	single := models.Absence{GroupID: groupID, StudentID: &studentID}
+	if refunds := refundEntries(single, consumed); len(refunds) != 1 || refunds[0].StudentID != 2 {
+		t.Fatalf("student absence refunds = %+v", refunds)
+	}
+}
+
+func TestBalanceAlertAction(t *testing.T) {
+	tests := []struct {
+		before, after float64
+		want          string
+	}{
+		{before: 10, after: 8.5, want: ""},
+		{before: 5, after: 3.5, want: "learning-hours-low"},
+		{before: 4, after: 2.5, want: "learning-hours-low"},
+		{before: 3.5, after: 2, want: ""}, // already alerted when it crossed the line
+		{before: 1.5, after: 0, want: "learning-hours-exhausted"},
+		{before: 6, after: -1, want: "learning-hours-exhausted"},
+		{before: 0, after: -1.5, want: ""},
+	}
+	for _, tt := range tests {
+		if got := balanceAlertAction(tt.before, tt.after, 4); got != tt.want {
+			t.Errorf("balanceAlertAction(%v, %v) = %q, want %q", tt.before, tt.after, got, tt.want)
+		}
+	}
+	if learningHoursLowThreshold() != defaultLearningHoursLowThreshold {
+		t.Errorf("threshold without config = %v", learningHoursLowThreshold())
+	}
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Leave quota history entry types
+const (
+	LeaveQuotaGrant      = "grant"
+	LeaveQuotaUse        = "use"
+	LeaveQuotaAdjustment = "adjustment"
+	LeaveQuotaMigration  = "migration"
+)
+
+var (
+	ErrLeaveQuotaNotFound  = errors.New("leave quota not found")
+	ErrLeaveQuotaInvalid   = errors.New("invalid leave quota")
+	ErrLeaveQuotaExhausted = errors.New("leave quota used up")
+)
+
+// StudentLeaveQuotaView is a quota with its remaining leaves
+type StudentLeaveQuotaView struct {
+	models.StudentLeaveQuota
+	TotalQuota int `json:"total_quota"`
+	Remaining  int `json:"remaining"`
+}
+
+func quotaView(q models.StudentLeaveQuota) StudentLeaveQuotaView {
+	total := q.BaseQuota + q.Adjustment
+	return StudentLeaveQuotaView{StudentLeaveQuota: q, TotalQuota: total, Remaining: total - q.UsedQuota}
+}
+
+// LeaveQuotaPolicyInput is a policy create/update request; Active is kept as-is on update when omitted
+type LeaveQuotaPolicyInput struct {
+	Name     string `json:"name"`
+	CourseID *uint  `json:"course_id"`
+	Leaves   int    `json:"leaves"`
+	PerHours int    `json:"per_hours"`
+	Active   *bool  `json:"active"`
+}
+
+// LeaveQuotaService manages leave quota policies and per-student quotas
+type LeaveQuotaService struct {
+	db *gorm.DB
+}
+
+func NewLeaveQuotaService() *LeaveQuotaService {
+	return &LeaveQuotaService{db: database.DB}
+}
+
+// LeavesForHours applies a policy to enrolled hours: Leaves per PerHours (rounded down, at least
+// one block when there are hours), or a flat Leaves when PerHours is 0
+func LeavesForHours(policy models.LeaveQuotaPolicy, hours float64) int {
+	if policy.Leaves <= 0 {
+		return 0
+	}
+	if policy.PerHours <= 0 {
+		return policy.Leaves
+	}
+	blocks := int(hours) / policy.PerHours
+	if blocks == 0 && hours > 0 {
+		blocks = 1
+	}
+	return blocks * policy.Leaves
+}
+
+// ListPolicies returns policies (course-specific first)
+func (s *LeaveQuotaService) ListPolicies() ([]models.LeaveQuotaPolicy, error) {
+	var policies []models.LeaveQuotaPolicy
+	err := s.db.Preload("Course").Order("course_id IS NULL, course_id ASC, id ASC").Find(&policies).Error
+	return policies, err
+}
+
+func validatePolicy(p *models.LeaveQuotaPolicy) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrLeaveQuotaInvalid)
+	}
+	if p.Leaves < 0 || p.PerHours < 0 {
+		return fmt.Errorf("%w: leaves and per_hours must not be negative", ErrLeaveQuotaInvalid)
+	}
+	return nil
+}
+
+func (s *LeaveQuotaService) CreatePolicy(in LeaveQuotaPolicyInput) (*models.LeaveQuotaPolicy, error) {
+	p := models.LeaveQuotaPolicy{Name: in.Name, CourseID: in.CourseID, Leaves: in.Leaves, PerHours: in.PerHours, Active: true}
+	if in.Active != nil {
+		p.Active = *in.Active
+	}
+	if err := validatePolicy(&p); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&p).Error; err != nil {
+		return nil, err
+	}
+	// Create skips a false Active (column default is true)
+	if !p.Active {
+		if err := s.db.Model(&p).Update("active", false).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+func (s *LeaveQuotaService) UpdatePolicy(id uint, in LeaveQuotaPolicyInput) (*models.LeaveQuotaPolicy, error) {
+	var p models.LeaveQuotaPolicy
+	if err := s.db.First(&p, id).Error; err != nil {
+		return nil, ErrLeaveQuotaNotFound
+	}
+	p.Name, p.CourseID, p.Leaves, p.PerHours = in.Name, in.CourseID, in.Leaves, in.PerHours
+	if in.Active != nil {
+		p.Active = *in.Active
+	}
+	if err := validatePolicy(&p); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *LeaveQuotaService) DeletePolicy(id uint) error {
+	res := s.db.Delete(&models.LeaveQuotaPolicy{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLeaveQuotaNotFound
+	}
+	return nil
+}
+
+// policyFor picks the active policy of the course, else the active default policy
+func (s *LeaveQuotaService) policyFor(courseID uint) *models.LeaveQuotaPolicy {
+	var p models.LeaveQuotaPolicy
+	if err := s.db.Where("course_id = ? AND active = ?", courseID, true).Order("id DESC").First(&p).Error; err == nil {
+		return &p
+	}
+	if err := s.db.Where("course_id IS NULL AND active = ?", true).Order("id DESC").First(&p).Error; err == nil {
+		return &p
+	}
+	return nil
+}
+
+// enrolledHours - ชั่วโมงที่ซื้อสำหรับกลุ่ม/คอร์สนี้ (ledger) หรือ total_hours ของตารางเรียนกลุ่ม
+func (s *LeaveQuotaService) enrolledHours(studentID, groupID, courseID uint) float64 {
+	var purchased float64
+	s.db.Model(&models.LearningHourEntry{}).
+		Select("COALESCE(SUM(hours), 0)").
+		Where("student_id = ? AND entry_type = ? AND (group_id = ? OR (group_id IS NULL AND course_id = ?))", studentID, HoursPurchase, groupID, courseID).
+		Scan(&purchased)
+	if purchased > 0 {
+		return purchased
+	}
+	var schedule models.Schedules
+	if err := s.db.Where("group_id = ? AND status <> ?", groupID, "cancelled").Order("id DESC").First(&schedule).Error; err == nil {
+		return float64(schedule.Total_hours)
+	}
+	return 0
+}
+
+// EnsureQuota returns the student's quota for a group, creating it from the course policy on first use
+func (s *LeaveQuotaService) EnsureQuota(studentID, groupID uint) (*models.StudentLeaveQuota, error) {
+	var quota models.StudentLeaveQuota
+	err := s.db.Where("student_id = ? AND group_id = ?", studentID, groupID).First(&quota).Error
+	if err == nil {
+		return &quota, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var group models.Group
+	if err := s.db.Select("id", "course_id").First(&group, groupID).Error; err != nil {
+		return nil, ErrLeaveQuotaNotFound
+	}
+	courseID := group.CourseID
+	quota = models.StudentLeaveQuota{StudentID: studentID, GroupID: groupID, CourseID: &courseID}
+	if policy := s.policyFor(courseID); policy != nil {
+		quota.PolicyID = &policy.ID
+		quota.EnrolledHours = roundHours(s.enrolledHours(studentID, groupID, courseID))
+		quota.BaseQuota = LeavesForHours(*policy, quota.EnrolledHours)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&quota).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.LeaveQuotaEntry{
+			QuotaID:   quota.ID,
+			StudentID: studentID,
+			EntryType: LeaveQuotaGrant,
+			Amount:    quota.BaseQuota,
+			Notes:     fmt.Sprintf("%d leave(s) for %.2f enrolled hour(s)", quota.BaseQuota, quota.EnrolledHours),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// CheckAvailable verifies every listed student has at least one leave left in the group
+func (s *LeaveQuotaService) CheckAvailable(groupID uint, studentIDs []uint) error {
+	var exhausted []string
+	for _, id := range studentIDs {
+		quota, err := s.EnsureQuota(id, groupID)
+		if err != nil {
+			return err
+		}
+		if quotaView(*quota).Remaining <= 0 {
+			var student models.Student
+			s.db.First(&student, id)
+			exhausted = append(exhausted, studentDisplayName(student))
+		}
+	}
+	if len(exhausted) > 0 {
+		return fmt.Errorf("%w: %s", ErrLeaveQuotaExhausted, strings.Join(exhausted, ", "))
+	}
+	return nil
+}
+
+// UseForAbsence consumes one leave for every student covered by an approved absence.
+// It runs in the caller's transaction: each quota row is locked and must still have a leave left,
+// otherwise ErrLeaveQuotaExhausted is returned and the approval is rolled back.
+func (s *LeaveQuotaService) UseForAbsence(tx *gorm.DB, absence models.Absence, studentIDs []uint) error {
+	now := time.Now()
+	absenceID := absence.ID
+	var exhausted []string
+	for _, id := range studentIDs {
+		var quota models.StudentLeaveQuota
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("student_id = ? AND group_id = ?", id, absence.GroupID).
+			First(&quota).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrLeaveQuotaNotFound
+			}
+			return err
+		}
+		if quotaView(quota).Remaining <= 0 {
+			var student models.Student
+			tx.First(&student, id)
+			exhausted = append(exhausted, studentDisplayName(student))
+			continue
+		}
+		if err := tx.Model(&quota).Updates(map[string]interface{}{
+			"used_quota":   gorm.Expr("used_quota + 1"),
+			"last_used_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.LeaveQuotaEntry{
+			QuotaID:   quota.ID,
+			StudentID: id,
+			EntryType: LeaveQuotaUse,
+			Amount:    -1,
+			AbsenceID: &absenceID,
+			CreatedBy: absence.ApprovedBy,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	if len(exhausted) > 0 {
+		return fmt.Errorf("%w: %s", ErrLeaveQuotaExhausted, strings.Join(exhausted, ", "))
+	}
+	return nil
+}
+
+// Adjust adds (or removes) leaves manually
+func (s *LeaveQuotaService) Adjust(quotaID uint, amount int, notes string, adminID uint) (*StudentLeaveQuotaView, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("%w: amount must not be 0", ErrLeaveQuotaInvalid)
+	}
+	if strings.TrimSpace(notes) == "" {
+		return nil, fmt.Errorf("%w: notes are required", ErrLeaveQuotaInvalid)
+	}
+	var quota models.StudentLeaveQuota
+	if err := s.db.First(&quota, quotaID).Error; err != nil {
+		return nil, ErrLeaveQuotaNotFound
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&quota).Update("adjustment", gorm.Expr("adjustment + ?", amount)).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.LeaveQuotaEntry{
+			QuotaID:   quota.ID,
+			StudentID: quota.StudentID,
+			EntryType: LeaveQuotaAdjustment,
+			Amount:    amount,
+			Notes:     strings.TrimSpace(notes),
+			CreatedBy: &adminID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&quota, quotaID).Error; err != nil {
+		return nil, err
+	}
+	view := quotaView(quota)
+	return &view, nil
+}
+
+// ForStudent returns the student's quotas for every active enrollment (created on demand)
+func (s *LeaveQuotaService) ForStudent(studentID uint) ([]StudentLeaveQuotaView, error) {
+	var groupIDs []uint
+	if err := s.db.Model(&models.GroupMember{}).
+		Where("student_id = ? AND status = ?", studentID, "active").
+		Pluck("group_id", &groupIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, groupID := range groupIDs {
+		if _, err := s.EnsureQuota(studentID, groupID); err != nil && !errors.Is(err, ErrLeaveQuotaNotFound) {
+			return nil, err
+		}
+	}
+
+	var quotas []models.StudentLeaveQuota
+	if err := s.db.Preload("Group").Preload("Policy").
+		Where("student_id = ?", studentID).
+		Order("id DESC").
+		Find(&quotas).Error; err != nil {
+		return nil, err
+	}
+	views := make([]StudentLeaveQuotaView, 0, len(quotas))
+	for _, q := range quotas {
+		views = append(views, quotaView(q))
+	}
+	return views, nil
+}
+
+// Get returns one quota
+func (s *LeaveQuotaService) Get(quotaID uint) (*StudentLeaveQuotaView, error) {
+	var quota models.StudentLeaveQuota
+	if err := s.db.Preload("Group").Preload("Policy").First(&quota, quotaID).Error; err != nil {
+		return nil, ErrLeaveQuotaNotFound
+	}
+	view := quotaView(quota)
+	return &view, nil
+}
+
+// History returns the usage / adjustment history of a quota, newest first
+func (s *LeaveQuotaService) History(quotaID uint) ([]models.LeaveQuotaEntry, error) {
+	var entries []models.LeaveQuotaEntry
+	err := s.db.Where("quota_id = ?", quotaID).Order("created_at DESC, id DESC").Find(&entries).Error
+	return entries, err
+}
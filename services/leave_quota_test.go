@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+func TestLeavesForHours(t *testing.T) {
+	perTwenty := models.LeaveQuotaPolicy{Leaves: 2, PerHours: 20}
+	cases := []struct {
+		policy models.LeaveQuotaPolicy
+		hours  float64
+		want   int
+	}{
+		{perTwenty, 40, 4},
+		{perTwenty, 45.5, 4},
+		{perTwenty, 10, 2}, // a partial block still grants one block
+		{perTwenty, 0, 0},
+		{models.LeaveQuotaPolicy{Leaves: 3}, 0, 3}, // flat per enrollment
+		{models.LeaveQuotaPolicy{Leaves: 0, PerHours: 10}, 100, 0},
+	}
+	for _, tc := range cases {
+		if got := LeavesForHours(tc.policy, tc.hours); got != tc.want {
+			t.Errorf("LeavesForHours(%+v, %.1f) = %d, want %d", tc.policy, tc.hours, got, tc.want)
+		}
+	}
+}
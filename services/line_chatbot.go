@@ -0,0 +1,334 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"gorm.io/gorm"
+)
+
+// Postback actions of the student chatbot (data is a query string, e.g. "action=leave_pick&session=12")
+const (
+	chatbotActionLeavePick    = "leave_pick"
+	chatbotActionLeaveConfirm = "leave_confirm"
+	chatbotActionCancel       = "cancel"
+)
+
+// Number of upcoming sessions shown / offered for leave
+const chatbotUpcomingLimit = 5
+
+// LineChatbotService answers 1:1 chats with the LINE OA: next classes, hours, leave quota and leave requests
+type LineChatbotService struct {
+	db *gorm.DB
+}
+
+func NewLineChatbotService() *LineChatbotService {
+	return &LineChatbotService{db: database.DB}
+}
+
+// chatbotMenu is the quick reply shown under every answer
+func chatbotMenu() *linebot.QuickReplyItems {
+	return linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction("ตารางเรียน", "ตารางเรียน")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction("ชั่วโมงเรียน", "ชั่วโมงเรียน")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction("สิทธิ์ลา", "สิทธิ์ลา")),
+		linebot.NewQuickReplyButton("", linebot.NewMessageAction("แจ้งลา", "แจ้งลา")),
+	)
+}
+
+func chatbotText(text string) []linebot.SendingMessage {
+	return []linebot.SendingMessage{linebot.NewTextMessage(text).WithQuickReplies(chatbotMenu())}
+}
+
+// linkedUsers returns the users bound to the sender; falls back to a legacy line_id that holds their real userId
+func (s *LineChatbotService) linkedUsers(lineUserID string) []models.User {
+	if lineUserID == "" {
+		return nil
+	}
+	users, _ := NewLineLinkService().LinkedUsers(lineUserID)
+	if len(users) == 0 {
+		s.db.Preload("Student").Where("line_user_id IS NULL AND line_id = ? AND status = ?", lineUserID, "active").Find(&users)
+	}
+	return users
+}
+
+// linkedStudent finds the user (and student profile) linked to the sender. A parent may link several
+// accounts to one LINE; the most recently linked student account answers.
+func (s *LineChatbotService) linkedStudent(lineUserID string) (*models.User, *models.Student) {
+	users := s.linkedUsers(lineUserID)
+	for i := range users {
+		if users[i].Student != nil {
+			return &users[i], users[i].Student
+		}
+	}
+	if len(users) > 0 {
+		return &users[0], nil
+	}
+	return nil, nil
+}
+
+const chatbotNotLinked = "ยังไม่ได้เชื่อมบัญชีกับ LINE นี้\nขอรหัสเชื่อมบัญชี (LINE link code) ในเว็บแล้วส่งรหัสมาในแชตนี้ได้เลยค่ะ"
+
+const chatbotNotStudent = "บัญชีที่เชื่อมกับ LINE นี้ไม่ใช่บัญชีนักเรียน จะได้รับการแจ้งเตือนผ่าน LINE นี้ค่ะ"
+
+// linkReply redeems a link code sent in the chat
+func (s *LineChatbotService) linkReply(lineUserID, code string) []linebot.SendingMessage {
+	user, err := NewLineLinkService().Redeem(lineUserID, code)
+	if err != nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage("รหัสไม่ถูกต้องหรือหมดอายุแล้ว กรุณาขอรหัสใหม่ในเว็บค่ะ")}
+	}
+	return chatbotText(fmt.Sprintf("✅ เชื่อมบัญชี %s กับ LINE นี้เรียบร้อยแล้วค่ะ", user.Username))
+}
+
+// HandleText answers a text message from a LINE user (a link code binds the LINE account first)
+func (s *LineChatbotService) HandleText(lineUserID, text string) []linebot.SendingMessage {
+	if code, ok := NormalizeLineLinkCode(text); ok {
+		return s.linkReply(lineUserID, code)
+	}
+	user, student := s.linkedStudent(lineUserID)
+	if user == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage(chatbotNotLinked)}
+	}
+	if student == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage(chatbotNotStudent)}
+	}
+
+	t := strings.ToLower(strings.TrimSpace(text))
+	switch {
+	case strings.Contains(t, "แจ้งลา") || t == "leave":
+		return s.leaveMenu(*student)
+	case strings.Contains(t, "ตาราง") || strings.Contains(t, "schedule") || strings.Contains(t, "class"):
+		return s.upcomingReply(*student)
+	case strings.Contains(t, "ชั่วโมง") || strings.Contains(t, "hour"):
+		return s.hoursReply(*student)
+	case strings.Contains(t, "สิทธิ์") || strings.Contains(t, "quota"):
+		return s.quotaReply(*student)
+	}
+	return chatbotText(fmt.Sprintf("สวัสดีค่ะ %s 👋\nเลือกเมนูด้านล่างเพื่อดูตารางเรียน ชั่วโมงเรียนคงเหลือ สิทธิ์ลา หรือแจ้งลาเรียน", studentDisplayName(*student)))
+}
+
+// HandlePostback handles the postbacks of the leave flow and of the teacher confirmation buttons
+func (s *LineChatbotService) HandlePostback(lineUserID, data string) []linebot.SendingMessage {
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return chatbotText("ไม่เข้าใจคำสั่ง กรุณาเลือกเมนูอีกครั้ง")
+	}
+	if isTeacherPostback(values.Get("action")) {
+		return s.teacherPostbackReply(lineUserID, values)
+	}
+
+	user, student := s.linkedStudent(lineUserID)
+	if user == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage(chatbotNotLinked)}
+	}
+	if student == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage(chatbotNotStudent)}
+	}
+	sessionID, _ := strconv.ParseUint(values.Get("session"), 10, 32)
+
+	switch values.Get("action") {
+	case chatbotActionLeavePick:
+		return s.leaveAssessReply(*user, *student, uint(sessionID))
+	case chatbotActionLeaveConfirm:
+		return s.leaveSubmitReply(*user, *student, uint(sessionID), values.Get("late") == "1")
+	case chatbotActionCancel:
+		return chatbotText("ยกเลิกแล้วค่ะ")
+	}
+	return chatbotText("ไม่เข้าใจคำสั่ง กรุณาเลือกเมนูอีกครั้ง")
+}
+
+// upcomingSessions returns the student's next sessions in their active groups
+func (s *LineChatbotService) upcomingSessions(studentID uint, limit int) ([]models.Schedule_Sessions, error) {
+	var sessions []models.Schedule_Sessions
+	err := s.db.Preload("Schedule").
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id AND schedules.deleted_at IS NULL").
+		Joins("JOIN group_members ON group_members.group_id = schedules.group_id AND group_members.deleted_at IS NULL").
+		Where("group_members.student_id = ? AND group_members.status = ?", studentID, "active").
+		Where("schedule_sessions.start_time > ?", time.Now()).
+		Where("schedule_sessions.status NOT IN ?", []string{"cancelled", "rescheduled", "no-show"}).
+		Where("schedules.status NOT IN ?", []string{"cancelled"}).
+		Order("schedule_sessions.start_time ASC").
+		Limit(limit).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func chatbotSessionLabel(session models.Schedule_Sessions) string {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	when := ""
+	if session.Start_time != nil {
+		when = session.Start_time.In(loc).Format("02/01 15:04")
+	}
+	name := ""
+	if session.Schedule != nil {
+		name = session.Schedule.ScheduleName
+	}
+	return strings.TrimSpace(when + " " + name)
+}
+
+func (s *LineChatbotService) upcomingReply(student models.Student) []linebot.SendingMessage {
+	sessions, err := s.upcomingSessions(student.ID, chatbotUpcomingLimit)
+	if err != nil {
+		return chatbotText("ไม่สามารถโหลดตารางเรียนได้ กรุณาลองใหม่อีกครั้ง")
+	}
+	if len(sessions) == 0 {
+		return chatbotText("ยังไม่มีคาบเรียนที่กำลังจะถึงค่ะ")
+	}
+	lines := []string{"📅 คาบเรียนถัดไป"}
+	for i, session := range sessions {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, chatbotSessionLabel(session)))
+	}
+	return chatbotText(strings.Join(lines, "\n"))
+}
+
+func (s *LineChatbotService) hoursReply(student models.Student) []linebot.SendingMessage {
+	balance, err := NewLearningHoursService().Balance(student.ID)
+	if err != nil {
+		return chatbotText("ไม่สามารถโหลดชั่วโมงเรียนได้ กรุณาลองใหม่อีกครั้ง")
+	}
+	if !balance.Tracked {
+		return chatbotText("ยังไม่มีข้อมูลการซื้อชั่วโมงเรียนค่ะ")
+	}
+	return chatbotText(fmt.Sprintf("⏱ ชั่วโมงเรียนคงเหลือ %.2f ชม.\nซื้อแล้ว %.2f ชม. ใช้ไป %.2f ชม.", balance.Balance, balance.Purchased, balance.Consumed))
+}
+
+func (s *LineChatbotService) quotaReply(student models.Student) []linebot.SendingMessage {
+	quotas, err := NewLeaveQuotaService().ForStudent(student.ID)
+	if err != nil {
+		return chatbotText("ไม่สามารถโหลดสิทธิ์ลาได้ กรุณาลองใหม่อีกครั้ง")
+	}
+	if len(quotas) == 0 {
+		return chatbotText("ยังไม่มีสิทธิ์ลาค่ะ")
+	}
+	lines := []string{"📝 สิทธิ์ลาคงเหลือ"}
+	for _, q := range quotas {
+		name := fmt.Sprintf("กลุ่ม #%d", q.GroupID)
+		if q.Group != nil {
+			name = q.Group.GroupName
+		}
+		lines = append(lines, fmt.Sprintf("• %s: %d/%d ครั้ง", name, q.Remaining, q.TotalQuota))
+	}
+	return chatbotText(strings.Join(lines, "\n"))
+}
+
+// leaveMenu offers the upcoming sessions as postback buttons
+func (s *LineChatbotService) leaveMenu(student models.Student) []linebot.SendingMessage {
+	sessions, err := s.upcomingSessions(student.ID, chatbotUpcomingLimit)
+	if err != nil || len(sessions) == 0 {
+		return chatbotText("ไม่มีคาบเรียนที่สามารถแจ้งลาได้ค่ะ")
+	}
+	buttons := make([]*linebot.QuickReplyButton, 0, len(sessions)+1)
+	lines := []string{"เลือกคาบเรียนที่ต้องการแจ้งลา"}
+	for i, session := range sessions {
+		label := chatbotSessionLabel(session)
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, label))
+		data := fmt.Sprintf("action=%s&session=%d", chatbotActionLeavePick, session.ID)
+		// LINE จำกัด label ไม่เกิน 20 ตัวอักษร
+		buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(truncateRunes(label, 20), data, "", "ลา "+label)))
+	}
+	buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction("ยกเลิก", "action="+chatbotActionCancel, "", "ยกเลิก")))
+	return []linebot.SendingMessage{linebot.NewTextMessage(strings.Join(lines, "\n")).WithQuickReplies(linebot.NewQuickReplyItems(buttons...))}
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// chatbotSession loads a session and its group (membership is checked by AssessLeave)
+func (s *LineChatbotService) chatbotSession(sessionID uint) (*models.Schedule_Sessions, uint, error) {
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil || session.Schedule == nil || session.Schedule.GroupID == nil {
+		return nil, 0, fmt.Errorf("ไม่พบคาบเรียน")
+	}
+	return &session, *session.Schedule.GroupID, nil
+}
+
+// alreadyOnLeave reports whether the student (or the whole group) already has a non-rejected leave for the session
+func (s *LineChatbotService) alreadyOnLeave(studentID, sessionID uint) bool {
+	onLeave, _ := alreadyOnLeave(s.db, sessionID, &studentID)
+	return onLeave
+}
+
+func (s *LineChatbotService) leaveAssessReply(user models.User, student models.Student, sessionID uint) []linebot.SendingMessage {
+	session, groupID, err := s.chatbotSession(sessionID)
+	if err != nil {
+		return chatbotText(err.Error())
+	}
+	if s.alreadyOnLeave(student.ID, session.ID) {
+		return chatbotText("แจ้งลาคาบนี้ไว้แล้วค่ะ")
+	}
+	studentID := student.ID
+	assessment, _, err := AssessLeave(groupID, session.ID, user.ID, &studentID)
+	if err != nil {
+		return chatbotText(err.Error())
+	}
+
+	late := "0"
+	if assessment.IsLate {
+		late = "1"
+	}
+	data := fmt.Sprintf("action=%s&session=%d&late=%s", chatbotActionLeaveConfirm, session.ID, late)
+	text := fmt.Sprintf("แจ้งลา %s\n%s\nยืนยันการแจ้งลาหรือไม่?", chatbotSessionLabel(*session), assessment.MessageTh)
+	return []linebot.SendingMessage{linebot.NewTextMessage(text).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("ยืนยันลา", data, "", "ยืนยันลา")),
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("ยกเลิก", "action="+chatbotActionCancel, "", "ยกเลิก")),
+	))}
+}
+
+func (s *LineChatbotService) leaveSubmitReply(user models.User, student models.Student, sessionID uint, confirmLate bool) []linebot.SendingMessage {
+	session, groupID, err := s.chatbotSession(sessionID)
+	if err != nil {
+		return chatbotText(err.Error())
+	}
+	// กดยืนยันซ้ำ (หรือ LINE ส่ง postback ซ้ำ) ไม่สร้างการลาซ้ำ
+	if s.alreadyOnLeave(student.ID, session.ID) {
+		return chatbotText("แจ้งลาคาบนี้ไว้แล้วค่ะ")
+	}
+	studentID := student.ID
+	absence, assessment, err := CreateAbsence(AbsenceRequest{
+		GroupID:     groupID,
+		SessionID:   session.ID,
+		StudentID:   &studentID,
+		Reason:      "แจ้งลาผ่าน LINE",
+		ConfirmLate: confirmLate,
+	}, user.ID)
+	if errors.Is(err, ErrAlreadyOnLeave) {
+		return chatbotText("แจ้งลาคาบนี้ไว้แล้วค่ะ")
+	}
+	if errors.Is(err, ErrLateLeaveNotConfirmed) {
+		// เลยเวลา cutoff ระหว่างที่รอกดยืนยัน: ให้ยืนยันลาช้าอีกครั้ง
+		return s.leaveAssessReply(user, student, session.ID)
+	}
+	if err != nil {
+		return chatbotText("แจ้งลาไม่สำเร็จ: " + err.Error())
+	}
+	if absence.IsLate {
+		return chatbotText(fmt.Sprintf("รับแจ้งลา %s แล้ว (ลาช้า หักชั่วโมงเรียน %.2f ชม.)", chatbotSessionLabel(*session), assessment.HoursDeducted))
+	}
+	return chatbotText(fmt.Sprintf("ส่งคำขอลา %s แล้ว รอเจ้าหน้าที่อนุมัติค่ะ", chatbotSessionLabel(*session)))
+}
+
+// HandleFollow greets a user that added (or unblocked) the OA
+func (s *LineChatbotService) HandleFollow(lineUserID string) []linebot.SendingMessage {
+	if err := NewLineLinkService().MarkFollowed(lineUserID); err != nil {
+		log.Printf("mark LINE follow %s: %v", lineUserID, err)
+	}
+	user, _ := s.linkedStudent(lineUserID)
+	if user == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage("ยินดีต้อนรับค่ะ 🙏\n" + chatbotNotLinked)}
+	}
+	return chatbotText(fmt.Sprintf("ยินดีต้อนรับกลับค่ะ %s 🙏", user.Username))
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+// LINE link status of a user
+const (
+	LineLinkLinked      = "linked"
+	LineLinkUnreachable = "unreachable" // linked but the user blocked / unfollowed the OA
+	LineLinkUnlinked    = "unlinked"
+)
+
+const (
+	lineLinkCodeTTL    = 10 * time.Minute
+	lineLinkCodePrefix = "EK"
+	lineLinkCodeLength = 8
+	// ไม่มี 0/O/1/I เพื่อให้พิมพ์ตามได้ง่าย
+	lineLinkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrLineLinkCodeInvalid = errors.New("link code is invalid or expired")
+	ErrLineLinkNotLinked   = errors.New("LINE account is not linked")
+
+	lineLinkCodePattern = regexp.MustCompile(`^` + lineLinkCodePrefix + `[` + lineLinkCodeAlphabet + `]{` + strconv.Itoa(lineLinkCodeLength) + `}$`)
+	// LINE userId: "U" + 32 hex
+	lineUserIDPattern = regexp.MustCompile(`^U[0-9a-f]{32}$`)
+)
+
+// LineLinkStatus describes a user's LINE link
+type LineLinkStatus struct {
+	UserID            uint       `json:"user_id"`
+	Status            string     `json:"status"`
+	LinkedAt          *time.Time `json:"linked_at"`
+	UnfollowedAt      *time.Time `json:"unfollowed_at"`
+	LegacyLineID      string     `json:"legacy_line_id,omitempty"`
+	LegacyDeliverable bool       `json:"legacy_deliverable"` // legacy line_id is a real LINE userId
+}
+
+// LineLinkStatusOf reports the link status of a user
+func LineLinkStatusOf(u models.User) LineLinkStatus {
+	st := LineLinkStatus{
+		UserID:            u.ID,
+		Status:            LineLinkUnlinked,
+		LinkedAt:          u.LineLinkedAt,
+		UnfollowedAt:      u.LineUnfollowedAt,
+		LegacyLineID:      u.LineID,
+		LegacyDeliverable: lineUserIDPattern.MatchString(u.LineID),
+	}
+	if u.LineUserID != nil && *u.LineUserID != "" {
+		st.Status = LineLinkLinked
+		if u.LineUnfollowedAt != nil {
+			st.Status = LineLinkUnreachable
+		}
+	}
+	return st
+}
+
+// LineRecipient returns the LINE userId to push to: the verified link (empty when unreachable),
+// else the legacy free-text line_id only when it is a real LINE userId
+func LineRecipient(u models.User) string {
+	if u.LineUserID != nil && *u.LineUserID != "" {
+		if u.LineUnfollowedAt != nil {
+			return ""
+		}
+		return *u.LineUserID
+	}
+	if lineUserIDPattern.MatchString(u.LineID) {
+		return u.LineID
+	}
+	return ""
+}
+
+// NormalizeLineLinkCode extracts a link code from a chat message ("ek-7h3k q2pa" -> "EK7H3KQ2PA")
+func NormalizeLineLinkCode(text string) (string, bool) {
+	code := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\n", "").Replace(strings.TrimSpace(text)))
+	if !lineLinkCodePattern.MatchString(code) {
+		return "", false
+	}
+	return code, true
+}
+
+// LineLinkService binds LINE accounts to users through one-time codes
+type LineLinkService struct {
+	db *gorm.DB
+}
+
+func NewLineLinkService() *LineLinkService {
+	return &LineLinkService{db: database.DB}
+}
+
+func generateLineLinkCode() (string, error) {
+	b := make([]byte, lineLinkCodeLength)
+	size := big.NewInt(int64(len(lineLinkCodeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = lineLinkCodeAlphabet[n.Int64()]
+	}
+	return lineLinkCodePrefix + string(b), nil
+}
+
+// IssueCode creates a fresh code for the user; older unused codes stop working
+func (s *LineLinkService) IssueCode(userID uint) (*models.LineLinkCode, error) {
+	code, err := generateLineLinkCode()
+	if err != nil {
+		return nil, err
+	}
+	link := models.LineLinkCode{UserID: userID, Code: code, ExpiresAt: time.Now().Add(lineLinkCodeTTL)}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&models.LineLinkCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&link).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Redeem binds the sender's LINE userId to the owner of the code
+func (s *LineLinkService) Redeem(lineUserID, code string) (*models.User, error) {
+	if lineUserID == "" {
+		return nil, ErrLineLinkCodeInvalid
+	}
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var link models.LineLinkCode
+		if err := tx.Where("code = ? AND used_at IS NULL AND expires_at > ?", code, time.Now()).First(&link).Error; err != nil {
+			return ErrLineLinkCodeInvalid
+		}
+		now := time.Now()
+		// ใช้ได้ครั้งเดียว: ถ้ามีคนใช้ไปพร้อมกันแล้ว RowsAffected = 0
+		res := tx.Model(&models.LineLinkCode{}).
+			Where("id = ? AND used_at IS NULL", link.ID).
+			Updates(map[string]interface{}{"used_at": now, "line_user_id": lineUserID})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrLineLinkCodeInvalid
+		}
+		if err := tx.First(&user, link.UserID).Error; err != nil {
+			return ErrLineLinkCodeInvalid
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"line_user_id":       lineUserID,
+			"line_linked_at":     now,
+			"line_unfollowed_at": nil,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Unlink removes the verified LINE account of a user
+func (s *LineLinkService) Unlink(userID uint) error {
+	res := s.db.Model(&models.User{}).
+		Where("id = ? AND line_user_id IS NOT NULL", userID).
+		Updates(map[string]interface{}{"line_user_id": nil, "line_linked_at": nil, "line_unfollowed_at": nil})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLineLinkNotLinked
+	}
+	return nil
+}
+
+// MarkFollowed clears the unreachable flag when a linked LINE user follows / unblocks the OA
+func (s *LineLinkService) MarkFollowed(lineUserID string) error {
+	return s.db.Model(&models.User{}).
+		Where("line_user_id = ? AND line_unfollowed_at IS NOT NULL", lineUserID).
+		Update("line_unfollowed_at", nil).Error
+}
+
+// MarkUnfollowed flags every user linked to the LINE account as unreachable
+func (s *LineLinkService) MarkUnfollowed(lineUserID string) error {
+	return s.db.Model(&models.User{}).
+		Where("line_user_id = ? AND line_unfollowed_at IS NULL", lineUserID).
+		Update("line_unfollowed_at", time.Now()).Error
+}
+
+// LinkedUsers returns the active users bound to a LINE account, most recently linked first
+func (s *LineLinkService) LinkedUsers(lineUserID string) ([]models.User, error) {
+	var users []models.User
+	err := s.db.Preload("Student").
+		Where("line_user_id = ? AND status = ?", lineUserID, "active").
+		Order("line_linked_at DESC").
+		Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestNormalizeLineLinkCode(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"EK7H3KQ2PA", "EK7H3KQ2PA", true},
+		{" ek-7h3k q2pa ", "EK7H3KQ2PA", true},
+		{"EK7H3KQ2P", "", false},  // too short
+		{"EK7H3KQ2P0", "", false}, // 0 is not in the alphabet
+		{"ตารางเรียน", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := NormalizeLineLinkCode(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("NormalizeLineLinkCode(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	code, err := generateLineLinkCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := NormalizeLineLinkCode(code); !ok || got != code {
+		t.Errorf("generated code %q does not round-trip", code)
+	}
+}
+
+func TestLineRecipient(t *testing.T) {
+	verified := "U0123456789abcdef0123456789abcdef"
+	now := time.Now()
+	cases := []struct {
+		name string
+		user models.User
+		want string
+	}{
+		{"verified", models.User{LineUserID: &verified, LineID: "somebody"}, verified},
+		{"unfollowed", models.User{LineUserID: &verified, LineUnfollowedAt: &now}, ""},
+		{"legacy userId", models.User{LineID: verified}, verified},
+		{"legacy handle", models.User{LineID: "@somebody"}, ""},
+	}
+	for _, tc := range cases {
+		if got := LineRecipient(tc.user); got != tc.want {
+			t.Errorf("%s: LineRecipient = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -58,3 +58,18 @@ func (s *LineMessagingService) SendLineMessageToUser(userLineID string, message
 	}
 	return nil
 }
+
+// PushMessages ส่งข้อความแบบใดก็ได้ (template / flex / quick reply) ไปยัง userId หรือ groupId
+func (s *LineMessagingService) PushMessages(to string, messages ...linebot.SendingMessage) error {
+	if s.Bot == nil {
+		return fmt.Errorf("LINE Bot client is not initialized")
+	}
+	if to == "" {
+		return fmt.Errorf("empty LINE recipient")
+	}
+	_, err := s.Bot.PushMessage(to, messages...).Do()
+	if err != nil {
+		return fmt.Errorf("LINE Messaging API failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"gorm.io/gorm"
+)
+
+func init() {
+	notifsvc.RegisterDeliverer(notifsvc.ChannelLine, &lineDeliverer{})
+}
+
+// AnnouncementLineMessage renders the "announcement" LINE template (Flex with text fallback);
+// a missing language falls back to the other one
+func AnnouncementLineMessage(titleEn, titleTh, msgEn, msgTh string) linebot.SendingMessage {
+	pick := func(v, other string) string {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+		if strings.TrimSpace(other) != "" {
+			return other
+		}
+		return "-"
+	}
+	rendered, err := NewLineTemplateService().Render(LineTemplateAnnouncement, map[string]string{
+		"title":      pick(titleEn, titleTh),
+		"title_th":   pick(titleTh, titleEn),
+		"message":    pick(msgEn, msgTh),
+		"message_th": pick(msgTh, msgEn),
+	})
+	if err != nil {
+		return linebot.NewTextMessage(titleTh + "\n" + msgTh + "\n\n" + titleEn + "\n" + msgEn)
+	}
+	return rendered.Message()
+}
+
+// lineDeliverer is the "line" notification channel: pushes to the user's linked LINE account
+type lineDeliverer struct {
+	once sync.Once
+	svc  *LineMessagingService
+}
+
+func (d *lineDeliverer) client() *LineMessagingService {
+	d.once.Do(func() { d.svc = NewLineMessagingService() })
+	return d.svc
+}
+
+func (d *lineDeliverer) Recipient(user models.User, _ models.UserSettings) (string, string) {
+	if os.Getenv("LINE_CHANNEL_SECRET") == "" || os.Getenv("LINE_CHANNEL_ACCESS_TOKEN") == "" {
+		return "", "LINE is not configured"
+	}
+	if to := LineRecipient(user); to != "" {
+		return to, ""
+	}
+	if user.LineUserID != nil && user.LineUnfollowedAt != nil {
+		return "", "user blocked the LINE OA"
+	}
+	return "", "user has no linked LINE account"
+}
+
+func (d *lineDeliverer) Send(to string, n models.Notification, _ models.UserSettings) (string, error) {
+	svc := d.client()
+	if svc.Bot == nil {
+		return "", fmt.Errorf("%w: LINE Bot client is not initialized", notifsvc.ErrDeliverySkipped)
+	}
+	msg, err := lineNotificationMessage(n)
+	if err != nil {
+		return "", err
+	}
+	res, err := svc.Bot.PushMessage(to, msg).Do()
+	if err != nil {
+		// 4xx (ยกเว้น 429 rate limit) = ผู้รับ/ข้อความไม่ถูกต้อง ส่งซ้ำก็ไม่ผ่าน
+		var apiErr *linebot.APIError
+		if errors.As(err, &apiErr) && apiErr.Code >= 400 && apiErr.Code < 500 && apiErr.Code != 429 {
+			return "", fmt.Errorf("%w: %v", notifsvc.ErrDeliveryPermanent, err)
+		}
+		return "", err
+	}
+	return res.RequestID, nil
+}
+
+// lineNotificationMessage renders a notification for LINE: the teacher confirm card for
+// "confirm-session" notifications (so the teacher can answer from LINE), else the announcement template
+func lineNotificationMessage(n models.Notification) (linebot.SendingMessage, error) {
+	var data struct {
+		Action    string `json:"action"`
+		SessionID uint   `json:"session_id"`
+	}
+	if len(n.Data) > 0 {
+		_ = json.Unmarshal(n.Data, &data)
+	}
+	if data.Action == "confirm-session" && data.SessionID != 0 {
+		var session models.Schedule_Sessions
+		err := database.DB.Preload("Schedule").First(&session, data.SessionID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && session.Schedule == nil) {
+			return nil, fmt.Errorf("%w: session %d no longer exists", notifsvc.ErrDeliverySkipped, data.SessionID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return TeacherConfirmLineMessage(session, *session.Schedule), nil
+	}
+	return AnnouncementLineMessage(n.Title, n.TitleTh, n.Message, n.MessageTh), nil
+}
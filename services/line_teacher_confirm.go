@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"englishkorat_go/models"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// Postback actions of the teacher confirmation buttons
+const (
+	chatbotActionSessionConfirm = "session_confirm"
+	chatbotActionSessionDecline = "session_decline"
+)
+
+// Preset decline reasons offered as quick replies (LINE postbacks cannot carry free text)
+var teacherDeclineReasons = []string{"ไม่สบาย", "ติดธุระ", "ตารางสอนชน", "เหตุฉุกเฉิน"}
+
+func teacherSessionLabel(session models.Schedule_Sessions, schedule models.Schedules) string {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	when := ""
+	if session.Start_time != nil {
+		when = session.Start_time.In(loc).Format("02/01/2006 15:04")
+	}
+	return fmt.Sprintf("'%s' เวลา %s", schedule.ScheduleName, when)
+}
+
+// TeacherConfirmLineMessage is the reminder with Confirm / Decline postback buttons
+func TeacherConfirmLineMessage(session models.Schedule_Sessions, schedule models.Schedules) linebot.SendingMessage {
+	text := truncateRunes("กรุณายืนยันคาบสอน "+teacherSessionLabel(session, schedule), 240)
+	return linebot.NewTemplateMessage(text, linebot.NewConfirmTemplate(text,
+		linebot.NewPostbackAction("ยืนยัน", fmt.Sprintf("action=%s&session=%d", chatbotActionSessionConfirm, session.ID), "", "ยืนยันคาบสอน"),
+		linebot.NewPostbackAction("ปฏิเสธ", fmt.Sprintf("action=%s&session=%d", chatbotActionSessionDecline, session.ID), "", "ปฏิเสธคาบสอน"),
+	))
+}
+
+// isTeacherPostback reports whether the postback belongs to the teacher confirmation flow
+func isTeacherPostback(action string) bool {
+	return action == chatbotActionSessionConfirm || action == chatbotActionSessionDecline
+}
+
+// teacherPostbackReply confirms / declines a session for the teacher behind the LINE account.
+// Respond is idempotent, so redelivered or double-tapped postbacks do not change anything twice.
+func (s *LineChatbotService) teacherPostbackReply(lineUserID string, values url.Values) []linebot.SendingMessage {
+	sessionID, _ := strconv.ParseUint(values.Get("session"), 10, 32)
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil || session.Schedule == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage("ไม่พบคาบสอนนี้แล้วค่ะ")}
+	}
+	teacherID := ResponsibleTeacherID(session, session.Schedule)
+
+	// LINE เดียวอาจผูกหลายบัญชี: ใช้บัญชีที่เป็นครูของคาบนี้
+	users := s.linkedUsers(lineUserID)
+	var teacher *models.User
+	for i := range users {
+		if teacherID != nil && users[i].ID == *teacherID {
+			teacher = &users[i]
+			break
+		}
+	}
+	if len(users) == 0 {
+		return []linebot.SendingMessage{linebot.NewTextMessage(chatbotNotLinked)}
+	}
+	if teacher == nil {
+		return []linebot.SendingMessage{linebot.NewTextMessage("คุณไม่ใช่ครูผู้สอนของคาบนี้แล้วค่ะ")}
+	}
+
+	label := teacherSessionLabel(session, *session.Schedule)
+	accept := values.Get("action") == chatbotActionSessionConfirm
+	reason := values.Get("reason")
+	if !accept && reason == "" {
+		buttons := make([]*linebot.QuickReplyButton, 0, len(teacherDeclineReasons))
+		for _, r := range teacherDeclineReasons {
+			data := fmt.Sprintf("action=%s&session=%d&reason=%s", chatbotActionSessionDecline, session.ID, url.QueryEscape(r))
+			buttons = append(buttons, linebot.NewQuickReplyButton("", linebot.NewPostbackAction(r, data, "", "ปฏิเสธ: "+r)))
+		}
+		return []linebot.SendingMessage{linebot.NewTextMessage("เลือกเหตุผลที่ไม่สามารถสอนคาบ " + label).
+			WithQuickReplies(linebot.NewQuickReplyItems(buttons...))}
+	}
+
+	result, err := NewTeacherConfirmationService().Respond(session.ID, teacher.ID, accept, reason)
+	switch {
+	case errors.Is(err, ErrSessionNotConfirmable):
+		return []linebot.SendingMessage{linebot.NewTextMessage("คาบสอนนี้ยืนยัน/ปฏิเสธไม่ได้แล้วค่ะ")}
+	case errors.Is(err, ErrNotSessionTeacher):
+		return []linebot.SendingMessage{linebot.NewTextMessage("คุณไม่ใช่ครูผู้สอนของคาบนี้แล้วค่ะ")}
+	case err != nil:
+		return []linebot.SendingMessage{linebot.NewTextMessage("บันทึกไม่สำเร็จ กรุณาลองใหม่หรือยืนยันผ่านเว็บค่ะ")}
+	}
+
+	if !result.Changed {
+		return []linebot.SendingMessage{linebot.NewTextMessage("บันทึกคำตอบของคาบ " + label + " ไว้แล้วค่ะ")}
+	}
+	if accept {
+		return []linebot.SendingMessage{linebot.NewTextMessage("✅ ยืนยันคาบสอน " + label + " แล้ว ขอบคุณค่ะ")}
+	}
+	return []linebot.SendingMessage{linebot.NewTextMessage("แจ้งปฏิเสธคาบสอน " + label + " แล้ว เจ้าหน้าที่จะจัดครูแทนค่ะ")}
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"englishkorat_go/config"
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"gorm.io/gorm"
+)
+
+// LINE message template keys
+const (
+	LineTemplateDailyClassReminder = "daily_class_reminder"
+	LineTemplateAnnouncement       = "announcement"
+)
+
+// DefaultAbsenceLink is where students file a leave (daily reminder) when ABSENCE_LINK is not set
+const DefaultAbsenceLink = "https://www.englishkorat.site/students/absence"
+
+// AbsenceLink returns the configured leave form URL (ABSENCE_LINK), else DefaultAbsenceLink
+func AbsenceLink() string {
+	if config.AppConfig != nil && strings.TrimSpace(config.AppConfig.AbsenceLink) != "" {
+		return strings.TrimSpace(config.AppConfig.AbsenceLink)
+	}
+	return DefaultAbsenceLink
+}
+
+var (
+	ErrLineTemplateNotFound = errors.New("LINE template not found")
+	ErrLineTemplateInvalid  = errors.New("invalid LINE template")
+
+	lineTemplateVarPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+)
+
+// LineTemplateDefinition is a built-in template: its variables, sample values and default content
+type LineTemplateDefinition struct {
+	Key          string            `json:"key"`
+	Name         string            `json:"name"`
+	Variables    []string          `json:"variables"`
+	Sample       map[string]string `json:"sample"`
+	AltText      string            `json:"-"`
+	FlexJSON     string            `json:"-"`
+	TextFallback string            `json:"-"`
+}
+
+var lineTemplateDefinitions = map[string]LineTemplateDefinition{
+	LineTemplateDailyClassReminder: {
+		Key:  LineTemplateDailyClassReminder,
+		Name: "Daily class reminder (LINE group)",
+		Variables: []string{
+			"group_name", "branch_name", "schedule_name", "date", "start_time", "end_time",
+			"teacher_name", "room_name", "leave_cutoff", "absence_link",
+		},
+		Sample: map[string]string{
+			"group_name": "Kids A1 เสาร์เช้า", "branch_name": "สาขาโคราช", "schedule_name": "Kids A1 (Sat)",
+			"date": "18/10/2026", "start_time": "09:00", "end_time": "11:00", "teacher_name": "T.John",
+			"room_name": "Room 3", "leave_cutoff": "18.00", "absence_link": DefaultAbsenceLink,
+		},
+		AltText: "📢 พรุ่งนี้มีเรียน {{group_name}} เวลา {{start_time}} - {{end_time}}",
+		FlexJSON: `{
+  "type": "bubble",
+  "header": {"type": "box", "layout": "vertical", "backgroundColor": "#1E3A8A", "contents": [
+    {"type": "text", "text": "📢 แจ้งเตือนตารางเรียนพรุ่งนี้", "color": "#FFFFFF", "weight": "bold"},
+    {"type": "text", "text": "{{date}}", "color": "#DBEAFE", "size": "sm"}
+  ]},
+  "body": {"type": "box", "layout": "vertical", "spacing": "sm", "contents": [
+    {"type": "text", "text": "{{group_name}}", "weight": "bold", "size": "lg", "wrap": true},
+    {"type": "box", "layout": "baseline", "contents": [
+      {"type": "text", "text": "สาขา", "color": "#6B7280", "size": "sm", "flex": 2},
+      {"type": "text", "text": "{{branch_name}}", "size": "sm", "flex": 5, "wrap": true}]},
+    {"type": "box", "layout": "baseline", "contents": [
+      {"type": "text", "text": "คลาส", "color": "#6B7280", "size": "sm", "flex": 2},
+      {"type": "text", "text": "{{schedule_name}}", "size": "sm", "flex": 5, "wrap": true}]},
+    {"type": "box", "layout": "baseline", "contents": [
+      {"type": "text", "text": "เวลา", "color": "#6B7280", "size": "sm", "flex": 2},
+      {"type": "text", "text": "{{start_time}} - {{end_time}}", "size": "sm", "flex": 5}]},
+    {"type": "box", "layout": "baseline", "contents": [
+      {"type": "text", "text": "ครู", "color": "#6B7280", "size": "sm", "flex": 2},
+      {"type": "text", "text": "{{teacher_name}}", "size": "sm", "flex": 5}]},
+    {"type": "box", "layout": "baseline", "contents": [
+      {"type": "text", "text": "ห้องเรียน", "color": "#6B7280", "size": "sm", "flex": 2},
+      {"type": "text", "text": "{{room_name}}", "size": "sm", "flex": 5}]},
+    {"type": "separator", "margin": "md"},
+    {"type": "text", "text": "แจ้งลาล่วงหน้าก่อนวันเรียน ภายใน {{leave_cutoff}} น. หากแจ้งหลังจากนี้ ระบบจะหักชั่วโมงเรียนอัตโนมัติ", "size": "xs", "color": "#6B7280", "wrap": true, "margin": "md"}
+  ]},
+  "footer": {"type": "box", "layout": "vertical", "contents": [
+    {"type": "button", "style": "primary", "action": {"type": "uri", "label": "แจ้งลา", "uri": "{{absence_link}}"}}
+  ]}
+}`,
+		TextFallback: "📢 แจ้งเตือนตารางเรียนพรุ่งนี้\nกลุ่ม: {{group_name}}\nสาขา: {{branch_name}}\nคลาส: {{schedule_name}}\nเวลา: {{start_time}} - {{end_time}}\nครู: {{teacher_name}}\nห้องเรียน: {{room_name}}\nกรณีแจ้งลา กรุณาแจ้งลาผ่านระบบล่วงหน้าก่อนวันเรียน และภายใน {{leave_cutoff}} น.\nหากแจ้งหลังจากนี้ ระบบจะหักชั่วโมงเรียนอัตโนมัติ\nแจ้งลาที่นี่: {{absence_link}}",
+	},
+	LineTemplateAnnouncement: {
+		Key:       LineTemplateAnnouncement,
+		Name:      "Announcement (notifications sent with the line channel)",
+		Variables: []string{"title", "title_th", "message", "message_th"},
+		Sample: map[string]string{
+			"title": "Holiday notice", "title_th": "ประกาศวันหยุด",
+			"message": "The school is closed on Monday.", "message_th": "โรงเรียนปิดทำการวันจันทร์",
+		},
+		AltText: "{{title_th}} {{title}}",
+		FlexJSON: `{
+  "type": "bubble",
+  "body": {"type": "box", "layout": "vertical", "spacing": "md", "contents": [
+    {"type": "text", "text": "{{title_th}}", "weight": "bold", "size": "lg", "wrap": true},
+    {"type": "text", "text": "{{message_th}}", "size": "sm", "wrap": true},
+    {"type": "separator"},
+    {"type": "text", "text": "{{title}}", "weight": "bold", "size": "md", "wrap": true, "color": "#374151"},
+    {"type": "text", "text": "{{message}}", "size": "sm", "wrap": true, "color": "#374151"}
+  ]}
+}`,
+		TextFallback: "{{title_th}}\n{{message_th}}\n\n{{title}}\n{{message}}",
+	},
+}
+
+// LineTemplateDefinitions lists the built-in templates (sorted by key)
+func LineTemplateDefinitions() []LineTemplateDefinition {
+	defs := make([]LineTemplateDefinition, 0, len(lineTemplateDefinitions))
+	for _, d := range lineTemplateDefinitions {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+	return defs
+}
+
+// RenderedLineMessage is a rendered template: the Flex message (nil when text only) and the text version
+type RenderedLineMessage struct {
+	AltText  string          `json:"alt_text"`
+	Flex     json.RawMessage `json:"flex,omitempty"`
+	Text     string          `json:"text"`
+	FlexErr  string          `json:"flex_error,omitempty"` // why the Flex part falls back to text
+	flexCont linebot.FlexContainer
+}
+
+// Message returns the Flex message, or the text fallback when the template has no (valid) Flex
+func (r *RenderedLineMessage) Message() linebot.SendingMessage {
+	if r.flexCont != nil {
+		return linebot.NewFlexMessage(r.AltText, r.flexCont)
+	}
+	return linebot.NewTextMessage(r.Text)
+}
+
+// substituteLineVars replaces {{name}}; escapeJSON escapes values for use inside JSON strings
+func substituteLineVars(tpl string, vars map[string]string, escapeJSON bool) string {
+	return lineTemplateVarPattern.ReplaceAllStringFunc(tpl, func(m string) string {
+		v := vars[lineTemplateVarPattern.FindStringSubmatch(m)[1]]
+		if escapeJSON {
+			b, _ := json.Marshal(v)
+			return string(b[1 : len(b)-1])
+		}
+		return v
+	})
+}
+
+// RenderLineTemplate fills a template with variables
+func RenderLineTemplate(tpl models.LineMessageTemplate, vars map[string]string) *RenderedLineMessage {
+	r := &RenderedLineMessage{
+		AltText: truncateRunes(strings.TrimSpace(substituteLineVars(tpl.AltText, vars, false)), 400),
+		Text:    strings.TrimSpace(substituteLineVars(tpl.TextFallback, vars, false)),
+	}
+	if r.AltText == "" {
+		r.AltText = truncateRunes(r.Text, 400)
+	}
+	if strings.TrimSpace(tpl.FlexJSON) == "" {
+		return r
+	}
+	raw := substituteLineVars(tpl.FlexJSON, vars, true)
+	container, err := linebot.UnmarshalFlexMessageJSON([]byte(raw))
+	if err != nil {
+		r.FlexErr = err.Error()
+		return r
+	}
+	r.flexCont = container
+	r.Flex = json.RawMessage(raw)
+	return r
+}
+
+// LineTemplateService stores admin-edited templates; keys without a stored template use the built-in one
+type LineTemplateService struct {
+	db *gorm.DB
+}
+
+func NewLineTemplateService() *LineTemplateService {
+	return &LineTemplateService{db: database.DB}
+}
+
+func defaultLineTemplate(def LineTemplateDefinition) models.LineMessageTemplate {
+	return models.LineMessageTemplate{
+		Key:          def.Key,
+		Name:         def.Name,
+		AltText:      def.AltText,
+		FlexJSON:     def.FlexJSON,
+		TextFallback: def.TextFallback,
+		Active:       true,
+	}
+}
+
+// Get returns the active stored template of a key, else the built-in default
+func (s *LineTemplateService) Get(key string) (*models.LineMessageTemplate, error) {
+	def, ok := lineTemplateDefinitions[key]
+	if !ok {
+		return nil, ErrLineTemplateNotFound
+	}
+	var tpl models.LineMessageTemplate
+	if err := s.db.Where("`key` = ? AND active = ?", key, true).First(&tpl).Error; err == nil {
+		return &tpl, nil
+	}
+	tpl = defaultLineTemplate(def)
+	return &tpl, nil
+}
+
+// List returns every key with its current template
+func (s *LineTemplateService) List() ([]models.LineMessageTemplate, error) {
+	var stored []models.LineMessageTemplate
+	if err := s.db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]models.LineMessageTemplate, len(stored))
+	for _, t := range stored {
+		byKey[t.Key] = t
+	}
+	out := make([]models.LineMessageTemplate, 0, len(lineTemplateDefinitions))
+	for _, def := range LineTemplateDefinitions() {
+		if t, ok := byKey[def.Key]; ok {
+			out = append(out, t)
+		} else {
+			out = append(out, defaultLineTemplate(def))
+		}
+	}
+	return out, nil
+}
+
+// Validate checks variables and that the Flex JSON renders with the sample values
+func (s *LineTemplateService) Validate(tpl models.LineMessageTemplate) (*RenderedLineMessage, error) {
+	def, ok := lineTemplateDefinitions[tpl.Key]
+	if !ok {
+		return nil, ErrLineTemplateNotFound
+	}
+	if strings.TrimSpace(tpl.TextFallback) == "" {
+		return nil, fmt.Errorf("%w: text_fallback is required", ErrLineTemplateInvalid)
+	}
+	known := make(map[string]bool, len(def.Variables))
+	for _, v := range def.Variables {
+		known[v] = true
+	}
+	var unknown []string
+	for _, part := range []string{tpl.AltText, tpl.FlexJSON, tpl.TextFallback} {
+		for _, m := range lineTemplateVarPattern.FindAllStringSubmatch(part, -1) {
+			if !known[m[1]] {
+				unknown = append(unknown, m[1])
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: unknown variable(s) %s; available: %s", ErrLineTemplateInvalid, strings.Join(unknown, ", "), strings.Join(def.Variables, ", "))
+	}
+	rendered := RenderLineTemplate(tpl, def.Sample)
+	if rendered.FlexErr != "" {
+		return nil, fmt.Errorf("%w: flex_json: %s", ErrLineTemplateInvalid, rendered.FlexErr)
+	}
+	return rendered, nil
+}
+
+// Save stores the template of a key after validating it
+func (s *LineTemplateService) Save(in models.LineMessageTemplate, adminID uint) (*models.LineMessageTemplate, error) {
+	if _, err := s.Validate(in); err != nil {
+		return nil, err
+	}
+	var tpl models.LineMessageTemplate
+	err := s.db.Where("`key` = ?", in.Key).First(&tpl).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	tpl.Key = in.Key
+	tpl.Name = strings.TrimSpace(in.Name)
+	if tpl.Name == "" {
+		tpl.Name = lineTemplateDefinitions[in.Key].Name
+	}
+	tpl.AltText, tpl.FlexJSON, tpl.TextFallback, tpl.Active = in.AltText, in.FlexJSON, in.TextFallback, in.Active
+	tpl.UpdatedBy = &adminID
+	if err := s.db.Save(&tpl).Error; err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// Reset deletes the stored template so the built-in default is used again
+func (s *LineTemplateService) Reset(key string) error {
+	if _, ok := lineTemplateDefinitions[key]; !ok {
+		return ErrLineTemplateNotFound
+	}
+	return s.db.Unscoped().Where("`key` = ?", key).Delete(&models.LineMessageTemplate{}).Error
+}
+
+// Render renders the current template of a key
+func (s *LineTemplateService) Render(key string, vars map[string]string) (*RenderedLineMessage, error) {
+	tpl, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return RenderLineTemplate(*tpl, vars), nil
+}
+
+// sessionTeacherNickname returns "T.<nickname>" of the session's teacher (session-level, else schedule default)
+func sessionTeacherNickname(db *gorm.DB, session models.Schedule_Sessions, schedule models.Schedules) string {
+	teacherID := ResponsibleTeacherID(session, &schedule)
+	if teacherID == nil {
+		return "-"
+	}
+	var user models.User
+	if err := db.Preload("Teacher").First(&user, *teacherID).Error; err != nil {
+		return "-"
+	}
+	if user.Teacher != nil {
+		if user.Teacher.NicknameEn != "" {
+			return "T." + user.Teacher.NicknameEn
+		}
+		if user.Teacher.NicknameTh != "" {
+			return "ครู" + user.Teacher.NicknameTh
+		}
+	}
+	return user.Username
+}
+
+// DailyClassReminderVars builds the variables of the daily reminder from the session's relations
+func DailyClassReminderVars(db *gorm.DB, session models.Schedule_Sessions, schedule models.Schedules, group models.Group) map[string]string {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	vars := map[string]string{
+		"group_name":    group.GroupName,
+		"schedule_name": schedule.ScheduleName,
+		"branch_name":   "-",
+		"room_name":     "-",
+		"teacher_name":  sessionTeacherNickname(db, session, schedule),
+		"leave_cutoff":  strings.Replace(LeaveCutoffFor(schedule), ":", ".", 1),
+		"absence_link":  AbsenceLink(),
+		"date":          "-",
+		"start_time":    "-",
+		"end_time":      "-",
+	}
+	if session.Start_time != nil {
+		vars["date"] = session.Start_time.In(loc).Format("02/01/2006")
+		vars["start_time"] = session.Start_time.In(loc).Format("15:04")
+	}
+	if session.End_time != nil {
+		vars["end_time"] = session.End_time.In(loc).Format("15:04")
+	}
+
+	roomID := session.RoomID
+	if roomID == nil {
+		roomID = schedule.DefaultRoomID
+	}
+	var room models.Room
+	if roomID != nil && db.First(&room, *roomID).Error == nil {
+		vars["room_name"] = room.RoomName
+	}
+	var branch models.Branch
+	if branchID := SessionBranchID(db, session); branchID != nil && db.First(&branch, *branchID).Error == nil {
+		vars["branch_name"] = branch.NameTh
+		if vars["branch_name"] == "" {
+			vars["branch_name"] = branch.NameEn
+		}
+	}
+	return vars
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+func TestDefaultLineTemplatesRender(t *testing.T) {
+	for _, def := range LineTemplateDefinitions() {
+		r := RenderLineTemplate(defaultLineTemplate(def), def.Sample)
+		if r.FlexErr != "" || r.Message() == nil || r.Flex == nil {
+			t.Errorf("%s: default Flex does not render: %s", def.Key, r.FlexErr)
+		}
+		if strings.Contains(r.Text, "{{") || strings.Contains(r.AltText, "{{") {
+			t.Errorf("%s: unreplaced variable in %q / %q", def.Key, r.Text, r.AltText)
+		}
+	}
+}
+
+func TestRenderLineTemplateEscapesFlexValues(t *testing.T) {
+	tpl := models.LineMessageTemplate{
+		FlexJSON:     `{"type": "bubble", "body": {"type": "box", "layout": "vertical", "contents": [{"type": "text", "text": "{{ title }}"}]}}`,
+		TextFallback: "{{title}}",
+	}
+	r := RenderLineTemplate(tpl, map[string]string{"title": "Say \"hi\"\nnow"})
+	if r.FlexErr != "" {
+		t.Fatalf("value with quotes / newline broke the Flex JSON: %s", r.FlexErr)
+	}
+	if r.Text != "Say \"hi\"\nnow" || r.AltText != r.Text {
+		t.Errorf("text = %q, alt = %q", r.Text, r.AltText)
+	}
+
+	tpl.FlexJSON = `{"type": "bubble"`
+	if r := RenderLineTemplate(tpl, nil); r.FlexErr == "" || r.Flex != nil {
+		t.Errorf("broken Flex JSON should fall back to text")
+	}
+}
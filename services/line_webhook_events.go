@@ -0,0 +1,361 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	"englishkorat_go/utils"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LINE webhook event statuses
+const (
+	LineEventPending    = "pending"
+	LineEventProcessing = "processing"
+	LineEventDone       = "done"
+	LineEventFailed     = "failed"
+)
+
+const (
+	lineEventMaxAttempts   = 5
+	lineEventLeaseDuration = 2 * time.Minute
+	lineEventPollInterval  = 15 * time.Second
+	lineEventClaimBatch    = 20
+)
+
+var (
+	// ErrLineWebhookPayload means the webhook body is not valid LINE JSON (LINE should not redeliver it)
+	ErrLineWebhookPayload = errors.New("invalid LINE webhook payload")
+	// ErrLineEventNotFound is returned when an event does not exist or cannot be replayed
+	ErrLineEventNotFound = errors.New("LINE webhook event not found or not replayable")
+)
+
+// LineEventProcessor handles one stored webhook event. Returning an error schedules a retry.
+type LineEventProcessor func(event *linebot.Event) error
+
+var (
+	lineEventProcessorMu sync.RWMutex
+	lineEventProcessor   LineEventProcessor
+)
+
+// RegisterLineEventProcessor sets the processor used by the webhook event worker
+func RegisterLineEventProcessor(p LineEventProcessor) {
+	lineEventProcessorMu.Lock()
+	defer lineEventProcessorMu.Unlock()
+	lineEventProcessor = p
+}
+
+func currentLineEventProcessor() LineEventProcessor {
+	lineEventProcessorMu.RLock()
+	defer lineEventProcessorMu.RUnlock()
+	return lineEventProcessor
+}
+
+// LineWebhookEventService persists LINE webhook events and processes them with claim/lease + retry,
+// the same way JobQueue does for scheduled jobs.
+type LineWebhookEventService struct {
+	db       *gorm.DB
+	workerID string
+}
+
+func NewLineWebhookEventService() *LineWebhookEventService {
+	host, _ := os.Hostname()
+	suffix, _ := utils.GenerateRandomString(8)
+	return &LineWebhookEventService{
+		db:       database.DB,
+		workerID: fmt.Sprintf("%s-%d-%s", host, os.Getpid(), suffix),
+	}
+}
+
+// lineWebhookEnvelope holds the fields the SDK's Event does not expose
+type lineWebhookEnvelope struct {
+	WebhookEventID  string `json:"webhookEventId"`
+	Type            string `json:"type"`
+	Timestamp       int64  `json:"timestamp"`
+	DeliveryContext struct {
+		IsRedelivery bool `json:"isRedelivery"`
+	} `json:"deliveryContext"`
+	Source struct {
+		Type    string `json:"type"`
+		UserID  string `json:"userId"`
+		GroupID string `json:"groupId"`
+		RoomID  string `json:"roomId"`
+	} `json:"source"`
+}
+
+func lineWebhookEventRow(raw json.RawMessage) (models.LineWebhookEvent, error) {
+	var env lineWebhookEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return models.LineWebhookEvent{}, fmt.Errorf("%w: %v", ErrLineWebhookPayload, err)
+	}
+	id := env.WebhookEventID
+	if id == "" {
+		// payload เก่า/ทดสอบที่ไม่มี webhookEventId: ใช้ hash ของ event แทนเพื่อยัง dedupe ได้
+		sum := sha256.Sum256(raw)
+		id = "sha256:" + hex.EncodeToString(sum[:])[:48]
+	}
+	sourceID := env.Source.UserID
+	switch env.Source.Type {
+	case "group":
+		sourceID = env.Source.GroupID
+	case "room":
+		sourceID = env.Source.RoomID
+	}
+	row := models.LineWebhookEvent{
+		WebhookEventID: id,
+		EventType:      env.Type,
+		SourceType:     env.Source.Type,
+		SourceID:       sourceID,
+		IsRedelivery:   env.DeliveryContext.IsRedelivery,
+		Payload:        models.JSON(raw),
+		Status:         LineEventPending,
+		MaxAttempts:    lineEventMaxAttempts,
+		NextAttemptAt:  time.Now(),
+	}
+	if env.Timestamp > 0 {
+		t := time.UnixMilli(env.Timestamp)
+		row.EventTime = &t
+	}
+	return row, nil
+}
+
+// Store persists every event of a webhook body. Already stored webhookEventIds (redeliveries) are skipped.
+// Returns the IDs of the newly stored events.
+func (s *LineWebhookEventService) Store(body []byte) ([]uint, error) {
+	var webhook struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLineWebhookPayload, err)
+	}
+	rows := make([]models.LineWebhookEvent, 0, len(webhook.Events))
+	for _, raw := range webhook.Events {
+		row, err := lineWebhookEventRow(raw)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	var ids []uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range rows {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows[i])
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				ids = append(ids, rows[i].ID)
+			} else {
+				log.Printf("[line-events] duplicate webhook event %s skipped", rows[i].WebhookEventID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ProcessIDs processes freshly stored events right away so chat replies are not delayed by the poller
+func (s *LineWebhookEventService) ProcessIDs(ids []uint) {
+	for _, id := range ids {
+		if ev, ok := s.claim(id); ok {
+			s.run(ev)
+		}
+	}
+}
+
+// StartWorker polls for events to (re)try until stop is closed
+func (s *LineWebhookEventService) StartWorker(stop <-chan struct{}) {
+	go func() {
+		log.Printf("[line-events] worker %s started", s.workerID)
+		ticker := time.NewTicker(lineEventPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				log.Printf("[line-events] worker %s stopping", s.workerID)
+				return
+			case <-ticker.C:
+				s.processDue()
+			}
+		}
+	}()
+}
+
+func (s *LineWebhookEventService) processDue() {
+	if s.db == nil {
+		return
+	}
+	now := time.Now()
+	var candidates []uint
+	err := s.db.Model(&models.LineWebhookEvent{}).
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND lease_until < ?)", LineEventPending, now, LineEventProcessing, now).
+		Order("id ASC").
+		Limit(lineEventClaimBatch).
+		Pluck("id", &candidates).Error
+	if err != nil {
+		log.Printf("[line-events] poll failed: %v", err)
+		return
+	}
+	s.ProcessIDs(candidates)
+}
+
+// claim takes ownership of an event via a conditional update; only one worker can win
+func (s *LineWebhookEventService) claim(id uint) (*models.LineWebhookEvent, bool) {
+	now := time.Now()
+	res := s.db.Model(&models.LineWebhookEvent{}).
+		Where("id = ? AND ((status = ? AND next_attempt_at <= ?) OR (status = ? AND lease_until < ?))", id, LineEventPending, now, LineEventProcessing, now).
+		Updates(map[string]interface{}{
+			"status":      LineEventProcessing,
+			"locked_by":   s.workerID,
+			"lease_until": now.Add(lineEventLeaseDuration),
+			"attempts":    gorm.Expr("attempts + 1"),
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, false
+	}
+	var ev models.LineWebhookEvent
+	if err := s.db.First(&ev, id).Error; err != nil {
+		return nil, false
+	}
+	return &ev, true
+}
+
+func (s *LineWebhookEventService) run(ev *models.LineWebhookEvent) {
+	err := s.process(ev)
+	owned := s.db.Model(&models.LineWebhookEvent{}).Where("id = ? AND locked_by = ? AND status = ?", ev.ID, s.workerID, LineEventProcessing)
+	if err == nil {
+		owned.Updates(map[string]interface{}{
+			"status":       LineEventDone,
+			"processed_at": time.Now(),
+			"lease_until":  nil,
+			"last_error":   "",
+		})
+		return
+	}
+
+	log.Printf("[line-events] event %d (%s) attempt %d failed: %v", ev.ID, ev.EventType, ev.Attempts, err)
+	if ev.Attempts >= ev.MaxAttempts || errors.Is(err, ErrLineWebhookPayload) {
+		owned.Updates(map[string]interface{}{
+			"status":      LineEventFailed,
+			"lease_until": nil,
+			"last_error":  err.Error(),
+		})
+		return
+	}
+	owned.Updates(map[string]interface{}{
+		"status":          LineEventPending,
+		"next_attempt_at": time.Now().Add(jobBackoff(ev.Attempts)),
+		"lease_until":     nil,
+		"last_error":      err.Error(),
+	})
+}
+
+// process decodes the stored payload and hands it to the registered processor (panics become errors)
+func (s *LineWebhookEventService) process(ev *models.LineWebhookEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	p := currentLineEventProcessor()
+	if p == nil {
+		return errors.New("no LINE event processor registered")
+	}
+	var event linebot.Event
+	if err := json.Unmarshal(ev.Payload, &event); err != nil {
+		return fmt.Errorf("%w: %v", ErrLineWebhookPayload, err)
+	}
+	return p(&event)
+}
+
+// ListLineEventsFilter narrows List results
+type ListLineEventsFilter struct {
+	Status    string
+	EventType string
+	SourceID  string
+	Limit     int
+	Offset    int
+}
+
+// List returns stored events, newest first, along with the total count
+func (s *LineWebhookEventService) List(f ListLineEventsFilter) ([]models.LineWebhookEvent, int64, error) {
+	query := s.db.Model(&models.LineWebhookEvent{})
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.EventType != "" {
+		query = query.Where("event_type = ?", f.EventType)
+	}
+	if f.SourceID != "" {
+		query = query.Where("source_id = ?", f.SourceID)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var events []models.LineWebhookEvent
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit).Offset(f.Offset)
+	}
+	err := query.Order("id DESC").Find(&events).Error
+	return events, total, err
+}
+
+// Get returns one stored event
+func (s *LineWebhookEventService) Get(id uint) (*models.LineWebhookEvent, error) {
+	var ev models.LineWebhookEvent
+	if err := s.db.First(&ev, id).Error; err != nil {
+		return nil, ErrLineEventNotFound
+	}
+	return &ev, nil
+}
+
+// replayUpdates resets a failed event so the worker picks it up with a fresh attempt budget
+func replayUpdates() map[string]interface{} {
+	return map[string]interface{}{
+		"status":          LineEventPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"locked_by":       "",
+		"lease_until":     nil,
+	}
+}
+
+// Replay re-queues a failed event and processes it immediately
+func (s *LineWebhookEventService) Replay(id uint) (*models.LineWebhookEvent, error) {
+	res := s.db.Model(&models.LineWebhookEvent{}).
+		Where("id = ? AND status = ?", id, LineEventFailed).
+		Updates(replayUpdates())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrLineEventNotFound
+	}
+	s.ProcessIDs([]uint{id})
+	return s.Get(id)
+}
+
+// ReplayFailed re-queues every failed event; the worker processes them on its next poll
+func (s *LineWebhookEventService) ReplayFailed() (int64, error) {
+	res := s.db.Model(&models.LineWebhookEvent{}).
+		Where("status = ?", LineEventFailed).
+		Updates(replayUpdates())
+	return res.RowsAffected, res.Error
+}
@@ -1,73 +1,287 @@
 package services
 
 import (
+	"errors"
 	"log"
-	"regexp"
+	"math"
+	"sort"
 	"strings"
+	"time"
+	"unicode"
 
-	"englishkorat_go/models"
 	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
 )
 
-type LineGroupMatcher struct{}
+const (
+	lineGroupCandidateLimit    = 5
+	lineGroupCandidateMinScore = 0.3
+)
+
+var (
+	ErrLineGroupNotFound = errors.New("LINE group not found")
+	ErrGroupNotFound     = errors.New("group not found")
+)
+
+type LineGroupMatcher struct {
+	db *gorm.DB
+}
 
 func NewLineGroupMatcher() *LineGroupMatcher {
-	return &LineGroupMatcher{}
+	return &LineGroupMatcher{db: database.DB}
 }
 
 // normalizeName ทำความสะอาด string ให้เหลือรูปแบบเทียบได้
+// lower case, ตัดเครื่องหมาย/emoji ออก (เก็บตัวอักษร ตัวเลข และสระ/วรรณยุกต์ไทย) แล้วยุบช่องว่าง
 func normalizeName(s string) string {
-	// 1. lower case
-	s = strings.ToLower(s)
-	// 2. trim space หน้า/หลัง
-	s = strings.TrimSpace(s)
-	// 3. replace multiple spaces ด้วย space เดียว
-	re := regexp.MustCompile(`\s+`)
-	s = re.ReplaceAllString(s, " ")
-	return s
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// nameTokens splits a normalized name on spaces and on Thai/Latin boundaries ("ielts7กลุ่มเช้า" -> ielts7, กลุ่มเช้า)
+func nameTokens(s string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(normalizeName(s)) {
+		start := 0
+		runes := []rune(field)
+		for i := 1; i < len(runes); i++ {
+			if isThaiRune(runes[i]) != isThaiRune(runes[i-1]) {
+				tokens = append(tokens, string(runes[start:i]))
+				start = i
+			}
+		}
+		tokens = append(tokens, string(runes[start:]))
+	}
+	return tokens
+}
+
+func isThaiRune(r rune) bool {
+	return unicode.Is(unicode.Thai, r)
+}
+
+// tokensMatch: ตรงกันทั้งคำ หรือคำไทยที่อยู่ในอีกคำ (ภาษาไทยไม่เว้นวรรคระหว่างคำ)
+func tokensMatch(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < 2 || len(rb) < 2 || !isThaiRune(ra[0]) || !isThaiRune(rb[0]) {
+		return false
+	}
+	return strings.Contains(a, b) || strings.Contains(b, a)
+}
+
+// tokenOverlap is a Jaccard-like ratio of matching tokens
+func tokenOverlap(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	matched := 0
+	for _, ta := range a {
+		for _, tb := range b {
+			if tokensMatch(ta, tb) {
+				matched++
+				break
+			}
+		}
+	}
+	union := len(a) + len(b) - matched
+	if union <= 0 {
+		return 1
+	}
+	return float64(matched) / float64(union)
+}
+
+// editDistance is the rune-level Levenshtein distance
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// LineGroupCandidate is a Group suggested for an unmatched LINE group
+type LineGroupCandidate struct {
+	GroupID      uint    `json:"group_id"`
+	GroupName    string  `json:"group_name"`
+	Score        float64 `json:"score"` // 0..1
+	Distance     int     `json:"distance"`
+	TokenOverlap float64 `json:"token_overlap"`
+}
+
+// rankGroupCandidates scores groups by edit-distance similarity and token overlap (50/50)
+func rankGroupCandidates(lineGroupName string, groups []models.Group, limit int) []LineGroupCandidate {
+	name := normalizeName(lineGroupName)
+	tokens := nameTokens(lineGroupName)
+	candidates := make([]LineGroupCandidate, 0)
+	for _, g := range groups {
+		gName := normalizeName(g.GroupName)
+		dist := editDistance(name, gName)
+		longest := max(len([]rune(name)), len([]rune(gName)))
+		similarity := 0.0
+		if longest > 0 {
+			similarity = 1 - float64(dist)/float64(longest)
+		}
+		overlap := tokenOverlap(tokens, nameTokens(g.GroupName))
+		score := math.Round((similarity+overlap)/2*1000) / 1000
+		if score < lineGroupCandidateMinScore {
+			continue
+		}
+		candidates = append(candidates, LineGroupCandidate{
+			GroupID:      g.ID,
+			GroupName:    g.GroupName,
+			Score:        score,
+			Distance:     dist,
+			TokenOverlap: math.Round(overlap*1000) / 1000,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Distance < candidates[j].Distance
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
 }
+
+// MatchLineGroupsToGroups links LINE groups to Groups whose normalized names are equal.
+// Groups are loaded once; locked LINE groups (manual decisions) are never touched.
 func (m *LineGroupMatcher) MatchLineGroupsToGroups() {
-	db := database.DB
-	var lineGroups []models.LineGroup
+	var groups []models.Group
+	if err := m.db.Select("id", "group_name").Find(&groups).Error; err != nil {
+		log.Printf("❌ Error fetching Groups: %v", err)
+		return
+	}
+	byName := make(map[string][]uint, len(groups))
+	for _, g := range groups {
+		key := normalizeName(g.GroupName)
+		byName[key] = append(byName[key], g.ID)
+	}
 
-	if err := db.Find(&lineGroups).Error; err != nil {
+	var lineGroups []models.LineGroup
+	if err := m.db.Where("match_locked = ?", false).Find(&lineGroups).Error; err != nil {
 		log.Printf("❌ Error fetching LineGroups: %v", err)
 		return
 	}
 
+	matched, unmatched := 0, 0
 	for _, lg := range lineGroups {
-		cleanLG := normalizeName(lg.GroupName)
-		log.Printf("🔍 Matching LineGroup '%s' → '%s'", lg.GroupName, cleanLG)
-
-		var groups []models.Group
-		if err := db.Find(&groups).Error; err != nil {
-			log.Printf("❌ Error fetching Groups: %v", err)
+		ids := byName[normalizeName(lg.GroupName)]
+		if len(ids) != 1 {
+			if len(ids) > 1 {
+				log.Printf("⚠️ LineGroup '%s' matches %d Groups after normalizing: skipped", lg.GroupName, len(ids))
+			}
+			unmatched++
 			continue
 		}
-
-		matched := false
-		for _, g := range groups {
-			cleanG := normalizeName(g.GroupName)
-			if cleanLG == cleanG {
-				if lg.MatchedGroupID == nil || *lg.MatchedGroupID != g.ID {
-					lg.MatchedGroupID = &g.ID
-					if err := db.Save(&lg).Error; err != nil {
-						log.Printf("❌ Failed to update LineGroup '%s' with MatchedGroupID=%d: %v",
-							lg.GroupName, g.ID, err)
-					} else {
-						log.Printf("✅ Matched LineGroup '%s' → Group '%s' (ID=%d)",
-							lg.GroupName, g.GroupName, g.ID)
-					}
-				} else {
-					log.Printf("ℹ️ Already matched: '%s' (Group ID=%d)", lg.GroupName, g.ID)
-				}
-				matched = true
-				break
-			}
+		if lg.MatchedGroupID != nil && *lg.MatchedGroupID == ids[0] {
+			continue
 		}
-
-		if !matched {
-			log.Printf("⚠️ No matching Group found for LineGroup '%s' (normalized='%s')", lg.GroupName, cleanLG)
+		now := time.Now()
+		res := m.db.Model(&models.LineGroup{}).
+			Where("id = ? AND match_locked = ?", lg.ID, false).
+			Updates(map[string]interface{}{"matched_group_id": ids[0], "matched_by": nil, "matched_at": now})
+		if res.Error != nil {
+			log.Printf("❌ Failed to update LineGroup '%s' with MatchedGroupID=%d: %v", lg.GroupName, ids[0], res.Error)
+			continue
+		}
+		if res.RowsAffected > 0 {
+			matched++
+			log.Printf("✅ Matched LineGroup '%s' → Group ID=%d", lg.GroupName, ids[0])
 		}
 	}
-}
\ No newline at end of file
+	log.Printf("🔍 LineGroup matching done: %d newly matched, %d without exact match", matched, unmatched)
+}
+
+// UnmatchedLineGroup is an active LINE group without a Group, with ranked suggestions
+type UnmatchedLineGroup struct {
+	LineGroup  models.LineGroup     `json:"line_group"`
+	Candidates []LineGroupCandidate `json:"candidates"`
+}
+
+// Unmatched lists active LINE groups without a matched Group along with candidate Groups
+func (m *LineGroupMatcher) Unmatched(limit int) ([]UnmatchedLineGroup, error) {
+	if limit <= 0 {
+		limit = lineGroupCandidateLimit
+	}
+	var lineGroups []models.LineGroup
+	if err := m.db.Where("matched_group_id IS NULL AND is_active = ?", true).Order("last_joined_at DESC").Find(&lineGroups).Error; err != nil {
+		return nil, err
+	}
+	var groups []models.Group
+	if err := m.db.Select("id", "group_name").Where("status <> ?", "inactive").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	result := make([]UnmatchedLineGroup, 0, len(lineGroups))
+	for _, lg := range lineGroups {
+		result = append(result, UnmatchedLineGroup{LineGroup: lg, Candidates: rankGroupCandidates(lg.GroupName, groups, limit)})
+	}
+	return result, nil
+}
+
+// Link binds a LINE group to a Group by hand and locks it against the automatic matcher
+func (m *LineGroupMatcher) Link(lineGroupID, groupID, userID uint) (*models.LineGroup, error) {
+	var group models.Group
+	if err := m.db.Select("id").First(&group, groupID).Error; err != nil {
+		return nil, ErrGroupNotFound
+	}
+	return m.update(lineGroupID, map[string]interface{}{
+		"matched_group_id": groupID,
+		"match_locked":     true,
+		"matched_by":       userID,
+		"matched_at":       time.Now(),
+	})
+}
+
+// Unlink removes the match; with lock the matcher will not re-link it automatically
+func (m *LineGroupMatcher) Unlink(lineGroupID, userID uint, lock bool) (*models.LineGroup, error) {
+	return m.update(lineGroupID, map[string]interface{}{
+		"matched_group_id": nil,
+		"match_locked":     lock,
+		"matched_by":       userID,
+		"matched_at":       time.Now(),
+	})
+}
+
+// SetLocked toggles the lock without changing the match
+func (m *LineGroupMatcher) SetLocked(lineGroupID uint, locked bool) (*models.LineGroup, error) {
+	return m.update(lineGroupID, map[string]interface{}{"match_locked": locked})
+}
+
+func (m *LineGroupMatcher) update(lineGroupID uint, updates map[string]interface{}) (*models.LineGroup, error) {
+	var lg models.LineGroup
+	if err := m.db.First(&lg, lineGroupID).Error; err != nil {
+		return nil, ErrLineGroupNotFound
+	}
+	if err := m.db.Model(&lg).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := m.db.Preload("MatchedGroup").First(&lg, lineGroupID).Error; err != nil {
+		return nil, err
+	}
+	return &lg, nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+func TestNormalizeName(t *testing.T) {
+	cases := map[string]string{
+		"  IELTS   7.0  ":     "ielts 7 0",
+		"ป.6 กลุ่ม-เช้า 🌞":    "ป 6 กลุ่ม เช้า",
+		"Conversation_A1 (ส)": "conversation a1 ส",
+	}
+	for in, want := range cases {
+		if got := normalizeName(in); got != want {
+			t.Errorf("normalizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNameTokensSplitsScripts(t *testing.T) {
+	got := nameTokens("IELTS7กลุ่มเช้า Kids")
+	want := []string{"ielts7", "กลุ่มเช้า", "kids"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("nameTokens = %v, want %v", got, want)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	if d := editDistance("kitten", "sitting"); d != 3 {
+		t.Fatalf("editDistance = %d, want 3", d)
+	}
+	if d := editDistance("กลุ่มเช้า", "กลุ่มเย็น"); d != 3 {
+		t.Fatalf("thai editDistance = %d, want 3", d)
+	}
+}
+
+func TestRankGroupCandidates(t *testing.T) {
+	groups := []models.Group{
+		{BaseModel: models.BaseModel{ID: 1}, GroupName: "IELTS 7 Morning"},
+		{BaseModel: models.BaseModel{ID: 2}, GroupName: "Kids Phonics กลุ่มเช้า"},
+		{BaseModel: models.BaseModel{ID: 3}, GroupName: "TOEIC Evening"},
+	}
+
+	got := rankGroupCandidates("Kids phonics (เช้า)", groups, 5)
+	if len(got) == 0 || got[0].GroupID != 2 {
+		t.Fatalf("expected group 2 first, got %+v", got)
+	}
+	for _, c := range got {
+		if c.GroupID == 3 {
+			t.Fatalf("unrelated group should be filtered out: %+v", got)
+		}
+	}
+
+	if got := rankGroupCandidates("IELTS 7 Morning", groups, 1); len(got) != 1 || got[0].Score != 1 {
+		t.Fatalf("exact name should score 1 and respect limit, got %+v", got)
+	}
+}
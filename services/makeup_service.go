@@ -0,0 +1,250 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMakeupOriginalNotFound = errors.New("original session not found")
+	ErrMakeupInvalid          = errors.New("invalid makeup session")
+	ErrMakeupSlotTaken        = errors.New("makeup slot is no longer available")
+)
+
+// MakeupInput describes a makeup for an existing session
+type MakeupInput struct {
+	OriginalSessionID uint
+	SessionDate       time.Time
+	StartTime         string // HH:MM
+	// OriginalStatus (cancelled / rescheduled / no-show) is written to the original session; empty keeps it
+	OriginalStatus   string
+	CancellingReason string
+}
+
+// CreateMakeupSession creates a makeup session with the original's length, number, teacher and room
+func CreateMakeupSession(in MakeupInput) (*models.Schedule_Sessions, *models.Schedule_Sessions, error) {
+	var originalSession models.Schedule_Sessions
+	if err := database.DB.First(&originalSession, in.OriginalSessionID).Error; err != nil {
+		return nil, nil, ErrMakeupOriginalNotFound
+	}
+
+	makeupSession, err := buildMakeupSession(originalSession, in.SessionDate, in.StartTime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Update original session status
+		if in.OriginalStatus != "" {
+			originalSession.Status = in.OriginalStatus
+			originalSession.Cancelling_Reason = in.CancellingReason
+			if err := tx.Save(&originalSession).Error; err != nil {
+				return fmt.Errorf("failed to update original session: %w", err)
+			}
+		}
+		if err := tx.Create(&makeupSession).Error; err != nil {
+			return fmt.Errorf("failed to create makeup session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &makeupSession, &originalSession, nil
+}
+
+// buildMakeupSession prepares (without saving) a makeup of the original at date + HH:MM
+func buildMakeupSession(originalSession models.Schedule_Sessions, sessionDate time.Time, startTime string) (models.Schedule_Sessions, error) {
+	newStartTime, err := time.Parse("15:04", startTime)
+	if err != nil {
+		return models.Schedule_Sessions{}, fmt.Errorf("%w: invalid time format, use HH:MM", ErrMakeupInvalid)
+	}
+
+	// Create new session date with the time
+	newStartDateTime := time.Date(
+		sessionDate.Year(), sessionDate.Month(), sessionDate.Day(),
+		newStartTime.Hour(), newStartTime.Minute(), 0, 0, sessionDate.Location(),
+	)
+	// Calculate duration safely: ensure original start/end times are non-nil
+	var newEndDateTime time.Time
+	if originalSession.Start_time != nil && originalSession.End_time != nil {
+		duration := originalSession.End_time.Sub(*originalSession.Start_time)
+		newEndDateTime = newStartDateTime.Add(duration)
+	} else {
+		// Fallback: assume 1 hour session if original times missing
+		newEndDateTime = newStartDateTime.Add(time.Hour)
+	}
+
+	// Use pointer assignments for time fields
+	nd := sessionDate
+	st := newStartDateTime
+	et := newEndDateTime
+	return models.Schedule_Sessions{
+		ScheduleID:            originalSession.ScheduleID,
+		Session_date:          &nd,
+		Start_time:            &st,
+		End_time:              &et,
+		Session_number:        originalSession.Session_number,
+		Week_number:           originalSession.Week_number,
+		Status:                "scheduled",
+		Is_makeup:             true,
+		Makeup_for_session_id: &originalSession.ID,
+		AssignedTeacherID:     originalSession.AssignedTeacherID,
+		RoomID:                originalSession.RoomID,
+	}, nil
+}
+
+// MakeupSlot is an offered makeup time
+type MakeupSlot struct {
+	Date      string    `json:"date"`
+	StartTime string    `json:"start_time"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// Makeup slots are searched from tomorrow up to this many days after the missed session
+const makeupSearchDays = 21
+
+// sessionSlotBusy reports whether the teacher, room or group already has a session overlapping [start, end)
+func sessionSlotBusy(db *gorm.DB, start, end time.Time, teacherID, roomID, groupID *uint) bool {
+	var conds []string
+	var args []interface{}
+	if teacherID != nil {
+		conds = append(conds, "(schedule_sessions.assigned_teacher_id = ? OR (schedule_sessions.assigned_teacher_id IS NULL AND schedules.default_teacher_id = ?))")
+		args = append(args, *teacherID, *teacherID)
+	}
+	if roomID != nil {
+		conds = append(conds, "schedule_sessions.room_id = ?")
+		args = append(args, *roomID)
+	}
+	if groupID != nil {
+		conds = append(conds, "schedules.group_id = ?")
+		args = append(args, *groupID)
+	}
+	if len(conds) == 0 {
+		return false
+	}
+	where := conds[0]
+	for _, c := range conds[1:] {
+		where += " OR " + c
+	}
+
+	var count int64
+	db.Model(&models.Schedule_Sessions{}).
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id AND schedules.deleted_at IS NULL").
+		Where("schedule_sessions.status NOT IN ?", []string{"cancelled", "rescheduled", "no-show"}).
+		Where("schedules.status NOT IN ?", []string{"cancelled"}).
+		Where("schedule_sessions.start_time < ? AND schedule_sessions.end_time > ?", end, start).
+		Where(where, args...).
+		Count(&count)
+	return count > 0
+}
+
+// lockSlotOwners locks the teacher, room and group rows so concurrent bookings that share one of them
+// run their busy check and insert one after the other
+func lockSlotOwners(tx *gorm.DB, teacherID, roomID, groupID *uint) error {
+	lock := func() *gorm.DB { return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id") }
+	if teacherID != nil {
+		if err := lock().First(&models.User{}, *teacherID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	if roomID != nil {
+		if err := lock().First(&models.Room{}, *roomID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	if groupID != nil {
+		if err := lock().First(&models.Group{}, *groupID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// offeredSlot returns the offered slot at date + HH:MM (nil when that time was not offered)
+func offeredSlot(slots []MakeupSlot, date time.Time, startTime string) *MakeupSlot {
+	key := holidayDate(date).Format("2006-01-02")
+	for i := range slots {
+		if slots[i].Date == key && slots[i].StartTime == startTime {
+			return &slots[i]
+		}
+	}
+	return nil
+}
+
+// SuggestMakeupSlots offers up to `limit` free slots at the missed session's time of day:
+// the teacher, room and group must be free, the slot must be within the branch's open hours
+// and the day must not be a holiday of the branch.
+func SuggestMakeupSlots(sessionID uint, limit int) ([]MakeupSlot, error) {
+	var session models.Schedule_Sessions
+	if err := database.DB.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		return nil, ErrMakeupOriginalNotFound
+	}
+	if session.Schedule == nil || session.Start_time == nil || session.End_time == nil {
+		return nil, fmt.Errorf("%w: session has no time", ErrMakeupInvalid)
+	}
+	if limit <= 0 {
+		limit = 5
+	}
+
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	start := session.Start_time.In(loc)
+	duration := session.End_time.Sub(*session.Start_time)
+
+	from := holidayDate(time.Now()).AddDate(0, 0, 1)
+	if d := holidayDate(start).AddDate(0, 0, 1); d.After(from) {
+		from = d
+	}
+	to := holidayDate(start).AddDate(0, 0, makeupSearchDays)
+	if !to.After(from) {
+		to = from.AddDate(0, 0, makeupSearchDays)
+	}
+
+	branchID := SessionBranchID(database.DB, session)
+	hours := SessionBranchHours(database.DB, session)
+	if !hours.Contains(start, start.Add(duration)) {
+		return []MakeupSlot{}, nil
+	}
+	closed := make(map[string]bool)
+	holidays, _ := NewHolidayService().HolidaysInRange(from, to, branchID)
+	for _, h := range holidays {
+		closed[holidayDate(h.Date).Format("2006-01-02")] = true
+	}
+
+	teacherID := session.AssignedTeacherID
+	if teacherID == nil {
+		teacherID = session.Schedule.DefaultTeacherID
+	}
+	roomID := session.RoomID
+	if roomID == nil {
+		roomID = session.Schedule.DefaultRoomID
+	}
+
+	slots := make([]MakeupSlot, 0, limit)
+	for day := from; !day.After(to) && len(slots) < limit; day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		if closed[key] {
+			continue
+		}
+		slotStart := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		slotEnd := slotStart.Add(duration)
+		if sessionSlotBusy(database.DB, slotStart, slotEnd, teacherID, roomID, session.Schedule.GroupID) {
+			continue
+		}
+		slots = append(slots, MakeupSlot{
+			Date:      key,
+			StartTime: slotStart.Format("15:04"),
+			Start:     slotStart,
+			End:       slotEnd,
+		})
+	}
+	return slots, nil
+}
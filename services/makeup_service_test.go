@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestCheckMakeupBookable(t *testing.T) {
+	studentID, makeupID := uint(3), uint(40)
+	tests := []struct {
+		name    string
+		absence models.Absence
+		wantErr bool
+	}{
+		{name: "approved whole-group leave", absence: models.Absence{Status: "approved"}},
+		{name: "pending", absence: models.Absence{Status: "pending"}, wantErr: true},
+		{name: "rejected", absence: models.Absence{Status: "rejected"}, wantErr: true},
+		{name: "late leave", absence: models.Absence{Status: AbsenceForfeited, IsLate: true}, wantErr: true},
+		{name: "per-student leave would move the whole group", absence: models.Absence{Status: "approved", StudentID: &studentID}, wantErr: true},
+		{name: "already booked", absence: models.Absence{Status: "approved", MakeupSessionID: &makeupID}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckMakeupBookable(tt.absence)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrMakeupInvalid) {
+				t.Fatalf("expected ErrMakeupInvalid, got %v", err)
+			}
+		})
+	}
+
+	booked := models.Absence{Status: "approved", MakeupSessionID: &makeupID}
+	if err := CheckMakeupOffered(booked); err != nil {
+		t.Fatalf("slots should still be listed after booking: %v", err)
+	}
+}
+
+func TestOfferedSlot(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	start := time.Date(2025, 10, 20, 17, 0, 0, 0, loc)
+	slots := []MakeupSlot{
+		{Date: "2025-10-20", StartTime: "17:00", Start: start, End: start.Add(time.Hour)},
+		{Date: "2025-10-22", StartTime: "17:00", Start: start.AddDate(0, 0, 2), End: start.AddDate(0, 0, 2).Add(time.Hour)},
+	}
+
+	if got := offeredSlot(slots, time.Date(2025, 10, 22, 0, 0, 0, 0, loc), "17:00"); got == nil || got.Date != "2025-10-22" {
+		t.Fatalf("offered slot = %+v, want 2025-10-22", got)
+	}
+	// วันที่ส่งมาเป็น UTC เที่ยงคืน ต้องตรงกับวันเดียวกันในเวลาไทย
+	if got := offeredSlot(slots, time.Date(2025, 10, 20, 0, 0, 0, 0, time.UTC), "17:00"); got == nil || got.Date != "2025-10-20" {
+		t.Fatalf("offered slot = %+v, want 2025-10-20", got)
+	}
+	if got := offeredSlot(slots, time.Date(2025, 10, 20, 0, 0, 0, 0, loc), "18:00"); got != nil {
+		t.Fatalf("a time that was not offered was accepted: %+v", got)
+	}
+	if got := offeredSlot(slots, time.Date(2025, 10, 21, 0, 0, 0, 0, loc), "17:00"); got != nil {
+		t.Fatalf("a day that was not offered was accepted: %+v", got)
+	}
+}
+
+func TestBranchOpenHours(t *testing.T) {
+	hours, err := BranchOpenHours(nil)
+	if err != nil || hours.OpenMinutes != 8*60 || hours.CloseMinutes != 21*60 {
+		t.Fatalf("default hours = %+v, %v", hours, err)
+	}
+
+	branch := &models.Branch{
+		OpenTime:  time.Date(0, 1, 1, 9, 30, 0, 0, time.UTC),
+		CloseTime: time.Date(0, 1, 1, 18, 0, 0, 0, time.UTC),
+	}
+	hours, err = BranchOpenHours(branch)
+	if err != nil || hours.OpenMinutes != 9*60+30 || hours.CloseMinutes != 18*60 {
+		t.Fatalf("branch hours = %+v, %v", hours, err)
+	}
+
+	day := func(h, m int) time.Time { return time.Date(2025, 10, 20, h, m, 0, 0, time.UTC) }
+	tests := []struct {
+		start, end time.Time
+		want       bool
+	}{
+		{start: day(9, 30), end: day(11, 0), want: true},
+		{start: day(16, 30), end: day(18, 0), want: true},
+		{start: day(9, 0), end: day(10, 0), want: false},
+		{start: day(17, 30), end: day(18, 30), want: false},
+	}
+	for _, tt := range tests {
+		if got := hours.Contains(tt.start, tt.end); got != tt.want {
+			t.Errorf("Contains(%s-%s) = %v, want %v", tt.start.Format("15:04"), tt.end.Format("15:04"), got, tt.want)
+		}
+	}
+
+	branch.CloseTime = branch.OpenTime
+	if _, err := BranchOpenHours(branch); err == nil {
+		t.Fatal("expected an error when the branch closes before it opens")
+	}
+}
+
+func TestBuildMakeupSession(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	start := time.Date(2025, 10, 13, 17, 0, 0, 0, loc)
+	end := start.Add(90 * time.Minute)
+	teacherID, roomID := uint(8), uint(2)
+	original := models.Schedule_Sessions{
+		ScheduleID: 5, Start_time: &start, End_time: &end,
+		Session_number: 6, Week_number: 3, AssignedTeacherID: &teacherID, RoomID: &roomID,
+	}
+	original.ID = 30
+
+	makeup, err := buildMakeupSession(original, time.Date(2025, 10, 20, 0, 0, 0, 0, loc), "17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !makeup.Start_time.Equal(start.AddDate(0, 0, 7)) || makeup.End_time.Sub(*makeup.Start_time) != 90*time.Minute {
+		t.Fatalf("makeup time = %v - %v", makeup.Start_time, makeup.End_time)
+	}
+	if !makeup.Is_makeup || *makeup.Makeup_for_session_id != 30 || makeup.Session_number != 6 || makeup.ScheduleID != 5 {
+		t.Fatalf("unexpected makeup %+v", makeup)
+	}
+	if *makeup.AssignedTeacherID != teacherID || *makeup.RoomID != roomID || makeup.Status != "scheduled" {
+		t.Fatalf("makeup teacher/room/status = %v/%v/%s", *makeup.AssignedTeacherID, *makeup.RoomID, makeup.Status)
+	}
+
+	if _, err := buildMakeupSession(original, start, "5pm"); !errors.Is(err, ErrMakeupInvalid) {
+		t.Fatalf("expected ErrMakeupInvalid for a bad time, got %v", err)
+	}
+}
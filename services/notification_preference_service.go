@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFirstReminderMinutes  = 1440
+	defaultSecondReminderMinutes = 60
+	defaultThirdReminderMinutes  = 15
+	defaultReminderCount         = 2
+
+	// maxReminderMinutes caps how far ahead a reminder may fire (7 days)
+	maxReminderMinutes = 7 * 24 * 60
+)
+
+// NotificationPreferenceService manages per-user schedule reminder preferences
+type NotificationPreferenceService struct{}
+
+// UpdateNotificationPreferenceInput describes the fields that can be updated
+type UpdateNotificationPreferenceInput struct {
+	EnableScheduleReminders *bool `json:"enable_schedule_reminders"`
+	FirstReminderMinutes    *int  `json:"first_reminder_minutes"`
+	SecondReminderMinutes   *int  `json:"second_reminder_minutes"`
+	ThirdReminderMinutes    *int  `json:"third_reminder_minutes"`
+	ReminderCount           *int  `json:"reminder_count"`
+}
+
+// NotificationPreferenceDTO is the API representation of reminder preferences
+type NotificationPreferenceDTO struct {
+	UserID                  uint  `json:"user_id"`
+	EnableScheduleReminders bool  `json:"enable_schedule_reminders"`
+	FirstReminderMinutes    int   `json:"first_reminder_minutes"`
+	SecondReminderMinutes   int   `json:"second_reminder_minutes"`
+	ThirdReminderMinutes    int   `json:"third_reminder_minutes"`
+	ReminderCount           int   `json:"reminder_count"`
+	ReminderOffsets         []int `json:"reminder_offsets"` // effective minutes-before list used by the scanner
+	IsDefault               bool  `json:"is_default"`
+}
+
+// NewNotificationPreferenceService creates a new service instance
+func NewNotificationPreferenceService() *NotificationPreferenceService {
+	return &NotificationPreferenceService{}
+}
+
+// DefaultNotificationPreference returns the defaults applied to users without a stored record
+func DefaultNotificationPreference(userID uint) models.NotificationPreference {
+	first, second, third := defaultFirstReminderMinutes, defaultSecondReminderMinutes, defaultThirdReminderMinutes
+	return models.NotificationPreference{
+		UserID:                  userID,
+		EnableScheduleReminders: true,
+		FirstReminderMinutes:    &first,
+		SecondReminderMinutes:   &second,
+		ThirdReminderMinutes:    &third,
+		ReminderCount:           defaultReminderCount,
+	}
+}
+
+// Get returns the stored preference or the defaults; the bool reports whether a record exists
+func (s *NotificationPreferenceService) Get(userID uint) (*models.NotificationPreference, bool, error) {
+	var pref models.NotificationPreference
+	err := database.DB.Where("user_id = ?", userID).First(&pref).Error
+	if err == nil {
+		return &pref, true, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		def := DefaultNotificationPreference(userID)
+		return &def, false, nil
+	}
+	return nil, false, err
+}
+
+// GetForUsers loads preferences for many users at once, filling in defaults for missing records
+func (s *NotificationPreferenceService) GetForUsers(userIDs []uint) (map[uint]models.NotificationPreference, error) {
+	out := make(map[uint]models.NotificationPreference, len(userIDs))
+	if len(userIDs) == 0 {
+		return out, nil
+	}
+	var prefs []models.NotificationPreference
+	if err := database.DB.Where("user_id IN ?", userIDs).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range prefs {
+		out[p.UserID] = p
+	}
+	for _, id := range userIDs {
+		if _, ok := out[id]; !ok {
+			out[id] = DefaultNotificationPreference(id)
+		}
+	}
+	return out, nil
+}
+
+// Upsert creates or updates the user's preference after validation
+func (s *NotificationPreferenceService) Upsert(userID uint, input UpdateNotificationPreferenceInput) (*models.NotificationPreference, error) {
+	pref, exists, err := s.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.EnableScheduleReminders != nil {
+		pref.EnableScheduleReminders = *input.EnableScheduleReminders
+	}
+	for _, f := range []struct {
+		name  string
+		value *int
+		dst   **int
+	}{
+		{"first_reminder_minutes", input.FirstReminderMinutes, &pref.FirstReminderMinutes},
+		{"second_reminder_minutes", input.SecondReminderMinutes, &pref.SecondReminderMinutes},
+		{"third_reminder_minutes", input.ThirdReminderMinutes, &pref.ThirdReminderMinutes},
+	} {
+		if f.value == nil {
+			continue
+		}
+		if *f.value < 1 || *f.value > maxReminderMinutes {
+			return nil, validationError(fmt.Sprintf("%s must be between 1 and %d", f.name, maxReminderMinutes))
+		}
+		v := *f.value
+		*f.dst = &v
+	}
+	if input.ReminderCount != nil {
+		if *input.ReminderCount < 1 || *input.ReminderCount > 3 {
+			return nil, validationError("reminder_count must be between 1 and 3")
+		}
+		pref.ReminderCount = *input.ReminderCount
+	}
+
+	if !exists {
+		if err := database.DB.Create(pref).Error; err != nil {
+			return nil, err
+		}
+		// GORM skips zero values for columns with a DB default, so persist "disabled" explicitly
+		if !pref.EnableScheduleReminders {
+			if err := database.DB.Model(pref).Update("enable_schedule_reminders", false).Error; err != nil {
+				return nil, err
+			}
+		}
+		return pref, nil
+	}
+	// Select all columns so that disabling reminders (false) is persisted as well
+	if err := database.DB.Model(pref).
+		Select("enable_schedule_reminders", "first_reminder_minutes", "second_reminder_minutes", "third_reminder_minutes", "reminder_count").
+		Updates(pref).Error; err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// Reset deletes the stored preference so the defaults apply again
+func (s *NotificationPreferenceService) Reset(userID uint) error {
+	return database.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.NotificationPreference{}).Error
+}
+
+// BuildDTO converts a preference into its API representation
+func (s *NotificationPreferenceService) BuildDTO(pref *models.NotificationPreference, exists bool) NotificationPreferenceDTO {
+	def := DefaultNotificationPreference(pref.UserID)
+	pick := func(v, fallback *int) int {
+		if v != nil {
+			return *v
+		}
+		return *fallback
+	}
+	offsets := ReminderOffsets(pref)
+	if offsets == nil {
+		offsets = []int{}
+	}
+	return NotificationPreferenceDTO{
+		UserID:                  pref.UserID,
+		EnableScheduleReminders: pref.EnableScheduleReminders,
+		FirstReminderMinutes:    pick(pref.FirstReminderMinutes, def.FirstReminderMinutes),
+		SecondReminderMinutes:   pick(pref.SecondReminderMinutes, def.SecondReminderMinutes),
+		ThirdReminderMinutes:    pick(pref.ThirdReminderMinutes, def.ThirdReminderMinutes),
+		ReminderCount:           pref.ReminderCount,
+		ReminderOffsets:         offsets,
+		IsDefault:               !exists,
+	}
+}
+
+// ReminderOffsets returns the distinct minutes-before values (largest first) a user wants to be
+// reminded at, honoring ReminderCount. Disabled preferences yield no offsets.
+func ReminderOffsets(pref *models.NotificationPreference) []int {
+	if pref == nil || !pref.EnableScheduleReminders {
+		return nil
+	}
+	count := pref.ReminderCount
+	if count < 1 {
+		count = 1
+	}
+	if count > 3 {
+		count = 3
+	}
+	candidates := []*int{pref.FirstReminderMinutes, pref.SecondReminderMinutes, pref.ThirdReminderMinutes}[:count]
+	seen := make(map[int]struct{}, count)
+	offsets := make([]int, 0, count)
+	for _, m := range candidates {
+		if m == nil || *m <= 0 {
+			continue
+		}
+		if _, ok := seen[*m]; ok {
+			continue
+		}
+		seen[*m] = struct{}{}
+		offsets = append(offsets, *m)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	return offsets
+}
@@ -3,7 +3,6 @@ package services
 import (
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -29,10 +28,17 @@ func NewNotificationScheduler() *NotificationScheduler {
 	}
 }
 
+const (
+	// upcomingReminderTick รอบการสแกน reminder ก่อนเรียน
+	upcomingReminderTick = 5 * time.Minute
+	// upcomingReminderLookback เผื่อรอบที่พลาด (restart / tick ช้า); dedupe key ทำให้ไม่ยิงซ้ำ
+	upcomingReminderLookback = 15 * time.Minute
+)
+
 // StartScheduler เริ่มต้น scheduler สำหรับ notification
 func (ns *NotificationScheduler) StartScheduler() {
-	// ตั้งค่าให้ทำงานทุก 15 นาที
-	ticker := time.NewTicker(15 * time.Minute)
+	// ตั้งค่าให้ทำงานทุก 5 นาที
+	ticker := time.NewTicker(upcomingReminderTick)
 	defer ticker.Stop()
 
 	fmt.Println("Notification scheduler started...")
@@ -43,31 +49,27 @@ func (ns *NotificationScheduler) StartScheduler() {
 }
 
 // CheckUpcomingSessions ตรวจสอบ sessions ที่จะเกิดขึ้นเร็วๆ นี้
-// อิง incoming: ยิงแจ้งเตือนแบบเป็นช่วงเวลา และกันยิงซ้ำด้วย hasNotificationBeenSent
-// เพิ่มเติมจาก current: รองรับแจ้งเตือน 5 นาทีด้วย
+// เวลาเตือนคำนวณต่อผู้รับจาก NotificationPreference (ผู้ที่ไม่มี record ใช้ค่า default)
+// reminder ของ offset หนึ่งจะถูกส่งเมื่อ start_time - offset อยู่ในช่วง (now - lookback, now]
 func (ns *NotificationScheduler) CheckUpcomingSessions() {
 	now := time.Now()
 
-	// กำหนดช่วงเวลาที่จะแจ้งเตือน (5, 30, 60 นาที)
-	notificationTimes := []struct {
-		minutes int
-		label   string
-	}{
-		{5, "5 minutes"},
-		{30, "30 minutes"},
-		{60, "1 hour"},
+	offsets, err := ns.activeReminderOffsets()
+	if err != nil {
+		fmt.Printf("Error loading reminder offsets: %v\n", err)
+		return
 	}
 
-	for _, nt := range notificationTimes {
-		targetTime := now.Add(time.Duration(nt.minutes) * time.Minute)
-
-		// หา sessions ที่จะเริ่มในช่วงเวลาที่กำหนด (±5 นาที เพื่อเผื่อสั่นนาฬิกา/สโครล cron)
-		startRange := targetTime.Add(-5 * time.Minute)
-		endRange := targetTime.Add(5 * time.Minute)
+	for _, minutes := range offsets {
+		windowEnd := now.Add(time.Duration(minutes) * time.Minute)
+		windowStart := windowEnd.Add(-upcomingReminderLookback)
+		if windowStart.Before(now) {
+			windowStart = now
+		}
 
 		var sessions []models.Schedule_Sessions
 		err := ns.db.
-			Where("start_time BETWEEN ? AND ? AND status = ?", startRange, endRange, "scheduled").
+			Where("start_time > ? AND start_time <= ? AND status = ?", windowStart, windowEnd, "scheduled").
 			Find(&sessions).Error
 		if err != nil {
 			fmt.Printf("Error checking upcoming sessions: %v\n", err)
@@ -75,79 +77,73 @@ func (ns *NotificationScheduler) CheckUpcomingSessions() {
 		}
 
 		for _, session := range sessions {
-			// ให้ฟังก์ชันส่งแจ้งเตือนเป็นผู้รับผิดชอบการกันซ้ำ (หลังดึง schedule แล้ว)
-			ns.sendUpcomingClassNotification(session, nt.minutes, nt.label)
+			ns.sendUpcomingClassNotification(session, minutes)
 		}
 	}
 }
 
-// hasNotificationBeenSent ตรวจสอบว่าได้ส่ง notification แล้วหรือยัง
-// ใช้ข้อความภาษาอังกฤษเป็น anchor แบบ incoming เพื่อความสม่ำเสมอ
-func (ns *NotificationScheduler) hasNotificationBeenSent(scheduleName, timeLabel, startAt string) bool {
-	// ใช้ anchor 3 ส่วน: ชื่อคลาส, ช่วงเวลา (เช่น 5 minutes/1 hour), และเวลาเริ่ม HH:MM
-	// จำกัดช่วงเวลา 3 ชั่วโมงเพื่อกันซ้ำรอบๆ cron windows
-	var count int64
-	cutoff := time.Now().Add(-3 * time.Hour)
-	// ตัวอย่างข้อความ: Your class 'ABC' will start in 1 hour at 14:30
-	if err := ns.db.Model(&models.Notification{}).
-		Where("message LIKE ?", fmt.Sprintf("%%class '%s'%%", scheduleName)).
-		Where("message LIKE ?", fmt.Sprintf("%%will start in %s%%", timeLabel)).
-		Where("message LIKE ?", fmt.Sprintf("%%at %s%%", startAt)).
-		Where("created_at > ?", cutoff).
-		Count(&count).Error; err != nil {
-		// หาก query มีปัญหา ให้ถือว่ายังไม่ส่ง (เพื่อไม่บล็อกการแจ้งเตือนโดยไม่ตั้งใจ)
-		return false
-	}
-	return count > 0
-}
-
-// sendUpcomingClassNotification ส่ง notification สำหรับ class ที่จะเริ่มเร็วๆ นี้
-// อิง incoming เป็นหลัก: ดึงผู้เรียนจาก group/participants, เลือกครูจาก AssignedTeacherID/DefaultTeacherID
-// เพิ่มเติมความเข้ากันได้กับ current: เผื่อกรณี schedule.AssignedToUserID (สคีมาเก่า)
-func (ns *NotificationScheduler) sendUpcomingClassNotification(session models.Schedule_Sessions, minutes int, timeLabel string) {
-	// ดึงข้อมูล schedule
-	var schedule models.Schedules
-	if err := ns.db.Preload("Group.Course").First(&schedule, session.ScheduleID).Error; err != nil {
-		fmt.Printf("Error fetching schedule for session %d: %v\n", session.ID, err)
-		return
+// activeReminderOffsets รวม offset (นาที) ทั้งหมดที่ผู้ใช้ตั้งไว้ + ค่า default เพื่อลดจำนวน query ต่อรอบ
+func (ns *NotificationScheduler) activeReminderOffsets() ([]int, error) {
+	def := DefaultNotificationPreference(0)
+	seen := map[int]struct{}{}
+	for _, m := range ReminderOffsets(&def) {
+		seen[m] = struct{}{}
+	}
+	for _, col := range []string{"first_reminder_minutes", "second_reminder_minutes", "third_reminder_minutes"} {
+		var vals []int
+		if err := ns.db.Model(&models.NotificationPreference{}).
+			Where("enable_schedule_reminders = ? AND "+col+" IS NOT NULL", true).
+			Distinct().
+			Pluck(col, &vals).Error; err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			if v > 0 {
+				seen[v] = struct{}{}
+			}
+		}
+	}
+	offsets := make([]int, 0, len(seen))
+	for m := range seen {
+		offsets = append(offsets, m)
 	}
+	return offsets, nil
+}
 
-	// กันยิงซ้ำ: ตรวจจากข้อความภาษาอังกฤษที่เราจะส่งจริง ๆ
-	startHHMM := ""
+// UpcomingReminderDedupeKey คือ key กันซ้ำของ reminder ก่อนเรียน ใช้ร่วมกันทั้ง scheduler และ job queue
+// เช่น "session:123:reminder:30m:1760000000" (service ต่อท้าย ":user:<id>" ให้ต่อผู้รับ)
+// มีเวลาเริ่ม (unix) อยู่ใน key เพื่อให้ session ที่ถูกเลื่อนเวลาได้รับการเตือนใหม่
+func UpcomingReminderDedupeKey(session models.Schedule_Sessions, minutes int) string {
+	var start int64
 	if session.Start_time != nil {
-		startHHMM = session.Start_time.Format("15:04")
-	}
-	if ns.hasNotificationBeenSent(schedule.ScheduleName, timeLabel, startHHMM) {
-		return
+		start = session.Start_time.Unix()
 	}
+	return fmt.Sprintf("session:%d:reminder:%dm:%d", session.ID, minutes, start)
+}
 
-	// ดึงรายชื่อผู้เข้าร่วม
-	var users []models.User
+// sessionRecipientIDs รวมผู้รับแจ้งเตือนของ session: สมาชิกกลุ่ม/participants และครูที่ถูก assign
+func (ns *NotificationScheduler) sessionRecipientIDs(session models.Schedule_Sessions, schedule models.Schedules) []uint {
+	var userIDs []uint
 
 	// ถ้าเป็นคลาสแบบ Group -> ดึงจากสมาชิกกลุ่ม
 	if schedule.GroupID != nil {
 		var groupMembers []models.GroupMember
-		if err := ns.db.Preload("Student.User").Where("group_id = ?", *schedule.GroupID).Find(&groupMembers).Error; err != nil {
+		if err := ns.db.Preload("Student").Where("group_id = ?", *schedule.GroupID).Find(&groupMembers).Error; err != nil {
 			fmt.Printf("Error fetching group members for group %d: %v\n", *schedule.GroupID, err)
-			return
 		}
 		for _, member := range groupMembers {
 			if member.Student.UserID != nil {
-				var u models.User
-				if err := ns.db.First(&u, *member.Student.UserID).Error; err == nil {
-					users = append(users, u)
-				}
+				userIDs = append(userIDs, *member.Student.UserID)
 			}
 		}
 	} else {
 		// ถ้าเป็น event/appointment -> ดึงจาก participants
 		var participants []models.ScheduleParticipant
-		if err := ns.db.Preload("User").Where("schedule_id = ?", schedule.ID).Find(&participants).Error; err != nil {
+		if err := ns.db.Where("schedule_id = ?", schedule.ID).Find(&participants).Error; err != nil {
 			fmt.Printf("Error fetching participants for schedule %d: %v\n", schedule.ID, err)
-			return
 		}
 		for _, p := range participants {
-			users = append(users, p.User)
+			userIDs = append(userIDs, p.UserID)
 		}
 	}
 
@@ -157,67 +153,107 @@ func (ns *NotificationScheduler) sendUpcomingClassNotification(session models.Sc
 		teacherID = schedule.DefaultTeacherID
 	}
 	if teacherID != nil {
-		var teacher models.User
-		if err := ns.db.First(&teacher, *teacherID).Error; err == nil {
-			users = append(users, teacher)
+		userIDs = append(userIDs, *teacherID)
+	}
+
+	// dedupe ผู้รับเพื่อกันยิงซ้ำต่อคน
+	unique := make(map[uint]struct{}, len(userIDs))
+	out := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		if _, ok := unique[id]; ok || id == 0 {
+			continue
 		}
+		unique[id] = struct{}{}
+		out = append(out, id)
 	}
+	return out
+}
 
-	// หมายเหตุ: ตัดการรองรับฟิลด์ legacy schedule.AssignedToUserID เนื่องจากไม่มีในโมเดลปัจจุบัน
+// sendUpcomingClassNotification ส่ง notification ให้ผู้รับที่ตั้ง reminder ไว้ที่ minutes นาทีก่อนเริ่ม
+func (ns *NotificationScheduler) sendUpcomingClassNotification(session models.Schedule_Sessions, minutes int) {
+	// ดึงข้อมูล schedule
+	var schedule models.Schedules
+	if err := ns.db.Preload("Group.Course").First(&schedule, session.ScheduleID).Error; err != nil {
+		fmt.Printf("Error fetching schedule for session %d: %v\n", session.ID, err)
+		return
+	}
 
-	// ส่ง notification ผ่าน service (รองรับ queue และ websocket broadcast)
-	if len(users) > 0 {
-		// dedupe ผู้รับเพื่อกันยิงซ้ำต่อคน
-		unique := make(map[uint]struct{}, len(users))
-		userIDs := make([]uint, 0, len(users))
-		for _, u := range users {
-			if _, ok := unique[u.ID]; !ok {
-				unique[u.ID] = struct{}{}
-				userIDs = append(userIDs, u.ID)
+	recipients := ns.sessionRecipientIDs(session, schedule)
+	if len(recipients) == 0 {
+		return
+	}
+
+	prefs, err := NewNotificationPreferenceService().GetForUsers(recipients)
+	if err != nil {
+		fmt.Printf("Error loading notification preferences for session %d: %v\n", session.ID, err)
+		return
+	}
+
+	// เลือกเฉพาะผู้ที่ต้องการเตือนที่ offset นี้
+	userIDs := make([]uint, 0, len(recipients))
+	for _, id := range recipients {
+		pref := prefs[id]
+		for _, m := range ReminderOffsets(&pref) {
+			if m == minutes {
+				userIDs = append(userIDs, id)
+				break
 			}
 		}
+	}
+	if len(userIDs) == 0 {
+		return
+	}
 
-		startLabel := ""
-		if session.Start_time != nil {
-			startLabel = session.Start_time.Format("15:04")
-		}
-		title := "Upcoming Class"
-		titleTh := "เรียนจะเริ่มเร็วๆ นี้"
-		msg := fmt.Sprintf("Your class '%s' will start in %s at %s",
-			schedule.ScheduleName, timeLabel, startLabel)
-		msgTh := fmt.Sprintf("คลาส '%s' ของคุณจะเริ่มในอีก %s เวลา %s",
-			schedule.ScheduleName, ns.translateTimeLabel(timeLabel), startLabel)
-
-		data := map[string]interface{}{
-			"link": map[string]interface{}{
-				"href":   fmt.Sprintf("/api/schedules/sessions/%d", session.ID),
-				"method": "GET",
-			},
-			"action":                  "open-session",
-			"session_id":              session.ID,
-			"schedule_id":             schedule.ID,
-			"reminder_before_minutes": minutes,
-		}
-		q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "info", data, "normal", "popup")
-		if err := ns.ns.EnqueueOrCreate(userIDs, q); err != nil {
-			fmt.Printf("Error creating notifications for session %d: %v\n", session.ID, err)
-		}
+	startLabel := ""
+	if session.Start_time != nil {
+		startLabel = session.Start_time.Format("15:04")
 	}
+	timeLabel, timeLabelTh := reminderTimeLabels(minutes)
 
-	fmt.Printf("Sent upcoming class notifications for session %d (%s before)\n", session.ID, timeLabel)
+	// ส่ง notification ผ่าน service (รองรับ queue และ websocket broadcast)
+	title := "Upcoming Class"
+	titleTh := "เรียนจะเริ่มเร็วๆ นี้"
+	msg := fmt.Sprintf("Your class '%s' will start in %s at %s",
+		schedule.ScheduleName, timeLabel, startLabel)
+	msgTh := fmt.Sprintf("คลาส '%s' ของคุณจะเริ่มในอีก %s เวลา %s",
+		schedule.ScheduleName, timeLabelTh, startLabel)
+
+	data := map[string]interface{}{
+		"link": map[string]interface{}{
+			"href":   fmt.Sprintf("/api/schedules/sessions/%d", session.ID),
+			"method": "GET",
+		},
+		"action":                  "open-session",
+		"session_id":              session.ID,
+		"schedule_id":             schedule.ID,
+		"reminder_before_minutes": minutes,
+	}
+	// กันยิงซ้ำด้วย dedupe key (รอบ scan ที่ซ้อนกัน / หลาย replica / job queue ส่งไปแล้ว)
+	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "info", data, "normal", "popup").
+		WithDedupeKey(UpcomingReminderDedupeKey(session, minutes))
+	if err := ns.ns.EnqueueOrCreate(userIDs, q); err != nil {
+		fmt.Printf("Error creating notifications for session %d: %v\n", session.ID, err)
+		return
+	}
+
+	fmt.Printf("Queued upcoming class notifications for session %d to %d users (%s before)\n", session.ID, len(userIDs), timeLabel)
 }
 
-// translateTimeLabel แปลงเวลาเป็นภาษาไทย
-func (ns *NotificationScheduler) translateTimeLabel(timeLabel string) string {
-	switch timeLabel {
-	case "5 minutes":
-		return "5 นาที"
-	case "30 minutes":
-		return "30 นาที"
-	case "1 hour":
-		return "1 ชั่วโมง"
+// reminderTimeLabels แปลงจำนวนนาทีเป็นข้อความ (อังกฤษ, ไทย) เช่น 60 -> "1 hour", "1 ชั่วโมง"
+func reminderTimeLabels(minutes int) (string, string) {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case minutes >= 1440 && minutes%1440 == 0:
+		return plural(minutes/1440, "day"), fmt.Sprintf("%d วัน", minutes/1440)
+	case minutes >= 60 && minutes%60 == 0:
+		return plural(minutes/60, "hour"), fmt.Sprintf("%d ชั่วโมง", minutes/60)
 	default:
-		return timeLabel
+		return plural(minutes, "minute"), fmt.Sprintf("%d นาที", minutes)
 	}
 }
 
@@ -287,16 +323,17 @@ func (ns *NotificationScheduler) SendDailyScheduleReminder() {
 		}
 	}
 
-	// ส่ง notification สำหรับแต่ละ user
+	// ส่ง notification สำหรับแต่ละ user (วันละครั้ง แม้ cron/startup จะเรียกซ้ำ)
+	day := today.Format("2006-01-02")
 	for userID, ss := range userSessions {
 		if len(ss) > 0 {
-			ns.sendDailyReminderNotification(userID, ss)
+			ns.sendDailyReminderNotification(userID, ss, day)
 		}
 	}
 }
 
 // sendDailyReminderNotification ส่ง notification สรุปตารางเรียนประจำวัน
-func (ns *NotificationScheduler) sendDailyReminderNotification(userID uint, sessions []models.Schedule_Sessions) {
+func (ns *NotificationScheduler) sendDailyReminderNotification(userID uint, sessions []models.Schedule_Sessions, day string) {
 	messageEn := "Today's schedule:\n"
 	messageTh := "ตารางเรียนวันนี้:\n"
 
@@ -312,7 +349,8 @@ func (ns *NotificationScheduler) sendDailyReminderNotification(userID uint, sess
 	data := map[string]interface{}{
 		"action": "open-today-schedule",
 	}
-	q := notifsvc.QueuedWithData("Daily Schedule Reminder", "เตือนตารางเรียนประจำวัน", messageEn, messageTh, "info", data, "normal", "popup")
+	q := notifsvc.QueuedWithData("Daily Schedule Reminder", "เตือนตารางเรียนประจำวัน", messageEn, messageTh, "info", data, "normal", "popup").
+		WithDedupeKey("daily-schedule:" + day)
 	if err := ns.ns.EnqueueOrCreate([]uint{userID}, q); err != nil {
 		fmt.Printf("Error creating daily reminder for user %d: %v\n", userID, err)
 	}
@@ -324,12 +362,25 @@ func (ns *NotificationScheduler) CheckMissedSessions() {
 	pastTime := now.Add(-30 * time.Minute) // ตรวจสอบ sessions ที่ผ่านมา 30 นาที
 
 	// อัพเดท sessions ที่ได้รับการยืนยันแล้วและจบไปเป็น completed
-	if tx := ns.db.Model(&models.Schedule_Sessions{}).
+	var finishedIDs []uint
+	ns.db.Model(&models.Schedule_Sessions{}).
 		Where("end_time IS NOT NULL AND end_time <= ? AND status = ?", now, "confirmed").
-		Update("status", "completed"); tx.Error != nil {
-		fmt.Printf("Error marking confirmed sessions as completed: %v\n", tx.Error)
-	} else if tx.RowsAffected > 0 {
-		fmt.Printf("Marked %d confirmed sessions as completed\n", tx.RowsAffected)
+		Pluck("id", &finishedIDs)
+	if len(finishedIDs) > 0 {
+		if tx := ns.db.Model(&models.Schedule_Sessions{}).
+			Where("id IN ? AND status = ?", finishedIDs, "confirmed").
+			Update("status", "completed"); tx.Error != nil {
+			fmt.Printf("Error marking confirmed sessions as completed: %v\n", tx.Error)
+		} else {
+			fmt.Printf("Marked %d confirmed sessions as completed\n", tx.RowsAffected)
+			// ตัดชั่วโมงเรียนของนักเรียนใน session ที่จบแล้ว
+			hours := NewLearningHoursService()
+			for _, id := range finishedIDs {
+				if _, err := hours.PostSessionConsumption(id); err != nil {
+					fmt.Printf("Error posting learning hours for session %d: %v\n", id, err)
+				}
+			}
+		}
 	}
 
 	var sessions []models.Schedule_Sessions
@@ -387,7 +438,8 @@ func (ns *NotificationScheduler) sendMissedSessionNotification(session models.Sc
 		"session_id":  session.ID,
 		"schedule_id": session.ScheduleID,
 	}
-	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "warning", data, "normal", "popup")
+	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "warning", data, "normal", "popup").
+		WithDedupeKey(fmt.Sprintf("session:%d:missed", session.ID))
 	if err := ns.ns.EnqueueOrCreate(userIDs, q); err != nil {
 		fmt.Printf("Error creating missed-session notifications: %v\n", err)
 	}
@@ -429,7 +481,6 @@ func (ns *NotificationScheduler) sendDailyLineGroupReminders() {
 	if err := db.
 		Preload("Schedule").
 		Preload("Schedule.Group").
-		Preload("Schedule.DefaultTeacher.Teacher").
 		Where("session_date BETWEEN ? AND ?", startOfTomorrow, endOfTomorrow).
 		//Where("status IN ?", []string{"scheduled", "confirmed"}).
 		Order("start_time ASC").
@@ -445,6 +496,7 @@ func (ns *NotificationScheduler) sendDailyLineGroupReminders() {
 	}
 
 	lineSvc := NewLineMessagingService()
+	templates := NewLineTemplateService()
 
 	for _, sess := range sessions {
 		if sess.Schedule == nil || sess.Schedule.Group == nil {
@@ -460,56 +512,20 @@ func (ns *NotificationScheduler) sendDailyLineGroupReminders() {
 			continue
 		}
 
-		teacherName := "-"
-		if sess.Schedule.DefaultTeacher != nil && sess.Schedule.DefaultTeacher.Teacher != nil {
-			t := sess.Schedule.DefaultTeacher.Teacher
-			if t.NicknameEn != "" {
-				teacherName = fmt.Sprintf("T.%s", t.NicknameEn)
-			}
-		} else if sess.Schedule.DefaultTeacher != nil {
-			// Fallback to User.username or phone if Teacher profile missing
-			if sess.Schedule.DefaultTeacher.Username != "" {
-				teacherName = sess.Schedule.DefaultTeacher.Username
-			}
-		}
-
-		// ✅ format เวลาเรียน
-		start := ""
-		end := ""
-		if sess.Start_time != nil {
-			start = sess.Start_time.Format("15:04")
-		}
-		if sess.End_time != nil {
-			end = sess.End_time.Format("15:04")
+		// Flex + text สำรองจาก template (แก้ไขได้ที่ /api/line/templates) ค่าจากข้อมูลจริงของสาขา/ห้อง/ครู
+		rendered, err := templates.Render(LineTemplateDailyClassReminder, DailyClassReminderVars(db, sess, *sess.Schedule, *sess.Schedule.Group))
+		if err != nil {
+			log.Printf("❌ Failed to render daily reminder for session %d: %v", sess.ID, err)
+			continue
 		}
 
-		// Hardcode branch/room ชั่วครัว
-		//branch := "สาขาโคราช"
-
-		// ✅ ดึงสาขาจาก ScheduleName
-		branch := "-"
-		if sess.Schedule.ScheduleName != "" {
-			parts := strings.SplitN(sess.Schedule.ScheduleName, "-", 2) // แบ่งออกเป็น 2 ส่วนเท่านั้น
-			if len(parts) > 0 {
-				branch = strings.TrimSpace(parts[0]) // เอาส่วนก่อนขีดแรก + trim space
-			}
+		err = lineSvc.PushMessages(lineGroup.GroupID, rendered.Message())
+		if err != nil && rendered.Flex != nil {
+			// LINE ปฏิเสธ Flex (เช่น uri ไม่ถูกต้อง): ส่ง text แทน
+			log.Printf("⚠️ Flex reminder rejected for group '%s', sending text: %v", lineGroup.GroupName, err)
+			err = lineSvc.SendLineMessageToGroup(lineGroup.GroupID, rendered.Text)
 		}
-		room := ""
-		AbsenceLink := "https://www.englishkorat.site/students/absence"
-
-		msg := fmt.Sprintf("📢 แจ้งเตือนตารางเรียนพรุ่งนี้\nกลุ่ม: %s\nสาขา: %s\nคลาส: %s\nเวลา: %s - %s\nครู: %s\nห้องเรียน: %s\nกรณีแจ้งลา กรุณาแจ้งลาผ่านระบบล่วงหน้าก่อนวันเรียน และภายใน 18.00 น.\nหากแจ้งหลังจากนี้ ระบบจะหักชั่วโมงเรียนอัตโนมัติ\nแจ้งลาที่นี่: %s",
-
-			sess.Schedule.Group.GroupName,
-			branch,
-			sess.Schedule.ScheduleName,
-			start,
-			end,
-			teacherName,
-			room,
-			AbsenceLink,
-		)
-
-		if err := lineSvc.SendLineMessageToGroup(lineGroup.GroupID, msg); err != nil {
+		if err != nil {
 			log.Printf("❌ Failed to send message to group '%s': %v", lineGroup.GroupName, err)
 		} else {
 			log.Printf("✅ Sent reminder to LineGroup '%s' (%s)", lineGroup.GroupName, lineGroup.GroupID)
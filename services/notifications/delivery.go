@@ -0,0 +1,333 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+// Delivery statuses of external channels
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliverySkipped = "skipped"
+)
+
+// External channels
+const (
+	ChannelEmail = "email" // SMTP
+	ChannelLine  = "line"  // LINE push to the user's linked account (Deliverer registered by package services)
+)
+
+const (
+	deliveryMaxAttempts  = 3
+	deliveryLease        = 5 * time.Minute
+	deliveryPollInterval = 30 * time.Second
+	deliveryBatchSize    = 50
+	deliveryBaseBackoff  = time.Minute
+	deliveryMaxBackoff   = time.Hour
+)
+
+var (
+	// ErrDeliverySkipped is returned (wrapped) by a Deliverer that decides not to send, e.g. a rate limit
+	ErrDeliverySkipped = errors.New("delivery skipped")
+	// ErrDeliveryPermanent is returned (wrapped) for errors a retry cannot fix, e.g. a rejected recipient
+	ErrDeliveryPermanent = errors.New("permanent delivery failure")
+)
+
+// Deliverer sends notifications over an external channel. In-app channels ("normal", "popup")
+// are delivered over WebSocket and have no Deliverer.
+type Deliverer interface {
+	// Recipient returns the address to deliver to, or a reason to skip (opted out, no address, ...)
+	Recipient(user models.User, settings models.UserSettings) (to string, skipReason string)
+	// Send delivers one notification; the returned id is stored as provider_message_id
+	Send(to string, n models.Notification, settings models.UserSettings) (string, error)
+}
+
+var (
+	deliverersMu sync.RWMutex
+	deliverers   = map[string]Deliverer{
+		ChannelEmail:     emailDeliverer{},
+		ChannelSMS:       smsDeliverer{},
+		ChannelSMSParent: smsDeliverer{parent: true},
+	}
+)
+
+// RegisterDeliverer binds a Deliverer to a channel name. Later registrations replace earlier ones.
+func RegisterDeliverer(channel string, d Deliverer) {
+	deliverersMu.Lock()
+	defer deliverersMu.Unlock()
+	deliverers[channel] = d
+}
+
+func lookupDeliverer(channel string) (Deliverer, bool) {
+	deliverersMu.RLock()
+	defer deliverersMu.RUnlock()
+	d, ok := deliverers[channel]
+	return d, ok
+}
+
+// defaultUserSettings mirrors the model defaults for users who never saved settings
+func defaultUserSettings(userID uint) models.UserSettings {
+	return models.UserSettings{UserID: userID, Language: "th", EnableInAppNotifications: true}
+}
+
+// loadDeliveryContext loads recipients and their settings once per batch
+func (s *Service) loadDeliveryContext(userIDs []uint) (map[uint]models.User, map[uint]models.UserSettings, error) {
+	var users []models.User
+	if err := s.db.Preload("Student").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+	var settings []models.UserSettings
+	if err := s.db.Where("user_id IN ?", userIDs).Find(&settings).Error; err != nil {
+		return nil, nil, err
+	}
+	userMap := make(map[uint]models.User, len(users))
+	for _, u := range users {
+		userMap[u.ID] = u
+	}
+	settingsMap := make(map[uint]models.UserSettings, len(userIDs))
+	for _, id := range userIDs {
+		settingsMap[id] = defaultUserSettings(id)
+	}
+	for _, st := range settings {
+		settingsMap[st.UserID] = st
+	}
+	return userMap, settingsMap, nil
+}
+
+// deliveryPlan holds the external deliveries of each recipient, resolved before the notifications are stored
+type deliveryPlan struct {
+	rows     map[uint][]models.NotificationDelivery
+	settings map[uint]models.UserSettings
+}
+
+// planDeliveries resolves the recipient of every external channel per user (nil when there is none)
+func (s *Service) planDeliveries(userIDs []uint, channels []string) (*deliveryPlan, error) {
+	var external []string
+	for _, ch := range channels {
+		if _, ok := lookupDeliverer(ch); ok {
+			external = append(external, ch)
+		}
+	}
+	if len(external) == 0 || len(userIDs) == 0 {
+		return nil, nil
+	}
+	users, settings, err := s.loadDeliveryContext(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	plan := &deliveryPlan{rows: make(map[uint][]models.NotificationDelivery, len(userIDs)), settings: settings}
+	for _, uid := range userIDs {
+		for _, ch := range external {
+			d, _ := lookupDeliverer(ch)
+			row := models.NotificationDelivery{UserID: uid, Channel: ch, Status: DeliveryPending, MaxAttempts: deliveryMaxAttempts}
+			user, ok := users[uid]
+			if !ok {
+				row.Status, row.LastError = DeliverySkipped, "user not found"
+			} else if to, reason := d.Recipient(user, settings[uid]); to == "" {
+				row.Status, row.LastError = DeliverySkipped, reason
+			} else {
+				row.Recipient = to
+			}
+			plan.rows[uid] = append(plan.rows[uid], row)
+		}
+	}
+	return plan, nil
+}
+
+// channelsFor falls back to the in-app list ("normal") when none of the user's external channels
+// can be delivered and the notification would otherwise be invisible
+func (p *deliveryPlan) channelsFor(userID uint, channels []string) []string {
+	if p == nil || len(p.rows[userID]) == 0 {
+		return channels
+	}
+	for _, ch := range channels {
+		if ch == "normal" || ch == "popup" {
+			return channels
+		}
+	}
+	for _, row := range p.rows[userID] {
+		if row.Status != DeliverySkipped {
+			return channels
+		}
+	}
+	return append(append([]string{}, channels...), "normal")
+}
+
+// record stores the planned rows of the created notifications and starts sending the pending ones.
+// New rows are leased to this process so the retry worker does not pick them up concurrently.
+func (s *Service) record(plan *deliveryPlan, notifs []models.Notification) error {
+	if plan == nil {
+		return nil
+	}
+	lease := time.Now().Add(deliveryLease)
+	byID := make(map[uint]models.Notification, len(notifs))
+	var rows []models.NotificationDelivery
+	for _, n := range notifs {
+		byID[n.ID] = n
+		for _, row := range plan.rows[n.UserID] {
+			row.NotificationID = n.ID
+			if row.Status == DeliveryPending {
+				row.NextAttemptAt = &lease
+			}
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if err := s.db.Create(&rows).Error; err != nil {
+		return err
+	}
+	var pending []models.NotificationDelivery
+	for _, r := range rows {
+		if r.Status == DeliveryPending {
+			pending = append(pending, r)
+		}
+	}
+	if len(pending) > 0 {
+		go func() {
+			for _, row := range pending {
+				s.deliver(row, byID[row.NotificationID], plan.settings[row.UserID])
+			}
+		}()
+	}
+	return nil
+}
+
+// deliver sends one claimed delivery and stores the outcome: sent, skipped, retry later or failed
+func (s *Service) deliver(row models.NotificationDelivery, n models.Notification, settings models.UserSettings) {
+	d, ok := lookupDeliverer(row.Channel)
+	var providerID string
+	var err error
+	if !ok {
+		err = fmt.Errorf("%w: no deliverer registered for channel %q", ErrDeliveryPermanent, row.Channel)
+	} else {
+		providerID, err = safeSend(d, row.Recipient, n, settings)
+	}
+
+	attempts := row.Attempts + 1
+	maxAttempts := row.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = deliveryMaxAttempts
+	}
+	updates := map[string]interface{}{"attempts": attempts, "next_attempt_at": nil}
+	switch {
+	case err == nil:
+		updates["status"] = DeliverySent
+		updates["last_error"] = ""
+		updates["provider_message_id"] = providerID
+		updates["sent_at"] = time.Now()
+	case errors.Is(err, ErrDeliverySkipped):
+		updates["status"] = DeliverySkipped
+		updates["last_error"] = err.Error()
+	case errors.Is(err, ErrDeliveryPermanent) || attempts >= maxAttempts:
+		log.Printf("[notif] %s delivery %d to user %d failed: %v", row.Channel, row.ID, row.UserID, err)
+		updates["status"] = DeliveryFailed
+		updates["last_error"] = err.Error()
+	default:
+		log.Printf("[notif] %s delivery %d to user %d attempt %d failed, will retry: %v", row.Channel, row.ID, row.UserID, attempts, err)
+		updates["status"] = DeliveryPending
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(deliveryBackoff(attempts))
+	}
+	if err := s.db.Model(&models.NotificationDelivery{}).Where("id = ?", row.ID).Updates(updates).Error; err != nil {
+		log.Printf("[notif] failed to record delivery %d: %v", row.ID, err)
+	}
+}
+
+// deliveryBackoff returns 1m, 2m, 4m, ... capped at one hour
+func deliveryBackoff(attempt int) time.Duration {
+	d := deliveryBaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= deliveryMaxBackoff {
+			return deliveryMaxBackoff
+		}
+	}
+	return d
+}
+
+// safeSend converts a deliverer panic into an error
+func safeSend(d Deliverer, to string, n models.Notification, settings models.UserSettings) (id string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return d.Send(to, n, settings)
+}
+
+// StartDeliveryWorker retries pending deliveries (failed attempts and rows left behind by a crash)
+func (s *Service) StartDeliveryWorker(stop <-chan struct{}) {
+	go func() {
+		log.Println("[notif] delivery retry worker started")
+		ticker := time.NewTicker(deliveryPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				log.Println("[notif] delivery retry worker stopping")
+				return
+			case <-ticker.C:
+				s.retryDueDeliveries()
+			}
+		}
+	}()
+}
+
+func (s *Service) retryDueDeliveries() {
+	if s.db == nil {
+		return
+	}
+	now := time.Now()
+	var ids []uint
+	if err := s.db.Model(&models.NotificationDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at ASC").Limit(deliveryBatchSize).
+		Pluck("id", &ids).Error; err != nil {
+		log.Printf("[notif] delivery poll failed: %v", err)
+		return
+	}
+	for _, id := range ids {
+		row, ok := s.claimDelivery(id)
+		if !ok {
+			continue
+		}
+		if row.NotificationID == 0 {
+			s.deliver(*row, storedMessage(*row), defaultUserSettings(row.UserID))
+			continue
+		}
+		var n models.Notification
+		if err := s.db.First(&n, row.NotificationID).Error; err != nil {
+			s.db.Model(&models.NotificationDelivery{}).Where("id = ?", row.ID).
+				Updates(map[string]interface{}{"status": DeliveryFailed, "last_error": "notification not found", "next_attempt_at": nil})
+			continue
+		}
+		settings := defaultUserSettings(row.UserID)
+		s.db.Where("user_id = ?", row.UserID).Limit(1).Find(&settings)
+		s.deliver(*row, n, settings)
+	}
+}
+
+// claimDelivery moves the lease forward with a conditional update; only one worker can win
+func (s *Service) claimDelivery(id uint) (*models.NotificationDelivery, bool) {
+	now := time.Now()
+	res := s.db.Model(&models.NotificationDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, DeliveryPending, now).
+		Update("next_attempt_at", now.Add(deliveryLease))
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, false
+	}
+	var row models.NotificationDelivery
+	if err := s.db.First(&row, id).Error; err != nil {
+		return nil, false
+	}
+	return &row, true
+}
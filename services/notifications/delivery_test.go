@@ -0,0 +1,39 @@
+package notifications
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestDeliveryBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 7: time.Hour, 20: time.Hour}
+	for attempt, want := range cases {
+		if got := deliveryBackoff(attempt); got != want {
+			t.Errorf("deliveryBackoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestChannelsForFallsBackToInApp(t *testing.T) {
+	plan := &deliveryPlan{rows: map[uint][]models.NotificationDelivery{
+		1: {{Channel: ChannelLine, Status: DeliverySkipped}},
+		2: {{Channel: ChannelLine, Status: DeliveryPending}},
+	}}
+	line := []string{ChannelLine}
+	if got := plan.channelsFor(1, line); !reflect.DeepEqual(got, []string{ChannelLine, "normal"}) {
+		t.Errorf("unreachable user: channels = %v, want in-app fallback", got)
+	}
+	if got := plan.channelsFor(2, line); !reflect.DeepEqual(got, line) {
+		t.Errorf("reachable user: channels = %v", got)
+	}
+	if got := plan.channelsFor(1, []string{"popup", ChannelLine}); !reflect.DeepEqual(got, []string{"popup", ChannelLine}) {
+		t.Errorf("in-app already requested: channels = %v", got)
+	}
+	var none *deliveryPlan
+	if got := none.channelsFor(1, line); !reflect.DeepEqual(got, line) {
+		t.Errorf("nil plan: channels = %v", got)
+	}
+}
@@ -0,0 +1,285 @@
+package notifications
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"englishkorat_go/config"
+	"englishkorat_go/models"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// EmailMessage is a rendered email ready to send
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// EmailSender delivers an email and returns its Message-ID
+type EmailSender interface {
+	SendEmail(msg EmailMessage) (string, error)
+}
+
+// SMTPConfig configures SMTPSender
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	FromName string
+	TLSMode  string // starttls | tls | none
+}
+
+// SMTPConfigFromApp reads the SMTP settings from config.AppConfig (ok=false when email is not configured)
+func SMTPConfigFromApp() (SMTPConfig, bool) {
+	c := config.AppConfig
+	if c == nil || strings.TrimSpace(c.SMTPHost) == "" || strings.TrimSpace(c.SMTPFrom) == "" {
+		return SMTPConfig{}, false
+	}
+	return SMTPConfig{
+		Host:     c.SMTPHost,
+		Port:     c.SMTPPort,
+		Username: c.SMTPUsername,
+		Password: c.SMTPPassword,
+		From:     c.SMTPFrom,
+		FromName: c.SMTPFromName,
+		TLSMode:  c.SMTPTLS,
+	}, true
+}
+
+// SMTPSender sends email through an SMTP server (a local stand-in such as Mailpit works with TLSMode "none")
+type SMTPSender struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPSender(cfg SMTPConfig) *SMTPSender {
+	return &SMTPSender{cfg: cfg}
+}
+
+// SendEmail opens one SMTP session per message
+func (s *SMTPSender) SendEmail(msg EmailMessage) (string, error) {
+	messageID, raw, err := buildEmailMIME(s.cfg.From, s.cfg.FromName, msg)
+	if err != nil {
+		return "", err
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, s.cfg.Port)
+	conn, err := net.DialTimeout("tcp", addr, smtpTimeout)
+	if err != nil {
+		return "", err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}
+	if s.cfg.TLSMode == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	defer client.Close()
+
+	if s.cfg.TLSMode == "" || s.cfg.TLSMode == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return "", errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return "", err
+		}
+	}
+	if s.cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := client.Mail(s.cfg.From); err != nil {
+		return "", err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return "", err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	_ = client.Quit()
+	return messageID, nil
+}
+
+// buildEmailMIME builds a multipart/alternative (text + HTML) message with UTF-8 quoted-printable bodies
+func buildEmailMIME(from, fromName string, msg EmailMessage) (string, []byte, error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	messageID := fmt.Sprintf("<%s@%s>", hex.EncodeToString(idBytes), domain)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return "", nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+
+	var out bytes.Buffer
+	sender := mail.Address{Name: fromName, Address: from}
+	headers := [][2]string{
+		{"From", sender.String()},
+		{"To", (&mail.Address{Address: msg.To}).String()},
+		{"Subject", mime.QEncoding.Encode("UTF-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary())},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&out, "%s: %s\r\n", h[0], h[1])
+	}
+	out.WriteString("\r\n")
+	out.Write(body.Bytes())
+	return messageID, out.Bytes(), nil
+}
+
+// emailSection is one language block of a notification email
+type emailSection struct {
+	Title string
+	Lines []string
+}
+
+var emailHTMLTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>{{.Subject}}</title></head>
+<body style="margin:0;padding:24px;background:#f4f5f7;font-family:Tahoma,'Noto Sans Thai',Arial,sans-serif;color:#1f2933">
+<div style="max-width:560px;margin:0 auto;background:#ffffff;border-radius:8px;padding:24px">
+{{range $i, $s := .Sections}}{{if $i}}<hr style="border:none;border-top:1px solid #e4e7eb;margin:24px 0">{{end}}
+<h2 style="margin:0 0 12px;font-size:18px">{{$s.Title}}</h2>
+<p style="margin:0;line-height:1.6">{{range $j, $l := $s.Lines}}{{if $j}}<br>{{end}}{{$l}}{{end}}</p>
+{{end}}
+</div>
+<p style="text-align:center;font-size:12px;color:#7b8794">English Korat</p>
+</body></html>`))
+
+// renderEmail renders a bilingual notification: the user's language first, the other one below (when different)
+func renderEmail(to string, n models.Notification, lang string) (EmailMessage, error) {
+	pick := func(v, other string) string {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+		return other
+	}
+	th := emailSection{Title: pick(n.TitleTh, n.Title), Lines: strings.Split(pick(n.MessageTh, n.Message), "\n")}
+	en := emailSection{Title: pick(n.Title, n.TitleTh), Lines: strings.Split(pick(n.Message, n.MessageTh), "\n")}
+
+	sections := []emailSection{th, en}
+	if lang == "en" {
+		sections = []emailSection{en, th}
+	}
+	if sections[0].Title == sections[1].Title && strings.Join(sections[0].Lines, "\n") == strings.Join(sections[1].Lines, "\n") {
+		sections = sections[:1]
+	}
+
+	var text strings.Builder
+	for i, s := range sections {
+		if i > 0 {
+			text.WriteString("\n----------------------------------------\n\n")
+		}
+		text.WriteString(s.Title + "\n\n" + strings.Join(s.Lines, "\n") + "\n")
+	}
+
+	var html bytes.Buffer
+	subject := sections[0].Title
+	if err := emailHTMLTemplate.Execute(&html, map[string]interface{}{"Subject": subject, "Sections": sections}); err != nil {
+		return EmailMessage{}, err
+	}
+	return EmailMessage{To: to, Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
+
+// emailDeliverer is the "email" channel: respects UserSettings.EnableEmailNotifications
+type emailDeliverer struct{}
+
+func (emailDeliverer) Recipient(user models.User, settings models.UserSettings) (string, string) {
+	if !settings.EnableEmailNotifications {
+		return "", "email notifications disabled by user"
+	}
+	if user.Email == nil || strings.TrimSpace(*user.Email) == "" {
+		return "", "user has no email address"
+	}
+	if _, ok := SMTPConfigFromApp(); !ok && emailSenderOverride == nil {
+		return "", "SMTP is not configured"
+	}
+	return strings.TrimSpace(*user.Email), ""
+}
+
+func (emailDeliverer) Send(to string, n models.Notification, settings models.UserSettings) (string, error) {
+	msg, err := renderEmail(to, n, settings.Language)
+	if err != nil {
+		return "", err
+	}
+	return currentEmailSender().SendEmail(msg)
+}
+
+// emailSenderOverride replaces the SMTP sender (tests / alternative providers)
+var emailSenderOverride EmailSender
+
+// SetEmailSender overrides the email sender; nil restores the SMTP sender from config
+func SetEmailSender(s EmailSender) {
+	emailSenderOverride = s
+}
+
+func currentEmailSender() EmailSender {
+	if emailSenderOverride != nil {
+		return emailSenderOverride
+	}
+	cfg, _ := SMTPConfigFromApp()
+	return NewSMTPSender(cfg)
+}
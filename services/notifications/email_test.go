@@ -0,0 +1,137 @@
+package notifications
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+// fakeSMTPServer is a minimal local SMTP stand-in that captures one message
+func fakeSMTPServer(t *testing.T) (addr string, received <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+		write("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250-localhost")
+				write("250 8BITMIME")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				write("250 OK")
+			case cmd == "DATA":
+				write("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				out <- data.String()
+				write("250 queued")
+			case cmd == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestSMTPSenderSendsMultipartEmail(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	sender := NewSMTPSender(SMTPConfig{Host: host, Port: port, From: "noreply@englishkorat.test", FromName: "English Korat", TLSMode: "none"})
+
+	n := models.Notification{Title: "Class cancelled", TitleTh: "งดเรียน", Message: "See you next week", MessageTh: "พบกันสัปดาห์หน้า"}
+	msg, err := renderEmail("student@example.com", n, "th")
+	if err != nil {
+		t.Fatalf("renderEmail: %v", err)
+	}
+	id, err := sender.SendEmail(msg)
+	if err != nil {
+		t.Fatalf("SendEmail: %v", err)
+	}
+	if !strings.HasSuffix(id, "@englishkorat.test>") {
+		t.Fatalf("unexpected message id %q", id)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(<-received))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "งดเรียน" {
+		t.Fatalf("subject = %q, want Thai title first", subject)
+	}
+	_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		types = append(types, strings.Split(part.Header.Get("Content-Type"), ";")[0])
+		if !strings.Contains(string(body), "พบกันสัปดาห์หน้า") || !strings.Contains(string(body), "See you next week") {
+			t.Fatalf("part %s is missing a language: %s", part.Header.Get("Content-Type"), body)
+		}
+	}
+	if strings.Join(types, ",") != "text/plain,text/html" {
+		t.Fatalf("parts = %v", types)
+	}
+}
+
+func TestRenderEmailLanguageOrderAndEscaping(t *testing.T) {
+	n := models.Notification{Title: "Hi <b>", Message: "line1\nline2"}
+	msg, err := renderEmail("a@example.com", n, "en")
+	if err != nil {
+		t.Fatalf("renderEmail: %v", err)
+	}
+	if msg.Subject != "Hi <b>" {
+		t.Fatalf("subject = %q", msg.Subject)
+	}
+	if strings.Contains(msg.HTML, "<b>") || !strings.Contains(msg.HTML, "line1<br>line2") {
+		t.Fatalf("html not escaped / line breaks missing: %s", msg.HTML)
+	}
+	// Thai missing -> falls back to English, so only one section is rendered
+	if strings.Count(msg.Text, "line1") != 1 {
+		t.Fatalf("duplicate section in text: %q", msg.Text)
+	}
+}
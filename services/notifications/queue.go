@@ -0,0 +1,449 @@
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Reliable Redis queue.
+// Producers RPUSH to redisListKey. A worker LMOVEs each item into its own processing list before
+// handling it, so an item is always in Redis until it is stored, scheduled for retry or dead-lettered.
+// Processing lists of workers whose heartbeat expired (crash, kill -9) are moved back to the queue.
+const (
+	redisProcessingPrefix = "notifications:processing:" // + consumer id
+	redisConsumerPrefix   = "notifications:consumer:"   // + consumer id, heartbeat with TTL
+	redisRetryKey         = "notifications:retry"       // ZSET, score = next attempt (unix ms)
+	redisDeadKey          = "notifications:dead"
+	redisStatsKey         = "notifications:stats" // HASH of counters
+)
+
+const (
+	queueMaxAttempts  = 5
+	queueBaseBackoff  = 5 * time.Second
+	queueMaxBackoff   = 5 * time.Minute
+	queueHeartbeatTTL = 30 * time.Second
+	queueHeartbeatGap = 10 * time.Second
+	queueRecoverEvery = 15 // ticks (~30s)
+	queuePromoteLimit = 500
+	queueMaxPerTick   = 1000
+	queueDeadPageMax  = 200
+)
+
+// ErrQueueDisabled is returned by the queue admin functions when Redis notifications are off
+var ErrQueueDisabled = errors.New("redis notification queue is disabled")
+
+// promoteRetriesScript moves due items from the retry ZSET back to the queue in one step
+var promoteRetriesScript = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, v in ipairs(items) do
+	redis.call('ZREM', KEYS[1], v)
+	redis.call('RPUSH', KEYS[2], v)
+end
+return #items
+`)
+
+// replaceScript removes ARGV[1] from list KEYS[1] and, only if it was there, pushes ARGV[2] to KEYS[2]
+var replaceScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 then
+	redis.call('RPUSH', KEYS[2], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// newQueueID returns a random id for a queued notification
+func newQueueID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// queueItemID is the id of a raw queue entry; entries queued before ids existed (or unreadable ones) get a content hash
+func queueItemID(raw string, q *queuedNotification) string {
+	if q != nil && q.ID != "" {
+		return q.ID
+	}
+	sum := sha1.Sum([]byte(raw))
+	return "h" + hex.EncodeToString(sum[:6])
+}
+
+// queueBackoff returns 5s, 10s, 20s, ... capped at five minutes
+func queueBackoff(attempt int) time.Duration {
+	d := queueBaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= queueMaxBackoff {
+			return queueMaxBackoff
+		}
+	}
+	return d
+}
+
+func newConsumerID() string {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "local"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newQueueID()[:6])
+}
+
+func (s *Service) processingKey() string {
+	return redisProcessingPrefix + s.consumer
+}
+
+// heartbeat marks this worker alive; its processing list is left alone while the key exists
+func (s *Service) heartbeat(ctx context.Context) {
+	if err := s.redis.Set(ctx, redisConsumerPrefix+s.consumer, time.Now().Unix(), queueHeartbeatTTL).Err(); err != nil {
+		log.Printf("[notif] queue heartbeat failed: %v", err)
+	}
+}
+
+// promoteRetries moves retries whose backoff has passed back to the queue
+func (s *Service) promoteRetries(ctx context.Context) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := promoteRetriesScript.Run(ctx, s.redis, []string{redisRetryKey, redisListKey}, now, queuePromoteLimit).Err(); err != nil && err != redis.Nil {
+		log.Printf("[notif] promoting retries failed: %v", err)
+	}
+}
+
+// recoverOrphans moves the processing lists of dead workers back to the front of the queue
+func (s *Service) recoverOrphans(ctx context.Context) {
+	keys, err := scanKeys(ctx, s.redis, redisProcessingPrefix+"*")
+	if err != nil {
+		log.Printf("[notif] scanning processing lists failed: %v", err)
+		return
+	}
+	for _, key := range keys {
+		consumer := strings.TrimPrefix(key, redisProcessingPrefix)
+		if consumer == s.consumer {
+			continue
+		}
+		if alive, err := s.redis.Exists(ctx, redisConsumerPrefix+consumer).Result(); err != nil || alive > 0 {
+			continue
+		}
+		moved := 0
+		for {
+			err := s.redis.LMove(ctx, key, redisListKey, "RIGHT", "LEFT").Err()
+			if err != nil {
+				if err != redis.Nil {
+					log.Printf("[notif] recovering %s failed: %v", key, err)
+				}
+				break
+			}
+			moved++
+		}
+		if moved > 0 {
+			s.redis.HIncrBy(ctx, redisStatsKey, "recovered", int64(moved))
+			log.Printf("[notif] recovered %d in-flight notification(s) of stopped worker %s", moved, consumer)
+		}
+	}
+}
+
+// flushBatch moves items one by one into this worker's processing list and stores them.
+// A batch can outlast the heartbeat TTL, so the heartbeat is refreshed between items; otherwise
+// another worker would take this worker for dead and requeue the items it is still storing.
+func (s *Service) flushBatch(ctx context.Context, maxItems int) {
+	if s.redis == nil {
+		return
+	}
+	lastBeat := time.Now()
+	for i := 0; i < maxItems; i++ {
+		if time.Since(lastBeat) >= queueHeartbeatGap {
+			s.heartbeat(ctx)
+			lastBeat = time.Now()
+		}
+		raw, err := s.redis.LMove(ctx, redisListKey, s.processingKey(), "LEFT", "RIGHT").Result()
+		if err == redis.Nil {
+			return
+		}
+		if err != nil {
+			log.Printf("[notif] LMOVE failed: %v", err)
+			return
+		}
+		s.processQueued(ctx, raw)
+	}
+}
+
+// processQueued stores one item and acknowledges it; failures are retried with backoff, then dead-lettered
+func (s *Service) processQueued(ctx context.Context, raw string) {
+	var q queuedNotification
+	if err := json.Unmarshal([]byte(raw), &q); err != nil {
+		// อ่านไม่ได้ ส่งซ้ำก็ไม่ผ่าน → dead-letter ทันที (เก็บ raw ไว้ตรวจสอบ)
+		log.Printf("[notif] dropping unreadable queue item to dead-letter: %v", err)
+		s.ack(ctx, raw, func(p redis.Pipeliner) {
+			p.RPush(ctx, redisDeadKey, raw)
+			p.HIncrBy(ctx, redisStatsKey, "dead_lettered", 1)
+		})
+		return
+	}
+
+	err := s.createDirect(q.UserIDs, q)
+	if err == nil {
+		s.ack(ctx, raw, func(p redis.Pipeliner) {
+			p.HIncrBy(ctx, redisStatsKey, "processed", 1)
+		})
+		return
+	}
+
+	q.Attempts++
+	q.LastError = err.Error()
+	if q.ID == "" {
+		q.ID = queueItemID(raw, nil)
+	}
+	if q.Attempts >= queueMaxAttempts {
+		now := time.Now().UTC()
+		q.FailedAt = &now
+		log.Printf("[notif] notification %s failed %d times, dead-lettered: %v", q.ID, q.Attempts, err)
+	} else {
+		log.Printf("[notif] notification %s attempt %d failed, retrying in %s: %v", q.ID, q.Attempts, queueBackoff(q.Attempts), err)
+	}
+	b, mErr := json.Marshal(q)
+	if mErr != nil {
+		b = []byte(raw)
+	}
+	s.ack(ctx, raw, func(p redis.Pipeliner) {
+		if q.FailedAt != nil {
+			p.RPush(ctx, redisDeadKey, b)
+			p.HIncrBy(ctx, redisStatsKey, "dead_lettered", 1)
+			return
+		}
+		next := time.Now().Add(queueBackoff(q.Attempts)).UnixMilli()
+		p.ZAdd(ctx, redisRetryKey, &redis.Z{Score: float64(next), Member: b})
+		p.HIncrBy(ctx, redisStatsKey, "retried", 1)
+	})
+}
+
+// ack removes raw from the processing list in the same MULTI as the follow-up commands
+func (s *Service) ack(ctx context.Context, raw string, then func(redis.Pipeliner)) {
+	_, err := s.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		then(p)
+		p.LRem(ctx, s.processingKey(), 1, raw)
+		return nil
+	})
+	if err != nil {
+		// ยังค้างใน processing list → ถูกกู้คืนเข้าคิวเมื่อ worker นี้หยุด (heartbeat หมดอายุ)
+		log.Printf("[notif] acknowledging queue item failed: %v", err)
+	}
+}
+
+func scanKeys(ctx context.Context, rdb *redis.Client, match string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := rdb.Scan(ctx, cursor, match, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		cursor = next
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// QueueStats describes the Redis notification queue
+type QueueStats struct {
+	Enabled                 bool             `json:"enabled"`
+	Pending                 int64            `json:"pending"`
+	Processing              int64            `json:"processing"`
+	Retrying                int64            `json:"retrying"`
+	DeadLettered            int64            `json:"dead_lettered"`
+	OldestPendingAgeSeconds float64          `json:"oldest_pending_age_seconds"`
+	NextRetryAt             *time.Time       `json:"next_retry_at,omitempty"`
+	Consumers               int              `json:"consumers"`
+	Counters                map[string]int64 `json:"counters"`
+}
+
+// QueueStats returns queue depth, age of the oldest pending item and lifetime counters
+func (s *Service) QueueStats(ctx context.Context) (*QueueStats, error) {
+	stats := &QueueStats{Enabled: s.useRedis, Counters: map[string]int64{}}
+	if !s.useRedis {
+		return stats, nil
+	}
+	var err error
+	if stats.Pending, err = s.redis.LLen(ctx, redisListKey).Result(); err != nil {
+		return nil, err
+	}
+	if stats.Retrying, err = s.redis.ZCard(ctx, redisRetryKey).Result(); err != nil {
+		return nil, err
+	}
+	if stats.DeadLettered, err = s.redis.LLen(ctx, redisDeadKey).Result(); err != nil {
+		return nil, err
+	}
+	processing, err := scanKeys(ctx, s.redis, redisProcessingPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range processing {
+		n, _ := s.redis.LLen(ctx, key).Result()
+		stats.Processing += n
+	}
+	consumers, err := scanKeys(ctx, s.redis, redisConsumerPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	stats.Consumers = len(consumers)
+
+	if head, err := s.redis.LIndex(ctx, redisListKey, 0).Result(); err == nil {
+		var q queuedNotification
+		if json.Unmarshal([]byte(head), &q) == nil && !q.CreatedAt.IsZero() {
+			stats.OldestPendingAgeSeconds = time.Since(q.CreatedAt).Seconds()
+		}
+	}
+	if next, err := s.redis.ZRangeWithScores(ctx, redisRetryKey, 0, 0).Result(); err == nil && len(next) > 0 {
+		t := time.UnixMilli(int64(next[0].Score)).UTC()
+		stats.NextRetryAt = &t
+	}
+	counters, err := s.redis.HGetAll(ctx, redisStatsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range counters {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		stats.Counters[k] = n
+	}
+	return stats, nil
+}
+
+// DeadLetter is one dead-lettered notification; Raw is set when the payload could not be decoded
+type DeadLetter struct {
+	ID        string     `json:"id"`
+	UserIDs   []uint     `json:"user_ids"`
+	Title     string     `json:"title"`
+	TitleTh   string     `json:"title_th"`
+	Message   string     `json:"message"`
+	MessageTh string     `json:"message_th"`
+	Type      string     `json:"type"`
+	Channels  []string   `json:"channels,omitempty"`
+	DedupeKey string     `json:"dedupe_key,omitempty"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error"`
+	CreatedAt time.Time  `json:"created_at"`
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
+	Raw       string     `json:"raw,omitempty"`
+}
+
+func toDeadLetter(raw string) DeadLetter {
+	var q queuedNotification
+	if err := json.Unmarshal([]byte(raw), &q); err != nil {
+		return DeadLetter{ID: queueItemID(raw, nil), LastError: "unreadable payload: " + err.Error(), Raw: raw}
+	}
+	return DeadLetter{
+		ID: queueItemID(raw, &q), UserIDs: q.UserIDs, Title: q.Title, TitleTh: q.TitleTh, Message: q.Message, MessageTh: q.MessageTh,
+		Type: q.Type, Channels: q.Channels, DedupeKey: q.DedupeKey, Attempts: q.Attempts, LastError: q.LastError, CreatedAt: q.CreatedAt, FailedAt: q.FailedAt,
+	}
+}
+
+// ListDeadLetters returns dead-lettered notifications, oldest first
+func (s *Service) ListDeadLetters(ctx context.Context, offset, limit int) ([]DeadLetter, int64, error) {
+	if !s.useRedis {
+		return nil, 0, ErrQueueDisabled
+	}
+	if limit < 1 || limit > queueDeadPageMax {
+		limit = 50
+	}
+	total, err := s.redis.LLen(ctx, redisDeadKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	raws, err := s.redis.LRange(ctx, redisDeadKey, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]DeadLetter, 0, len(raws))
+	for _, raw := range raws {
+		out = append(out, toDeadLetter(raw))
+	}
+	return out, total, nil
+}
+
+// matchDeadLetters returns the raw dead-letter entries with the given ids (all entries when ids is empty)
+func (s *Service) matchDeadLetters(ctx context.Context, ids []string) ([]string, error) {
+	raws, err := s.redis.LRange(ctx, redisDeadKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return raws, err
+	}
+	want := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		want[id] = struct{}{}
+	}
+	var out []string
+	for _, raw := range raws {
+		if _, ok := want[toDeadLetter(raw).ID]; ok {
+			out = append(out, raw)
+		}
+	}
+	return out, nil
+}
+
+// RequeueDeadLetters puts dead-lettered notifications back on the queue with a fresh attempt budget.
+// An empty ids list requeues everything; unreadable entries are never requeued.
+func (s *Service) RequeueDeadLetters(ctx context.Context, ids []string) (int, error) {
+	if !s.useRedis {
+		return 0, ErrQueueDisabled
+	}
+	raws, err := s.matchDeadLetters(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for _, raw := range raws {
+		var q queuedNotification
+		if json.Unmarshal([]byte(raw), &q) != nil {
+			continue
+		}
+		q.ID = queueItemID(raw, &q)
+		q.Attempts, q.LastError, q.FailedAt = 0, "", nil
+		b, err := json.Marshal(q)
+		if err != nil {
+			continue
+		}
+		n, err := replaceScript.Run(ctx, s.redis, []string{redisDeadKey, redisListKey}, raw, b).Int()
+		if err != nil {
+			return requeued, err
+		}
+		requeued += n
+	}
+	if requeued > 0 {
+		s.redis.HIncrBy(ctx, redisStatsKey, "requeued", int64(requeued))
+	}
+	return requeued, nil
+}
+
+// PurgeDeadLetters deletes dead-lettered notifications (all of them when ids is empty)
+func (s *Service) PurgeDeadLetters(ctx context.Context, ids []string) (int, error) {
+	if !s.useRedis {
+		return 0, ErrQueueDisabled
+	}
+	raws, err := s.matchDeadLetters(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, raw := range raws {
+		n, err := s.redis.LRem(ctx, redisDeadKey, 1, raw).Result()
+		if err != nil {
+			return purged, err
+		}
+		purged += int(n)
+	}
+	if purged > 0 {
+		s.redis.HIncrBy(ctx, redisStatsKey, "purged", int64(purged))
+	}
+	return purged, nil
+}
@@ -0,0 +1,48 @@
+package notifications
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQueueBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 4: 40 * time.Second, 10: 5 * time.Minute}
+	for attempt, want := range cases {
+		if got := queueBackoff(attempt); got != want {
+			t.Errorf("queueBackoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestToDeadLetter(t *testing.T) {
+	q := queuedNotification{ID: "abc", UserIDs: []uint{1, 2}, Title: "t", Attempts: 5, LastError: "db down"}
+	raw, _ := json.Marshal(q)
+	dl := toDeadLetter(string(raw))
+	if dl.ID != "abc" || dl.Attempts != 5 || dl.LastError != "db down" || len(dl.UserIDs) != 2 || dl.Raw != "" {
+		t.Fatalf("toDeadLetter = %+v", dl)
+	}
+
+	// items queued before ids existed get a stable content hash
+	legacy := `{"user_ids":[3],"title":"old"}`
+	if a, b := toDeadLetter(legacy).ID, toDeadLetter(legacy).ID; a == "" || a != b {
+		t.Fatalf("legacy ids %q / %q", a, b)
+	}
+
+	bad := toDeadLetter("{not json")
+	if bad.Raw != "{not json" || bad.ID == "" || bad.LastError == "" {
+		t.Fatalf("unreadable entry = %+v", bad)
+	}
+}
+
+func TestDedupeKeySurvivesQueue(t *testing.T) {
+	q := QueuedWithData("t", "", "m", "", "info", nil, "normal").WithDedupeKey("session:123:reminder:30m")
+	raw, _ := json.Marshal(q)
+	var back queuedNotification
+	if err := json.Unmarshal(raw, &back); err != nil || back.DedupeKey != "session:123:reminder:30m" {
+		t.Fatalf("dedupe key lost through the queue: %s", raw)
+	}
+	if got := UserDedupeKey(back.DedupeKey, 9); got != "session:123:reminder:30m:user:9" {
+		t.Fatalf("UserDedupeKey = %q", got)
+	}
+}
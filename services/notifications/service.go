@@ -8,11 +8,13 @@ import (
 	"englishkorat_go/models"
 	"englishkorat_go/utils"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type queuedNotification struct {
@@ -25,6 +27,14 @@ type queuedNotification struct {
 	Channels  []string  `json:"channels,omitempty"`
 	Data      any       `json:"data,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// DedupeKey is the per-notification part of Notification.DedupeKey; ":user:<id>" is appended per recipient
+	DedupeKey string `json:"dedupe_key,omitempty"`
+
+	// Reliable queue bookkeeping (see queue.go)
+	ID        string     `json:"id,omitempty"`
+	Attempts  int        `json:"attempts,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
 }
 
 const redisListKey = "notifications:queue"
@@ -53,7 +63,8 @@ type Service struct {
 	db       *gorm.DB
 	redis    *redis.Client
 	useRedis bool
-	wsHub    WSHub // WebSocket hub interface
+	wsHub    WSHub  // WebSocket hub interface
+	consumer string // Redis worker id, set by StartWorker
 }
 
 // WSHub interface for WebSocket broadcasting
@@ -89,7 +100,7 @@ func normalizeChannels(in []string) []string {
 	if len(in) == 0 {
 		return []string{"normal"}
 	}
-	allowed := map[string]struct{}{"normal": {}, "popup": {}, "line": {}}
+	allowed := map[string]struct{}{"normal": {}, "popup": {}, ChannelLine: {}, ChannelEmail: {}, ChannelSMS: {}, ChannelSMSParent: {}}
 	out := make([]string, 0, len(in))
 	seen := map[string]struct{}{}
 	for _, ch := range in {
@@ -118,6 +129,18 @@ func QueuedWithData(title, titleTh, message, messageTh, typ string, data any, ch
 	return queuedNotification{Title: title, TitleTh: titleTh, Message: message, MessageTh: messageTh, Type: typ, Channels: ch, Data: data}
 }
 
+// WithDedupeKey makes the notification idempotent: a recipient who already has a notification with the
+// same key (e.g. "session:123:reminder:30m") is skipped. The key is stored as "<key>:user:<id>".
+func (q queuedNotification) WithDedupeKey(key string) queuedNotification {
+	q.DedupeKey = key
+	return q
+}
+
+// UserDedupeKey returns the stored dedupe key of one recipient
+func UserDedupeKey(key string, userID uint) string {
+	return fmt.Sprintf("%s:user:%d", key, userID)
+}
+
 // EnqueueOrCreate stores notifications using Redis queue if enabled, else direct insert.
 func (s *Service) EnqueueOrCreate(userIDs []uint, n queuedNotification) error {
 	if len(userIDs) == 0 {
@@ -127,6 +150,7 @@ func (s *Service) EnqueueOrCreate(userIDs []uint, n queuedNotification) error {
 	n.CreatedAt = time.Now().UTC()
 
 	if s.useRedis {
+		n.ID = newQueueID()
 		b, err := json.Marshal(n)
 		if err != nil {
 			return err
@@ -146,15 +170,21 @@ func (s *Service) createDirect(userIDs []uint, n queuedNotification) error { //n
 	if len(userIDs) == 0 {
 		return nil
 	}
+	if n.DedupeKey != "" {
+		userIDs = s.withoutDuplicates(userIDs, n.DedupeKey)
+		if len(userIDs) == 0 {
+			return nil
+		}
+	}
 	notifs := make([]models.Notification, 0, len(userIDs))
-	// marshal channels to JSON
-	// Always set channels JSON, defaulting to ["normal"] to avoid DB default on JSON which MySQL forbids
-	var channelsJSON []byte
-	var err error
-	channelsJSON, err = json.Marshal(normalizeChannels(n.Channels))
+	channels := normalizeChannels(n.Channels)
+
+	// Resolve external recipients (email, sms, line) first: users none of them can reach get the in-app channel
+	plan, err := s.planDeliveries(userIDs, channels)
 	if err != nil {
-		channelsJSON = []byte(`["normal"]`)
+		log.Printf("[notif] failed to plan external deliveries: %v", err)
 	}
+
 	// marshal data if provided
 	var dataJSON []byte
 	if n.Data != nil {
@@ -163,7 +193,12 @@ func (s *Service) createDirect(userIDs []uint, n queuedNotification) error { //n
 		}
 	}
 	for _, uid := range userIDs {
-		notifs = append(notifs, models.Notification{
+		// Always set channels JSON, defaulting to ["normal"] to avoid DB default on JSON which MySQL forbids
+		channelsJSON, err := json.Marshal(plan.channelsFor(uid, channels))
+		if err != nil {
+			channelsJSON = []byte(`["normal"]`)
+		}
+		notif := models.Notification{
 			UserID:    uid,
 			Title:     n.Title,
 			TitleTh:   n.TitleTh,
@@ -173,14 +208,31 @@ func (s *Service) createDirect(userIDs []uint, n queuedNotification) error { //n
 			Read:      false,
 			Channels:  channelsJSON,
 			Data:      dataJSON,
-		})
+		}
+		if n.DedupeKey != "" {
+			key := UserDedupeKey(n.DedupeKey, uid)
+			notif.DedupeKey = &key
+		}
+		notifs = append(notifs, notif)
 	}
 
 	// Create notifications in database
-	if err := s.db.Create(&notifs).Error; err != nil {
+	if n.DedupeKey != "" {
+		if notifs, err = s.createUnique(notifs); err != nil {
+			return err
+		}
+		if len(notifs) == 0 {
+			return nil
+		}
+	} else if err := s.db.Create(&notifs).Error; err != nil {
 		return err
 	}
 
+	// External channels are sent in the background with a delivery record per recipient (retried by StartDeliveryWorker)
+	if err := s.record(plan, notifs); err != nil {
+		log.Printf("[notif] failed to record external deliveries: %v", err)
+	}
+
 	// Send WebSocket notifications if hub is available
 	if s.wsHub != nil {
 		for _, notif := range notifs {
@@ -213,56 +265,79 @@ func (s *Service) createDirect(userIDs []uint, n queuedNotification) error { //n
 	return nil
 }
 
-// StartWorker starts a background worker polling Redis queue and flushing to DB
+// withoutDuplicates drops users who already have a notification with the key (cheap pre-check;
+// createUnique is what makes it atomic)
+func (s *Service) withoutDuplicates(userIDs []uint, key string) []uint {
+	keys := make([]string, 0, len(userIDs))
+	for _, uid := range userIDs {
+		keys = append(keys, UserDedupeKey(key, uid))
+	}
+	var existing []uint
+	if err := s.db.Unscoped().Model(&models.Notification{}).Where("dedupe_key IN ?", keys).Pluck("user_id", &existing).Error; err != nil {
+		return userIDs
+	}
+	if len(existing) == 0 {
+		return userIDs
+	}
+	sent := make(map[uint]struct{}, len(existing))
+	for _, id := range existing {
+		sent[id] = struct{}{}
+	}
+	out := make([]uint, 0, len(userIDs))
+	for _, uid := range userIDs {
+		if _, ok := sent[uid]; !ok {
+			out = append(out, uid)
+		}
+	}
+	return out
+}
+
+// createUnique inserts keyed notifications one by one; a row that hits the unique dedupe_key index
+// (sent concurrently by another scheduler/replica) is skipped. Returns the rows actually created.
+func (s *Service) createUnique(notifs []models.Notification) ([]models.Notification, error) {
+	created := notifs[:0]
+	for _, notif := range notifs {
+		res := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notif)
+		if res.Error != nil {
+			return created, res.Error
+		}
+		if res.RowsAffected == 0 || notif.ID == 0 {
+			continue
+		}
+		created = append(created, notif)
+	}
+	return created, nil
+}
+
+// StartWorker starts a background worker that drains the Redis queue into the DB.
+// Items are moved to a per-worker processing list first (see queue.go), so a crash never loses them.
 func (s *Service) StartWorker(stop <-chan struct{}) {
 	if !s.useRedis {
 		log.Println("[notif] Redis notifications disabled; worker not started")
 		return
 	}
+	s.consumer = newConsumerID()
 	go func() {
-		log.Println("[notif] Redis notification worker started")
+		log.Printf("[notif] Redis notification worker %s started", s.consumer)
 		ticker := time.NewTicker(2 * time.Second)
 		defer ticker.Stop()
 		ctx := context.Background()
-		batchSize := 200
-		for {
+		s.heartbeat(ctx)
+		s.recoverOrphans(ctx)
+		for tick := 1; ; tick++ {
 			select {
 			case <-stop:
 				log.Println("[notif] Worker stopping")
+				s.redis.Del(ctx, redisConsumerPrefix+s.consumer)
 				return
 			case <-ticker.C:
-				s.flushBatch(ctx, batchSize)
+				s.heartbeat(ctx)
+				s.promoteRetries(ctx)
+				if tick%queueRecoverEvery == 0 {
+					s.recoverOrphans(ctx)
+				}
+				s.flushBatch(ctx, queueMaxPerTick)
 			}
 		}
 	}()
 }
-
-// flushBatch polls redis queue and processes notifications in batches.
-func (s *Service) flushBatch(ctx context.Context, batchSize int) { //nolint:gocognit
-	if s.redis == nil {
-		return
-	}
-	// Use pipeline: LRange + LTrim approach to make it safe for moderate concurrency
-	for i := 0; i < 5; i++ { // up to 5 sub-batches per tick
-		vals, err := s.redis.LRange(ctx, redisListKey, 0, int64(batchSize-1)).Result()
-		if err != nil || len(vals) == 0 {
-			return
-		}
-		// Trim immediately to avoid duplicates (best-effort)
-		if err = s.redis.LTrim(ctx, redisListKey, int64(len(vals)), -1).Err(); err != nil {
-			log.Printf("[notif] LTrim failed: %v", err)
-		}
-		for _, raw := range vals {
-			var q queuedNotification
-			if err := json.Unmarshal([]byte(raw), &q); err != nil {
-				continue
-			}
-			if err := s.createDirect(q.UserIDs, q); err != nil {
-				log.Printf("[notif] DB insert failed (retry later?): %v", err)
-			}
-		}
-		if len(vals) < batchSize {
-			return
-		}
-	}
-}
@@ -0,0 +1,434 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"englishkorat_go/config"
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SMS channels: "sms" goes to the user's own phone (opt-in), "sms_parent" to Student.ParentPhone
+const (
+	ChannelSMS       = "sms"
+	ChannelSMSParent = "sms_parent"
+)
+
+// SMS encodings and segment sizes. Thai is not in the GSM 03.38 alphabet, so any Thai text is UCS-2.
+const (
+	SMSEncodingGSM7 = "GSM-7"
+	SMSEncodingUCS2 = "UCS-2"
+)
+
+const (
+	gsm7SingleLimit = 160
+	gsm7PartLimit   = 153
+	ucs2SingleLimit = 70
+	ucs2PartLimit   = 67
+)
+
+// SMSProvider sends one text message and returns the provider's message id
+type SMSProvider interface {
+	SendSMS(to, text string) (string, error)
+}
+
+// LogSMSProvider only logs messages (development)
+type LogSMSProvider struct{}
+
+func (LogSMSProvider) SendSMS(to, text string) (string, error) {
+	info := SMSInfoOf(text)
+	log.Printf("[sms] to=%s encoding=%s segments=%d text=%q", to, info.Encoding, info.Segments, text)
+	return fmt.Sprintf("log-%d", time.Now().UnixNano()), nil
+}
+
+// HTTPSMSProvider posts {"to","message","sender"} as JSON to a gateway URL with a Bearer token.
+// A local stand-in only has to answer 2xx with an optional {"id": "..."} body.
+type HTTPSMSProvider struct {
+	URL    string
+	Token  string
+	Sender string
+	Client *http.Client
+}
+
+func (p *HTTPSMSProvider) SendSMS(to, text string) (string, error) {
+	body, err := json.Marshal(map[string]string{"to": to, "message": text, "sender": p.Sender})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("sms gateway returned %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	var out struct {
+		ID        string `json:"id"`
+		MessageID string `json:"message_id"`
+	}
+	_ = json.Unmarshal(raw, &out)
+	if out.ID == "" {
+		out.ID = out.MessageID
+	}
+	return out.ID, nil
+}
+
+// smsProviderOverride replaces the configured provider (tests / custom gateways)
+var smsProviderOverride SMSProvider
+
+// SetSMSProvider overrides the SMS provider; nil restores the provider from config
+func SetSMSProvider(p SMSProvider) {
+	smsProviderOverride = p
+}
+
+// currentSMSProvider returns the configured provider (ok=false when SMS is disabled)
+func currentSMSProvider() (SMSProvider, bool) {
+	if smsProviderOverride != nil {
+		return smsProviderOverride, true
+	}
+	c := config.AppConfig
+	if c == nil {
+		return nil, false
+	}
+	switch c.SMSProvider {
+	case "log":
+		return LogSMSProvider{}, true
+	case "http":
+		if c.SMSHTTPURL == "" {
+			return nil, false
+		}
+		return &HTTPSMSProvider{URL: c.SMSHTTPURL, Token: c.SMSHTTPToken, Sender: c.SMSSender}, true
+	default:
+		return nil, false
+	}
+}
+
+// NormalizeThaiPhone converts local numbers to E.164 ("081-234-5678" -> "+66812345678")
+func NormalizeThaiPhone(phone string) (string, bool) {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(d, "66") && len(d) == 11:
+		d = d[2:]
+	case strings.HasPrefix(d, "0") && len(d) == 10:
+		d = d[1:]
+	default:
+		return "", false
+	}
+	// มือถือไทยขึ้นต้นด้วย 6, 8, 9
+	if d[0] != '6' && d[0] != '8' && d[0] != '9' {
+		return "", false
+	}
+	return "+66" + d, true
+}
+
+// gsm7Basic / gsm7Extended are the GSM 03.38 characters (extended ones take two units)
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// SMSInfo describes how a text is billed
+type SMSInfo struct {
+	Encoding string `json:"encoding"`
+	Units    int    `json:"units"` // GSM-7 septets or UCS-2 code units
+	Segments int    `json:"segments"`
+}
+
+// SMSInfoOf computes the encoding and number of segments of a text
+func SMSInfoOf(text string) SMSInfo {
+	units := 0
+	gsm := true
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			units++
+		case strings.ContainsRune(gsm7Extended, r):
+			units += 2
+		default:
+			gsm = false
+		}
+		if !gsm {
+			break
+		}
+	}
+	single, part := gsm7SingleLimit, gsm7PartLimit
+	info := SMSInfo{Encoding: SMSEncodingGSM7, Units: units}
+	if !gsm {
+		single, part = ucs2SingleLimit, ucs2PartLimit
+		info.Encoding = SMSEncodingUCS2
+		info.Units = 0
+		for _, r := range text {
+			info.Units += utf16Units(r)
+		}
+	}
+	switch {
+	case info.Units == 0:
+		info.Segments = 0
+	case info.Units <= single:
+		info.Segments = 1
+	default:
+		info.Segments = (info.Units + part - 1) / part
+	}
+	return info
+}
+
+func utf16Units(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// fitSMS shortens a text to at most maxSegments segments, ending with "..." when cut
+func fitSMS(text string, maxSegments int) string {
+	if maxSegments < 1 || SMSInfoOf(text).Segments <= maxSegments {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		candidate := strings.TrimSpace(string(runes)) + "..."
+		if SMSInfoOf(candidate).Segments <= maxSegments {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// renderSMS renders "title: message" in the user's language (falling back to the other one) on one line
+func renderSMS(n models.Notification, lang string, maxSegments int) string {
+	pick := func(v, other string) string {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+		return other
+	}
+	title, message := pick(n.TitleTh, n.Title), pick(n.MessageTh, n.Message)
+	if lang == "en" {
+		title, message = pick(n.Title, n.TitleTh), pick(n.Message, n.MessageTh)
+	}
+	text := strings.Join(strings.Fields(title), " ")
+	if body := strings.Join(strings.Fields(message), " "); body != "" && text != "" {
+		text += ": " + body
+	} else if body != "" {
+		text = body
+	}
+	return fitSMS(text, maxSegments)
+}
+
+// smsRateLimiter is a process-wide sliding one-minute window
+type smsRateLimiter struct {
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+var smsLimiter = &smsRateLimiter{}
+
+// wait blocks until another message fits in the per-minute budget
+func (l *smsRateLimiter) wait(perMinute int) {
+	if perMinute <= 0 {
+		return
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		cutoff := now.Add(-time.Minute)
+		kept := l.sent[:0]
+		for _, t := range l.sent {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		l.sent = kept
+		if len(l.sent) < perMinute {
+			l.sent = append(l.sent, now)
+			l.mu.Unlock()
+			return
+		}
+		delay := l.sent[0].Sub(cutoff)
+		l.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+func smsLimits() (perMinute, dailyPerRecipient, maxSegments int) {
+	perMinute, dailyPerRecipient, maxSegments = 30, 10, 3
+	if c := config.AppConfig; c != nil {
+		if c.SMSRatePerMinute > 0 {
+			perMinute = c.SMSRatePerMinute
+		}
+		if c.SMSDailyLimit > 0 {
+			dailyPerRecipient = c.SMSDailyLimit
+		}
+		if c.SMSMaxSegments > 0 {
+			maxSegments = c.SMSMaxSegments
+		}
+	}
+	return
+}
+
+// smsDay is the Bangkok calendar day the daily limit is counted on
+func smsDay(t time.Time) string {
+	if loc, err := time.LoadLocation("Asia/Bangkok"); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format("2006-01-02")
+}
+
+// reserveSMSSlot takes one message of the recipient's daily budget with a conditional update,
+// so concurrent senders cannot both pass the limit. ok=false when the budget is used up.
+func reserveSMSSlot(db *gorm.DB, to, day string, limit int) (bool, error) {
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.SMSDailyCount{Recipient: to, Day: day}).Error; err != nil {
+		return false, err
+	}
+	res := db.Model(&models.SMSDailyCount{}).
+		Where("recipient = ? AND day = ? AND count < ?", to, day, limit).
+		UpdateColumn("count", gorm.Expr("count + 1"))
+	return res.RowsAffected > 0, res.Error
+}
+
+// releaseSMSSlot gives a reserved message back when the provider did not take it
+func releaseSMSSlot(db *gorm.DB, to, day string) {
+	if err := db.Model(&models.SMSDailyCount{}).
+		Where("recipient = ? AND day = ? AND count > 0", to, day).
+		UpdateColumn("count", gorm.Expr("count - 1")).Error; err != nil {
+		log.Printf("[sms] failed to release daily slot of %s: %v", to, err)
+	}
+}
+
+// parentSMSRecipient returns the student's parent phone, or why sms_parent cannot be sent
+func parentSMSRecipient(student *models.Student) (string, string) {
+	if _, ok := currentSMSProvider(); !ok {
+		return "", "SMS provider is not configured"
+	}
+	if student == nil || strings.TrimSpace(student.ParentPhone) == "" {
+		return "", "student has no parent phone"
+	}
+	if !student.ParentSMSOptIn {
+		return "", "parent has not opted in to SMS"
+	}
+	if to, ok := NormalizeThaiPhone(student.ParentPhone); ok {
+		return to, ""
+	}
+	return "", "parent phone is not a valid mobile number"
+}
+
+// smsDeliverer implements "sms" (the user's own phone) and "sms_parent" (the student's parent)
+type smsDeliverer struct {
+	parent bool
+}
+
+func (d smsDeliverer) Recipient(user models.User, settings models.UserSettings) (string, string) {
+	if d.parent {
+		return parentSMSRecipient(user.Student)
+	}
+	if _, ok := currentSMSProvider(); !ok {
+		return "", "SMS provider is not configured"
+	}
+	if !settings.EnablePhoneNotifications {
+		return "", "phone notifications disabled by user"
+	}
+	phone := user.Phone
+	if strings.TrimSpace(phone) == "" && user.Student != nil {
+		phone = user.Student.Phone
+	}
+	if strings.TrimSpace(phone) == "" {
+		return "", "user has no phone number"
+	}
+	if to, ok := NormalizeThaiPhone(phone); ok {
+		return to, ""
+	}
+	return "", "phone is not a valid mobile number"
+}
+
+func (d smsDeliverer) Send(to string, n models.Notification, settings models.UserSettings) (string, error) {
+	provider, ok := currentSMSProvider()
+	if !ok {
+		return "", fmt.Errorf("%w: SMS provider is not configured", ErrDeliverySkipped)
+	}
+	perMinute, daily, maxSegments := smsLimits()
+	text := renderSMS(n, settings.Language, maxSegments)
+	if utf8.RuneCountInString(text) == 0 {
+		return "", fmt.Errorf("%w: empty message", ErrDeliverySkipped)
+	}
+	db := database.GetDB()
+	day := smsDay(time.Now())
+	if db != nil {
+		reserved, err := reserveSMSSlot(db, to, day, daily)
+		if err != nil {
+			return "", err
+		}
+		if !reserved {
+			return "", fmt.Errorf("%w: daily SMS limit (%d) reached for recipient", ErrDeliverySkipped, daily)
+		}
+	}
+	smsLimiter.wait(perMinute)
+	id, err := provider.SendSMS(to, text)
+	if err != nil && db != nil {
+		releaseSMSSlot(db, to, day)
+	}
+	return id, err
+}
+
+// SendParentSMS sends sms_parent for a student without a user account: there is no notification
+// or user to key the delivery on, so the row keeps the student and the rendered (Thai) text
+func (s *Service) SendParentSMS(student models.Student, n queuedNotification) error {
+	if s.db == nil {
+		return nil
+	}
+	_, _, maxSegments := smsLimits()
+	text := renderSMS(models.Notification{Title: n.Title, TitleTh: n.TitleTh, Message: n.Message, MessageTh: n.MessageTh}, "th", maxSegments)
+	studentID := student.ID
+	row := models.NotificationDelivery{StudentID: &studentID, Channel: ChannelSMSParent, Message: text, Status: DeliveryPending, MaxAttempts: deliveryMaxAttempts}
+	if to, reason := parentSMSRecipient(&student); to == "" {
+		row.Status, row.LastError = DeliverySkipped, reason
+	} else {
+		lease := time.Now().Add(deliveryLease)
+		row.Recipient, row.NextAttemptAt = to, &lease
+	}
+	if err := s.db.Create(&row).Error; err != nil {
+		return err
+	}
+	if row.Status == DeliveryPending {
+		go s.deliver(row, storedMessage(row), defaultUserSettings(0))
+	}
+	return nil
+}
+
+// storedMessage rebuilds the notification of a delivery that was recorded without one
+func storedMessage(row models.NotificationDelivery) models.Notification {
+	return models.Notification{Message: row.Message, MessageTh: row.Message}
+}
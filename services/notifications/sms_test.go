@@ -0,0 +1,114 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+func TestNormalizeThaiPhone(t *testing.T) {
+	cases := map[string]string{
+		"081-234-5678":    "+66812345678",
+		"0912345678":      "+66912345678",
+		"+66 81 234 5678": "+66812345678",
+		"66612345678":     "+66612345678",
+		"021234567":       "",
+		"0212345678":      "",
+		"":                "",
+	}
+	for in, want := range cases {
+		got, ok := NormalizeThaiPhone(in)
+		if got != want || ok != (want != "") {
+			t.Errorf("NormalizeThaiPhone(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+}
+
+func TestSMSInfoOf(t *testing.T) {
+	cases := []struct {
+		text     string
+		encoding string
+		segments int
+	}{
+		{strings.Repeat("a", 160), SMSEncodingGSM7, 1},
+		{strings.Repeat("a", 161), SMSEncodingGSM7, 2},
+		{strings.Repeat("a", 159) + "€", SMSEncodingGSM7, 2}, // extended char takes two septets
+		{strings.Repeat("ก", 70), SMSEncodingUCS2, 1},
+		{strings.Repeat("ก", 71), SMSEncodingUCS2, 2},
+		{strings.Repeat("ก", 134), SMSEncodingUCS2, 2},
+		{strings.Repeat("ก", 135), SMSEncodingUCS2, 3},
+		{"Hello ครับ", SMSEncodingUCS2, 1},
+	}
+	for _, c := range cases {
+		info := SMSInfoOf(c.text)
+		if info.Encoding != c.encoding || info.Segments != c.segments {
+			t.Errorf("SMSInfoOf(%d runes) = %+v, want %s/%d", len([]rune(c.text)), info, c.encoding, c.segments)
+		}
+	}
+}
+
+func TestRenderSMSFitsSegments(t *testing.T) {
+	n := models.Notification{Title: "Absence", TitleTh: "แจ้งขาดเรียน", Message: "x", MessageTh: strings.Repeat("ขาดเรียน ", 40)}
+	text := renderSMS(n, "th", 2)
+	if info := SMSInfoOf(text); info.Segments != 2 || !strings.HasSuffix(text, "...") {
+		t.Fatalf("renderSMS = %q (%+v), want cut to 2 segments", text, info)
+	}
+	if got := renderSMS(n, "en", 2); got != "Absence: x" {
+		t.Fatalf("renderSMS(en) = %q", got)
+	}
+}
+
+func TestHTTPSMSProvider(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"id":"msg-1"}`))
+	}))
+	defer srv.Close()
+
+	p := &HTTPSMSProvider{URL: srv.URL, Token: "secret", Sender: "EK"}
+	id, err := p.SendSMS("+66812345678", "สวัสดี")
+	if err != nil || id != "msg-1" {
+		t.Fatalf("SendSMS = %q, %v", id, err)
+	}
+	if got["to"] != "+66812345678" || got["message"] != "สวัสดี" || got["sender"] != "EK" {
+		t.Fatalf("gateway received %v", got)
+	}
+
+	p.Token = "wrong"
+	if _, err := p.SendSMS("+66812345678", "x"); err == nil {
+		t.Fatal("expected error on non-2xx response")
+	}
+}
+
+func TestRenderSMSWithoutTitle(t *testing.T) {
+	n := models.Notification{MessageTh: "น้องเอ ขาดเรียน"}
+	if got := renderSMS(n, "th", 3); got != "น้องเอ ขาดเรียน" {
+		t.Fatalf("renderSMS = %q", got)
+	}
+}
+
+func TestParentSMSRecipientRequiresOptIn(t *testing.T) {
+	SetSMSProvider(LogSMSProvider{})
+	defer SetSMSProvider(nil)
+
+	student := &models.Student{ParentPhone: "081-234-5678"}
+	if to, reason := parentSMSRecipient(student); to != "" || reason != "parent has not opted in to SMS" {
+		t.Fatalf("parentSMSRecipient without consent = %q, %q", to, reason)
+	}
+	student.ParentSMSOptIn = true
+	if to, reason := parentSMSRecipient(student); to != "+66812345678" {
+		t.Fatalf("parentSMSRecipient = %q, %q", to, reason)
+	}
+	if to, _ := (smsDeliverer{parent: true}).Recipient(models.User{Student: student}, models.UserSettings{}); to != "+66812345678" {
+		t.Fatalf("sms_parent Recipient = %q", to)
+	}
+}
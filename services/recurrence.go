@@ -0,0 +1,544 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+// Recurrence frequencies (RFC 5545 FREQ)
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// maxRecurrenceYears guards iteration of rules that never terminate (no COUNT / UNTIL)
+const maxRecurrenceYears = 30
+
+// WeekdayNum is a BYDAY entry, e.g. "TU" (Ordinal 0), "2TU" or "-1FR"
+type WeekdayNum struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// RecurrenceRule is the subset of an RFC 5545 RRULE used for session generation:
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, WKST plus EXDATE.
+//
+// Unlike RFC 5545, the start date is not an occurrence by itself - only dates matching the rule are.
+type RecurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time // inclusive, compared by date
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+	ExDates    map[string]bool // YYYY-MM-DD
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+var rruleWeekdayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// IsRecurrenceRule reports whether value looks like an RRULE rather than a preset name
+func IsRecurrenceRule(value string) bool {
+	v := strings.ToUpper(value)
+	return strings.Contains(v, "FREQ=")
+}
+
+// ParseRecurrenceRule parses an RRULE, optionally prefixed with "RRULE:" and followed by
+// EXDATE lines, e.g. "RRULE:FREQ=MONTHLY;BYDAY=2SA;COUNT=6\nEXDATE:20250412,20250510".
+func ParseRecurrenceRule(value string) (*RecurrenceRule, error) {
+	rule := &RecurrenceRule{Interval: 1, WeekStart: time.Monday, ExDates: map[string]bool{}}
+
+	lines := strings.FieldsFunc(strings.ReplaceAll(value, `\n`, "\n"), func(r rune) bool { return r == '\n' || r == '\r' })
+	foundRule := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		upper := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(upper, "EXDATE"):
+			idx := strings.Index(line, ":")
+			if idx < 0 {
+				return nil, fmt.Errorf("invalid EXDATE line %q", line)
+			}
+			for _, raw := range strings.Split(line[idx+1:], ",") {
+				d, err := parseRRuleDate(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid EXDATE value %q", raw)
+				}
+				rule.ExDates[d.Format("2006-01-02")] = true
+			}
+		case strings.HasPrefix(upper, "DTSTART"):
+			// the schedule start date is authoritative
+		default:
+			if foundRule {
+				return nil, fmt.Errorf("only one RRULE is supported")
+			}
+			if err := rule.parseParts(strings.TrimPrefix(upper, "RRULE:")); err != nil {
+				return nil, err
+			}
+			foundRule = true
+		}
+	}
+
+	if !foundRule {
+		return nil, fmt.Errorf("recurrence rule must contain FREQ")
+	}
+	return rule, nil
+}
+
+func (r *RecurrenceRule) parseParts(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid RRULE part %q", part)
+		}
+		key, val := kv[0], kv[1]
+		switch key {
+		case "FREQ":
+			switch val {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+				r.Freq = val
+			default:
+				return fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("INTERVAL must be a positive integer")
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("COUNT must be a positive integer")
+			}
+			r.Count = n
+		case "UNTIL":
+			d, err := parseRRuleDate(val)
+			if err != nil {
+				return fmt.Errorf("invalid UNTIL %q", val)
+			}
+			r.Until = &d
+		case "BYDAY":
+			for _, item := range strings.Split(val, ",") {
+				wd, err := parseWeekdayNum(item)
+				if err != nil {
+					return err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(item))
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return fmt.Errorf("invalid BYMONTHDAY %q", item)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, item := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(item))
+				if err != nil || n < 1 || n > 12 {
+					return fmt.Errorf("invalid BYMONTH %q", item)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			wd, ok := rruleWeekdays[val]
+			if !ok {
+				return fmt.Errorf("invalid WKST %q", val)
+			}
+			r.WeekStart = wd
+		default:
+			return fmt.Errorf("unsupported RRULE part %s", key)
+		}
+	}
+	if r.Freq == "" {
+		return fmt.Errorf("recurrence rule must contain FREQ")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return fmt.Errorf("COUNT and UNTIL cannot be used together")
+	}
+	return nil
+}
+
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	code := value[len(value)-2:]
+	wd, ok := rruleWeekdays[code]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	ord := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+		}
+		ord = n
+	}
+	return WeekdayNum{Ordinal: ord, Weekday: wd}, nil
+}
+
+func parseRRuleDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date")
+	}
+	bangkokLoc, _ := time.LoadLocation("Asia/Bangkok")
+	return time.ParseInLocation("20060102", value[:8], bangkokLoc)
+}
+
+// String renders the rule back to RRULE (and EXDATE) form
+func (r *RecurrenceRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+	if len(r.ByMonth) > 0 {
+		items := make([]string, 0, len(r.ByMonth))
+		for _, m := range r.ByMonth {
+			items = append(items, strconv.Itoa(int(m)))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(items, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		items := make([]string, 0, len(r.ByMonthDay))
+		for _, d := range r.ByMonthDay {
+			items = append(items, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(items, ","))
+	}
+	if len(r.ByDay) > 0 {
+		items := make([]string, 0, len(r.ByDay))
+		for _, d := range r.ByDay {
+			item := rruleWeekdayCodes[d.Weekday]
+			if d.Ordinal != 0 {
+				item = strconv.Itoa(d.Ordinal) + item
+			}
+			items = append(items, item)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(items, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+rruleWeekdayCodes[r.WeekStart])
+	}
+
+	out := "RRULE:" + strings.Join(parts, ";")
+	if len(r.ExDates) > 0 {
+		dates := make([]string, 0, len(r.ExDates))
+		for d := range r.ExDates {
+			dates = append(dates, strings.ReplaceAll(d, "-", ""))
+		}
+		sort.Strings(dates)
+		out += "\nEXDATE;VALUE=DATE:" + strings.Join(dates, ",")
+	}
+	return out
+}
+
+// PresetRecurrenceRule maps the legacy pattern names to rules relative to the start date.
+// customDays uses 0=Sunday (7 is accepted as Sunday too).
+func PresetRecurrenceRule(pattern string, start time.Time, customDays []int) (*RecurrenceRule, error) {
+	rule := &RecurrenceRule{Interval: 1, WeekStart: time.Monday, ExDates: map[string]bool{}}
+	switch strings.ToLower(strings.TrimSpace(pattern)) {
+	case "daily":
+		rule.Freq = FreqDaily
+	case "", "weekly":
+		rule.Freq = FreqWeekly
+		rule.ByDay = []WeekdayNum{{Weekday: start.Weekday()}}
+	case "bi-weekly":
+		rule.Freq = FreqWeekly
+		rule.Interval = 2
+		rule.ByDay = []WeekdayNum{{Weekday: start.Weekday()}}
+	case "monthly":
+		rule.Freq = FreqMonthly
+		rule.ByMonthDay = []int{start.Day()}
+	case "yearly":
+		rule.Freq = FreqYearly
+		rule.ByMonth = []time.Month{start.Month()}
+		rule.ByMonthDay = []int{start.Day()}
+	case "custom":
+		if len(customDays) == 0 {
+			return nil, fmt.Errorf("custom pattern requires custom_recurring_days")
+		}
+		rule.Freq = FreqWeekly
+		seen := map[time.Weekday]bool{}
+		for _, d := range customDays {
+			wd := time.Weekday(((d % 7) + 7) % 7)
+			if !seen[wd] {
+				seen[wd] = true
+				rule.ByDay = append(rule.ByDay, WeekdayNum{Weekday: wd})
+			}
+		}
+	case "none":
+		rule.Freq = FreqDaily
+		rule.Count = 1
+	default:
+		return nil, fmt.Errorf("unsupported recurring pattern %q", pattern)
+	}
+	return rule, nil
+}
+
+// ScheduleRecurrenceRule resolves the rule of a schedule: RecurrenceRule wins, then an RRULE
+// placed in Recurring_pattern, then the preset for the pattern name.
+func ScheduleRecurrenceRule(schedule models.Schedules, customDays []int) (*RecurrenceRule, error) {
+	if strings.TrimSpace(schedule.RecurrenceRule) != "" {
+		return ParseRecurrenceRule(schedule.RecurrenceRule)
+	}
+	if IsRecurrenceRule(schedule.Recurring_pattern) {
+		return ParseRecurrenceRule(schedule.Recurring_pattern)
+	}
+	return PresetRecurrenceRule(schedule.Recurring_pattern, schedule.Start_date, customDays)
+}
+
+// slotRecurrenceRule resolves the rule used with explicit weekday/time slots. Presets are expressed
+// on the slot weekdays: weekly/custom/daily -> every week, bi-weekly -> every other week,
+// monthly/yearly -> the same nth weekday as the start date each month/year.
+func slotRecurrenceRule(schedule models.Schedules, slots []SessionSlot) (*RecurrenceRule, error) {
+	days := make([]time.Weekday, 0, len(slots))
+	seen := map[time.Weekday]bool{}
+	for _, slot := range slots {
+		if !seen[slot.Weekday] {
+			seen[slot.Weekday] = true
+			days = append(days, slot.Weekday)
+		}
+	}
+	withOrdinal := func(ord int) []WeekdayNum {
+		out := make([]WeekdayNum, 0, len(days))
+		for _, d := range days {
+			out = append(out, WeekdayNum{Ordinal: ord, Weekday: d})
+		}
+		return out
+	}
+
+	if strings.TrimSpace(schedule.RecurrenceRule) != "" || IsRecurrenceRule(schedule.Recurring_pattern) {
+		rule, err := ScheduleRecurrenceRule(schedule, nil)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Freq == FreqWeekly && len(rule.ByDay) == 0 {
+			rule.ByDay = withOrdinal(0)
+		}
+		return rule, nil
+	}
+
+	rule := &RecurrenceRule{Freq: FreqWeekly, Interval: 1, WeekStart: time.Monday, ExDates: map[string]bool{}}
+	nth := (schedule.Start_date.Day()-1)/7 + 1
+	if nth > 4 {
+		nth = -1 // 5th weekday does not exist every month; use "last"
+	}
+	switch strings.ToLower(strings.TrimSpace(schedule.Recurring_pattern)) {
+	case "bi-weekly":
+		rule.Interval = 2
+		rule.ByDay = withOrdinal(0)
+	case "monthly":
+		rule.Freq = FreqMonthly
+		rule.ByDay = withOrdinal(nth)
+	case "yearly":
+		rule.Freq = FreqYearly
+		rule.ByMonth = []time.Month{schedule.Start_date.Month()}
+		rule.ByDay = withOrdinal(nth)
+	default:
+		rule.ByDay = withOrdinal(0)
+	}
+	return rule, nil
+}
+
+// Each calls fn with every occurrence date (midnight in start's location) from start onward,
+// until fn returns false or COUNT / UNTIL / the iteration guard is reached.
+// EXDATE dates are skipped but still count toward COUNT, as in RFC 5545.
+func (r *RecurrenceRule) Each(start time.Time, fn func(day time.Time) bool) {
+	loc := start.Location()
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	guard := first.AddDate(maxRecurrenceYears, 0, 0)
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	generated := 0
+	for day := first; !day.After(guard); day = day.AddDate(0, 0, 1) {
+		if r.Until != nil {
+			until := time.Date(r.Until.Year(), r.Until.Month(), r.Until.Day(), 0, 0, 0, 0, loc)
+			if day.After(until) {
+				return
+			}
+		}
+		if !r.matches(day, first, interval) {
+			continue
+		}
+		generated++
+		if !r.ExDates[day.Format("2006-01-02")] {
+			if !fn(day) {
+				return
+			}
+		}
+		if r.Count > 0 && generated >= r.Count {
+			return
+		}
+	}
+}
+
+// Occurrences returns up to limit occurrence dates (limit <= 0 means no limit besides the rule itself)
+func (r *RecurrenceRule) Occurrences(start time.Time, limit int) []time.Time {
+	out := make([]time.Time, 0)
+	r.Each(start, func(day time.Time) bool {
+		out = append(out, day)
+		return limit <= 0 || len(out) < limit
+	})
+	return out
+}
+
+func (r *RecurrenceRule) matches(day, start time.Time, interval int) bool {
+	if len(r.ByMonth) > 0 && !containsMonth(r.ByMonth, day.Month()) {
+		return false
+	}
+
+	switch r.Freq {
+	case FreqDaily:
+		if daysBetween(start, day)%interval != 0 {
+			return false
+		}
+		return r.matchMonthDay(day, true) && r.matchByDayPlain(day, true)
+	case FreqWeekly:
+		if weeksBetween(start, day, r.WeekStart)%interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return day.Weekday() == start.Weekday() && r.matchMonthDay(day, true)
+		}
+		return r.matchByDayPlain(day, false) && r.matchMonthDay(day, true)
+	case FreqMonthly:
+		months := (day.Year()-start.Year())*12 + int(day.Month()) - int(start.Month())
+		if months%interval != 0 {
+			return false
+		}
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			return day.Day() == start.Day()
+		}
+		return r.matchMonthDay(day, true) && r.matchByDayOrdinal(day, true, true)
+	case FreqYearly:
+		if (day.Year()-start.Year())%interval != 0 {
+			return false
+		}
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			if len(r.ByMonth) == 0 && day.Month() != start.Month() {
+				return false
+			}
+			return day.Day() == start.Day()
+		}
+		// ordinals are counted within the month when BYMONTH is present, otherwise within the year
+		return r.matchMonthDay(day, true) && r.matchByDayOrdinal(day, true, len(r.ByMonth) > 0)
+	}
+	return false
+}
+
+func (r *RecurrenceRule) matchMonthDay(day time.Time, emptyOK bool) bool {
+	if len(r.ByMonthDay) == 0 {
+		return emptyOK
+	}
+	dim := daysIn(day.Year(), day.Month())
+	for _, md := range r.ByMonthDay {
+		if md > 0 && day.Day() == md {
+			return true
+		}
+		if md < 0 && day.Day() == dim+md+1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RecurrenceRule) matchByDayPlain(day time.Time, emptyOK bool) bool {
+	if len(r.ByDay) == 0 {
+		return emptyOK
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RecurrenceRule) matchByDayOrdinal(day time.Time, emptyOK, inMonth bool) bool {
+	if len(r.ByDay) == 0 {
+		return emptyOK
+	}
+	var nth, fromEnd int
+	if inMonth {
+		dim := daysIn(day.Year(), day.Month())
+		nth = (day.Day()-1)/7 + 1
+		fromEnd = (dim-day.Day())/7 + 1
+	} else {
+		diy := time.Date(day.Year(), 12, 31, 0, 0, 0, 0, day.Location()).YearDay()
+		nth = (day.YearDay()-1)/7 + 1
+		fromEnd = (diy-day.YearDay())/7 + 1
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday != day.Weekday() {
+			continue
+		}
+		if wd.Ordinal == 0 || wd.Ordinal == nth || wd.Ordinal == -fromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonth(months []time.Month, m time.Month) bool {
+	for _, v := range months {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func daysBetween(a, b time.Time) int {
+	ua := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	ub := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(ub.Sub(ua).Hours() / 24)
+}
+
+// weeksBetween counts week boundaries (starting at wkst) between a and b
+func weeksBetween(a, b time.Time, wkst time.Weekday) int {
+	weekStart := func(t time.Time) time.Time {
+		offset := (int(t.Weekday()) - int(wkst) + 7) % 7
+		d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return d.AddDate(0, 0, -offset)
+	}
+	return daysBetween(weekStart(a), weekStart(b)) / 7
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func formatDates(days []time.Time) []string {
+	out := make([]string, 0, len(days))
+	for _, d := range days {
+		out = append(out, d.Format("2006-01-02"))
+	}
+	return out
+}
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		limit int
+		exp   []string
+	}{
+		{
+			name:  "monthly by nth weekday",
+			rule:  "RRULE:FREQ=MONTHLY;BYDAY=2SA;COUNT=3",
+			start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			exp:   []string{"2025-01-11", "2025-02-08", "2025-03-08"},
+		},
+		{
+			name:  "monthly last friday",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR;COUNT=2",
+			start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			exp:   []string{"2025-01-31", "2025-02-28"},
+		},
+		{
+			name:  "monthly day 31 skips short months",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31",
+			start: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+			limit: 3,
+			exp:   []string{"2025-01-31", "2025-03-31", "2025-05-31"},
+		},
+		{
+			name:  "every other week on two days",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=4",
+			start: time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC),
+			exp:   []string{"2025-01-06", "2025-01-09", "2025-01-20", "2025-01-23"},
+		},
+		{
+			name:  "until is inclusive",
+			rule:  "FREQ=WEEKLY;UNTIL=20250120",
+			start: time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC),
+			exp:   []string{"2025-01-06", "2025-01-13", "2025-01-20"},
+		},
+		{
+			name:  "exdate counts toward count",
+			rule:  "RRULE:FREQ=DAILY;COUNT=3\nEXDATE;VALUE=DATE:20250102",
+			start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			exp:   []string{"2025-01-01", "2025-01-03"},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := ParseRecurrenceRule(tc.rule)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := formatDates(rule.Occurrences(tc.start, tc.limit))
+			if len(got) != len(tc.exp) {
+				t.Fatalf("expected %v, got %v", tc.exp, got)
+			}
+			for i := range got {
+				if got[i] != tc.exp[i] {
+					t.Fatalf("expected %v, got %v", tc.exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPresetRecurrence(t *testing.T) {
+	monthly, err := PresetRecurrenceRule("monthly", time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := formatDates(monthly.Occurrences(time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), 3)); got[2] != "2025-03-15" {
+		t.Fatalf("unexpected monthly occurrences %v", got)
+	}
+
+	yearly, err := PresetRecurrenceRule("yearly", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := formatDates(yearly.Occurrences(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), 2)); got[1] != "2028-02-29" {
+		t.Fatalf("unexpected yearly occurrences %v", got)
+	}
+
+	if _, err := PresetRecurrenceRule("custom", time.Now(), nil); err == nil {
+		t.Fatalf("expected error for custom pattern without days")
+	}
+}
+
+func TestParseRecurrenceRuleInvalid(t *testing.T) {
+	for _, value := range []string{"FREQ=HOURLY", "FREQ=DAILY;COUNT=2;UNTIL=20250101", "BYDAY=MO", "FREQ=WEEKLY;BYDAY=XX"} {
+		if _, err := ParseRecurrenceRule(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestGenerateScheduleSessionsMonthly(t *testing.T) {
+	schedule := models.Schedules{
+		Recurring_pattern: "monthly",
+		Total_hours:       6,
+		Hours_per_session: 2,
+		Session_per_week:  1,
+		Start_date:        time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+	}
+	sessions, err := GenerateScheduleSessions(schedule, "18:00", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sessions))
+	}
+	if got := sessions[2].Session_date.Format("2006-01-02"); got != "2025-03-15" {
+		t.Fatalf("expected third session on 2025-03-15, got %s", got)
+	}
+}
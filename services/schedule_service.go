@@ -3,6 +3,7 @@ package services
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +15,8 @@ import (
 	"englishkorat_go/database"
 	"englishkorat_go/models"
 	notifsvc "englishkorat_go/services/notifications"
+
+	"gorm.io/gorm"
 )
 
 // HolidayResponse represents the Thai holiday API response
@@ -93,56 +96,6 @@ func fetchMyHoraJSONWithNames(year int) (map[string]string, error) {
 	return extractHolidaysFromResponse(holidayResp), nil
 }
 
-func fetchMyHoraJSON(year int) ([]time.Time, error) {
-	buddhistYear := year + 543
-	url := fmt.Sprintf("https://www.myhora.com/calendar/ical/holiday.aspx?%d.json", buddhistYear)
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create holiday request for year %d: %w", year, err)
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; EnglishKorat/1.0; +https://englishkorat.com)")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Referer", fmt.Sprintf("https://www.myhora.com/calendar/%d.aspx", buddhistYear))
-
-	resp, err := myHoraHTTPClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch holidays for year %d: %w", year, err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read holiday response for year %d: %w", year, err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch holidays for year %d: status %d", year, resp.StatusCode)
-	}
-
-	trimmed := strings.TrimSpace(string(body))
-	if trimmed == "" {
-		return nil, nil
-	}
-	if strings.HasPrefix(trimmed, "<") {
-		return nil, fmt.Errorf("failed to decode holiday response for year %d: unexpected html content", year)
-	}
-
-	var holidayResp HolidayResponse
-	if err := json.Unmarshal(body, &holidayResp); err != nil {
-		return nil, fmt.Errorf("failed to decode holiday response for year %d: %w", year, err)
-	}
-
-	holidayMap := extractHolidaysFromResponse(holidayResp)
-	result := make([]time.Time, 0, len(holidayMap))
-	for dateStr := range holidayMap {
-		if date, err := time.Parse("2006-01-02", dateStr); err == nil {
-			result = append(result, date)
-		}
-	}
-	return result, nil
-}
-
 func fetchMyHoraICSWithNames(year int) (map[string]string, error) {
 	buddhistYear := year + 543
 	url := fmt.Sprintf("https://www.myhora.com/calendar/ical/holiday.aspx?%d.ics", buddhistYear)
@@ -165,104 +118,83 @@ func fetchMyHoraICSWithNames(year int) (map[string]string, error) {
 		return nil, fmt.Errorf("failed to fetch fallback holidays for year %d: status %d", year, resp.StatusCode)
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	holidayMap := make(map[string]string)
-	var currentDate string
-	var currentSummary string
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if strings.HasPrefix(line, "DTSTART") {
-			colonIdx := strings.Index(line, ":")
-			if colonIdx == -1 {
-				continue
-			}
-			value := strings.TrimSpace(line[colonIdx+1:])
-			if len(value) > 8 {
-				value = value[:8]
-			}
-			if len(value) == 8 {
-				if date, err := time.Parse("20060102", value); err == nil {
-					currentDate = date.Format("2006-01-02")
-				}
-			}
-		} else if strings.HasPrefix(line, "SUMMARY:") {
-			currentSummary = strings.TrimSpace(strings.TrimPrefix(line, "SUMMARY:"))
-		} else if line == "END:VEVENT" {
-			if currentDate != "" && currentSummary != "" {
-				if _, exists := holidayMap[currentDate]; !exists {
-					holidayMap[currentDate] = currentSummary
-				}
-			}
-			currentDate = ""
-			currentSummary = ""
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
+	holidayMap, err := parseICSHolidays(resp.Body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse fallback holidays for year %d: %w", year, err)
 	}
-
 	return holidayMap, nil
 }
 
-func fetchMyHoraICS(year int) ([]time.Time, error) {
-	buddhistYear := year + 543
-	url := fmt.Sprintf("https://www.myhora.com/calendar/ical/holiday.aspx?%d.ics", buddhistYear)
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create holiday fallback request for year %d: %w", year, err)
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; EnglishKorat/1.0; +https://englishkorat.com)")
-	req.Header.Set("Accept", "text/calendar, text/plain, */*")
-	req.Header.Set("Referer", fmt.Sprintf("https://www.myhora.com/calendar/%d.aspx", buddhistYear))
-
-	resp, err := myHoraHTTPClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch fallback holidays for year %d: %w", year, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch fallback holidays for year %d: status %d", year, resp.StatusCode)
-	}
-
-	scanner := bufio.NewScanner(resp.Body)
-	seen := make(map[string]struct{})
-	holidayDates := make([]time.Time, 0)
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if !strings.HasPrefix(line, "DTSTART") {
-			continue
-		}
+// parseICSHolidays อ่าน VEVENT จากไฟล์ iCalendar แล้วคืน map วันที่ (YYYY-MM-DD) -> ชื่อวันหยุด
+// all-day events ที่มี DTEND จะถูกขยายเป็นทุกวันในช่วง (DTEND ไม่รวม ตามมาตรฐาน iCalendar)
+func parseICSHolidays(r io.Reader) (map[string]string, error) {
+	scanner := bufio.NewScanner(r)
+	holidayMap := make(map[string]string)
+	var (
+		inEvent        bool
+		currentStart   time.Time
+		currentEnd     time.Time
+		currentSummary string
+	)
+
+	parseDateValue := func(line string) time.Time {
 		colonIdx := strings.Index(line, ":")
 		if colonIdx == -1 {
-			continue
+			return time.Time{}
 		}
 		value := strings.TrimSpace(line[colonIdx+1:])
 		if len(value) > 8 {
 			value = value[:8]
 		}
-		if len(value) != 8 {
-			continue
+		date, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}
 		}
-		if date, err := time.Parse("20060102", value); err == nil {
-			key := date.Format("2006-01-02")
-			if _, exists := seen[key]; !exists {
-				seen[key] = struct{}{}
-				holidayDates = append(holidayDates, date)
+		return date
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+			currentStart, currentEnd, currentSummary = time.Time{}, time.Time{}, ""
+		case !inEvent:
+			continue
+		case strings.HasPrefix(line, "DTSTART"):
+			currentStart = parseDateValue(line)
+		case strings.HasPrefix(line, "DTEND"):
+			currentEnd = parseDateValue(line)
+		case strings.HasPrefix(line, "SUMMARY"):
+			if colonIdx := strings.Index(line, ":"); colonIdx != -1 {
+				currentSummary = strings.TrimSpace(line[colonIdx+1:])
+			}
+		case line == "END:VEVENT":
+			inEvent = false
+			if currentStart.IsZero() || currentSummary == "" {
+				continue
+			}
+			days := 1
+			if !currentEnd.IsZero() && currentEnd.After(currentStart) {
+				days = int(currentEnd.Sub(currentStart).Hours() / 24)
+				if days > 31 {
+					days = 31 // ป้องกัน event ที่ยาวผิดปกติ
+				}
+			}
+			for i := 0; i < days; i++ {
+				key := currentStart.AddDate(0, 0, i).Format("2006-01-02")
+				if _, exists := holidayMap[key]; !exists {
+					holidayMap[key] = currentSummary
+				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to parse fallback holidays for year %d: %w", year, err)
+		return nil, err
 	}
-
-	return holidayDates, nil
+	return holidayMap, nil
 }
 
 func extractHolidaysFromResponse(resp HolidayResponse) map[string]string {
@@ -362,166 +294,110 @@ func sessionsOverlap(session1, session2 models.Schedule_Sessions) bool {
 }
 
 // generateSessionTimes สร้างเวลา session ชั่วคราวสำหรับตรวจสอบการชน
+// recurringPattern รับได้ทั้งชื่อ preset (daily, weekly, ...) และ RRULE
 func generateSessionTimes(startDate, endDate time.Time, sessionStartTime time.Time, hoursPerSession int, recurringPattern string, customDays []int) []models.Schedule_Sessions {
 	var sessions []models.Schedule_Sessions
-	current := startDate
-	sessionNumber := 1
-
-	// Normalize custom days: accept 0..6 with 0=Sun, and map 7->0 (some clients send 7 for Sunday)
-	normalizedDays := make(map[int]bool, len(customDays))
-	for _, d := range customDays {
-		if d == 7 {
-			d = 0
-		}
-		if d < 0 {
-			d = 0
-		}
-		if d > 6 {
-			d = d % 7
-		}
-		normalizedDays[d] = true
-	}
-
-	for current.Before(endDate) || current.Equal(endDate) {
-		shouldCreateSession := false
-
-		switch recurringPattern {
-		case "daily":
-			shouldCreateSession = true
-		case "weekly":
-			shouldCreateSession = current.Weekday() == startDate.Weekday()
-		case "bi-weekly":
-			weeksDiff := int(current.Sub(startDate).Hours() / (24 * 7))
-			shouldCreateSession = weeksDiff%2 == 0 && current.Weekday() == startDate.Weekday()
-		case "custom":
-			weekday := int(current.Weekday())
-			if normalizedDays[weekday] {
-				shouldCreateSession = true
-			}
-		case "none":
-			// One-off / non-recurring schedule: create only the first session
-			if sessionNumber == 1 {
-				shouldCreateSession = true
-			}
-		}
 
-		if shouldCreateSession {
-			sessionStart := time.Date(current.Year(), current.Month(), current.Day(),
-				sessionStartTime.Hour(), sessionStartTime.Minute(), 0, 0, current.Location())
-			sessionEnd := sessionStart.Add(time.Duration(hoursPerSession) * time.Hour)
+	var (
+		rule *RecurrenceRule
+		err  error
+	)
+	if IsRecurrenceRule(recurringPattern) {
+		rule, err = ParseRecurrenceRule(recurringPattern)
+	} else {
+		rule, err = PresetRecurrenceRule(recurringPattern, startDate, customDays)
+	}
+	if err != nil {
+		return sessions
+	}
 
-			// Convert times to pointers to match nullable model fields
-			sd := current
-			ss := sessionStart
-			se := sessionEnd
-			session := models.Schedule_Sessions{
-				Session_date:          &sd,
-				Start_time:            &ss,
-				End_time:              &se,
-				Session_number:        sessionNumber,
-				Status:                "scheduled",
-				Makeup_for_session_id: nil,
-			}
-			sessions = append(sessions, session)
-			sessionNumber++
+	sessionNumber := 1
+	rule.Each(startDate, func(current time.Time) bool {
+		if current.After(endDate) {
+			return false
 		}
-
-		current = current.AddDate(0, 0, 1)
-	}
+		sessionStart := time.Date(current.Year(), current.Month(), current.Day(),
+			sessionStartTime.Hour(), sessionStartTime.Minute(), 0, 0, current.Location())
+		sessionEnd := sessionStart.Add(time.Duration(hoursPerSession) * time.Hour)
+
+		// Convert times to pointers to match nullable model fields
+		sd := current
+		ss := sessionStart
+		se := sessionEnd
+		sessions = append(sessions, models.Schedule_Sessions{
+			Session_date:          &sd,
+			Start_time:            &ss,
+			End_time:              &se,
+			Session_number:        sessionNumber,
+			Status:                "scheduled",
+			Makeup_for_session_id: nil,
+		})
+		sessionNumber++
+		return true
+	})
 
 	return sessions
 }
 
-// GenerateScheduleSessions สร้าง sessions ตาม recurring pattern
+// GenerateScheduleSessions สร้าง sessions ตาม recurring pattern หรือ RecurrenceRule (RRULE) ของ schedule
 func GenerateScheduleSessions(schedule models.Schedules, sessionStartTime string, customDays []int) ([]models.Schedule_Sessions, error) {
 	startTime, err := time.Parse("15:04", sessionStartTime)
 	if err != nil {
 		return nil, fmt.Errorf("invalid session start time format")
 	}
 
+	rule, err := ScheduleRecurrenceRule(schedule, customDays)
+	if err != nil {
+		return nil, err
+	}
+
 	var sessions []models.Schedule_Sessions
-	current := schedule.Start_date
 	sessionNumber := 1
 	weekNumber := 1
 
 	// คำนวณ sessions ที่ต้องการทั้งหมด
 	totalSessions := schedule.Total_hours / schedule.Hours_per_session
 
-	// Determine an end guard: if Estimated_end_date is zero, derive a soft cap by days to cover required sessions
-	// Assume max of 365 days lookahead to avoid infinite loop when no end date provided.
+	// Estimated_end_date (if set) caps generation; otherwise the rule's COUNT/UNTIL or the session total does
 	hasEndDate := !schedule.Estimated_end_date.IsZero()
-	softEnd := schedule.Start_date.AddDate(1, 0, 0) // one year cap
-
-	// Normalize custom days for this generator as well
-	normalizedDays := make(map[int]bool, len(customDays))
-	for _, d := range customDays {
-		if d == 7 {
-			d = 0
-		}
-		if d < 0 {
-			d = 0
-		}
-		if d > 6 {
-			d = d % 7
-		}
-		normalizedDays[d] = true
-	}
 
 	// Use Asia/Bangkok timezone for consistent date handling
 	bangkokLoc, _ := time.LoadLocation("Asia/Bangkok")
+	startInLoc := schedule.Start_date.In(bangkokLoc)
+	var endDate time.Time
+	if hasEndDate {
+		endInLoc := schedule.Estimated_end_date.In(bangkokLoc)
+		endDate = time.Date(endInLoc.Year(), endInLoc.Month(), endInLoc.Day(), 0, 0, 0, 0, bangkokLoc)
+	}
 
-	for sessionNumber <= totalSessions && ((hasEndDate && (current.Before(schedule.Estimated_end_date) || current.Equal(schedule.Estimated_end_date))) || (!hasEndDate && current.Before(softEnd))) {
-		shouldCreateSession := false
-
-		switch schedule.Recurring_pattern {
-		case "daily":
-			shouldCreateSession = true
-		case "weekly":
-			shouldCreateSession = current.Weekday() == schedule.Start_date.Weekday()
-		case "bi-weekly":
-			weeksDiff := int(current.Sub(schedule.Start_date).Hours() / (24 * 7))
-			shouldCreateSession = weeksDiff%2 == 0 && current.Weekday() == schedule.Start_date.Weekday()
-		case "monthly":
-			shouldCreateSession = current.Day() == schedule.Start_date.Day()
-		case "custom":
-			weekday := int(current.Weekday())
-			if normalizedDays[weekday] {
-				shouldCreateSession = true
-			}
-		case "none":
-			// One-off / non-recurring schedule: create only the first session
-			if sessionNumber == 1 {
-				shouldCreateSession = true
-			}
+	rule.Each(startInLoc, func(current time.Time) bool {
+		if sessionNumber > totalSessions || (hasEndDate && current.After(endDate)) {
+			return false
 		}
 
-		if shouldCreateSession {
-			// Use the specific date for session, maintaining consistent timezone
-			sessionDate := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, bangkokLoc)
-			sessionStart := time.Date(current.Year(), current.Month(), current.Day(),
-				startTime.Hour(), startTime.Minute(), 0, 0, bangkokLoc)
-			sessionEnd := sessionStart.Add(time.Duration(schedule.Hours_per_session) * time.Hour)
-
-			session := models.Schedule_Sessions{
-				Session_date:          &sessionDate,
-				Start_time:            &sessionStart,
-				End_time:              &sessionEnd,
-				Session_number:        sessionNumber,
-				Week_number:           weekNumber,
-				Status:                "scheduled",
-				Makeup_for_session_id: nil,
-			}
-			sessions = append(sessions, session)
-			sessionNumber++
+		// Use the specific date for session, maintaining consistent timezone
+		sessionDate := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, bangkokLoc)
+		sessionStart := time.Date(current.Year(), current.Month(), current.Day(),
+			startTime.Hour(), startTime.Minute(), 0, 0, bangkokLoc)
+		sessionEnd := sessionStart.Add(time.Duration(schedule.Hours_per_session) * time.Hour)
+
+		sessions = append(sessions, models.Schedule_Sessions{
+			Session_date:          &sessionDate,
+			Start_time:            &sessionStart,
+			End_time:              &sessionEnd,
+			Session_number:        sessionNumber,
+			Week_number:           weekNumber,
+			Status:                "scheduled",
+			Makeup_for_session_id: nil,
+		})
+		sessionNumber++
 
-			// เพิ่ม week number ตาม session per week
-			if sessionNumber%schedule.Session_per_week == 1 && sessionNumber > 1 {
-				weekNumber++
-			}
+		// เพิ่ม week number ตาม session per week
+		if schedule.Session_per_week > 0 && sessionNumber%schedule.Session_per_week == 1 && sessionNumber > 1 {
+			weekNumber++
 		}
-
-		current = current.AddDate(0, 0, 1)
-	}
+		return sessionNumber <= totalSessions
+	})
 
 	return sessions, nil
 }
@@ -574,51 +450,54 @@ func GenerateScheduleSessionsWithSlots(schedule models.Schedules, slots []Sessio
 	startInLoc := schedule.Start_date.In(bangkokLoc)
 	startDate := time.Date(startInLoc.Year(), startInLoc.Month(), startInLoc.Day(), 0, 0, 0, 0, bangkokLoc)
 
+	// Dates come from the recurrence rule (preset on the slot weekdays or the schedule's RRULE)
+	rule, err := slotRecurrenceRule(schedule, slots)
+	if err != nil {
+		return nil, err
+	}
+
 	sessions := make([]models.Schedule_Sessions, 0, totalSessions)
-	current := startDate
-	maxDays := 366 * 2 // guard against runaway loops (2 years)
-	processedDays := 0
-
-	for len(sessions) < totalSessions && processedDays <= maxDays {
-		daySlots := slotsByDay[current.Weekday()]
-		if len(daySlots) > 0 {
-			for _, slot := range daySlots {
-				if len(sessions) >= totalSessions {
-					break
-				}
+	var genErr error
 
-				sessionStart := time.Date(current.Year(), current.Month(), current.Day(), slot.StartHour, slot.StartMinute, 0, 0, bangkokLoc)
-				sessionEnd := sessionStart.Add(time.Duration(hoursPerSession) * time.Hour)
-				sessionDate := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, bangkokLoc)
+	rule.Each(startDate, func(current time.Time) bool {
+		for _, slot := range slotsByDay[current.Weekday()] {
+			if len(sessions) >= totalSessions {
+				break
+			}
 
-				// Additional safety: ensure session end still within same day bounds
-				if sessionEnd.Sub(sessionStart) != time.Duration(hoursPerSession)*time.Hour {
-					return nil, fmt.Errorf("failed to compute session duration for %s", sessionStart.Format(time.RFC3339))
-				}
+			sessionStart := time.Date(current.Year(), current.Month(), current.Day(), slot.StartHour, slot.StartMinute, 0, 0, bangkokLoc)
+			sessionEnd := sessionStart.Add(time.Duration(hoursPerSession) * time.Hour)
+			sessionDate := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, bangkokLoc)
 
-				sd := sessionDate
-				st := sessionStart
-				et := sessionEnd
+			// Additional safety: ensure session end still within same day bounds
+			if sessionEnd.Sub(sessionStart) != time.Duration(hoursPerSession)*time.Hour {
+				genErr = fmt.Errorf("failed to compute session duration for %s", sessionStart.Format(time.RFC3339))
+				return false
+			}
 
-				sessionNumber := len(sessions) + 1
-				weeksFromStart := int(sd.Sub(startDate).Hours() / (24 * 7))
-				if weeksFromStart < 0 {
-					weeksFromStart = 0
-				}
+			sd := sessionDate
+			st := sessionStart
+			et := sessionEnd
 
-				sessions = append(sessions, models.Schedule_Sessions{
-					Session_date:   &sd,
-					Start_time:     &st,
-					End_time:       &et,
-					Session_number: sessionNumber,
-					Week_number:    weeksFromStart + 1,
-					Status:         "scheduled",
-				})
+			sessionNumber := len(sessions) + 1
+			weeksFromStart := int(sd.Sub(startDate).Hours() / (24 * 7))
+			if weeksFromStart < 0 {
+				weeksFromStart = 0
 			}
-		}
 
-		current = current.AddDate(0, 0, 1)
-		processedDays++
+			sessions = append(sessions, models.Schedule_Sessions{
+				Session_date:   &sd,
+				Start_time:     &st,
+				End_time:       &et,
+				Session_number: sessionNumber,
+				Week_number:    weeksFromStart + 1,
+				Status:         "scheduled",
+			})
+		}
+		return len(sessions) < totalSessions
+	})
+	if genErr != nil {
+		return nil, genErr
 	}
 
 	if len(sessions) != totalSessions {
@@ -678,118 +557,52 @@ func ReindexSessions(sessions []models.Schedule_Sessions, startDate time.Time) {
 	}
 }
 
-// GetThaiHolidaysWithNames ดึงวันหยุดไทยพร้อมชื่อจาก API
-func GetThaiHolidaysWithNames(startYear, endYear int) (map[string]string, error) {
-	holidayNames := make(map[string]string)
-	var errorSummaries []string
-
-	for year := startYear; year <= endYear; year++ {
-		var yearHolidays map[string]string
-		var yearErrors []string
+// fetchMyHoraHolidays ดึงวันหยุดไทยของปีจาก myhora (JSON ก่อน แล้ว fallback เป็น ICS)
+func fetchMyHoraHolidays(year int) (map[string]string, error) {
+	var yearErrors []string
 
-		if holidays, err := fetchMyHoraJSONWithNames(year); err != nil {
+	holidays, err := fetchMyHoraJSONWithNames(year)
+	if err != nil {
+		yearErrors = append(yearErrors, err.Error())
+	}
+	if len(holidays) == 0 {
+		holidays, err = fetchMyHoraICSWithNames(year)
+		if err != nil {
 			yearErrors = append(yearErrors, err.Error())
-		} else {
-			yearHolidays = holidays
-		}
-
-		if len(yearHolidays) == 0 {
-			if holidays, err := fetchMyHoraICSWithNames(year); err != nil {
-				yearErrors = append(yearErrors, err.Error())
-			} else {
-				yearHolidays = holidays
-			}
-		}
-
-		if len(yearHolidays) == 0 {
-			if len(yearErrors) > 0 {
-				errorSummaries = append(errorSummaries, fmt.Sprintf("%d: %s", year, strings.Join(yearErrors, " | ")))
-			}
-			continue
-		}
-
-		for dateStr, name := range yearHolidays {
-			if _, exists := holidayNames[dateStr]; !exists {
-				holidayNames[dateStr] = name
-			}
 		}
 	}
 
-	if len(errorSummaries) > 0 {
-		if len(holidayNames) == 0 {
-			return nil, fmt.Errorf("failed to fetch holidays: %s", strings.Join(errorSummaries, "; "))
-		}
-		log.Printf("warning: partial holiday fetch failures: %s", strings.Join(errorSummaries, "; "))
+	if len(holidays) == 0 && len(yearErrors) > 0 {
+		return nil, errors.New(strings.Join(yearErrors, " | "))
 	}
+	return holidays, nil
+}
 
-	return holidayNames, nil
+// GetThaiHolidaysWithNames ดึงวันหยุดที่ใช้กับทุกสาขาพร้อมชื่อจากตาราง holidays
+func GetThaiHolidaysWithNames(startYear, endYear int) (map[string]string, error) {
+	return GetBranchHolidaysWithNames(startYear, endYear, nil)
 }
 
-// GetThaiHolidays ดึงวันหยุดไทยจาก API
+// GetThaiHolidays ดึงวันหยุดที่ใช้กับทุกสาขาจากตาราง holidays
 func GetThaiHolidays(startYear, endYear int) ([]time.Time, error) {
-	seen := make(map[string]struct{})
-	var allHolidays []time.Time
-	var errorSummaries []string
-
-	for year := startYear; year <= endYear; year++ {
-		var yearHolidays []time.Time
-		var yearErrors []string
-
-		if holidays, err := fetchMyHoraJSON(year); err != nil {
-			yearErrors = append(yearErrors, err.Error())
-		} else {
-			yearHolidays = append(yearHolidays, holidays...)
-		}
-
-		if len(yearHolidays) == 0 {
-			if holidays, err := fetchMyHoraICS(year); err != nil {
-				yearErrors = append(yearErrors, err.Error())
-			} else {
-				yearHolidays = append(yearHolidays, holidays...)
-			}
-		}
-
-		if len(yearHolidays) == 0 {
-			if len(yearErrors) > 0 {
-				errorSummaries = append(errorSummaries, fmt.Sprintf("%d: %s", year, strings.Join(yearErrors, " | ")))
-			}
-			continue
-		}
-
-		for _, date := range yearHolidays {
-			key := date.Format("2006-01-02")
-			if _, exists := seen[key]; exists {
-				continue
-			}
-			seen[key] = struct{}{}
-			allHolidays = append(allHolidays, date)
-		}
-	}
-
-	sort.Slice(allHolidays, func(i, j int) bool {
-		return allHolidays[i].Before(allHolidays[j])
-	})
-
-	if len(errorSummaries) > 0 {
-		if len(allHolidays) == 0 {
-			return nil, fmt.Errorf("failed to fetch holidays: %s", strings.Join(errorSummaries, "; "))
-		}
-		log.Printf("warning: partial holiday fetch failures: %s", strings.Join(errorSummaries, "; "))
-	}
-
-	return allHolidays, nil
+	return GetBranchHolidays(startYear, endYear, nil)
 }
 
 // RescheduleSessions ปรับ sessions ที่ตรงกับวันหยุด โดยยกเลิกและไปต่อท้าย
 func RescheduleSessions(sessions []models.Schedule_Sessions, holidays []time.Time) []models.Schedule_Sessions {
-	if len(sessions) == 0 {
-		return sessions
-	}
-
 	holidayMap := make(map[string]bool)
 	for _, holiday := range holidays {
 		holidayMap[holiday.Format("2006-01-02")] = true
 	}
+	return rescheduleSessionsOn(sessions, holidayMap, holidayMap)
+}
+
+// rescheduleSessionsOn ย้าย sessions ที่อยู่ในวัน moveDates ไปต่อท้าย โดยวันใหม่ต้องไม่ตรงกับ holidayMap
+// (วันหยุดอื่นที่ไม่อยู่ใน moveDates จะไม่ถูกย้าย)
+func rescheduleSessionsOn(sessions []models.Schedule_Sessions, moveDates, holidayMap map[string]bool) []models.Schedule_Sessions {
+	if len(sessions) == 0 {
+		return sessions
+	}
 
 	// สกัด pattern ของวันที่ใช้ในตาราง (weekdays ที่ใช้)
 	weekdayPattern := make(map[time.Weekday]bool)
@@ -813,7 +626,7 @@ func RescheduleSessions(sessions []models.Schedule_Sessions, holidays []time.Tim
 
 	for _, session := range sessions {
 		sessionDate := session.Session_date.Format("2006-01-02")
-		if holidayMap[sessionDate] {
+		if moveDates[sessionDate] {
 			postponedSessions = append(postponedSessions, session)
 		} else {
 			rescheduledSessions = append(rescheduledSessions, session)
@@ -962,21 +775,38 @@ func NotifyStudentsScheduleConfirmed(scheduleID uint) {
 	}
 }
 
+// upcomingReminderSlack คือเวลาที่ยอมให้ job เตือนก่อนเรียนทำงานช้ากว่ากำหนด (worker poll / retry)
+const upcomingReminderSlack = 5 * time.Minute
+
 // NotifyUpcomingClass ส่ง notification เตือนก่อนเรียน
-func NotifyUpcomingClass(sessionID uint, minutesBefore int) {
+// คืน error เมื่อควรลองใหม่ (DB/queue ล่ม); session ที่ถูกลบหรือไม่ได้อยู่ในสถานะ scheduled ถือว่าจบงาน
+func NotifyUpcomingClass(sessionID uint, minutesBefore int) error {
 	var session models.Schedule_Sessions
 	if err := database.DB.First(&session, sessionID).Error; err != nil {
-		return
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	// job ถูกตั้งไว้ตามเวลาเดิม: ถ้า session ถูกเลื่อนไปแล้ว ไม่ต้องเตือน (scanner จะเตือนตามเวลาใหม่ด้วย key ใหม่)
+	if session.Start_time == nil {
+		return nil
+	}
+	if until := time.Until(*session.Start_time); until <= 0 || until > time.Duration(minutesBefore)*time.Minute+upcomingReminderSlack {
+		return nil
 	}
 
 	var schedule models.Schedules
 	if err := database.DB.Preload("Group.Course").First(&schedule, session.ScheduleID).Error; err != nil {
-		return
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
 	}
 
 	// ตรวจสอบว่า session ได้รับการยืนยันแล้ว (ในระบบนี้ถือว่า 'scheduled' คือยืนยันแล้ว)
 	if session.Status != "scheduled" {
-		return
+		return nil
 	}
 
 	// ดึงรายชื่อผู้เข้าร่วม (ครูและนักเรียน)
@@ -986,24 +816,25 @@ func NotifyUpcomingClass(sessionID uint, minutesBefore int) {
 	if schedule.GroupID != nil {
 		var groupMembers []models.GroupMember
 		err := database.DB.Preload("Student.User").Where("group_id = ?", *schedule.GroupID).Find(&groupMembers).Error
-		if err == nil {
-			for _, member := range groupMembers {
-				if member.Student.UserID != nil {
-					var user models.User
-					if err := database.DB.First(&user, *member.Student.UserID).Error; err == nil {
-						users = append(users, user)
-					}
+		if err != nil {
+			return err
+		}
+		for _, member := range groupMembers {
+			if member.Student.UserID != nil {
+				var user models.User
+				if err := database.DB.First(&user, *member.Student.UserID).Error; err == nil {
+					users = append(users, user)
 				}
 			}
 		}
 	} else {
 		// For event/appointment schedules - get participants
 		var participants []models.ScheduleParticipant
-		err := database.DB.Preload("User").Where("schedule_id = ?", schedule.ID).Find(&participants).Error
-		if err == nil {
-			for _, participant := range participants {
-				users = append(users, participant.User)
-			}
+		if err := database.DB.Preload("User").Where("schedule_id = ?", schedule.ID).Find(&participants).Error; err != nil {
+			return err
+		}
+		for _, participant := range participants {
+			users = append(users, participant.User)
 		}
 	}
 
@@ -1013,39 +844,46 @@ func NotifyUpcomingClass(sessionID uint, minutesBefore int) {
 		teacherID = schedule.DefaultTeacherID
 	}
 
+	var recipients []uint
 	if teacherID != nil {
 		var assignedTeacher models.User
 		if err := database.DB.First(&assignedTeacher, *teacherID).Error; err == nil {
-			// สร้าง notification สำหรับครู
-			notification := models.Notification{
-				UserID:    assignedTeacher.ID,
-				Title:     "Upcoming Class",
-				TitleTh:   "เรียนจะเริ่มเร็วๆ นี้",
-				Message:   fmt.Sprintf("Your class '%s' will start in %d minutes at %s", schedule.ScheduleName, minutesBefore, session.Start_time.Format("15:04")),
-				MessageTh: fmt.Sprintf("คลาส '%s' ของคุณจะเริ่มในอีก %d นาที เวลา %s", schedule.ScheduleName, minutesBefore, session.Start_time.Format("15:04")),
-				Type:      "info",
-			}
-			database.DB.Create(&notification)
+			recipients = append(recipients, assignedTeacher.ID)
 		}
 	}
-
-	// สร้าง notification สำหรับนักเรียน
 	for _, user := range users {
 		if user.Role == "student" {
-			notification := models.Notification{
-				UserID:    user.ID,
-				Title:     "Upcoming Class",
-				TitleTh:   "เรียนจะเริ่มเร็วๆ นี้",
-				Message:   fmt.Sprintf("Your class '%s' will start in %d minutes at %s", schedule.ScheduleName, minutesBefore, session.Start_time.Format("15:04")),
-				MessageTh: fmt.Sprintf("คลาส '%s' ของคุณจะเริ่มในอีก %d นาที เวลา %s", schedule.ScheduleName, minutesBefore, session.Start_time.Format("15:04")),
-				Type:      "info",
-			}
-			database.DB.Create(&notification)
+			recipients = append(recipients, user.ID)
 		}
 	}
+	if len(recipients) == 0 || session.Start_time == nil {
+		return nil
+	}
+
+	// ใช้ dedupe key เดียวกับ NotificationScheduler: ถ้า scheduler เตือนที่ offset นี้ไปแล้วจะไม่ส่งซ้ำ
+	timeLabel, timeLabelTh := reminderTimeLabels(minutesBefore)
+	startLabel := session.Start_time.Format("15:04")
+	q := notifsvc.QueuedWithData(
+		"Upcoming Class",
+		"เรียนจะเริ่มเร็วๆ นี้",
+		fmt.Sprintf("Your class '%s' will start in %s at %s", schedule.ScheduleName, timeLabel, startLabel),
+		fmt.Sprintf("คลาส '%s' ของคุณจะเริ่มในอีก %s เวลา %s", schedule.ScheduleName, timeLabelTh, startLabel),
+		"info",
+		map[string]interface{}{
+			"link":                    map[string]interface{}{"href": fmt.Sprintf("/api/schedules/sessions/%d", session.ID), "method": "GET"},
+			"action":                  "open-session",
+			"session_id":              session.ID,
+			"schedule_id":             schedule.ID,
+			"reminder_before_minutes": minutesBefore,
+		},
+		"normal", "popup",
+	).WithDedupeKey(UpcomingReminderDedupeKey(session, minutesBefore))
+	// dedupe key ทำให้ job ที่ถูก retry ไม่ส่งซ้ำให้คนที่ได้ไปแล้ว
+	return notifsvc.NewService().EnqueueOrCreate(recipients, q)
 }
 
 // ScheduleNotifications ตั้งเวลา notification สำหรับ sessions ที่จะมาถึง
+// งานเตือนถูกเก็บลง scheduled_jobs เพื่อไม่ให้หายเมื่อ restart และไม่ส่งซ้ำเมื่อรันหลาย replica
 func ScheduleNotifications() {
 	// ดึง sessions ที่จะเกิดขึ้นในอนาคต
 	var sessions []models.Schedule_Sessions
@@ -1059,19 +897,31 @@ func ScheduleNotifications() {
 		return
 	}
 
+	queue := NewJobQueue()
 	for _, session := range sessions {
+		if session.Start_time == nil {
+			continue
+		}
 		// คำนวณเวลาก่อนเรียน (เช่น 30 นาที, 1 ชั่วโมง)
 		notificationTimes := []int{30, 60} // นาที
 
 		for _, minutes := range notificationTimes {
 			notifyTime := session.Start_time.Add(-time.Duration(minutes) * time.Minute)
-
-			if notifyTime.After(now) {
-				// ตั้งเวลาส่ง notification (ในระบบจริงอาจใช้ cron job หรือ message queue)
-				go func(sessionID uint, mins int, notifyAt time.Time) {
-					time.Sleep(notifyAt.Sub(now))
-					NotifyUpcomingClass(sessionID, mins)
-				}(session.ID, minutes, notifyTime)
+			if !notifyTime.After(now) {
+				continue
+			}
+			sessionID := session.ID
+			scheduleID := session.ScheduleID
+			err := queue.Enqueue(EnqueueJobInput{
+				JobType:    JobTypeUpcomingClassReminder,
+				RunAt:      notifyTime,
+				SessionID:  &sessionID,
+				ScheduleID: &scheduleID,
+				Payload:    upcomingClassJobPayload{SessionID: sessionID, MinutesBefore: minutes},
+				DedupeKey:  fmt.Sprintf("upcoming:%d:%d:%d", sessionID, minutes, notifyTime.Unix()),
+			})
+			if err != nil {
+				log.Printf("[jobs] enqueue upcoming reminder for session %d failed: %v", sessionID, err)
 			}
 		}
 	}
@@ -1079,6 +929,7 @@ func ScheduleNotifications() {
 
 // ScheduleTeacherConfirmReminders schedules reminder notifications for a class session
 // to prompt the assigned/default teacher to confirm at T-24h and T-6h before start.
+// Reminders are persisted as scheduled jobs and picked up by the job worker.
 func ScheduleTeacherConfirmReminders(session models.Schedule_Sessions, schedule models.Schedules) {
 	// Only for class schedules and sessions in 'assigned' status
 	if schedule.ScheduleType != "class" {
@@ -1100,7 +951,6 @@ func ScheduleTeacherConfirmReminders(session models.Schedule_Sessions, schedule
 		return
 	}
 
-	// Calculate absolute start datetime in Asia/Bangkok
 	// Session.Start_time already has correct date/time; use it directly
 	startAt := *session.Start_time
 	now := time.Now()
@@ -1108,34 +958,100 @@ func ScheduleTeacherConfirmReminders(session models.Schedule_Sessions, schedule
 	// Reminder offsets (hours before)
 	offsets := []time.Duration{24 * time.Hour, 6 * time.Hour}
 
-	notifService := notifsvc.NewService()
+	queue := NewJobQueue()
 	for _, off := range offsets {
 		notifyAt := startAt.Add(-off)
-		if notifyAt.After(now) {
-			// schedule a goroutine sleep; in production, prefer a durable scheduler/queue
-			go func(teacher uint, sess models.Schedule_Sessions, sch models.Schedules, when time.Time) {
-				time.Sleep(time.Until(when))
-				// double-check status still 'assigned' before sending
-				var latest models.Schedule_Sessions
-				if err := database.DB.First(&latest, sess.ID).Error; err == nil && latest.Status == "assigned" {
-					// Build notification
-					data := map[string]any{
-						"link":        map[string]any{"href": fmt.Sprintf("/api/schedules/sessions/%d", sess.ID), "method": "GET"},
-						"action":      "confirm-session",
-						"session_id":  sess.ID,
-						"schedule_id": sch.ID,
-					}
-					payload := notifsvc.QueuedWithData(
-						"Please confirm your session",
-						"กรุณายืนยันคาบเรียนของคุณ",
-						fmt.Sprintf("Please confirm the session for '%s' at %s.", sch.ScheduleName, startAt.Format("2006-01-02 15:04")),
-						fmt.Sprintf("กรุณายืนยันคาบเรียนสำหรับ '%s' เวลา %s", sch.ScheduleName, startAt.Format("2006-01-02 15:04")),
-						"info", data,
-						"popup", "normal",
-					)
-					_ = notifService.EnqueueOrCreate([]uint{teacher}, payload)
-				}
-			}(*teacherID, session, schedule, notifyAt)
+		if !notifyAt.After(now) {
+			continue
 		}
+		sessionID := session.ID
+		scheduleID := schedule.ID
+		err := queue.Enqueue(EnqueueJobInput{
+			JobType:    JobTypeTeacherConfirmReminder,
+			RunAt:      notifyAt,
+			SessionID:  &sessionID,
+			ScheduleID: &scheduleID,
+			Payload:    teacherConfirmJobPayload{TeacherID: *teacherID, SessionID: sessionID, ScheduleID: scheduleID, NotifyAt: notifyAt.Unix()},
+			DedupeKey:  fmt.Sprintf("teacher-confirm:%d:%d:%d", sessionID, *teacherID, notifyAt.Unix()),
+		})
+		if err != nil {
+			log.Printf("[jobs] enqueue confirm reminder for session %d failed: %v", sessionID, err)
+		}
+	}
+}
+
+type upcomingClassJobPayload struct {
+	SessionID     uint `json:"session_id"`
+	MinutesBefore int  `json:"minutes_before"`
+}
+
+type teacherConfirmJobPayload struct {
+	TeacherID  uint  `json:"teacher_id"`
+	SessionID  uint  `json:"session_id"`
+	ScheduleID uint  `json:"schedule_id"`
+	NotifyAt   int64 `json:"notify_at"` // unix เวลาเตือนที่ตั้งไว้ (run_at ของ job ถูกเลื่อนเมื่อ retry)
+}
+
+// teacherConfirmDedupeKey คือ key กันซ้ำของ notification เตือนยืนยันคาบ ผูกกับเวลาเตือนใน payload
+// job เก่าที่ไม่มี notify_at ใช้ id ของ job แทน (ไม่เปลี่ยนเมื่อ retry)
+func teacherConfirmDedupeKey(job *models.ScheduledJob, p teacherConfirmJobPayload) string {
+	if p.NotifyAt == 0 {
+		return fmt.Sprintf("session:%d:confirm-reminder:job-%d", p.SessionID, job.ID)
+	}
+	return fmt.Sprintf("session:%d:confirm-reminder:%d", p.SessionID, p.NotifyAt)
+}
+
+func init() {
+	RegisterJobHandler(JobTypeUpcomingClassReminder, handleUpcomingClassJob)
+	RegisterJobHandler(JobTypeTeacherConfirmReminder, handleTeacherConfirmJob)
+}
+
+func handleUpcomingClassJob(job *models.ScheduledJob) error {
+	var p upcomingClassJobPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	return NotifyUpcomingClass(p.SessionID, p.MinutesBefore)
+}
+
+func handleTeacherConfirmJob(job *models.ScheduledJob) error {
+	var p teacherConfirmJobPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
 	}
+
+	// double-check status still 'assigned' before sending
+	var latest models.Schedule_Sessions
+	if err := database.DB.First(&latest, p.SessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if latest.Status != "assigned" || latest.Start_time == nil {
+		return nil
+	}
+	var sch models.Schedules
+	if err := database.DB.First(&sch, p.ScheduleID).Error; err != nil {
+		return err
+	}
+
+	startAt := *latest.Start_time
+	data := map[string]any{
+		"link":        map[string]any{"href": fmt.Sprintf("/api/schedules/sessions/%d", latest.ID), "method": "GET"},
+		"action":      "confirm-session",
+		"session_id":  latest.ID,
+		"schedule_id": sch.ID,
+	}
+	// job ที่ lease หมดแล้วถูกรันซ้ำจะไม่สร้าง notification ซ้ำ
+	// ช่อง line ส่งการ์ดยืนยัน/ปฏิเสธผ่าน delivery worker (ส่งซ้ำเมื่อ LINE ล้มเหลว)
+	payload := notifsvc.QueuedWithData(
+		"Please confirm your session",
+		"กรุณายืนยันคาบเรียนของคุณ",
+		fmt.Sprintf("Please confirm the session for '%s' at %s.", sch.ScheduleName, startAt.Format("2006-01-02 15:04")),
+		fmt.Sprintf("กรุณายืนยันคาบเรียนสำหรับ '%s' เวลา %s", sch.ScheduleName, startAt.Format("2006-01-02 15:04")),
+		"info", data,
+		"popup", "normal", notifsvc.ChannelLine,
+	).WithDedupeKey(teacherConfirmDedupeKey(job, p))
+	return notifsvc.NewService().EnqueueOrCreate([]uint{p.TeacherID}, payload)
 }
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"englishkorat_go/models"
+)
+
+// Revision feeds the iCal SEQUENCE, so every update path has to bump it in SQL
+func TestSessionUpdatesBumpRevisionInSQL(t *testing.T) {
+	db, f := newFakeDB(t)
+
+	// batch struct update: the empty model must not reset every row to revision 1
+	db.Model(&models.Schedule_Sessions{}).Where("schedule_id = ?", 5).Updates(models.Schedule_Sessions{Status: "cancelled"})
+	// stale loaded struct
+	stale := models.Schedule_Sessions{ScheduleID: 5, Status: "scheduled", Revision: 2}
+	stale.ID = 30
+	db.Save(&stale)
+	// map update
+	db.Model(&models.Schedule_Sessions{}).Where("id = ?", 30).Updates(map[string]interface{}{"status": "completed"})
+
+	var updates []string
+	for _, stmt := range f.execs {
+		if strings.HasPrefix(stmt.query, "UPDATE `schedule_sessions`") {
+			updates = append(updates, stmt.query)
+		}
+	}
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 updates, got %d: %v", len(updates), updates)
+	}
+	for _, q := range updates {
+		if strings.Count(q, "`revision`=") != 1 || !strings.Contains(q, "`revision`=revision + 1") {
+			t.Errorf("update does not bump revision in SQL: %s", q)
+		}
+	}
+	if !strings.Contains(updates[0], "`status`=?") || !strings.Contains(updates[0], "schedule_id = ?") {
+		t.Errorf("batch update lost its columns or conditions: %s", updates[0])
+	}
+}
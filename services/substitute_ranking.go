@@ -0,0 +1,367 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+// Substitute ranking weights (max 100)
+const (
+	substituteWeightBranch         = 35
+	substituteWeightSpecialization = 30
+	substituteWeightTeacherType    = 25
+	substituteWeightPreference     = 10
+)
+
+var (
+	ErrSubstituteSessionNotFound = errors.New("session not found")
+	ErrSubstituteNoTimeRange     = errors.New("session has no time range")
+)
+
+// TeacherConflict is an existing session that overlaps the session being covered
+type TeacherConflict struct {
+	ScheduleID   uint   `json:"schedule_id"`
+	ScheduleName string `json:"schedule_name"`
+	SessionID    uint   `json:"session_id"`
+	SessionDate  string `json:"session_date"`
+	StartTime    string `json:"start_time"`
+	EndTime      string `json:"end_time"`
+}
+
+// SubstituteCandidate is a ranked replacement teacher for a session
+type SubstituteCandidate struct {
+	TeacherID       uint              `json:"teacher_id"` // user ID (same as assigned_teacher_id)
+	TeacherName     string            `json:"teacher_name"`
+	BranchID        uint              `json:"branch_id"`
+	TeacherType     string            `json:"teacher_type"`
+	Specializations []string          `json:"specializations"`
+	Score           int               `json:"score"`
+	Available       bool              `json:"available"`
+	Reasons         []string          `json:"reasons"`
+	Conflicts       []TeacherConflict `json:"conflicts,omitempty"`
+}
+
+// SubstituteRanking is the result of RankSubstitutes
+type SubstituteRanking struct {
+	SessionID        uint                  `json:"session_id"`
+	CurrentTeacherID *uint                 `json:"current_teacher_id"`
+	BranchID         *uint                 `json:"branch_id"`
+	Audience         string                `json:"audience"`
+	Candidates       []SubstituteCandidate `json:"candidates"`
+}
+
+// substituteContext holds what we know about the session's audience
+type substituteContext struct {
+	branchID        *uint
+	courseText      string
+	audience        string         // "Kid", "Adults" or "" when unknown
+	preferenceCount map[string]int // normalized PreferredTeacherType -> number of students
+	preferenceTotal int
+}
+
+// SubstituteService ranks and checks replacement teachers for sessions
+type SubstituteService struct {
+	db *gorm.DB
+}
+
+func NewSubstituteService() *SubstituteService {
+	return &SubstituteService{db: database.DB}
+}
+
+// LoadSession loads a session with what ranking and assignment need (room, schedule, group course)
+func (s *SubstituteService) LoadSession(sessionID uint) (*models.Schedule_Sessions, *models.Schedules, error) {
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Room").First(&session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSubstituteSessionNotFound
+		}
+		return nil, nil, err
+	}
+	var schedule models.Schedules
+	if err := s.db.Preload("Group.Course.Category").Preload("DefaultRoom").First(&schedule, session.ScheduleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSubstituteSessionNotFound
+		}
+		return nil, nil, err
+	}
+	return &session, &schedule, nil
+}
+
+// RankSubstitutes ranks active teachers as replacements for a session: available teachers first, then by score.
+// Conflicts of all teachers are loaded with one query.
+func (s *SubstituteService) RankSubstitutes(sessionID uint, limit int, onlyAvailable bool) (*SubstituteRanking, error) {
+	session, schedule, err := s.LoadSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Start_time == nil || session.End_time == nil || session.Session_date == nil {
+		return nil, ErrSubstituteNoTimeRange
+	}
+
+	ctx := s.buildContext(session, schedule)
+	current := ResponsibleTeacherID(*session, schedule)
+
+	var teachers []models.Teacher
+	if err := s.db.Preload("User").
+		Joins("JOIN users ON users.id = teachers.user_id AND users.deleted_at IS NULL").
+		Where("teachers.active = ? AND users.status = ? AND users.role = ?", true, "active", "teacher").
+		Find(&teachers).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(teachers))
+	for _, t := range teachers {
+		ids = append(ids, t.UserID)
+	}
+	conflicts, err := s.TeacherConflicts(ids, *session)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]SubstituteCandidate, 0, len(teachers))
+	for _, t := range teachers {
+		if current != nil && t.UserID == *current {
+			continue
+		}
+		candidate := rankSubstitute(t, conflicts[t.UserID], ctx)
+		if onlyAvailable && !candidate.Available {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Available != candidates[j].Available {
+			return candidates[i].Available
+		}
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].TeacherID < candidates[j].TeacherID
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	return &SubstituteRanking{
+		SessionID:        session.ID,
+		CurrentTeacherID: current,
+		BranchID:         ctx.branchID,
+		Audience:         ctx.audience,
+		Candidates:       candidates,
+	}, nil
+}
+
+// TeacherConflicts returns, per teacher user ID, the active sessions they teach that overlap the session
+// (see FindTeacherConflicts)
+func (s *SubstituteService) TeacherConflicts(teacherIDs []uint, session models.Schedule_Sessions) (map[uint][]TeacherConflict, error) {
+	if session.Session_date == nil {
+		return map[uint][]TeacherConflict{}, nil
+	}
+	// widen by a day to tolerate session_date stored with a time component
+	day := *session.Session_date
+	sessionID := session.ID
+	return FindTeacherConflicts(s.db, TeacherConflictQuery{
+		TeacherIDs:       teacherIDs,
+		Sessions:         []models.Schedule_Sessions{session},
+		From:             day.AddDate(0, 0, -1),
+		To:               day.AddDate(0, 0, 1),
+		ExcludeSessionID: &sessionID,
+	})
+}
+
+func sameDayOverlap(aStart, aEnd, bStart, bEnd *time.Time) bool {
+	if aStart == nil || aEnd == nil || bStart == nil || bEnd == nil {
+		return false
+	}
+	ay, am, ad := aStart.Date()
+	by, bm, bd := bStart.Date()
+	if ay != by || am != bm || ad != bd {
+		return false
+	}
+	return aStart.Before(*bEnd) && bStart.Before(*aEnd)
+}
+
+func formatClockOrUnknown(t *time.Time) string {
+	if t == nil {
+		return "unknown"
+	}
+	return t.Format("15:04")
+}
+
+func (s *SubstituteService) buildContext(session *models.Schedule_Sessions, schedule *models.Schedules) substituteContext {
+	ctx := substituteContext{preferenceCount: map[string]int{}}
+	ctx.branchID = ResolveBranchID(s.db, BranchLookup{
+		RoomID: session.RoomID, Room: session.Room,
+		DefaultRoomID: schedule.DefaultRoomID, DefaultRoom: schedule.DefaultRoom,
+		GroupID: schedule.GroupID, Group: schedule.Group,
+	})
+
+	if schedule.Group == nil {
+		ctx.courseText = strings.ToLower(schedule.ScheduleName)
+		return ctx
+	}
+	course := schedule.Group.Course
+	ctx.courseText = strings.ToLower(strings.Join([]string{
+		schedule.ScheduleName, course.Name, course.CourseType, course.Level,
+		course.Category.NameEn, course.Category.Type,
+	}, " "))
+
+	var students []models.Student
+	s.db.Joins("JOIN group_members ON group_members.student_id = students.id AND group_members.deleted_at IS NULL").
+		Where("group_members.group_id = ?", schedule.Group.ID).
+		Find(&students)
+
+	kids, adults := 0, 0
+	for _, st := range students {
+		switch st.AgeGroup {
+		case "kids":
+			kids++
+		case "teens", "adults":
+			adults++
+		}
+		if p := strings.ToLower(strings.TrimSpace(st.PreferredTeacherType)); p != "" {
+			ctx.preferenceCount[p]++
+			ctx.preferenceTotal++
+		}
+	}
+	switch {
+	case kids > 0 && adults == 0:
+		ctx.audience = "Kid"
+	case adults > 0 && kids == 0:
+		ctx.audience = "Adults"
+	case course.Category.Type == "kids" || strings.Contains(ctx.courseText, "kid"):
+		ctx.audience = "Kid"
+	}
+	return ctx
+}
+
+// rankSubstitute scores a teacher against the session context; conflicts come from TeacherConflicts
+func rankSubstitute(t models.Teacher, conflicts []TeacherConflict, ctx substituteContext) SubstituteCandidate {
+	name := t.User.Username
+	if t.NicknameEn != "" {
+		name = "T." + t.NicknameEn
+	}
+	specs := parseSpecializations(t.Specializations)
+	cand := SubstituteCandidate{
+		TeacherID:       t.UserID,
+		TeacherName:     name,
+		BranchID:        t.BranchID,
+		TeacherType:     t.TeacherType,
+		Specializations: specs,
+		Reasons:         []string{},
+		Available:       len(conflicts) == 0,
+		Conflicts:       conflicts,
+	}
+	if !cand.Available {
+		cand.Reasons = append(cand.Reasons, fmt.Sprintf("has %d overlapping session(s)", len(conflicts)))
+	}
+
+	if ctx.branchID != nil && t.BranchID == *ctx.branchID {
+		cand.Score += substituteWeightBranch
+		cand.Reasons = append(cand.Reasons, "same branch")
+	}
+
+	if matched := matchSpecialization(specs, ctx.courseText); matched != "" {
+		cand.Score += substituteWeightSpecialization
+		cand.Reasons = append(cand.Reasons, "specialization: "+matched)
+	}
+
+	switch {
+	case t.TeacherType == "Admin Team":
+		cand.Reasons = append(cand.Reasons, "admin team")
+	case ctx.audience == "":
+		cand.Score += substituteWeightTeacherType / 2
+	case t.TeacherType == ctx.audience:
+		cand.Score += substituteWeightTeacherType
+		cand.Reasons = append(cand.Reasons, "teaches "+ctx.audience)
+	case t.TeacherType == "Both":
+		cand.Score += substituteWeightTeacherType * 4 / 5
+		cand.Reasons = append(cand.Reasons, "teaches both kids and adults")
+	default:
+		cand.Reasons = append(cand.Reasons, fmt.Sprintf("teacher type %s does not match %s", t.TeacherType, ctx.audience))
+	}
+
+	if ctx.preferenceTotal > 0 {
+		matches := 0
+		for pref, n := range ctx.preferenceCount {
+			if preferenceMatchesTeacher(pref, t) {
+				matches += n
+			}
+		}
+		if matches > 0 {
+			cand.Score += substituteWeightPreference * matches / ctx.preferenceTotal
+			cand.Reasons = append(cand.Reasons, fmt.Sprintf("matches preferred teacher type of %d/%d students", matches, ctx.preferenceTotal))
+		}
+	}
+
+	return cand
+}
+
+// parseSpecializations accepts a JSON array or a comma/semicolon/newline separated list
+func parseSpecializations(raw string) []string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return []string{}
+	}
+	var list []string
+	if strings.HasPrefix(raw, "[") && json.Unmarshal([]byte(raw), &list) == nil {
+		return list
+	}
+	parts := strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == '\n' })
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// matchSpecialization returns the first specialization whose keywords appear in the course description
+func matchSpecialization(specs []string, courseText string) string {
+	if courseText == "" {
+		return ""
+	}
+	ignore := map[string]bool{"kid": true, "kids": true, "adult": true, "for": true, "class": true, "english": true, "and": true}
+	for _, spec := range specs {
+		for _, word := range strings.FieldsFunc(strings.ToLower(spec), func(r rune) bool {
+			return r == ' ' || r == '&' || r == '/' || r == '-'
+		}) {
+			if len(word) < 3 || ignore[word] {
+				continue
+			}
+			if strings.Contains(courseText, word) {
+				return spec
+			}
+		}
+	}
+	return ""
+}
+
+// preferenceMatchesTeacher compares a student's free-text PreferredTeacherType with a teacher profile
+func preferenceMatchesTeacher(pref string, t models.Teacher) bool {
+	if pref == "" {
+		return false
+	}
+	tt := strings.ToLower(t.TeacherType)
+	nat := strings.ToLower(t.Nationality)
+	switch {
+	case tt != "" && (pref == tt || strings.Contains(pref, tt)):
+		return true
+	case nat != "" && strings.Contains(pref, nat):
+		return true
+	case strings.Contains(pref, "thai") && strings.Contains(nat, "thai"):
+		return true
+	case (strings.Contains(pref, "native") || strings.Contains(pref, "foreign")) && nat != "" && !strings.Contains(nat, "thai"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"gorm.io/gorm"
+)
+
+// GroupStudentUserIDs returns user IDs of students in a group that have a linked user account
+func GroupStudentUserIDs(db *gorm.DB, groupID uint) []uint {
+	var ids []uint
+	err := db.Table("group_members").
+		Select("DISTINCT students.user_id").
+		Joins("JOIN students ON students.id = group_members.student_id").
+		Where("group_members.group_id = ? AND group_members.deleted_at IS NULL AND students.user_id IS NOT NULL", groupID).
+		Pluck("students.user_id", &ids).Error
+	if err != nil {
+		log.Printf("warning: failed to load students of group %d: %v", groupID, err)
+	}
+	return ids
+}
+
+// PushLineToGroup sends a text message to the active LINE group matched with a learning group (if any)
+func PushLineToGroup(db *gorm.DB, groupID uint, message string) {
+	var lineGroup models.LineGroup
+	if err := db.Where("matched_group_id = ? AND is_active = ?", groupID, true).First(&lineGroup).Error; err != nil {
+		return
+	}
+	if err := NewLineMessagingService().SendLineMessageToGroup(lineGroup.GroupID, message); err != nil {
+		log.Printf("❌ Failed to send message to group '%s': %v", lineGroup.GroupName, err)
+	}
+}
+
+// NotifySubstituteAssigned informs the previous teacher, the new teacher and the group
+// (in-app + matched LINE group) that a session has a substitute teacher.
+func NotifySubstituteAssigned(session models.Schedule_Sessions, schedule models.Schedules, previousTeacherID *uint, newTeacherID uint) {
+	db := database.DB
+	ns := notifsvc.NewService()
+
+	when := ""
+	if session.Start_time != nil {
+		when = session.Start_time.Format("2006-01-02 15:04")
+	}
+	newTeacherName := fmt.Sprintf("Teacher #%d", newTeacherID)
+	var newTeacher models.User
+	if err := db.Preload("Teacher").First(&newTeacher, newTeacherID).Error; err == nil {
+		newTeacherName = newTeacher.Username
+		if newTeacher.Teacher != nil && newTeacher.Teacher.NicknameEn != "" {
+			newTeacherName = "T." + newTeacher.Teacher.NicknameEn
+		}
+	}
+	link := map[string]any{"href": fmt.Sprintf("/api/schedules/sessions/%d", session.ID), "method": "GET"}
+
+	// New teacher: needs to accept the session
+	q := notifsvc.QueuedWithData(
+		"You have been assigned a session",
+		"คุณได้รับมอบหมายคาบสอนแทน",
+		fmt.Sprintf("You are substituting '%s' at %s. Please confirm the session.", schedule.ScheduleName, when),
+		fmt.Sprintf("คุณได้รับมอบหมายให้สอนแทนคลาส '%s' เวลา %s กรุณายืนยันคาบเรียน", schedule.ScheduleName, when),
+		"info",
+		map[string]any{"link": link, "action": "confirm-session", "session_id": session.ID, "schedule_id": schedule.ID},
+		"popup", "normal",
+	)
+	if err := ns.EnqueueOrCreate([]uint{newTeacherID}, q); err != nil {
+		log.Printf("Error notifying substitute teacher for session %d: %v", session.ID, err)
+	}
+
+	// Previous teacher: released from the session
+	if previousTeacherID != nil && *previousTeacherID != newTeacherID {
+		q := notifsvc.QueuedWithData(
+			"Session reassigned",
+			"คาบเรียนถูกมอบหมายให้ครูท่านอื่น",
+			fmt.Sprintf("'%s' at %s has been reassigned to %s.", schedule.ScheduleName, when, newTeacherName),
+			fmt.Sprintf("คลาส '%s' เวลา %s ถูกมอบหมายให้ %s แล้ว", schedule.ScheduleName, when, newTeacherName),
+			"info",
+			map[string]any{"link": link, "action": "open-session", "session_id": session.ID, "schedule_id": schedule.ID},
+			"normal",
+		)
+		if err := ns.EnqueueOrCreate([]uint{*previousTeacherID}, q); err != nil {
+			log.Printf("Error notifying previous teacher for session %d: %v", session.ID, err)
+		}
+	}
+
+	// Group: students in-app and the matched LINE group
+	if schedule.GroupID == nil {
+		return
+	}
+	msg := fmt.Sprintf("Your class '%s' at %s will be taught by %s.", schedule.ScheduleName, when, newTeacherName)
+	msgTh := fmt.Sprintf("คลาส '%s' เวลา %s จะสอนโดย %s", schedule.ScheduleName, when, newTeacherName)
+	if studentIDs := GroupStudentUserIDs(db, *schedule.GroupID); len(studentIDs) > 0 {
+		q := notifsvc.QueuedWithData(
+			"Teacher change", "เปลี่ยนครูผู้สอน", msg, msgTh, "info",
+			map[string]any{"link": link, "action": "open-session", "session_id": session.ID, "schedule_id": schedule.ID},
+			"normal", "popup",
+		)
+		if err := ns.EnqueueOrCreate(studentIDs, q); err != nil {
+			log.Printf("Error notifying group %d about substitute: %v", *schedule.GroupID, err)
+		}
+	}
+	PushLineToGroup(db, *schedule.GroupID, "📢 แจ้งเปลี่ยนครูผู้สอน\n"+msgTh)
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"englishkorat_go/database"
+	"englishkorat_go/models"
+	notifsvc "englishkorat_go/services/notifications"
+
+	"gorm.io/gorm"
+)
+
+// Teacher confirmation errors surfaced to the controller layer
+var (
+	ErrConfirmationSessionNotFound = errors.New("session not found")
+	ErrNotSessionTeacher           = errors.New("you are not the teacher responsible for this session")
+	ErrSessionNotConfirmable       = errors.New("session can no longer be confirmed or declined")
+	ErrDeclineReasonRequired       = errors.New("a reason is required when declining a session")
+)
+
+// SessionConfirmation statuses written by the teacher workflow
+const (
+	ConfirmationStatusConfirmed = "confirmed"
+	ConfirmationStatusDeclined  = "declined"
+)
+
+// TeacherConfirmationService records teacher accept/decline responses for assigned sessions
+type TeacherConfirmationService struct {
+	db *gorm.DB
+	ns *notifsvc.Service
+}
+
+// TeacherResponseResult describes the outcome of a teacher response
+type TeacherResponseResult struct {
+	Confirmation *models.SessionConfirmation `json:"confirmation"`
+	Session      *models.Schedule_Sessions   `json:"session"`
+	Changed      bool                        `json:"changed"` // false when the same response was already recorded
+}
+
+// NewTeacherConfirmationService creates a new service instance
+func NewTeacherConfirmationService() *TeacherConfirmationService {
+	return &TeacherConfirmationService{
+		db: database.DB,
+		ns: notifsvc.NewService(),
+	}
+}
+
+// ResponsibleTeacherID returns the session-level teacher or the schedule default
+func ResponsibleTeacherID(session models.Schedule_Sessions, schedule *models.Schedules) *uint {
+	if session.AssignedTeacherID != nil {
+		return session.AssignedTeacherID
+	}
+	if schedule != nil {
+		return schedule.DefaultTeacherID
+	}
+	return nil
+}
+
+// Respond records a teacher's accept (accept=true) or decline for a session.
+// Repeating the current response is a no-op so callers (API, LINE postbacks) can retry safely.
+func (s *TeacherConfirmationService) Respond(sessionID, teacherUserID uint, accept bool, reason string) (*TeacherResponseResult, error) {
+	reason = strings.TrimSpace(reason)
+	if !accept && reason == "" {
+		return nil, ErrDeclineReasonRequired
+	}
+
+	var session models.Schedule_Sessions
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConfirmationSessionNotFound
+		}
+		return nil, err
+	}
+
+	teacherID := ResponsibleTeacherID(session, session.Schedule)
+	if teacherID == nil || *teacherID != teacherUserID {
+		return nil, ErrNotSessionTeacher
+	}
+	switch session.Status {
+	case "completed", "cancelled", "no-show", "rescheduled":
+		return nil, ErrSessionNotConfirmable
+	}
+
+	status := ConfirmationStatusConfirmed
+	if !accept {
+		status = ConfirmationStatusDeclined
+	}
+
+	var confirmation models.SessionConfirmation
+	changed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("session_id = ? AND user_id = ?", sessionID, teacherUserID).First(&confirmation).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && confirmation.Status == status && confirmation.Reason == reason {
+			return nil
+		}
+		changed = true
+
+		now := time.Now()
+		confirmation.SessionID = sessionID
+		confirmation.UserID = teacherUserID
+		confirmation.Status = status
+		confirmation.Reason = reason
+		if accept {
+			confirmation.ConfirmedAt = &now
+			confirmation.DeclinedAt = nil
+		} else {
+			confirmation.DeclinedAt = &now
+			confirmation.ConfirmedAt = nil
+		}
+		if err := tx.Save(&confirmation).Error; err != nil {
+			return err
+		}
+
+		// Mirror the response on the session: accepted sessions become 'scheduled' (same as ConfirmSession),
+		// declined sessions go back to 'pending' until an admin assigns someone else.
+		updates := map[string]interface{}{}
+		if accept {
+			updates["status"] = "scheduled"
+			updates["confirmed_at"] = &now
+			updates["confirmed_by_user_id"] = teacherUserID
+		} else {
+			updates["status"] = "pending"
+			updates["confirmed_at"] = nil
+			updates["confirmed_by_user_id"] = nil
+		}
+		return tx.Model(&session).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		// Confirmation reminders are no longer needed once the teacher has answered
+		if _, err := NewJobQueue().CancelJobsForSession(sessionID, JobTypeTeacherConfirmReminder); err != nil {
+			log.Printf("warning: failed to cancel reminder jobs for session %d: %v", sessionID, err)
+		}
+		if !accept {
+			s.notifyAdminsOfDecline(session, teacherUserID, reason)
+		}
+	}
+
+	if err := s.db.Preload("Schedule").First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+	return &TeacherResponseResult{Confirmation: &confirmation, Session: &session, Changed: changed}, nil
+}
+
+// BranchAdminUserIDs returns active owners plus admins of the given branch (all admins when branch is unknown)
+func BranchAdminUserIDs(db *gorm.DB, branchID *uint) []uint {
+	q := db.Model(&models.User{}).Where("status = ?", "active")
+	if branchID != nil {
+		q = q.Where("role = ? OR (role = ? AND branch_id = ?)", "owner", "admin", *branchID)
+	} else {
+		q = q.Where("role IN ?", []string{"owner", "admin"})
+	}
+	var ids []uint
+	if err := q.Pluck("id", &ids).Error; err != nil {
+		log.Printf("warning: failed to load admins: %v", err)
+	}
+	return ids
+}
+
+// notifyAdminsOfDecline alerts branch admins (websocket popup + LINE) that a teacher declined a session
+func (s *TeacherConfirmationService) notifyAdminsOfDecline(session models.Schedule_Sessions, teacherUserID uint, reason string) {
+	adminIDs := BranchAdminUserIDs(s.db, SessionBranchID(s.db, session))
+	if len(adminIDs) == 0 {
+		return
+	}
+
+	teacherName := fmt.Sprintf("#%d", teacherUserID)
+	var teacher models.User
+	if err := s.db.Preload("Teacher").First(&teacher, teacherUserID).Error; err == nil {
+		teacherName = teacher.Username
+		if teacher.Teacher != nil && teacher.Teacher.NicknameEn != "" {
+			teacherName = "T." + teacher.Teacher.NicknameEn
+		}
+	}
+	scheduleName := ""
+	if session.Schedule != nil {
+		scheduleName = session.Schedule.ScheduleName
+	}
+	when := ""
+	if session.Start_time != nil {
+		when = session.Start_time.Format("2006-01-02 15:04")
+	}
+
+	title := "Session declined by teacher"
+	titleTh := "ครูปฏิเสธคาบเรียน"
+	msg := fmt.Sprintf("%s declined '%s' at %s. Reason: %s", teacherName, scheduleName, when, reason)
+	msgTh := fmt.Sprintf("%s ปฏิเสธคาบเรียน '%s' เวลา %s เหตุผล: %s", teacherName, scheduleName, when, reason)
+	data := map[string]any{
+		"link":        map[string]any{"href": fmt.Sprintf("/api/schedules/sessions/%d", session.ID), "method": "GET"},
+		"action":      "session-declined",
+		"session_id":  session.ID,
+		"schedule_id": session.ScheduleID,
+		"teacher_id":  teacherUserID,
+		"reason":      reason,
+	}
+	q := notifsvc.QueuedWithData(title, titleTh, msg, msgTh, "warning", data, "popup", "normal", notifsvc.ChannelLine)
+	if err := s.ns.EnqueueOrCreate(adminIDs, q); err != nil {
+		log.Printf("Error notifying admins about declined session %d: %v", session.ID, err)
+	}
+}
+
+// ConfirmationDashboardItem is one session needing admin attention
+type ConfirmationDashboardItem struct {
+	SessionID       uint       `json:"session_id"`
+	ScheduleID      uint       `json:"schedule_id"`
+	ScheduleName    string     `json:"schedule_name"`
+	SessionDate     *time.Time `json:"session_date"`
+	StartTime       *time.Time `json:"start_time"`
+	EndTime         *time.Time `json:"end_time"`
+	SessionStatus   string     `json:"session_status"`
+	BranchID        *uint      `json:"branch_id"`
+	TeacherID       *uint      `json:"teacher_id"`
+	TeacherName     string     `json:"teacher_name"`
+	State           string     `json:"state"` // unconfirmed, declined
+	DeclinedAt      *time.Time `json:"declined_at,omitempty"`
+	DeclineReason   string     `json:"decline_reason,omitempty"`
+	HoursUntilStart float64    `json:"hours_until_start"`
+}
+
+// ConfirmationDashboardBranch groups dashboard items per branch
+type ConfirmationDashboardBranch struct {
+	BranchID    *uint                       `json:"branch_id"`
+	Unconfirmed int                         `json:"unconfirmed"`
+	Declined    int                         `json:"declined"`
+	Sessions    []ConfirmationDashboardItem `json:"sessions"`
+}
+
+// ConfirmationDashboard lists class sessions starting within the next `days` days that are
+// still awaiting teacher confirmation or were declined, grouped by branch.
+func (s *TeacherConfirmationService) ConfirmationDashboard(days int, branchID *uint) ([]ConfirmationDashboardBranch, error) {
+	now := time.Now()
+	until := now.AddDate(0, 0, days)
+
+	var sessions []models.Schedule_Sessions
+	q := s.db.Model(&models.Schedule_Sessions{}).
+		Select("schedule_sessions.*").
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id").
+		Where("schedules.schedule_type = ?", "class").
+		Where("schedule_sessions.start_time BETWEEN ? AND ?", now, until).
+		Where("schedule_sessions.status IN ?", []string{"assigned", "pending"}).
+		Preload("Schedule.Group.Course").
+		Preload("Schedule.DefaultRoom").
+		Preload("Schedule.DefaultTeacher.Teacher").
+		Preload("AssignedTeacher.Teacher").
+		Preload("Room").
+		Order("schedule_sessions.start_time ASC")
+	if err := q.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	sessionIDs := make([]uint, 0, len(sessions))
+	for _, sess := range sessions {
+		sessionIDs = append(sessionIDs, sess.ID)
+	}
+	declines := map[uint]models.SessionConfirmation{}
+	if len(sessionIDs) > 0 {
+		var confirmations []models.SessionConfirmation
+		if err := s.db.Where("session_id IN ? AND status = ?", sessionIDs, ConfirmationStatusDeclined).Find(&confirmations).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range confirmations {
+			declines[c.SessionID] = c
+		}
+	}
+
+	grouped := map[string]*ConfirmationDashboardBranch{}
+	order := []string{}
+	for _, sess := range sessions {
+		item := ConfirmationDashboardItem{
+			SessionID:     sess.ID,
+			ScheduleID:    sess.ScheduleID,
+			SessionDate:   sess.Session_date,
+			StartTime:     sess.Start_time,
+			EndTime:       sess.End_time,
+			SessionStatus: sess.Status,
+			TeacherID:     ResponsibleTeacherID(sess, sess.Schedule),
+			State:         "unconfirmed",
+		}
+		if sess.Start_time != nil {
+			item.HoursUntilStart = sess.Start_time.Sub(now).Hours()
+		}
+		if sess.Schedule != nil {
+			item.ScheduleName = sess.Schedule.ScheduleName
+		}
+		item.BranchID = SessionBranchID(s.db, sess) // same rule as decline alerts
+		if branchID != nil && (item.BranchID == nil || *item.BranchID != *branchID) {
+			continue
+		}
+		item.TeacherName = dashboardTeacherName(sess)
+
+		// A decline by the currently responsible teacher marks the session as declined
+		if d, ok := declines[sess.ID]; ok && item.TeacherID != nil && d.UserID == *item.TeacherID {
+			item.State = "declined"
+			item.DeclinedAt = d.DeclinedAt
+			item.DeclineReason = d.Reason
+		}
+
+		key := "none"
+		if item.BranchID != nil {
+			key = fmt.Sprintf("%d", *item.BranchID)
+		}
+		bucket, ok := grouped[key]
+		if !ok {
+			bucket = &ConfirmationDashboardBranch{BranchID: item.BranchID}
+			grouped[key] = bucket
+			order = append(order, key)
+		}
+		if item.State == "declined" {
+			bucket.Declined++
+		} else {
+			bucket.Unconfirmed++
+		}
+		bucket.Sessions = append(bucket.Sessions, item)
+	}
+
+	out := make([]ConfirmationDashboardBranch, 0, len(order))
+	for _, key := range order {
+		out = append(out, *grouped[key])
+	}
+	return out, nil
+}
+
+func dashboardTeacherName(sess models.Schedule_Sessions) string {
+	u := sess.AssignedTeacher
+	if u == nil && sess.Schedule != nil {
+		u = sess.Schedule.DefaultTeacher
+	}
+	if u == nil {
+		return ""
+	}
+	if u.Teacher != nil && u.Teacher.NicknameEn != "" {
+		return "T." + u.Teacher.NicknameEn
+	}
+	return u.Username
+}
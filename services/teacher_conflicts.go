@@ -0,0 +1,80 @@
+package services
+
+import (
+	"time"
+
+	"englishkorat_go/models"
+
+	"gorm.io/gorm"
+)
+
+// TeacherConflictQuery describes candidate sessions to check against the sessions teachers already teach
+type TeacherConflictQuery struct {
+	TeacherIDs        []uint
+	Sessions          []models.Schedule_Sessions // candidates; only start/end time are used
+	From, To          time.Time                  // session_date range to search
+	ExcludeScheduleID *uint                      // e.g. the schedule being edited
+	ExcludeSessionID  *uint                      // e.g. the session being covered
+}
+
+type teacherConflictRow struct {
+	SessionID         uint       `gorm:"column:session_id"`
+	ScheduleID        uint       `gorm:"column:schedule_id"`
+	ScheduleName      string     `gorm:"column:schedule_name"`
+	SessionDate       *time.Time `gorm:"column:session_date"`
+	StartTime         *time.Time `gorm:"column:start_time"`
+	EndTime           *time.Time `gorm:"column:end_time"`
+	AssignedTeacherID *uint      `gorm:"column:assigned_teacher_id"`
+	DefaultTeacherID  *uint      `gorm:"column:default_teacher_id"`
+}
+
+// FindTeacherConflicts returns, per teacher user ID, the active sessions they teach that overlap one of the
+// candidate sessions on the same day, with one query for all teachers. Who teaches a session follows
+// ResponsibleTeacherID: its assigned teacher, else the schedule's default teacher.
+func FindTeacherConflicts(db *gorm.DB, q TeacherConflictQuery) (map[uint][]TeacherConflict, error) {
+	out := make(map[uint][]TeacherConflict)
+	if len(q.TeacherIDs) == 0 || len(q.Sessions) == 0 || q.From.IsZero() || q.To.IsZero() {
+		return out, nil
+	}
+	query := db.Table("schedule_sessions").
+		Select("schedule_sessions.id AS session_id, schedule_sessions.schedule_id, schedules.schedule_name, schedule_sessions.session_date, schedule_sessions.start_time, schedule_sessions.end_time, schedule_sessions.assigned_teacher_id, schedules.default_teacher_id").
+		Joins("JOIN schedules ON schedules.id = schedule_sessions.schedule_id").
+		Where("(schedule_sessions.assigned_teacher_id IN ? OR (schedule_sessions.assigned_teacher_id IS NULL AND schedules.default_teacher_id IN ?))", q.TeacherIDs, q.TeacherIDs).
+		Where("schedule_sessions.status NOT IN ?", []string{"cancelled", "no-show"}).
+		Where("schedules.status IN ?", []string{"assigned", "scheduled"}).
+		Where("schedule_sessions.session_date BETWEEN ? AND ?", q.From, q.To).
+		Where("schedule_sessions.deleted_at IS NULL")
+	if q.ExcludeScheduleID != nil {
+		query = query.Where("schedule_sessions.schedule_id <> ?", *q.ExcludeScheduleID)
+	}
+	if q.ExcludeSessionID != nil {
+		query = query.Where("schedule_sessions.id <> ?", *q.ExcludeSessionID)
+	}
+	var rows []teacherConflictRow
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		teacherID := ResponsibleTeacherID(
+			models.Schedule_Sessions{AssignedTeacherID: row.AssignedTeacherID},
+			&models.Schedules{DefaultTeacherID: row.DefaultTeacherID},
+		)
+		if teacherID == nil {
+			continue
+		}
+		for _, candidate := range q.Sessions {
+			if sameDayOverlap(row.StartTime, row.EndTime, candidate.Start_time, candidate.End_time) {
+				out[*teacherID] = append(out[*teacherID], TeacherConflict{
+					ScheduleID:   row.ScheduleID,
+					ScheduleName: row.ScheduleName,
+					SessionID:    row.SessionID,
+					SessionDate:  formatDateOrEmpty(row.SessionDate),
+					StartTime:    formatClockOrUnknown(row.StartTime),
+					EndTime:      formatClockOrUnknown(row.EndTime),
+				})
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+func TestFindTeacherConflictsUsesResponsibleTeacher(t *testing.T) {
+	db, f := newFakeDB(t)
+	loc, _ := time.LoadLocation("Asia/Bangkok")
+	day := time.Date(2025, 10, 20, 0, 0, 0, 0, loc)
+	start := time.Date(2025, 10, 20, 17, 0, 0, 0, loc)
+	candidate := models.Schedule_Sessions{Start_time: &start, End_time: ptrTime(start.Add(time.Hour))}
+	excludeID := uint(30)
+	q := TeacherConflictQuery{
+		TeacherIDs: []uint{3}, Sessions: []models.Schedule_Sessions{candidate},
+		From: day, To: day, ExcludeSessionID: &excludeID,
+	}
+
+	// session without an assigned teacher: the schedule's default teacher teaches it
+	f.row = map[string]driver.Value{
+		"session_id": int64(41), "schedule_id": int64(6), "schedule_name": "Kids A",
+		"session_date": day, "start_time": start.Add(30 * time.Minute), "end_time": start.Add(90 * time.Minute),
+		"assigned_teacher_id": nil, "default_teacher_id": int64(3),
+	}
+	conflicts, err := FindTeacherConflicts(db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conflicts[3]; len(got) != 1 || got[0].SessionID != 41 || got[0].StartTime != "17:30" {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	sql := f.queries[len(f.queries)-1].query
+	for _, part := range []string{"assigned_teacher_id IS NULL AND schedules.default_teacher_id IN", "schedule_sessions.id <> ?"} {
+		if !strings.Contains(sql, part) {
+			t.Fatalf("query %q is missing %q", sql, part)
+		}
+	}
+
+	// assigned to someone else: the default teacher is free
+	f.row["assigned_teacher_id"] = int64(8)
+	if conflicts, _ := FindTeacherConflicts(db, q); len(conflicts[3]) != 0 || len(conflicts[8]) != 1 {
+		t.Fatalf("a session taught by its assigned teacher was counted for the default teacher: %+v", conflicts)
+	}
+
+	// back to back is not a conflict
+	f.row["assigned_teacher_id"] = nil
+	f.row["start_time"], f.row["end_time"] = start.Add(time.Hour), start.Add(2*time.Hour)
+	if conflicts, _ := FindTeacherConflicts(db, q); len(conflicts) != 0 {
+		t.Fatalf("adjacent session reported as conflict: %+v", conflicts)
+	}
+}
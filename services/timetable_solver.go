@@ -0,0 +1,520 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"englishkorat_go/models"
+)
+
+// Timetable proposal weights (soft constraints, total 100)
+const (
+	proposalWeightPreference  = 40.0
+	proposalWeightSpread      = 30.0
+	proposalWeightConsistency = 30.0
+
+	// how many passing candidate slots to keep per weekday, and how many to test against conflict checks
+	proposalSlotsPerDay     = 4
+	proposalMaxChecksPerDay = 10
+)
+
+// MinuteRange is a time window in minutes from midnight [Start, End)
+type MinuteRange struct {
+	Start int
+	End   int
+}
+
+// MemberAvailability is the parsed availability of one group member.
+// Available is nil when the student has no availability_schedule (treated as "unknown").
+type MemberAvailability struct {
+	StudentID   uint
+	Name        string
+	Available   map[time.Weekday][]MinuteRange
+	Unavailable map[time.Weekday][]MinuteRange
+	Preferred   map[time.Weekday][]MinuteRange
+}
+
+// TimetableConflictCheck is a hard constraint evaluated against the sessions a slot would produce.
+// Check returns the number of existing sessions that clash.
+type TimetableConflictCheck struct {
+	Name  string
+	Label string
+	Check func(sessions []models.Schedule_Sessions) (int, error)
+}
+
+// TimetableSolverInput describes the weekly pattern we are looking for
+type TimetableSolverInput struct {
+	Hours           BranchHours
+	HoursPerSession int
+	SessionsPerWeek int
+	StartDate       time.Time
+	HorizonWeeks    int            // weeks of sessions checked against conflicts
+	StepMinutes     int            // granularity of candidate start times
+	Weekdays        []time.Weekday // optional restriction, empty = all days
+	Members         []MemberAvailability
+	Checks          []TimetableConflictCheck
+	TopK            int
+}
+
+// ProposedSessionTime mirrors the session_times entry accepted by PreviewSchedule / CreateSchedule
+type ProposedSessionTime struct {
+	Weekday     int    `json:"weekday"`
+	WeekdayName string `json:"weekday_name"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+}
+
+// ProposalConstraint explains how a proposal did on one constraint
+type ProposalConstraint struct {
+	Constraint string  `json:"constraint"`
+	Hard       bool    `json:"hard"`
+	Satisfied  bool    `json:"satisfied"`
+	Score      float64 `json:"score"`
+	MaxScore   float64 `json:"max_score"`
+	Detail     string  `json:"detail"`
+}
+
+// TimetableProposal is one ranked weekly slot set
+type TimetableProposal struct {
+	Rank         int                   `json:"rank"`
+	Score        float64               `json:"score"`
+	SessionTimes []ProposedSessionTime `json:"session_times"`
+	Constraints  []ProposalConstraint  `json:"constraints"`
+}
+
+// TimetableSolverResult holds proposals plus bookkeeping useful to explain empty results
+type TimetableSolverResult struct {
+	Proposals       []TimetableProposal `json:"proposals"`
+	CandidateSlots  int                 `json:"candidate_slots"`
+	RejectedByCheck map[string]int      `json:"rejected_by_check"`
+}
+
+type slotCandidate struct {
+	slot        SessionSlot
+	start       int
+	end         int
+	unavailable []string
+	preferred   int
+	prefTotal   int
+	unknown     int
+}
+
+// SolveTimetable proposes the top-k weekly slot sets for a group.
+// Branch hours, member availability and conflict checks are hard constraints; preferences,
+// spread across the week and consistent start times are scored.
+// Members without recorded availability never block a slot.
+func SolveTimetable(in TimetableSolverInput) (*TimetableSolverResult, error) {
+	if in.HoursPerSession <= 0 {
+		return nil, fmt.Errorf("hours per session must be greater than zero")
+	}
+	if in.SessionsPerWeek <= 0 || in.SessionsPerWeek > 7 {
+		return nil, fmt.Errorf("sessions per week must be between 1 and 7")
+	}
+	if in.Hours.CloseMinutes <= in.Hours.OpenMinutes {
+		return nil, fmt.Errorf("branch operating hours are invalid")
+	}
+	if in.StepMinutes <= 0 {
+		in.StepMinutes = 30
+	}
+	if in.HorizonWeeks <= 0 {
+		in.HorizonWeeks = 8
+	}
+	if in.TopK <= 0 {
+		in.TopK = 5
+	}
+	weekdays := in.Weekdays
+	if len(weekdays) == 0 {
+		weekdays = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	}
+	if len(weekdays) < in.SessionsPerWeek {
+		return nil, fmt.Errorf("only %d weekdays allowed for %d sessions per week", len(weekdays), in.SessionsPerWeek)
+	}
+
+	duration := in.HoursPerSession * 60
+	result := &TimetableSolverResult{RejectedByCheck: map[string]int{}}
+	perDay := make(map[time.Weekday][]slotCandidate)
+
+	for _, day := range weekdays {
+		candidates := make([]slotCandidate, 0)
+		for start := in.Hours.OpenMinutes; start+duration <= in.Hours.CloseMinutes; start += in.StepMinutes {
+			candidates = append(candidates, evaluateSlot(day, start, duration, in.Members))
+		}
+		result.CandidateSlots += len(candidates)
+
+		// best preference fit first, earlier start on ties
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].preferred > candidates[j].preferred
+		})
+
+		kept := make([]slotCandidate, 0, proposalSlotsPerDay)
+		checked := 0
+		for _, cand := range candidates {
+			if len(kept) >= proposalSlotsPerDay || checked >= proposalMaxChecksPerDay {
+				break
+			}
+			if len(cand.unavailable) > 0 {
+				result.RejectedByCheck["member_availability"]++
+				continue
+			}
+			if len(in.Checks) > 0 {
+				checked++
+				failed, err := runSlotChecks(in, cand.slot)
+				if err != nil {
+					return nil, err
+				}
+				if failed != "" {
+					result.RejectedByCheck[failed]++
+					continue
+				}
+			}
+			kept = append(kept, cand)
+		}
+		if len(kept) > 0 {
+			perDay[day] = kept
+		}
+	}
+
+	days := make([]time.Weekday, 0, len(perDay))
+	for _, day := range weekdays {
+		if _, ok := perDay[day]; ok {
+			days = append(days, day)
+		}
+	}
+
+	proposals := make([]TimetableProposal, 0)
+	chosen := make([]slotCandidate, 0, in.SessionsPerWeek)
+	var walk func(from int)
+	walk = func(from int) {
+		if len(chosen) == in.SessionsPerWeek {
+			proposals = append(proposals, scoreProposal(in, chosen))
+			return
+		}
+		for i := from; i <= len(days)-(in.SessionsPerWeek-len(chosen)); i++ {
+			for _, cand := range perDay[days[i]] {
+				chosen = append(chosen, cand)
+				walk(i + 1)
+				chosen = chosen[:len(chosen)-1]
+			}
+		}
+	}
+	walk(0)
+
+	sort.SliceStable(proposals, func(i, j int) bool { return proposals[i].Score > proposals[j].Score })
+	if len(proposals) > in.TopK {
+		proposals = proposals[:in.TopK]
+	}
+	for i := range proposals {
+		proposals[i].Rank = i + 1
+	}
+	result.Proposals = proposals
+	return result, nil
+}
+
+func evaluateSlot(day time.Weekday, start, duration int, members []MemberAvailability) slotCandidate {
+	cand := slotCandidate{
+		slot:  SessionSlot{Weekday: day, StartHour: start / 60, StartMinute: start % 60},
+		start: start,
+		end:   start + duration,
+	}
+	for _, m := range members {
+		ok := true
+		if rangesOverlap(m.Unavailable[day], cand.start, cand.end) {
+			ok = false
+		} else if m.Available != nil && !rangesCover(m.Available[day], cand.start, cand.end) {
+			ok = false
+		}
+		if m.Available == nil && len(m.Unavailable) == 0 {
+			cand.unknown++
+		}
+		if !ok {
+			cand.unavailable = append(cand.unavailable, m.Name)
+		}
+		if len(m.Preferred) > 0 {
+			cand.prefTotal++
+			if rangesCover(m.Preferred[day], cand.start, cand.end) {
+				cand.preferred++
+			}
+		}
+	}
+	return cand
+}
+
+// runSlotChecks returns the name of the first failing check, or "" when the slot is clear
+func runSlotChecks(in TimetableSolverInput, slot SessionSlot) (string, error) {
+	schedule := models.Schedules{Start_date: in.StartDate}
+	sessions, err := GenerateScheduleSessionsWithSlots(schedule, []SessionSlot{slot}, in.HorizonWeeks, in.HoursPerSession, in.Hours)
+	if err != nil {
+		return "", err
+	}
+	for _, check := range in.Checks {
+		n, err := check.Check(sessions)
+		if err != nil {
+			return "", fmt.Errorf("%s check failed: %w", check.Name, err)
+		}
+		if n > 0 {
+			return check.Name, nil
+		}
+	}
+	return "", nil
+}
+
+func scoreProposal(in TimetableSolverInput, chosen []slotCandidate) TimetableProposal {
+	n := len(chosen)
+	times := make([]ProposedSessionTime, 0, n)
+	memberCount := len(in.Members)
+
+	prefSum := 0.0
+	prefMembers := 0
+	starts := map[int]bool{}
+	for _, cand := range chosen {
+		times = append(times, ProposedSessionTime{
+			Weekday:     int(cand.slot.Weekday),
+			WeekdayName: cand.slot.Weekday.String(),
+			StartTime:   formatMinutes(cand.start),
+			EndTime:     formatMinutes(cand.end),
+		})
+		starts[cand.start] = true
+		if cand.prefTotal > 0 {
+			prefMembers = cand.prefTotal
+			prefSum += float64(cand.preferred) / float64(cand.prefTotal)
+		}
+	}
+
+	constraints := make([]ProposalConstraint, 0, 6+len(in.Checks))
+	constraints = append(constraints, ProposalConstraint{
+		Constraint: "branch_hours",
+		Hard:       true,
+		Satisfied:  true,
+		Detail:     fmt.Sprintf("all sessions within %s-%s", formatMinutes(in.Hours.OpenMinutes), formatMinutes(in.Hours.CloseMinutes)),
+	})
+	for _, check := range in.Checks {
+		constraints = append(constraints, ProposalConstraint{
+			Constraint: check.Name,
+			Hard:       true,
+			Satisfied:  true,
+			Detail:     fmt.Sprintf("no %s conflicts in the first %d weeks", check.Label, in.HorizonWeeks),
+		})
+	}
+
+	availability := ProposalConstraint{Constraint: "member_availability", Hard: true, Satisfied: true}
+	if memberCount == 0 {
+		availability.Detail = "group has no members"
+	} else {
+		availability.Detail = fmt.Sprintf("all %d members available in every slot", memberCount)
+	}
+	if unknown := chosen[0].unknown; unknown > 0 {
+		availability.Detail += fmt.Sprintf(" (%d member(s) have no availability recorded)", unknown)
+	}
+	constraints = append(constraints, availability)
+
+	preference := ProposalConstraint{Constraint: "member_preference", MaxScore: proposalWeightPreference, Satisfied: true}
+	if prefMembers == 0 {
+		preference.Score = proposalWeightPreference
+		preference.Detail = "no preferred time slots recorded"
+	} else {
+		ratio := prefSum / float64(n)
+		preference.Score = proposalWeightPreference * ratio
+		preference.Satisfied = ratio >= 0.5
+		preference.Detail = fmt.Sprintf("%.0f%% of preferred slots matched (%d member(s) with preferences)", ratio*100, prefMembers)
+	}
+	constraints = append(constraints, preference)
+
+	spread := ProposalConstraint{Constraint: "weekly_spread", MaxScore: proposalWeightSpread, Satisfied: true}
+	if n == 1 {
+		spread.Score = proposalWeightSpread
+		spread.Detail = "single session per week"
+	} else {
+		gap := minWeekdayGap(chosen)
+		ideal := 7 / n
+		ratio := math.Min(1, float64(gap)/float64(ideal))
+		spread.Score = proposalWeightSpread * ratio
+		spread.Satisfied = gap > 1 || ideal <= 1
+		spread.Detail = fmt.Sprintf("shortest gap between sessions is %d day(s), ideal %d", gap, ideal)
+	}
+	constraints = append(constraints, spread)
+
+	consistency := ProposalConstraint{Constraint: "consistent_start_time", MaxScore: proposalWeightConsistency}
+	if n == 1 || len(starts) == 1 {
+		consistency.Score = proposalWeightConsistency
+		consistency.Satisfied = true
+		consistency.Detail = "same start time every session"
+	} else {
+		consistency.Score = proposalWeightConsistency * (1 - float64(len(starts)-1)/float64(n-1))
+		consistency.Detail = fmt.Sprintf("%d different start times", len(starts))
+	}
+	constraints = append(constraints, consistency)
+
+	total := 0.0
+	for _, c := range constraints {
+		total += c.Score
+	}
+
+	return TimetableProposal{
+		Score:        math.Round(total*10) / 10,
+		SessionTimes: times,
+		Constraints:  roundConstraintScores(constraints),
+	}
+}
+
+func roundConstraintScores(cs []ProposalConstraint) []ProposalConstraint {
+	for i := range cs {
+		cs[i].Score = math.Round(cs[i].Score*10) / 10
+	}
+	return cs
+}
+
+// minWeekdayGap returns the smallest distance (in days, wrapping around the week) between chosen weekdays
+func minWeekdayGap(chosen []slotCandidate) int {
+	days := make([]int, 0, len(chosen))
+	for _, c := range chosen {
+		days = append(days, int(c.slot.Weekday))
+	}
+	sort.Ints(days)
+	gap := 7
+	for i := range days {
+		next := days[(i+1)%len(days)]
+		d := next - days[i]
+		if d <= 0 {
+			d += 7
+		}
+		if d < gap {
+			gap = d
+		}
+	}
+	return gap
+}
+
+func rangesOverlap(ranges []MinuteRange, start, end int) bool {
+	for _, r := range ranges {
+		if start < r.End && r.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+func rangesCover(ranges []MinuteRange, start, end int) bool {
+	for _, r := range ranges {
+		if r.Start <= start && end <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+func formatMinutes(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+// ParseStudentAvailability reads PreferredTimeSlots, UnavailableTimeSlots and AvailabilitySchedule of a student.
+// Unparseable entries are skipped.
+func ParseStudentAvailability(student models.Student) MemberAvailability {
+	name := strings.TrimSpace(student.NicknameEn)
+	if name == "" {
+		name = strings.TrimSpace(student.FirstName + " " + student.LastName)
+	}
+	if name == "" {
+		name = fmt.Sprintf("Student #%d", student.ID)
+	}
+
+	m := MemberAvailability{
+		StudentID:   student.ID,
+		Name:        name,
+		Unavailable: map[time.Weekday][]MinuteRange{},
+		Preferred:   map[time.Weekday][]MinuteRange{},
+	}
+	addSlots(m.Preferred, student.PreferredTimeSlots)
+	addSlots(m.Unavailable, student.UnavailableTimeSlots)
+	addSlots(m.Unavailable, student.UnavailableTimes)
+
+	if len(student.AvailabilitySchedule) > 0 {
+		var schedule map[string][]struct {
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+		}
+		if err := json.Unmarshal(student.AvailabilitySchedule, &schedule); err == nil && len(schedule) > 0 {
+			m.Available = map[time.Weekday][]MinuteRange{}
+			for key, windows := range schedule {
+				day, ok := ParseWeekdayName(key)
+				if !ok {
+					continue
+				}
+				for _, w := range windows {
+					if r, ok := parseMinuteRange(w.StartTime, w.EndTime); ok {
+						m.Available[day] = append(m.Available[day], r)
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+func addSlots(target map[time.Weekday][]MinuteRange, raw models.JSON) {
+	if len(raw) == 0 {
+		return
+	}
+	var slots []struct {
+		Day       string `json:"day"`
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+	}
+	if err := json.Unmarshal(raw, &slots); err != nil {
+		return
+	}
+	for _, s := range slots {
+		day, ok := ParseWeekdayName(s.Day)
+		if !ok {
+			continue
+		}
+		if r, ok := parseMinuteRange(s.StartTime, s.EndTime); ok {
+			target[day] = append(target[day], r)
+		}
+	}
+}
+
+func parseMinuteRange(start, end string) (MinuteRange, bool) {
+	s, ok1 := parseClockMinutes(start)
+	e, ok2 := parseClockMinutes(end)
+	if !ok1 || !ok2 || e <= s {
+		return MinuteRange{}, false
+	}
+	return MinuteRange{Start: s, End: e}, true
+}
+
+func parseClockMinutes(v string) (int, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{"15:04", "15:04:05", "15.04"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Hour()*60 + t.Minute(), true
+		}
+	}
+	return 0, false
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday, "อาทิตย์": time.Sunday, "วันอาทิตย์": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday, "จันทร์": time.Monday, "วันจันทร์": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday, "อังคาร": time.Tuesday, "วันอังคาร": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday, "พุธ": time.Wednesday, "วันพุธ": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "พฤหัสบดี": time.Thursday, "พฤหัส": time.Thursday, "วันพฤหัสบดี": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday, "ศุกร์": time.Friday, "วันศุกร์": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday, "เสาร์": time.Saturday, "วันเสาร์": time.Saturday,
+}
+
+// ParseWeekdayName accepts English / Thai day names, 3-letter abbreviations or 0-6 (0 = Sunday)
+func ParseWeekdayName(v string) (time.Weekday, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if d, ok := weekdayNames[v]; ok {
+		return d, true
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 6 {
+		return time.Weekday(n), true
+	}
+	return time.Sunday, false
+}
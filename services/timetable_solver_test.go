@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func solverInput(members ...MemberAvailability) TimetableSolverInput {
+	return TimetableSolverInput{
+		Hours:           BranchHours{OpenMinutes: 9 * 60, CloseMinutes: 12 * 60},
+		HoursPerSession: 1,
+		SessionsPerWeek: 1,
+		StepMinutes:     60,
+		Weekdays:        []time.Weekday{time.Monday},
+		Members:         members,
+		TopK:            10,
+	}
+}
+
+func proposalStarts(res *TimetableSolverResult) []string {
+	out := make([]string, 0, len(res.Proposals))
+	for _, p := range res.Proposals {
+		out = append(out, p.SessionTimes[0].StartTime)
+	}
+	return out
+}
+
+func TestSolveTimetableMemberAvailabilityIsHard(t *testing.T) {
+	busy := MemberAvailability{Name: "Ann", Unavailable: map[time.Weekday][]MinuteRange{
+		time.Monday: {{Start: 9 * 60, End: 10*60 + 30}},
+	}}
+	res, err := SolveTimetable(solverInput(busy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := proposalStarts(res)
+	if len(got) != 1 || got[0] != "11:00" {
+		t.Fatalf("proposals = %v, want only 11:00", got)
+	}
+	if res.RejectedByCheck["member_availability"] != 2 {
+		t.Fatalf("rejected = %v", res.RejectedByCheck)
+	}
+	for _, c := range res.Proposals[0].Constraints {
+		if c.Constraint == "member_availability" && (!c.Hard || !c.Satisfied) {
+			t.Fatalf("member_availability constraint = %+v", c)
+		}
+	}
+}
+
+func TestSolveTimetableAvailableWindowRestrictsSlots(t *testing.T) {
+	windowed := MemberAvailability{Name: "Ben", Available: map[time.Weekday][]MinuteRange{
+		time.Monday: {{Start: 10 * 60, End: 11 * 60}},
+	}}
+	res, err := SolveTimetable(solverInput(windowed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proposalStarts(res); len(got) != 1 || got[0] != "10:00" {
+		t.Fatalf("proposals = %v, want only 10:00", got)
+	}
+}
+
+func TestSolveTimetableUnknownMembersDoNotBlock(t *testing.T) {
+	res, err := SolveTimetable(solverInput(MemberAvailability{Name: "Cat"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proposalStarts(res); len(got) != 3 {
+		t.Fatalf("proposals = %v, want every slot", got)
+	}
+}
+
+func TestSolveTimetableNoSlotWhenEveryoneBusy(t *testing.T) {
+	busy := MemberAvailability{Name: "Dan", Unavailable: map[time.Weekday][]MinuteRange{
+		time.Monday: {{Start: 0, End: 24 * 60}},
+	}}
+	res, err := SolveTimetable(solverInput(busy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Proposals) != 0 || res.RejectedByCheck["member_availability"] != 3 {
+		t.Fatalf("proposals = %v, rejected = %v", proposalStarts(res), res.RejectedByCheck)
+	}
+}
+
+func TestSolveTimetablePreferenceRanksFirst(t *testing.T) {
+	pref := MemberAvailability{Name: "Eve", Preferred: map[time.Weekday][]MinuteRange{
+		time.Monday: {{Start: 11 * 60, End: 12 * 60}},
+	}}
+	res, err := SolveTimetable(solverInput(pref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proposalStarts(res); len(got) == 0 || got[0] != "11:00" {
+		t.Fatalf("proposals = %v, want preferred 11:00 first", got)
+	}
+}
+
+func TestSolveTimetableSpreadsSessions(t *testing.T) {
+	in := solverInput()
+	in.SessionsPerWeek = 2
+	in.Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Thursday}
+	in.TopK = 1
+	res, err := SolveTimetable(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Proposals) != 1 {
+		t.Fatalf("got %d proposals", len(res.Proposals))
+	}
+	days := res.Proposals[0].SessionTimes
+	if days[0].Weekday == int(time.Monday) && days[1].Weekday == int(time.Tuesday) {
+		t.Fatalf("best proposal uses back-to-back days: %+v", days)
+	}
+}
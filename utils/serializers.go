@@ -384,6 +384,7 @@ type ScheduleDetailDTO struct {
 	ScheduleType     string           `json:"schedule_type"`
 	Status           string           `json:"status"`
 	RecurringPattern string           `json:"recurring_pattern"`
+	RecurrenceRule   string           `json:"recurrence_rule,omitempty"`
 	TotalHours       int              `json:"total_hours"`
 	HoursPerSession  int              `json:"hours_per_session"`
 	SessionPerWeek   int              `json:"session_per_week"`